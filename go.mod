@@ -3,7 +3,11 @@ module github.com/banux/nxt-opds
 go 1.24.0
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/gorilla/mux v1.8.1
+	github.com/nwaples/rardecode/v2 v2.2.0
+	golang.org/x/crypto v0.43.0
+	golang.org/x/image v0.25.0
 	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.46.1
 )
@@ -0,0 +1,117 @@
+// Package bookfile holds the handling of book files shared by the catalog
+// backends: parsing a file according to its format, scanning many of them
+// concurrently, retrying those that could not be opened yet and filing
+// uploads.
+package bookfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/epub"
+)
+
+// Result is the outcome of parsing one file found by a scan.
+type Result struct {
+	Book       catalog.Book
+	OK         bool        // Book holds the parsed file
+	Unreadable bool        // the file could not be opened and is worth retrying
+	Hash       string      // SHA-256 of the file, when deduplicating by content
+	Info       fs.FileInfo // the file as stat'ed before parsing, or nil
+}
+
+// ParseFile parses the book file at path according to its extension.
+func ParseFile(path, coversDir string, opts epub.Options) Result {
+	book, err := ParseBook(path, coversDir, opts)
+	if err != nil {
+		return Result{Unreadable: errors.Is(err, epub.ErrOpen)}
+	}
+	return Result{Book: book, OK: true}
+}
+
+// ParseBook parses the book file at path according to its extension. The
+// error wraps epub.ErrOpen if the file could not be opened, e.g. because
+// it is still being copied.
+func ParseBook(path, coversDir string, opts epub.Options) (catalog.Book, error) {
+	var (
+		book catalog.Book
+		err  error
+	)
+	switch ext := epub.FileExt(path); {
+	case epub.IsComic(path):
+		book, err = epub.ParseComicWithOptions(path, coversDir, opts)
+	case ext == ".epub" || ext == ".kepub.epub":
+		book, err = epub.ParseBookWithOptions(path, coversDir, opts)
+	case ext == ".pdf":
+		book = epub.ParsePathWithOptions(path, opts)
+	case ext == ".mobi" || ext == ".azw3":
+		book = epub.ParseMOBIWithOptions(path, opts)
+	case ext == ".fb2" || ext == ".fb2.zip":
+		book, err = epub.ParseFB2WithOptions(path, coversDir, opts)
+	default:
+		return catalog.Book{}, fmt.Errorf("unsupported file type %q", ext)
+	}
+	if err != nil {
+		return catalog.Book{}, fmt.Errorf("parse %q: %w", filepath.Base(path), err)
+	}
+	return book, nil
+}
+
+// OrganizeUpload moves the freshly stored upload at path, parsed as bk, to
+// its Author/Series/Title location under root and parses it again there,
+// since book IDs derive from the path. The cover cached under the staging
+// ID is removed.
+func OrganizeUpload(root, coversDir, path string, bk catalog.Book, opts epub.Options) (catalog.Book, error) {
+	dest := epub.UniquePath(epub.OrganizedPath(root, bk, epub.FileExt(path)))
+	if err := epub.MoveInto(path, dest); err != nil {
+		return bk, fmt.Errorf("move upload: %w", err)
+	}
+	if cover, err := epub.CoverPath(coversDir, bk.ID); err == nil {
+		_ = os.Remove(cover)
+	}
+	parsed, err := ParseBook(dest, coversDir, opts)
+	if err != nil {
+		return bk, err
+	}
+	return parsed, nil
+}
+
+// ParseFiles calls parse for every path, at most n at a time, and returns
+// the results in the order of paths.
+func ParseFiles(paths []string, n int, parse func(path string) Result) []Result {
+	results := make([]Result, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(n, len(paths)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = parse(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// ListingStat returns an epub.Options.Stat that answers from the directory
+// entries a scan collected, falling back to os.Stat for paths it did not
+// list and for symbolic links, whose listing describes the link itself.
+func ListingStat(entries map[string]fs.DirEntry) func(string) (fs.FileInfo, error) {
+	return func(path string) (fs.FileInfo, error) {
+		if d, ok := entries[path]; ok && d.Type().IsRegular() {
+			return d.Info()
+		}
+		return os.Stat(path)
+	}
+}
@@ -0,0 +1,95 @@
+package bookfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/epub"
+)
+
+func TestParseFiles_KeepsOrder(t *testing.T) {
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, fmt.Sprintf("book%02d.epub", i))
+	}
+	for _, n := range []int{1, 4, 50} {
+		results := ParseFiles(paths, n, func(path string) Result {
+			return Result{Book: catalog.Book{Title: path}, OK: true}
+		})
+		if len(results) != len(paths) {
+			t.Fatalf("n=%d: got %d results, want %d", n, len(results), len(paths))
+		}
+		for i, res := range results {
+			if res.Book.Title != paths[i] {
+				t.Errorf("n=%d: result %d is for %q, want %q", n, i, res.Book.Title, paths[i])
+			}
+		}
+	}
+}
+
+func TestPending_RetriesUntilStopped(t *testing.T) {
+	var p Pending
+	retried := make(chan struct{}, 10)
+	retry := func() { retried <- struct{}{} }
+
+	p.Update([]string{"b.epub", "a.epub"}, time.Millisecond, retry)
+	if got := p.Paths(); !slices.Equal(got, []string{"a.epub", "b.epub"}) {
+		t.Errorf("Paths() = %v, want [a.epub b.epub]", got)
+	}
+	select {
+	case <-retried:
+	case <-time.After(time.Second):
+		t.Fatal("no retry scheduled")
+	}
+
+	// Files failing MaxPendingAttempts times in a row stop triggering
+	// retries, but stay pending.
+	for range MaxPendingAttempts - 1 {
+		p.Update([]string{"a.epub"}, 0, retry)
+	}
+	p.Update([]string{"a.epub"}, time.Millisecond, retry)
+	if got := p.Paths(); !slices.Equal(got, []string{"a.epub"}) {
+		t.Errorf("Paths() = %v, want [a.epub]", got)
+	}
+
+	p.Update([]string{"c.epub"}, 20*time.Millisecond, retry)
+	p.Stop()
+	p.Update([]string{"c.epub"}, time.Millisecond, retry)
+	select {
+	case <-retried:
+		t.Error("retry ran after Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestOrganizeUpload(t *testing.T) {
+	root := t.TempDir()
+	staging := filepath.Join(root, "upload.pdf")
+	bk := catalog.Book{Title: "Dune", Authors: []catalog.Author{{Name: "Frank Herbert"}}, Series: "Dune"}
+
+	// A second upload of the same book gets a free name next to the first.
+	dir := filepath.Join(root, "Frank Herbert", "Dune")
+	for _, wantPath := range []string{filepath.Join(dir, "Dune.pdf"), filepath.Join(dir, "Dune (2).pdf")} {
+		if err := os.WriteFile(staging, []byte("%PDF-1.4"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := OrganizeUpload(root, filepath.Join(root, ".covers"), staging, bk, epub.Options{})
+		if err != nil {
+			t.Fatalf("OrganizeUpload() error: %v", err)
+		}
+		if len(got.Files) != 1 || got.Files[0].Path != wantPath {
+			t.Errorf("OrganizeUpload() files = %+v, want %s", got.Files, wantPath)
+		}
+		if _, err := os.Stat(wantPath); err != nil {
+			t.Errorf("file not moved to %s: %v", wantPath, err)
+		}
+		if _, err := os.Stat(staging); !os.IsNotExist(err) {
+			t.Error("staging file left behind")
+		}
+	}
+}
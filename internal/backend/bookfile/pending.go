@@ -0,0 +1,85 @@
+package bookfile
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxPendingAttempts is the number of consecutive scans a file may fail to
+// open before it no longer triggers automatic retries.
+const MaxPendingAttempts = 5
+
+// Pending tracks the files scans could not open, e.g. because a sync
+// client was still writing them, and schedules a one-shot retry while some
+// are below MaxPendingAttempts. The zero value is ready to use and its
+// methods are safe for concurrent use.
+type Pending struct {
+	mu      sync.Mutex
+	counts  map[string]int // path -> consecutive open failures
+	timer   *time.Timer    // non-nil while a retry is scheduled
+	stopped bool
+}
+
+// Update records the paths the last scan failed to open: each failure count
+// is carried over and incremented, and paths that opened successfully (or
+// vanished) are dropped. If delay is positive and some path is still worth
+// retrying, retry is then called once after delay, unless one is already
+// scheduled.
+func (p *Pending) Update(failed []string, delay time.Duration, retry func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := make(map[string]int, len(failed))
+	for _, path := range failed {
+		next[path] = p.counts[path] + 1
+	}
+	p.counts = next
+
+	if delay <= 0 || p.stopped || p.timer != nil || !p.shouldRetryLocked() {
+		return
+	}
+	p.timer = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		if p.timer == nil { // cancelled by Stop
+			p.mu.Unlock()
+			return
+		}
+		p.timer = nil
+		p.mu.Unlock()
+		retry()
+	})
+}
+
+// shouldRetryLocked reports whether any pending file is still worth
+// retrying. Callers must hold p.mu.
+func (p *Pending) shouldRetryLocked() bool {
+	for _, n := range p.counts {
+		if n < MaxPendingAttempts {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths returns the pending paths, sorted.
+func (p *Pending) Paths() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	paths := make([]string, 0, len(p.counts))
+	for path := range p.counts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Stop cancels any scheduled retry; later updates schedule none.
+func (p *Pending) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.stopped = true
+}
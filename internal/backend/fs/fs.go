@@ -4,18 +4,23 @@
 package fs
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
+	mrand "math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/banux/nxt-opds/internal/backend/bookfile"
 	"github.com/banux/nxt-opds/internal/catalog"
 	"github.com/banux/nxt-opds/internal/epub"
 )
@@ -24,54 +29,171 @@ import (
 // Pointer fields: nil = not overridden; non-nil = override active (even if empty string).
 // Slice fields: nil = not overridden; non-nil (including empty) = override active.
 type metaOverride struct {
-	Title       *string  `json:"title"`
-	Authors     []string `json:"authors"`
-	Tags        []string `json:"tags"`
-	Summary     *string  `json:"summary"`
-	Publisher   *string  `json:"publisher"`
-	Language    *string  `json:"language"`
-	Series      *string  `json:"series"`
-	SeriesIndex *string  `json:"seriesIndex"`
-	SeriesTotal *string  `json:"seriesTotal"`
-	Collection  *string  `json:"collection"`
-	IsRead      *bool    `json:"isRead"`
-	Rating      *int     `json:"rating"`
+	Title       *string    `json:"title"`
+	Authors     []string   `json:"authors"`
+	Tags        []string   `json:"tags"`
+	Summary     *string    `json:"summary"`
+	Publisher   *string    `json:"publisher"`
+	Language    *string    `json:"language"`
+	Series      *string    `json:"series"`
+	SeriesIndex *string    `json:"seriesIndex"`
+	SeriesTotal *string    `json:"seriesTotal"`
+	Collection  *string    `json:"collection"`
+	Source      *string    `json:"source,omitempty"`
+	IsRead      *bool      `json:"isRead"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+	Rating      *int       `json:"rating"`
+	Hidden      *bool      `json:"hidden,omitempty"`
 }
 
 // Backend is a filesystem-based catalog backend.
 // It scans a root directory for EPUB/PDF files on creation (or on Refresh).
 type Backend struct {
-	root         string
-	coversDir    string // {root}/.covers – extracted cover images
-	metadataPath string // {root}/.metadata.json – user metadata overrides
+	root            string
+	coversDir       string // {root}/.covers by default – extracted cover images
+	epubOpts        epub.Options
+	organizeUploads bool   // file uploads under Author/Series/Title
+	maxUploadSize   int64  // StoreBook rejects larger files; see catalog.UploadLimit
+	removeEmptyDirs bool   // DeleteBook removes the folders it empties
+	scanConcurrency int    // files parsed at once by a scan
+	trustDirListing bool   // take file sizes and times from the scan's listing
+	dedupeByHash    bool   // fold files with the same content into one book
+	scanHiddenFiles bool   // index dotfiles and files in dot-directories
+	metadataPath    string // {root}/.metadata.json – user metadata overrides
+	listsPath       string // {root}/.lists.json – user reading lists
+	downloadsPath   string // {root}/.downloads.json – per-book download counts
+	aliasesPath     string // {root}/.aliases.json – author pseudonyms
+	addedPath       string // {root}/.added.json – when books entered the catalog
 
 	mu         sync.RWMutex
 	books      []catalog.Book
 	byID       map[string]*catalog.Book
-	authors    map[string][]string // author name -> book IDs
-	tags       map[string][]string // tag -> book IDs
-	publishers map[string][]string // publisher name -> book IDs
+	authors    map[string][]string     // catalog.AuthorKey -> book IDs
+	tags       map[string][]string     // tag -> book IDs
+	publishers map[string][]string     // publisher name -> book IDs
 	overrides  map[string]metaOverride // book ID -> user-edited metadata
+	lists      []readingList           // user reading lists, in creation order
+	downloads  map[string]int          // book ID -> download count
+	added      map[string]time.Time    // book ID -> first indexed, with epub.AddedAtIndexing
+	aliases    map[string]authorAlias  // alias AuthorKey -> alias and canonical author
+	changedAt  time.Time               // last change no book dates record (removals, aliases)
+
+	hashMu sync.Mutex          // guards hashes, which scans fill concurrently
+	hashes map[string]fileHash // path -> content hash, with DedupeByHash
+
+	scanned map[string]scannedFile // path -> book parsed by the last scan; guarded by mu
+
+	navMu sync.Mutex // guards nav, which readers build under b.mu.RLock
+	nav   *navIndex  // sorted author and tag lists; nil until needed after a change
+
+	pendingRetryDelay time.Duration
+	pending           bookfile.Pending
+}
+
+// Options holds optional settings for the filesystem backend.
+type Options struct {
+	// CoversDir is where extracted and uploaded cover images are stored.
+	// Defaults to {dir}/.covers when empty. If it cannot be written, covers
+	// are not cached and are read from the EPUB files when requested.
+	CoversDir string
+
+	// EPUB controls EPUB parsing (e.g. strict vs. lenient handling of
+	// malformed files).
+	EPUB epub.Options
+
+	// PendingRetryDelay is how long to wait before rescanning when some
+	// files could not be opened (e.g. they were still being copied).
+	// 0 disables the automatic retry; such files are then picked up by the
+	// next Refresh.
+	PendingRetryDelay time.Duration
+
+	// OrganizeUploads files uploaded books under Author/Series/Title.ext
+	// (see epub.OrganizedPath) instead of flat in the root directory.
+	OrganizeUploads bool
+
+	// MaxUploadSize is the largest file, in bytes, StoreBook accepts;
+	// longer ones fail with catalog.ErrTooLarge. 0 means
+	// catalog.DefaultMaxUploadSize and a negative value means no limit.
+	MaxUploadSize int64
+
+	// RemoveEmptyDirs makes DeleteBook also remove the folders the book's
+	// files leave empty, up to but excluding the root directory.
+	RemoveEmptyDirs bool
+
+	// ScanConcurrency is how many files a scan parses at once, which hides
+	// the latency of network file systems. Values below 1 mean 1.
+	ScanConcurrency int
+
+	// TrustDirListing takes file sizes and modification times from the
+	// directory listing a scan walks instead of a separate stat of every
+	// file. Where listings carry this information (Windows, SMB shares) it
+	// saves a round trip per file.
+	TrustDirListing bool
+
+	// ScanHiddenFiles indexes files whose name starts with a dot and those
+	// inside dot-directories, which scans skip by default: they are the
+	// catalog's own data (covers, backups) or temporary files of uploads
+	// and sync clients.
+	ScanHiddenFiles bool
+
+	// DedupeByHash lists files with the same content (SHA-256) as one
+	// book, the first by path holding the others as extra formats, instead
+	// of one book per file.
+	DedupeByHash bool
 }
 
 // New creates a new filesystem backend rooted at dir and performs an initial scan.
 func New(dir string) (*Backend, error) {
-	coversDir := filepath.Join(dir, ".covers")
-	if err := os.MkdirAll(coversDir, 0755); err != nil {
-		return nil, fmt.Errorf("create covers dir: %w", err)
+	return NewWithOptions(dir, Options{})
+}
+
+// NewWithOptions is like New but applies the given Options.
+func NewWithOptions(dir string, opts Options) (*Backend, error) {
+	coversDir := opts.CoversDir
+	if coversDir == "" {
+		coversDir = filepath.Join(dir, ".covers")
+	}
+	// A covers directory that cannot be written (e.g. a read-only mount) is
+	// detected once here; covers are then read from the books on demand.
+	epubOpts := opts.EPUB
+	if !epub.DirWritable(coversDir) {
+		epubOpts.InMemoryCovers = true
 	}
 	b := &Backend{
-		root:         dir,
-		coversDir:    coversDir,
-		metadataPath: filepath.Join(dir, ".metadata.json"),
-		byID:         make(map[string]*catalog.Book),
-		authors:      make(map[string][]string),
-		tags:         make(map[string][]string),
-		publishers:   make(map[string][]string),
-		overrides:    make(map[string]metaOverride),
+		root:          dir,
+		coversDir:     coversDir,
+		epubOpts:      epubOpts,
+		metadataPath:  filepath.Join(dir, ".metadata.json"),
+		listsPath:     filepath.Join(dir, ".lists.json"),
+		downloadsPath: filepath.Join(dir, ".downloads.json"),
+		aliasesPath:   filepath.Join(dir, ".aliases.json"),
+		addedPath:     filepath.Join(dir, ".added.json"),
+		byID:          make(map[string]*catalog.Book),
+		authors:       make(map[string][]string),
+		tags:          make(map[string][]string),
+		publishers:    make(map[string][]string),
+		overrides:     make(map[string]metaOverride),
+		downloads:     make(map[string]int),
+		added:         make(map[string]time.Time),
+		aliases:       make(map[string]authorAlias),
+		hashes:        make(map[string]fileHash),
+		scanned:       make(map[string]scannedFile),
+
+		pendingRetryDelay: opts.PendingRetryDelay,
+		organizeUploads:   opts.OrganizeUploads,
+		maxUploadSize:     opts.MaxUploadSize,
+		removeEmptyDirs:   opts.RemoveEmptyDirs,
+		scanConcurrency:   max(opts.ScanConcurrency, 1),
+		trustDirListing:   opts.TrustDirListing,
+		dedupeByHash:      opts.DedupeByHash,
+		scanHiddenFiles:   opts.ScanHiddenFiles,
 	}
 	// Load persisted metadata overrides (ignore error if file doesn't exist yet)
 	_ = b.loadOverrides()
+	_ = b.loadLists()
+	_ = b.loadDownloads()
+	_ = b.loadAliases()
+	_ = b.loadAdded()
 	if err := b.Refresh(); err != nil {
 		return nil, err
 	}
@@ -102,6 +224,147 @@ func (b *Backend) saveOverrides() error {
 	return nil
 }
 
+// readingList is the persisted form of a catalog.List in .lists.json.
+type readingList struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	BookIDs   []string  `json:"bookIds"`
+}
+
+// loadLists reads the .lists.json file into b.lists.
+func (b *Backend) loadLists() error {
+	data, err := os.ReadFile(b.listsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read lists: %w", err)
+	}
+	return json.Unmarshal(data, &b.lists)
+}
+
+// saveLists persists b.lists to .lists.json.
+func (b *Backend) saveLists() error {
+	data, err := json.MarshalIndent(b.lists, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal lists: %w", err)
+	}
+	if err := os.WriteFile(b.listsPath, data, 0644); err != nil {
+		return fmt.Errorf("write lists: %w", err)
+	}
+	return nil
+}
+
+// authorAlias records that Alias is a pseudonym of Author.
+type authorAlias struct {
+	Alias  string `json:"alias"`
+	Author string `json:"author"`
+}
+
+// loadAliases reads the .aliases.json file into b.aliases.
+func (b *Backend) loadAliases() error {
+	data, err := os.ReadFile(b.aliasesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read aliases: %w", err)
+	}
+	return json.Unmarshal(data, &b.aliases)
+}
+
+// saveAliases persists b.aliases to .aliases.json.
+func (b *Backend) saveAliases() error {
+	data, err := json.MarshalIndent(b.aliases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal aliases: %w", err)
+	}
+	if err := os.WriteFile(b.aliasesPath, data, 0644); err != nil {
+		return fmt.Errorf("write aliases: %w", err)
+	}
+	return nil
+}
+
+// loadDownloads reads the .downloads.json file into b.downloads.
+func (b *Backend) loadDownloads() error {
+	data, err := os.ReadFile(b.downloadsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read downloads: %w", err)
+	}
+	return json.Unmarshal(data, &b.downloads)
+}
+
+// saveDownloads persists b.downloads to .downloads.json.
+func (b *Backend) saveDownloads() error {
+	data, err := json.MarshalIndent(b.downloads, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal downloads: %w", err)
+	}
+	if err := os.WriteFile(b.downloadsPath, data, 0644); err != nil {
+		return fmt.Errorf("write downloads: %w", err)
+	}
+	return nil
+}
+
+// loadAdded reads the .added.json file into b.added.
+func (b *Backend) loadAdded() error {
+	data, err := os.ReadFile(b.addedPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read added dates: %w", err)
+	}
+	return json.Unmarshal(data, &b.added)
+}
+
+// saveAdded persists b.added to .added.json.
+func (b *Backend) saveAdded() error {
+	data, err := json.MarshalIndent(b.added, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal added dates: %w", err)
+	}
+	if err := os.WriteFile(b.addedPath, data, 0644); err != nil {
+		return fmt.Errorf("write added dates: %w", err)
+	}
+	return nil
+}
+
+// keepAddedLocked dates books by when they first entered the catalog,
+// recording the parse time of those seen for the first time, so that
+// epub.AddedAtIndexing survives restarts of the in-memory index. The dates
+// of books no longer in the catalog are dropped, except for those whose
+// files could not be opened this time. b.mu must be held for writing.
+func (b *Backend) keepAddedLocked(books []catalog.Book, unreadable []string) {
+	changed := false
+	ids := make(map[string]bool, len(books)+len(unreadable))
+	for _, path := range unreadable {
+		ids[epub.PathToID(path)] = true
+	}
+	for i := range books {
+		ids[books[i].ID] = true
+		if t, ok := b.added[books[i].ID]; ok {
+			books[i].AddedAt = t
+		} else {
+			b.added[books[i].ID] = books[i].AddedAt
+			changed = true
+		}
+	}
+	for id := range b.added {
+		if !ids[id] {
+			delete(b.added, id)
+			changed = true
+		}
+	}
+	if changed {
+		_ = b.saveAdded()
+	}
+}
+
 // applyOverride merges any stored override for bk.ID on top of bk.
 func (b *Backend) applyOverride(bk catalog.Book) catalog.Book {
 	ov, ok := b.overrides[bk.ID]
@@ -112,14 +375,23 @@ func (b *Backend) applyOverride(bk catalog.Book) catalog.Book {
 }
 
 // mergeOverride applies an override to a book copy and returns it.
+// Sort names parsed from the file are kept only while the overridden name
+// they belong to is unchanged.
 func mergeOverride(bk catalog.Book, ov metaOverride) catalog.Book {
 	if ov.Title != nil {
+		if *ov.Title != bk.Title {
+			bk.TitleSort = ""
+		}
 		bk.Title = *ov.Title
 	}
 	if ov.Authors != nil {
+		sortNames := make(map[string]string, len(bk.Authors))
+		for _, a := range bk.Authors {
+			sortNames[a.Name] = a.SortName
+		}
 		bk.Authors = make([]catalog.Author, 0, len(ov.Authors))
 		for _, name := range ov.Authors {
-			bk.Authors = append(bk.Authors, catalog.Author{Name: name})
+			bk.Authors = append(bk.Authors, catalog.Author{Name: name, SortName: sortNames[name]})
 		}
 	}
 	if ov.Tags != nil {
@@ -132,6 +404,9 @@ func mergeOverride(bk catalog.Book, ov metaOverride) catalog.Book {
 		bk.Publisher = *ov.Publisher
 	}
 	if ov.Language != nil {
+		if *ov.Language != bk.Language {
+			bk.Languages = nil
+		}
 		bk.Language = *ov.Language
 	}
 	if ov.Series != nil {
@@ -146,12 +421,23 @@ func mergeOverride(bk catalog.Book, ov metaOverride) catalog.Book {
 	if ov.Collection != nil {
 		bk.Collection = *ov.Collection
 	}
+	if ov.Source != nil {
+		bk.Source = *ov.Source
+	}
 	if ov.IsRead != nil {
 		bk.IsRead = *ov.IsRead
 	}
+	if ov.ReadAt != nil {
+		bk.ReadAt = *ov.ReadAt
+	} else if ov.IsRead != nil {
+		bk.ReadAt = time.Time{}
+	}
 	if ov.Rating != nil {
 		bk.Rating = *ov.Rating
 	}
+	if ov.Hidden != nil {
+		bk.Hidden = *ov.Hidden
+	}
 	return bk
 }
 
@@ -176,7 +462,7 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 		ov.Authors = update.Authors
 	}
 	if update.Tags != nil {
-		ov.Tags = update.Tags
+		ov.Tags = catalog.UniqueTags(update.Tags)
 	}
 	if update.Summary != nil {
 		ov.Summary = update.Summary
@@ -199,12 +485,25 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 	if update.Collection != nil {
 		ov.Collection = update.Collection
 	}
+	if update.Source != nil {
+		ov.Source = update.Source
+	}
 	if update.IsRead != nil {
 		ov.IsRead = update.IsRead
+		switch {
+		case !*update.IsRead:
+			ov.ReadAt = nil
+		case !bk.IsRead || bk.ReadAt.IsZero():
+			now := time.Now().UTC()
+			ov.ReadAt = &now
+		}
 	}
 	if update.Rating != nil {
 		ov.Rating = update.Rating
 	}
+	if update.Hidden != nil {
+		ov.Hidden = update.Hidden
+	}
 
 	b.overrides[id] = ov
 
@@ -222,15 +521,8 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 	updated := b.applyOverride(*bk)
 	*bk = updated
 
-	for _, a := range bk.Authors {
-		b.authors[a.Name] = append(b.authors[a.Name], bk.ID)
-	}
-	for _, t := range bk.Tags {
-		b.tags[t] = append(b.tags[t], bk.ID)
-	}
-	if bk.Publisher != "" {
-		b.publishers[bk.Publisher] = append(b.publishers[bk.Publisher], bk.ID)
-	}
+	indexBook(b.authors, b.tags, b.publishers, bk)
+	b.invalidateNavLocked()
 
 	bk.UpdatedAt = time.Now()
 
@@ -242,6 +534,52 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 	return &result, nil
 }
 
+// reindexLocked re-points byID into b.books after the slice has been
+// reallocated or shifted. b.mu must be held for writing.
+func (b *Backend) reindexLocked() {
+	for i := range b.books {
+		b.byID[b.books[i].ID] = &b.books[i]
+	}
+}
+
+// sortByTime sorts books by the time returned by key (newest first when
+// desc), breaking ties with titleThenID so the order is total.
+func sortByTime(books []catalog.Book, desc bool, key func(catalog.Book) time.Time) {
+	sort.Slice(books, func(i, j int) bool {
+		ti, tj := key(books[i]), key(books[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj) != desc
+		}
+		return titleThenID(books[i], books[j])
+	})
+}
+
+// newerFirst reports whether a precedes b in the default order of b.books:
+// newest AddedAt first, then titleThenID, matching sortByTime.
+func newerFirst(a, b catalog.Book) bool {
+	if !a.AddedAt.Equal(b.AddedAt) {
+		return a.AddedAt.After(b.AddedAt)
+	}
+	return titleThenID(a, b)
+}
+
+// titleThenID is the final tiebreaker for every book ordering: lower-cased
+// title, then ID. It keeps pagination stable when sort keys collide.
+func titleThenID(a, b catalog.Book) bool {
+	if ta, tb := titleKey(a), titleKey(b); ta != tb {
+		return ta < tb
+	}
+	return a.ID < b.ID
+}
+
+// titleKey is the case-folded title sort name, or the title if there is none.
+func titleKey(bk catalog.Book) string {
+	if bk.TitleSort != "" {
+		return strings.ToLower(bk.TitleSort)
+	}
+	return strings.ToLower(bk.Title)
+}
+
 // removeID removes the first occurrence of id from ids slice.
 func removeID(ids []string, id string) []string {
 	for i, v := range ids {
@@ -257,6 +595,12 @@ func (b *Backend) CoverPath(id string) (string, error) {
 	return epub.CoverPath(b.coversDir, id)
 }
 
+// ThumbnailPath returns the filesystem path to the cover thumbnail for the
+// given book ID. It implements catalog.ThumbnailProvider.
+func (b *Backend) ThumbnailPath(id string) (string, error) {
+	return epub.Thumbnail(b.coversDir, id)
+}
+
 // UpdateCover replaces the cover image for the given book ID with the data
 // from src. It removes any previously cached cover image files for that ID
 // and updates the in-memory book record's CoverURL/ThumbnailURL fields.
@@ -264,6 +608,10 @@ func (b *Backend) CoverPath(id string) (string, error) {
 func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	defer src.Close()
 
+	if !epub.IsCoverExt(ext) {
+		return fmt.Errorf("unsupported cover image type %q", ext)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -272,9 +620,7 @@ func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	}
 
 	// Remove existing cover files for this book (any extension).
-	for _, oldExt := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
-		_ = os.Remove(filepath.Join(b.coversDir, id+oldExt))
-	}
+	epub.RemoveCovers(b.coversDir, id)
 
 	destPath := filepath.Join(b.coversDir, id+ext)
 	out, err := os.Create(destPath)
@@ -292,7 +638,7 @@ func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	// Update in-memory record so subsequent API responses reflect the new cover.
 	bk := b.byID[id]
 	bk.CoverURL = "/covers/" + id
-	bk.ThumbnailURL = "/covers/" + id
+	bk.ThumbnailURL = "/covers/" + id + "/thumb"
 	// Mirror into the main slice (byID points into books slice, but update to be safe).
 	for i := range b.books {
 		if b.books[i].ID == id {
@@ -306,45 +652,115 @@ func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 
 // Refresh re-scans the root directory and rebuilds the in-memory catalog.
 func (b *Backend) Refresh() error {
-	var books []catalog.Book
+	_, err := b.RefreshWithStats()
+	return err
+}
 
+// RefreshWithStats is Refresh, reporting the books added, re-parsed and
+// removed compared with the previous catalog. It implements
+// catalog.StatsRefresher.
+func (b *Backend) RefreshWithStats() (catalog.RefreshStats, error) {
+	var paths []string
+	entries := make(map[string]fs.DirEntry)
 	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
 		if d.IsDir() {
+			if sameDir(path, b.coversDir) {
+				return filepath.SkipDir
+			}
+			if path != b.root && b.skipHidden(d.Name()) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		switch ext {
-		case ".epub":
-			book, err := epub.ParseBook(path, b.coversDir)
-			if err != nil {
-				return nil
-			}
-			books = append(books, book)
-		case ".pdf":
-			books = append(books, epub.ParsePath(path))
+		if b.skipHidden(d.Name()) {
+			return nil
+		}
+		switch epub.FileExt(path) {
+		case ".epub", ".kepub.epub", ".pdf", ".mobi", ".azw3", ".fb2", ".fb2.zip", ".cbz", ".cbr":
+			paths = append(paths, path)
+			entries[path] = d
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("scanning directory %q: %w", b.root, err)
+		return catalog.RefreshStats{}, fmt.Errorf("scanning directory %q: %w", b.root, err)
+	}
+
+	opts := b.epubOpts
+	if b.trustDirListing {
+		opts.Stat = bookfile.ListingStat(entries)
+	}
+	stat := os.Stat
+	if opts.Stat != nil {
+		stat = opts.Stat
 	}
 
+	// Files whose size and modification time are unchanged since the last
+	// scan keep the book parsed then; the others, new or edited, are parsed.
 	b.mu.RLock()
-	overrides := b.overrides
+	prev := b.scanned
 	b.mu.RUnlock()
+	infos := make(map[string]fs.FileInfo, len(paths))
+	for _, path := range paths {
+		infos[path], _ = stat(path)
+	}
+
+	var stats catalog.RefreshStats
+	var books []catalog.Book
+	var unreadable []string
+	hashes := make(map[string]string)
+	scanned := make(map[string]scannedFile, len(paths))
+	for i, res := range bookfile.ParseFiles(paths, b.scanConcurrency, func(path string) bookfile.Result {
+		var res bookfile.Result
+		if f, ok := prev[path]; ok && f.unchanged(infos[path]) {
+			res = bookfile.Result{Book: f.book, OK: true}
+		} else {
+			res = bookfile.ParseFile(path, b.coversDir, opts)
+		}
+		if res.OK && b.dedupeByHash {
+			res.Hash = b.contentHash(path)
+		}
+		return res
+	}) {
+		switch {
+		case res.Unreadable:
+			unreadable = append(unreadable, paths[i])
+		case res.OK:
+			books = append(books, res.Book)
+			if res.Hash != "" {
+				hashes[paths[i]] = res.Hash
+			}
+			if info := infos[paths[i]]; info != nil {
+				scanned[paths[i]] = scannedFile{size: info.Size(), modTime: info.ModTime(), book: res.Book}
+			}
+			if f, ok := prev[paths[i]]; ok && !f.unchanged(infos[paths[i]]) {
+				stats.Updated++
+			}
+		}
+	}
+	if b.dedupeByHash {
+		books = foldDuplicates(books, hashes)
+		b.pruneHashes(entries)
+	}
+
+	b.mu.Lock()
+	if b.epubOpts.AddedAt == epub.AddedAtIndexing {
+		b.keepAddedLocked(books, unreadable)
+	}
+	overrides := b.overrides
+	b.mu.Unlock()
 	for i := range books {
 		if ov, ok := overrides[books[i].ID]; ok {
 			books[i] = mergeOverride(books[i], ov)
 		}
 	}
 
-	// Default sort: newest first (by file mod time / AddedAt).
-	sort.Slice(books, func(i, j int) bool {
-		return books[i].AddedAt.After(books[j].AddedAt)
-	})
+	// Default sort: newest first (by file mod time / AddedAt), then by title
+	// and ID so books imported with identical mtimes page deterministically.
+	sortByTime(books, true, func(bk catalog.Book) time.Time { return bk.AddedAt })
 
 	byID := make(map[string]*catalog.Book, len(books))
 	authors := make(map[string][]string)
@@ -354,24 +770,131 @@ func (b *Backend) Refresh() error {
 	for i := range books {
 		bk := &books[i]
 		byID[bk.ID] = bk
-		for _, a := range bk.Authors {
-			authors[a.Name] = append(authors[a.Name], bk.ID)
-		}
-		for _, t := range bk.Tags {
-			tags[t] = append(tags[t], bk.ID)
-		}
-		if bk.Publisher != "" {
-			publishers[bk.Publisher] = append(publishers[bk.Publisher], bk.ID)
-		}
+		indexBook(authors, tags, publishers, bk)
 	}
 
 	b.mu.Lock()
+	for id := range byID {
+		if _, ok := b.byID[id]; !ok {
+			stats.Added++
+		}
+	}
+	formatsChanged := false
+	for id, old := range b.byID {
+		bk, ok := byID[id]
+		if !ok {
+			stats.Removed++
+		} else if len(bk.Files) != len(old.Files) {
+			formatsChanged = true
+		}
+	}
+	// A removal leaves no book behind to date it, and an added or re-parsed
+	// book's dates come from its file and metadata, which may be older than
+	// the last change already served. Neither dates a duplicate file folded
+	// into a book.
+	if stats.Removed > 0 || stats.Added > 0 || stats.Updated > 0 || formatsChanged {
+		b.changedAt = time.Now()
+	}
 	b.books = books
 	b.byID = byID
+	b.scanned = scanned
 	b.authors = authors
 	b.tags = tags
 	b.publishers = publishers
+	b.invalidateNavLocked()
+	b.pending.Update(unreadable, b.pendingRetryDelay, func() { _ = b.Refresh() })
 	b.mu.Unlock()
+
+	// Best-effort: drop the covers of books that disappeared.
+	_, _ = b.PruneCovers(false)
+	return stats, nil
+}
+
+// scannedFile is the book parsed from a file, before user overrides, valid
+// while the file's size and modification time are unchanged.
+type scannedFile struct {
+	size    int64
+	modTime time.Time
+	book    catalog.Book
+}
+
+// unchanged reports whether info still describes the parsed file.
+func (f scannedFile) unchanged(info fs.FileInfo) bool {
+	return info != nil && f.size == info.Size() && f.modTime.Equal(info.ModTime())
+}
+
+// fileHash is the content hash of a file, valid while its size and
+// modification time are unchanged.
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// contentHash returns the SHA-256 of the file at path, hashing it only if
+// it changed since the last scan, or "" if it cannot be read.
+func (b *Backend) contentHash(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	b.hashMu.Lock()
+	h, ok := b.hashes[path]
+	b.hashMu.Unlock()
+	if ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.sum
+	}
+	sum, err := epub.HashFile(path)
+	if err != nil {
+		return ""
+	}
+	b.hashMu.Lock()
+	b.hashes[path] = fileHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	b.hashMu.Unlock()
+	return sum
+}
+
+// pruneHashes forgets the hashes of files a scan no longer found.
+func (b *Backend) pruneHashes(onDisk map[string]fs.DirEntry) {
+	b.hashMu.Lock()
+	defer b.hashMu.Unlock()
+	for path := range b.hashes {
+		if _, ok := onDisk[path]; !ok {
+			delete(b.hashes, path)
+		}
+	}
+}
+
+// foldDuplicates returns books without those whose file has the same
+// content hash as an earlier book's; such files are appended to the
+// earlier book as extra formats. hashes maps file paths to content hashes.
+func foldDuplicates(books []catalog.Book, hashes map[string]string) []catalog.Book {
+	owners := make(map[string]int) // content hash -> index in kept
+	kept := books[:0]
+	for _, bk := range books {
+		sum := hashes[bk.Files[0].Path]
+		if i, ok := owners[sum]; ok && sum != "" {
+			kept[i].Files = append(kept[i].Files, bk.Files...)
+			continue
+		}
+		owners[sum] = len(kept)
+		kept = append(kept, bk)
+	}
+	return kept
+}
+
+// Pending returns the paths of files that could not be opened during the
+// last scan, sorted. They are retried automatically after
+// Options.PendingRetryDelay and on every Refresh.
+func (b *Backend) Pending() []string {
+	return b.pending.Paths()
+}
+
+// Close cancels any scheduled pending retry. The backend holds no other
+// resources; it stays usable, but no longer retries pending files on its
+// own.
+func (b *Backend) Close() error {
+	b.pending.Stop()
 	return nil
 }
 
@@ -407,7 +930,8 @@ func (b *Backend) AllBooks(offset, limit int) ([]catalog.Book, int, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	total := len(b.books)
+	books := b.visibleBooks()
+	total := len(books)
 	if offset >= total {
 		return nil, total, nil
 	}
@@ -415,7 +939,16 @@ func (b *Backend) AllBooks(offset, limit int) ([]catalog.Book, int, error) {
 	if end > total {
 		end = total
 	}
-	return b.books[offset:end], total, nil
+	return books[offset:end], total, nil
+}
+
+// visibleBooks returns the books that are not hidden, in the default
+// order. b.mu must be held.
+func (b *Backend) visibleBooks() []catalog.Book {
+	if !slices.ContainsFunc(b.books, func(bk catalog.Book) bool { return bk.Hidden }) {
+		return b.books
+	}
+	return slices.DeleteFunc(slices.Clone(b.books), func(bk catalog.Book) bool { return bk.Hidden })
 }
 
 // BookByID returns a single book by its ID.
@@ -430,6 +963,35 @@ func (b *Backend) BookByID(id string) (*catalog.Book, error) {
 	return bk, nil
 }
 
+// RandomBook returns a book chosen at random, or nil if the catalog is
+// empty. It implements catalog.RandomPicker.
+func (b *Backend) RandomBook() (*catalog.Book, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	books := b.visibleBooks()
+	if len(books) == 0 {
+		return nil, nil
+	}
+	bk := books[mrand.IntN(len(books))]
+	return &bk, nil
+}
+
+// RandomBooks returns up to n distinct books chosen at random. It
+// implements catalog.RandomPicker.
+func (b *Backend) RandomBooks(n int) ([]catalog.Book, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	visible := b.visibleBooks()
+	n = min(max(n, 0), len(visible))
+	books := make([]catalog.Book, n)
+	for i, j := range mrand.Perm(len(visible))[:n] {
+		books[i] = visible[j]
+	}
+	return books, nil
+}
+
 // Search performs a basic case-insensitive substring search over title and author.
 // If q.Query is empty all books are candidates (filtered only by q.UnreadOnly).
 func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
@@ -439,16 +1001,22 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 	qLower := strings.ToLower(q.Query)
 	var matched []catalog.Book
 	for _, bk := range b.books {
+		if bk.Hidden && !q.IncludeHidden {
+			continue
+		}
 		if q.UnreadOnly && bk.IsRead {
 			continue
 		}
+		if q.ReadOnly && !bk.IsRead {
+			continue
+		}
 		if q.Series != "" && bk.Series != q.Series {
 			continue
 		}
 		if q.Author != "" {
 			authorMatch := false
 			for _, a := range bk.Authors {
-				if strings.EqualFold(a.Name, q.Author) {
+				if catalog.AuthorKey(a.Name) == catalog.AuthorKey(q.Author) {
 					authorMatch = true
 					break
 				}
@@ -475,6 +1043,12 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 		if q.Collection != "" && !strings.EqualFold(bk.Collection, q.Collection) {
 			continue
 		}
+		if q.Language != "" && !strings.EqualFold(bk.Language, q.Language) {
+			continue
+		}
+		if bk.Rating < q.MinRating {
+			continue
+		}
 		if q.Query == "" {
 			matched = append(matched, bk)
 			continue
@@ -483,11 +1057,8 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 			matched = append(matched, bk)
 			continue
 		}
-		for _, a := range bk.Authors {
-			if strings.Contains(strings.ToLower(a.Name), qLower) {
-				matched = append(matched, bk)
-				break
-			}
+		if matchesAuthor(bk, qLower) || matchesIdentifier(bk, qLower) {
+			matched = append(matched, bk)
 		}
 	}
 
@@ -508,23 +1079,40 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 			if fi != fj {
 				return fi < fj
 			}
-			return strings.ToLower(matched[i].Title) < strings.ToLower(matched[j].Title)
+			return titleThenID(matched[i], matched[j])
 		})
 	case "title":
-		if q.SortOrder == "asc" {
-			sort.Slice(matched, func(i, j int) bool {
-				return strings.ToLower(matched[i].Title) < strings.ToLower(matched[j].Title)
-			})
-		} else {
-			sort.Slice(matched, func(i, j int) bool {
-				return strings.ToLower(matched[i].Title) > strings.ToLower(matched[j].Title)
-			})
-		}
+		desc := q.SortOrder != "asc"
+		sort.Slice(matched, func(i, j int) bool {
+			ti, tj := titleKey(matched[i]), titleKey(matched[j])
+			if ti != tj {
+				return (ti < tj) != desc
+			}
+			return matched[i].ID < matched[j].ID
+		})
+	case "size":
+		desc := q.SortOrder != "asc"
+		sort.Slice(matched, func(i, j int) bool {
+			si, sj := matched[i].TotalSize(), matched[j].TotalSize()
+			if si != sj {
+				return (si < sj) != desc
+			}
+			return titleThenID(matched[i], matched[j])
+		})
+	case "updated":
+		sortByTime(matched, q.SortOrder != "asc", func(bk catalog.Book) time.Time { return bk.UpdatedAt })
+	case "read_at":
+		sortByTime(matched, q.SortOrder != "asc", func(bk catalog.Book) time.Time { return bk.ReadAt })
+	case "rating":
+		// Stable, so equally rated books keep the natural newest-first order.
+		desc := q.SortOrder != "asc"
+		sort.SliceStable(matched, func(i, j int) bool {
+			ri, rj := matched[i].Rating, matched[j].Rating
+			return ri != rj && (ri < rj) != desc
+		})
 	case "added":
 		if q.SortOrder == "asc" {
-			sort.Slice(matched, func(i, j int) bool {
-				return matched[i].AddedAt.Before(matched[j].AddedAt)
-			})
+			sortByTime(matched, false, func(bk catalog.Book) time.Time { return bk.AddedAt })
 		}
 		// desc is already natural order from b.books
 	}
@@ -542,12 +1130,13 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 	return matched[offset:end], total, nil
 }
 
-// BooksByAuthor returns books by a specific author with pagination.
+// BooksByAuthor returns books by a specific author with pagination. Any
+// spelling with the same catalog.AuthorKey matches.
 func (b *Backend) BooksByAuthor(author string, offset, limit int) ([]catalog.Book, int, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	ids := b.authors[author]
+	ids := b.authorGroupIDs(b.canonicalAuthorKey(catalog.AuthorKey(author)))
 	total := len(ids)
 	if offset >= total {
 		return nil, total, nil
@@ -590,62 +1179,349 @@ func (b *Backend) BooksByTag(tag string, offset, limit int) ([]catalog.Book, int
 	return books, total, nil
 }
 
-// Authors returns all distinct author names with pagination.
+// Authors returns all distinct authors with pagination. Spellings sharing a
+// catalog.AuthorKey are one author, shown under the spelling most books
+// use (the first in byte order on a tie) with its whitespace collapsed.
 func (b *Backend) Authors(offset, limit int) ([]string, int, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-
-	names := make([]string, 0, len(b.authors))
-	for name := range b.authors {
-		names = append(names, name)
-	}
-
-	total := len(names)
-	if offset >= total {
-		return nil, total, nil
-	}
-	end := offset + limit
-	if end > total {
-		end = total
-	}
-	return names[offset:end], total, nil
+	names := b.navLists().authors
+	return pageOf(names, offset, limit), len(names), nil
 }
 
-// Tags returns all distinct tags with pagination.
-func (b *Backend) Tags(offset, limit int) ([]string, int, error) {
+// AuthorsWithCounts is Authors with the number of books of each author,
+// its aliases' included. It implements catalog.AuthorCounter.
+func (b *Backend) AuthorsWithCounts(offset, limit int) ([]catalog.AuthorEntry, int, error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-
-	tagList := make([]string, 0, len(b.tags))
-	for t := range b.tags {
-		tagList = append(tagList, t)
+	nav := b.navLists()
+	var entries []catalog.AuthorEntry
+	for _, name := range pageOf(nav.authors, offset, limit) {
+		entries = append(entries, catalog.AuthorEntry{Name: name, Count: nav.authorCounts[name]})
 	}
+	return entries, len(nav.authors), nil
+}
 
-	total := len(tagList)
-	if offset >= total {
-		return nil, total, nil
+// AuthorsByLetter returns the authors whose sort name (or name) is listed
+// under letter, with their book counts. It implements catalog.AuthorIndexer.
+func (b *Backend) AuthorsByLetter(letter string, offset, limit int) ([]catalog.AuthorEntry, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	nav := b.navLists()
+	var names []string
+	for _, name := range nav.authors {
+		if nav.authorLetters[name] == letter {
+			names = append(names, name)
+		}
 	}
-	end := offset + limit
-	if end > total {
-		end = total
+	var entries []catalog.AuthorEntry
+	for _, name := range pageOf(names, offset, limit) {
+		entries = append(entries, catalog.AuthorEntry{Name: name, Count: nav.authorCounts[name]})
 	}
-	return tagList[offset:end], total, nil
+	return entries, len(names), nil
 }
 
-// Publishers returns all distinct non-empty publisher names sorted alphabetically with pagination.
-func (b *Backend) Publishers(offset, limit int) ([]string, int, error) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+// SetAuthorAlias makes alias a pseudonym of author. It implements
+// catalog.AuthorAliaser.
+func (b *Backend) SetAuthorAlias(alias, author string) error {
+	aliasKey := catalog.AuthorKey(alias)
+	if aliasKey == "" {
+		return fmt.Errorf("alias is required")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	pubList := make([]string, 0, len(b.publishers))
-	for p := range b.publishers {
-		pubList = append(pubList, p)
+	if author == "" {
+		delete(b.aliases, aliasKey)
+	} else {
+		if a, ok := b.aliases[catalog.AuthorKey(author)]; ok {
+			author = a.Author
+		}
+		authorKey := catalog.AuthorKey(author)
+		if authorKey == aliasKey {
+			return fmt.Errorf("%q cannot be an alias of itself", alias)
+		}
+		for key, a := range b.aliases {
+			if catalog.AuthorKey(a.Author) == aliasKey {
+				a.Author = author
+				b.aliases[key] = a
+			}
+		}
+		b.aliases[aliasKey] = authorAlias{Alias: strings.Join(strings.Fields(alias), " "), Author: strings.Join(strings.Fields(author), " ")}
 	}
-	sort.Strings(pubList)
+	// No book changes, but the author feeds differ.
+	b.changedAt = time.Now()
+	b.invalidateNavLocked()
+	return b.saveAliases()
+}
 
-	total := len(pubList)
-	if offset >= total {
-		return nil, total, nil
+// canonicalAuthorKey returns the key of the author the author with the
+// given key is an alias of, or key itself. b.mu must be held.
+func (b *Backend) canonicalAuthorKey(key string) string {
+	if a, ok := b.aliases[key]; ok {
+		return catalog.AuthorKey(a.Author)
+	}
+	return key
+}
+
+// authorGroupIDs returns the IDs of the books of the canonical author with
+// the given key and of its aliases, in catalog order. b.mu must be held.
+func (b *Backend) authorGroupIDs(key string) []string {
+	members := []string{key}
+	for aliasKey, a := range b.aliases {
+		if catalog.AuthorKey(a.Author) == key {
+			members = append(members, aliasKey)
+		}
+	}
+	if len(members) == 1 {
+		return b.authors[key]
+	}
+	in := make(map[string]bool)
+	for _, k := range members {
+		for _, id := range b.authors[k] {
+			in[id] = true
+		}
+	}
+	ids := make([]string, 0, len(in))
+	for _, bk := range b.books {
+		if in[bk.ID] {
+			ids = append(ids, bk.ID)
+		}
+	}
+	return ids
+}
+
+// authorSortName returns the sort name recorded for the author with the
+// given key on any of the given books, or its display name. b.mu must be
+// held.
+func (b *Backend) authorSortName(key string, ids []string) string {
+	for _, id := range ids {
+		bk, ok := b.byID[id]
+		if !ok {
+			continue
+		}
+		for _, a := range bk.Authors {
+			if a.SortName != "" && catalog.AuthorKey(a.Name) == key {
+				return a.SortName
+			}
+		}
+	}
+	return b.authorDisplayName(key, ids)
+}
+
+// authorDisplayName returns the spelling of the author with the given key
+// that most of the given books use, the first in byte order on a tie, with
+// its whitespace collapsed. b.mu must be held.
+func (b *Backend) authorDisplayName(key string, ids []string) string {
+	counts := make(map[string]int)
+	for _, id := range ids {
+		if bk, ok := b.byID[id]; ok {
+			for _, a := range bk.Authors {
+				if catalog.AuthorKey(a.Name) == key {
+					counts[strings.Join(strings.Fields(a.Name), " ")]++
+				}
+			}
+		}
+	}
+	best := ""
+	for name, n := range counts {
+		if best == "" || n > counts[best] || n == counts[best] && name < best {
+			best = name
+		}
+	}
+	return best
+}
+
+// indexBook adds bk to the author, tag and publisher indexes behind the
+// listings. Hidden books are left out of them.
+func indexBook(authors, tags, publishers map[string][]string, bk *catalog.Book) {
+	if bk.Hidden {
+		return
+	}
+	indexAuthors(authors, bk)
+	for _, t := range bk.Tags {
+		tags[t] = append(tags[t], bk.ID)
+	}
+	if bk.Publisher != "" {
+		publishers[bk.Publisher] = append(publishers[bk.Publisher], bk.ID)
+	}
+}
+
+// indexAuthors adds bk to index under the catalog.AuthorKey of each of its
+// authors, once per key.
+func indexAuthors(index map[string][]string, bk *catalog.Book) {
+	for _, a := range bk.Authors {
+		key := catalog.AuthorKey(a.Name)
+		if !slices.Contains(index[key], bk.ID) {
+			index[key] = append(index[key], bk.ID)
+		}
+	}
+}
+
+// Tags returns all distinct tags sorted case-insensitively, with
+// pagination.
+func (b *Backend) Tags(offset, limit int) ([]string, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	tags := b.navLists().tags
+	return pageOf(tags, offset, limit), len(tags), nil
+}
+
+// TagsWithCounts is Tags with the number of books carrying each tag. It
+// implements catalog.TagCounter.
+func (b *Backend) TagsWithCounts(offset, limit int) ([]catalog.TagEntry, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	nav := b.navLists()
+	var entries []catalog.TagEntry
+	for _, t := range pageOf(nav.tags, offset, limit) {
+		entries = append(entries, catalog.TagEntry{Name: t, Count: nav.tagCounts[t]})
+	}
+	return entries, len(nav.tags), nil
+}
+
+// RelatedTags returns the tags co-occurring with tag on visible books, most
+// shared books first. It implements catalog.TagRelater.
+func (b *Backend) RelatedTags(tag string, limit int) ([]catalog.TagEntry, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, id := range b.tags[tag] {
+		seen := map[string]bool{tag: true}
+		for _, t := range b.byID[id].Tags {
+			if !seen[t] {
+				seen[t] = true
+				counts[t]++
+			}
+		}
+	}
+	entries := make([]catalog.TagEntry, 0, len(counts))
+	for t, n := range counts {
+		entries = append(entries, catalog.TagEntry{Name: t, Count: n})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, c := entries[i], entries[j]
+		if a.Count != c.Count {
+			return a.Count > c.Count
+		}
+		if la, lc := strings.ToLower(a.Name), strings.ToLower(c.Name); la != lc {
+			return la < lc
+		}
+		return a.Name < c.Name
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// navIndex holds the sorted author and tag lists of the navigation feeds
+// and the number of books listed under each name.
+type navIndex struct {
+	authors      []string
+	tags         []string
+	authorCounts map[string]int
+	tagCounts    map[string]int
+
+	authorLetters map[string]string // author -> catalog.IndexLetter of its sort name
+}
+
+// navLists returns the sorted author and tag lists, building them on first
+// use after a change to the catalog so that paging through the navigation
+// feeds does not sort every name on each request. b.mu must be held.
+func (b *Backend) navLists() *navIndex {
+	b.navMu.Lock()
+	defer b.navMu.Unlock()
+	if b.nav != nil {
+		return b.nav
+	}
+
+	// Aliases are listed under their canonical author, named as printed
+	// on its own books or, when it has none, as the alias was defined.
+	groups := make(map[string]bool, len(b.authors))
+	for key, ids := range b.authors {
+		if len(ids) > 0 {
+			groups[b.canonicalAuthorKey(key)] = true
+		}
+	}
+	nav := &navIndex{
+		authors:       make([]string, 0, len(groups)),
+		authorCounts:  make(map[string]int, len(groups)),
+		authorLetters: make(map[string]string, len(groups)),
+		tagCounts:     make(map[string]int, len(b.tags)),
+	}
+	keys := make(map[string]string, len(groups))
+	for key := range groups {
+		var name, sortName string
+		if ids := b.authors[key]; len(ids) > 0 {
+			name = b.authorDisplayName(key, ids)
+			sortName = b.authorSortName(key, ids)
+		} else {
+			for _, a := range b.aliases {
+				if catalog.AuthorKey(a.Author) == key && (name == "" || a.Author < name) {
+					name, sortName = a.Author, a.Author
+				}
+			}
+		}
+		nav.authors = append(nav.authors, name)
+		nav.authorCounts[name] = len(b.authorGroupIDs(key))
+		nav.authorLetters[name] = catalog.IndexLetter(sortName)
+		keys[name] = strings.ToLower(sortName)
+	}
+	sort.Slice(nav.authors, func(i, j int) bool {
+		if ki, kj := keys[nav.authors[i]], keys[nav.authors[j]]; ki != kj {
+			return ki < kj
+		}
+		return nav.authors[i] < nav.authors[j]
+	})
+
+	nav.tags = make([]string, 0, len(b.tags))
+	for t, ids := range b.tags {
+		if len(ids) > 0 {
+			nav.tags = append(nav.tags, t)
+			nav.tagCounts[t] = len(ids)
+		}
+	}
+	sort.Slice(nav.tags, func(i, j int) bool {
+		if li, lj := strings.ToLower(nav.tags[i]), strings.ToLower(nav.tags[j]); li != lj {
+			return li < lj
+		}
+		return nav.tags[i] < nav.tags[j]
+	})
+
+	b.nav = nav
+	return nav
+}
+
+// invalidateNavLocked drops the sorted navigation lists after the author
+// or tag index changed. b.mu must be held for writing.
+func (b *Backend) invalidateNavLocked() {
+	b.nav = nil
+}
+
+// pageOf returns a copy of the page of names starting at offset, so callers
+// cannot modify the cached list.
+func pageOf(names []string, offset, limit int) []string {
+	if offset >= len(names) || limit <= 0 {
+		return nil
+	}
+	return slices.Clone(names[offset:min(offset+limit, len(names))])
+}
+
+// Publishers returns all distinct non-empty publisher names sorted alphabetically with pagination.
+func (b *Backend) Publishers(offset, limit int) ([]string, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	pubList := make([]string, 0, len(b.publishers))
+	for p := range b.publishers {
+		pubList = append(pubList, p)
+	}
+	sort.Strings(pubList)
+
+	total := len(pubList)
+	if offset >= total {
+		return nil, total, nil
 	}
 	end := offset + limit
 	if end > total || limit == 0 {
@@ -685,7 +1561,7 @@ func (b *Backend) Series() ([]catalog.SeriesEntry, error) {
 	defer b.mu.RUnlock()
 
 	counts := make(map[string]int)
-	for _, bk := range b.books {
+	for _, bk := range b.visibleBooks() {
 		if bk.Series != "" {
 			counts[bk.Series]++
 		}
@@ -700,6 +1576,134 @@ func (b *Backend) Series() ([]catalog.SeriesEntry, error) {
 	return entries, nil
 }
 
+// Languages returns every distinct language tag, lower-cased, with the number
+// of books in each. It implements catalog.LanguageLister.
+func (b *Backend) Languages() ([]catalog.LanguageEntry, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, bk := range b.visibleBooks() {
+		if bk.Language != "" {
+			counts[strings.ToLower(bk.Language)]++
+		}
+	}
+	entries := make([]catalog.LanguageEntry, 0, len(counts))
+	for lang, count := range counts {
+		entries = append(entries, catalog.LanguageEntry{Language: lang, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Language < entries[j].Language
+	})
+	return entries, nil
+}
+
+// Decades returns every decade that contains at least one dated book, oldest
+// first, with the number of books in each. It implements catalog.YearBrowser.
+func (b *Backend) Decades() ([]catalog.DecadeEntry, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	counts := make(map[int]int)
+	for _, bk := range b.visibleBooks() {
+		if bk.PublishedAt.IsZero() {
+			continue
+		}
+		counts[(bk.PublishedAt.UTC().Year()/10)*10]++
+	}
+	entries := make([]catalog.DecadeEntry, 0, len(counts))
+	for decade, count := range counts {
+		entries = append(entries, catalog.DecadeEntry{Decade: decade, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Decade < entries[j].Decade
+	})
+	return entries, nil
+}
+
+// BooksByYearRange returns books published between the years from and to
+// (inclusive) ordered by publication date. It implements catalog.YearBrowser.
+func (b *Backend) BooksByYearRange(from, to, offset, limit int) ([]catalog.Book, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var matched []catalog.Book
+	for _, bk := range b.visibleBooks() {
+		if bk.PublishedAt.IsZero() {
+			continue
+		}
+		if y := bk.PublishedAt.UTC().Year(); y >= from && y <= to {
+			matched = append(matched, bk)
+		}
+	}
+	sortByTime(matched, false, func(bk catalog.Book) time.Time { return bk.PublishedAt })
+
+	total := len(matched)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return matched[offset:end], total, nil
+}
+
+// ResetPersonalData drops the read state, read date and rating overrides of
+// every book and persists .metadata.json. The fs backend does not track
+// reading progress. It implements catalog.PersonalDataResetter.
+func (b *Backend) ResetPersonalData() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for id, ov := range b.overrides {
+		if ov.IsRead == nil && ov.ReadAt == nil && ov.Rating == nil {
+			continue
+		}
+		ov.IsRead, ov.ReadAt, ov.Rating = nil, nil, nil
+		b.overrides[id] = ov
+		if bk, ok := b.byID[id]; ok {
+			bk.IsRead, bk.ReadAt, bk.Rating = false, time.Time{}, 0
+			bk.UpdatedAt = now
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, b.saveOverrides()
+}
+
+// PruneCovers removes cached covers of books that are no longer in the
+// catalog. It implements catalog.CoverPurger.
+func (b *Backend) PruneCovers(dryRun bool) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return epub.PurgeCovers(b.coversDir, func(id string) bool {
+		_, ok := b.byID[id]
+		return ok
+	}, dryRun)
+}
+
+// LastModified returns the latest UpdatedAt or AddedAt of the books and of
+// the last change recorded in changedAt. It implements catalog.LastModifier.
+func (b *Backend) LastModified() (time.Time, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	latest := b.changedAt
+	for _, bk := range b.books {
+		if bk.UpdatedAt.After(latest) {
+			latest = bk.UpdatedAt
+		}
+		if bk.AddedAt.After(latest) {
+			latest = bk.AddedAt
+		}
+	}
+	return latest, nil
+}
+
 // DeleteBook removes the book with the given ID from the catalog and deletes
 // its file(s) and cover image from disk. It implements catalog.Deleter.
 func (b *Backend) DeleteBook(id string) error {
@@ -714,11 +1718,13 @@ func (b *Backend) DeleteBook(id string) error {
 	// Delete each associated file.
 	for _, f := range bk.Files {
 		_ = os.Remove(f.Path)
+		if b.removeEmptyDirs {
+			epub.RemoveEmptyDirs(b.root, filepath.Dir(f.Path))
+		}
 	}
 
-	// Delete the cached cover image if it exists.
-	coverPath := filepath.Join(b.coversDir, id+".jpg")
-	_ = os.Remove(coverPath)
+	// Delete the cached cover image, whatever its type, and its thumbnail.
+	epub.RemoveCovers(b.coversDir, id)
 
 	// Remove from in-memory indexes.
 	for name, ids := range b.authors {
@@ -730,6 +1736,7 @@ func (b *Backend) DeleteBook(id string) error {
 	for pub, ids := range b.publishers {
 		b.publishers[pub] = removeID(ids, id)
 	}
+	b.invalidateNavLocked()
 	delete(b.byID, id)
 	for i, bk := range b.books {
 		if bk.ID == id {
@@ -737,29 +1744,107 @@ func (b *Backend) DeleteBook(id string) error {
 			break
 		}
 	}
+	b.changedAt = time.Now()
+	b.reindexLocked()
 
 	// Remove override entry and persist.
 	delete(b.overrides, id)
 	_ = b.saveOverrides()
 
+	for i := range b.lists {
+		b.lists[i].BookIDs = removeID(b.lists[i].BookIDs, id)
+	}
+	_ = b.saveLists()
+
+	if _, ok := b.downloads[id]; ok {
+		delete(b.downloads, id)
+		_ = b.saveDownloads()
+	}
+	if _, ok := b.added[id]; ok {
+		delete(b.added, id)
+		_ = b.saveAdded()
+	}
+
 	return nil
 }
 
+// RecordDownload adds one to the download count of a book and persists it.
+// It implements catalog.DownloadCounter.
+func (b *Backend) RecordDownload(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.byID[id]; !ok {
+		return fmt.Errorf("book %q not found", id)
+	}
+	b.downloads[id]++
+	return b.saveDownloads()
+}
+
+// DownloadCount returns how many times a book has been downloaded. It
+// implements catalog.DownloadCounter.
+func (b *Backend) DownloadCount(id string) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if _, ok := b.byID[id]; !ok {
+		return 0, fmt.Errorf("book %q not found", id)
+	}
+	return b.downloads[id], nil
+}
+
+// PopularBooks returns the downloaded books, most downloaded first. It
+// implements catalog.DownloadCounter.
+func (b *Backend) PopularBooks(offset, limit int) ([]catalog.Book, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var books []catalog.Book
+	for id, n := range b.downloads {
+		if bk, ok := b.byID[id]; ok && n > 0 && !bk.Hidden {
+			books = append(books, *bk)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		ni, nj := b.downloads[books[i].ID], b.downloads[books[j].ID]
+		if ni != nj {
+			return ni > nj
+		}
+		if !books[i].AddedAt.Equal(books[j].AddedAt) {
+			return books[i].AddedAt.After(books[j].AddedAt)
+		}
+		return books[i].ID < books[j].ID
+	})
+	total := len(books)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total || limit <= 0 {
+		end = total
+	}
+	return books[offset:end], total, nil
+}
+
 // StoreBook writes src to the backend's root directory as filename, then
 // parses and indexes it immediately. It implements catalog.Uploader.
 func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book, error) {
 	defer src.Close()
 
-	filename = filepath.Base(filename)
-	ext := strings.ToLower(filepath.Ext(filename))
+	// A leading dot would hide the file from the next scan.
+	filename = strings.TrimLeft(filepath.Base(filename), ".")
+	ext := epub.FileExt(filename)
 	switch ext {
-	case ".epub", ".pdf":
+	case ".epub", ".kepub.epub", ".pdf", ".mobi", ".azw3", ".fb2", ".fb2.zip", ".cbz", ".cbr":
 	default:
-		return nil, fmt.Errorf("unsupported file type %q (only .epub and .pdf are accepted)", ext)
+		return nil, fmt.Errorf("unsupported file type %q (only .epub, .pdf, .mobi, .azw3, .fb2, .fb2.zip, .cbz and .cbr are accepted)", ext)
 	}
 
 	destPath := filepath.Join(b.root, filename)
-	if _, err := os.Stat(destPath); err == nil {
+	if b.organizeUploads {
+		// Staging name only; the file moves once its metadata is known.
+		destPath = epub.UniquePath(destPath)
+	} else if _, err := os.Stat(destPath); err == nil {
 		return nil, fmt.Errorf("file %q already exists in the catalog", filename)
 	}
 
@@ -770,7 +1855,7 @@ func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book,
 	tmpPath := tmp.Name()
 	defer func() { _ = os.Remove(tmpPath) }()
 
-	if _, err := io.Copy(tmp, src); err != nil {
+	if _, err := catalog.CopyLimited(tmp, src, b.maxUploadSize); err != nil {
 		tmp.Close()
 		return nil, fmt.Errorf("write upload: %w", err)
 	}
@@ -782,35 +1867,240 @@ func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book,
 		return nil, fmt.Errorf("rename upload: %w", err)
 	}
 
-	var book catalog.Book
-	switch ext {
-	case ".epub":
-		book, err = epub.ParseBook(destPath, b.coversDir)
-		if err != nil {
-			return nil, fmt.Errorf("parse epub %q: %w", filename, err)
+	book, err := bookfile.ParseBook(destPath, b.coversDir, b.epubOpts)
+	if err != nil {
+		return nil, err
+	}
+	if b.organizeUploads {
+		if book, err = bookfile.OrganizeUpload(b.root, b.coversDir, destPath, book, b.epubOpts); err != nil {
+			return nil, err
 		}
-	case ".pdf":
-		book = epub.ParsePath(destPath)
+	}
+
+	var sum string
+	if b.dedupeByHash {
+		sum = b.contentHash(book.Files[0].Path)
 	}
 
 	b.mu.Lock()
+	if owner := b.hashOwnerLocked(sum); owner != nil {
+		// A copy of a book already in the catalog: the upload becomes one
+		// of its formats, as the next scan would make it.
+		owner.Files = append(owner.Files, book.Files...)
+		b.changedAt = time.Now()
+		bk := *owner
+		b.mu.Unlock()
+		return &bk, nil
+	}
+	if b.epubOpts.AddedAt == epub.AddedAtIndexing {
+		if _, ok := b.added[book.ID]; !ok {
+			b.added[book.ID] = book.AddedAt
+			_ = b.saveAdded()
+		}
+	}
 	if ov, ok := b.overrides[book.ID]; ok {
 		book = mergeOverride(book, ov)
 	}
-	// Prepend so the new book appears first in the default (newest-first) order.
-	b.books = append([]catalog.Book{book}, b.books...)
-	bk := &b.books[0]
-	b.byID[bk.ID] = bk
+	// Insert at its place in the default (newest-first) order, which an
+	// upload does not always head: the file may keep an older mtime.
+	i := sort.Search(len(b.books), func(i int) bool { return newerFirst(book, b.books[i]) })
+	b.books = slices.Insert(b.books, i, book)
+	b.reindexLocked()
+	bk := &b.books[i]
+	indexBook(b.authors, b.tags, b.publishers, bk)
+	b.invalidateNavLocked()
+	b.mu.Unlock()
+
+	return bk, nil
+}
+
+// hashOwnerLocked returns the book one of whose files has content hash sum,
+// or nil if there is none or sum is empty. b.mu must be held.
+func (b *Backend) hashOwnerLocked(sum string) *catalog.Book {
+	if sum == "" {
+		return nil
+	}
+	b.hashMu.Lock()
+	defer b.hashMu.Unlock()
+	for i := range b.books {
+		for _, f := range b.books[i].Files {
+			if h, ok := b.hashes[f.Path]; ok && h.sum == sum {
+				return &b.books[i]
+			}
+		}
+	}
+	return nil
+}
+
+// CreateList creates an empty reading list. It implements catalog.ListManager.
+func (b *Backend) CreateList(name string) (*catalog.List, error) {
+	id, err := newListID()
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rl := readingList{ID: id, Name: name, CreatedAt: time.Now().Truncate(time.Second)}
+	b.lists = append(b.lists, rl)
+	if err := b.saveLists(); err != nil {
+		b.lists = b.lists[:len(b.lists)-1]
+		return nil, err
+	}
+	l := b.toList(rl)
+	return &l, nil
+}
+
+// Lists returns all reading lists sorted by name. It implements catalog.ListManager.
+func (b *Backend) Lists() ([]catalog.List, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	lists := make([]catalog.List, 0, len(b.lists))
+	for _, rl := range b.lists {
+		lists = append(lists, b.toList(rl))
+	}
+	sort.SliceStable(lists, func(i, j int) bool {
+		return strings.ToLower(lists[i].Name) < strings.ToLower(lists[j].Name)
+	})
+	return lists, nil
+}
+
+// ListByID returns a single reading list. It implements catalog.ListManager.
+func (b *Backend) ListByID(id string) (*catalog.List, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rl := b.findList(id)
+	if rl == nil {
+		return nil, fmt.Errorf("list %q not found", id)
+	}
+	l := b.toList(*rl)
+	return &l, nil
+}
+
+// AddToList appends a book to a reading list; re-adding is a no-op.
+// It implements catalog.ListManager.
+func (b *Backend) AddToList(listID, bookID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rl := b.findList(listID)
+	if rl == nil {
+		return fmt.Errorf("list %q not found", listID)
+	}
+	if _, ok := b.byID[bookID]; !ok {
+		return fmt.Errorf("book %q not found", bookID)
+	}
+	for _, id := range rl.BookIDs {
+		if id == bookID {
+			return nil
+		}
+	}
+	rl.BookIDs = append(rl.BookIDs, bookID)
+	if err := b.saveLists(); err != nil {
+		rl.BookIDs = rl.BookIDs[:len(rl.BookIDs)-1]
+		return err
+	}
+	return nil
+}
+
+// ListBooks returns the books on a reading list in the order they were
+// added; entries whose file has disappeared or that are hidden are skipped. It implements
+// catalog.ListManager.
+func (b *Backend) ListBooks(listID string, offset, limit int) ([]catalog.Book, int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rl := b.findList(listID)
+	if rl == nil {
+		return nil, 0, fmt.Errorf("list %q not found", listID)
+	}
+	var books []catalog.Book
+	for _, id := range rl.BookIDs {
+		if bk, ok := b.byID[id]; ok && !bk.Hidden {
+			books = append(books, *bk)
+		}
+	}
+	total := len(books)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total || limit <= 0 {
+		end = total
+	}
+	return books[offset:end], total, nil
+}
+
+// findList returns a pointer into b.lists, or nil. b.mu must be held.
+func (b *Backend) findList(id string) *readingList {
+	for i := range b.lists {
+		if b.lists[i].ID == id {
+			return &b.lists[i]
+		}
+	}
+	return nil
+}
+
+// toList converts a stored list, counting only books still in the catalog
+// and not hidden. b.mu must be held.
+func (b *Backend) toList(rl readingList) catalog.List {
+	l := catalog.List{ID: rl.ID, Name: rl.Name, CreatedAt: rl.CreatedAt}
+	for _, id := range rl.BookIDs {
+		if bk, ok := b.byID[id]; ok && !bk.Hidden {
+			l.BookCount++
+		}
+	}
+	return l
+}
+
+// newListID returns a random 16-character hex identifier for a list.
+func newListID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate list id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// matchesAuthor reports whether any author name contains qLower.
+func matchesAuthor(bk catalog.Book, qLower string) bool {
 	for _, a := range bk.Authors {
-		b.authors[a.Name] = append(b.authors[a.Name], bk.ID)
+		if strings.Contains(strings.ToLower(a.Name), qLower) {
+			return true
+		}
 	}
-	for _, t := range bk.Tags {
-		b.tags[t] = append(b.tags[t], bk.ID)
+	return false
+}
+
+// matchesIdentifier reports whether any identifier contains qLower, with
+// hyphens ignored on both sides so that ISBNs match however they are typed.
+func matchesIdentifier(bk catalog.Book, qLower string) bool {
+	q := strings.ReplaceAll(qLower, "-", "")
+	if q == "" {
+		return false
 	}
-	if bk.Publisher != "" {
-		b.publishers[bk.Publisher] = append(b.publishers[bk.Publisher], bk.ID)
+	for _, v := range bk.Identifiers {
+		if strings.Contains(strings.ReplaceAll(strings.ToLower(v), "-", ""), q) {
+			return true
+		}
 	}
-	b.mu.Unlock()
+	return false
+}
 
-	return bk, nil
+// skipHidden reports whether a scan skips the file or directory called
+// name: a dotfile or dot-directory, unless ScanHiddenFiles is set.
+func (b *Backend) skipHidden(name string) bool {
+	return !b.scanHiddenFiles && strings.HasPrefix(name, ".")
+}
+
+// sameDir reports whether a and b refer to the same directory path.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
 }
@@ -3,9 +3,14 @@ package fs
 import (
 	"archive/zip"
 	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/banux/nxt-opds/internal/catalog"
 	"github.com/banux/nxt-opds/internal/epub"
@@ -192,6 +197,145 @@ func TestBackend_AuthorsAndTags(t *testing.T) {
 	_ = tags
 }
 
+func TestBackend_AuthorsAndTags_Counts(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author One", "SciFi")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author One", "SciFi")
+	createMinimalEPUB(t, filepath.Join(dir, "c.epub"), "Book C", "Author Two", "Fantasy")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	authors, total, err := b.AuthorsWithCounts(0, 50)
+	if err != nil {
+		t.Fatalf("AuthorsWithCounts() error: %v", err)
+	}
+	want := []catalog.AuthorEntry{{Name: "Author One", Count: 2}, {Name: "Author Two", Count: 1}}
+	if total != 2 || !reflect.DeepEqual(authors, want) {
+		t.Errorf("AuthorsWithCounts() = %v (total %d), want %v", authors, total, want)
+	}
+
+	tags, total, err := b.TagsWithCounts(0, 50)
+	if err != nil {
+		t.Fatalf("TagsWithCounts() error: %v", err)
+	}
+	wantTags := []catalog.TagEntry{{Name: "Fantasy", Count: 1}, {Name: "SciFi", Count: 2}}
+	if total != 2 || !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("TagsWithCounts() = %v (total %d), want %v", tags, total, wantTags)
+	}
+}
+
+func TestBackend_AuthorsAndTags_Paging(t *testing.T) {
+	const n = 300
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		// Descending numbers make the scan order differ from the sort order;
+		// alternating case checks that tags sort case-insensitively.
+		tag := fmt.Sprintf("genre %03d", n-i)
+		if i%2 == 0 {
+			tag = strings.ToUpper(tag)
+		}
+		createMinimalEPUB(t, filepath.Join(dir, fmt.Sprintf("%03d.epub", i)),
+			fmt.Sprintf("Book %03d", i), fmt.Sprintf("Author %03d", n-i), tag)
+	}
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	pages := func(list func(offset, limit int) ([]string, int, error)) []string {
+		t.Helper()
+		var all []string
+		for offset := 0; ; offset += 70 {
+			page, total, err := list(offset, 70)
+			if err != nil || total != n {
+				t.Fatalf("offset %d: total %d, err %v; want %d", offset, total, err, n)
+			}
+			if len(page) == 0 {
+				return all
+			}
+			all = append(all, page...)
+		}
+	}
+	authors := pages(b.Authors)
+	tags := pages(b.Tags)
+	for i := 0; i < n; i++ {
+		if want := fmt.Sprintf("Author %03d", i+1); authors[i] != want {
+			t.Fatalf("authors[%d] = %q, want %q", i, authors[i], want)
+		}
+		if want := fmt.Sprintf("genre %03d", i+1); !strings.EqualFold(tags[i], want) {
+			t.Fatalf("tags[%d] = %q, want %q", i, tags[i], want)
+		}
+	}
+
+	// Pages are copies of the cached list, which follows catalog changes.
+	page, _, _ := b.Authors(0, 1)
+	page[0] = "Mutated"
+	books, _, _ := b.BooksByAuthor("Author 001", 0, 1)
+	if _, err := b.UpdateBook(books[0].ID, catalog.BookUpdate{Authors: []string{"Zed Last"}, Tags: []string{}}); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+	page, total, _ := b.Authors(0, 1)
+	if total != n || page[0] != "Author 002" {
+		t.Errorf("after update: first author %v of %d, want Author 002 of %d", page, total, n)
+	}
+	if last, _, _ := b.Authors(n-1, 10); len(last) != 1 || last[0] != "Zed Last" {
+		t.Errorf("after update: last author %v, want Zed Last", last)
+	}
+	if _, total, _ := b.Tags(0, 1); total != n-1 {
+		t.Errorf("after update: %d tags, want %d", total, n-1)
+	}
+}
+
+func TestBackend_PruneCovers(t *testing.T) {
+	dir := t.TempDir()
+	vanishing := filepath.Join(dir, "vanishing.epub")
+	createMinimalEPUB(t, vanishing, "Vanishing", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, "kept.epub"), "Kept", "Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	books, _, _ := b.AllBooks(0, 50)
+	coversDir := filepath.Join(dir, ".covers")
+	for _, bk := range books {
+		if err := b.UpdateCover(bk.ID, io.NopCloser(strings.NewReader("webp")), ".webp"); err != nil {
+			t.Fatalf("UpdateCover() error: %v", err)
+		}
+	}
+	orphan := filepath.Join(coversDir, "0123456789abcdef.png")
+	if err := os.WriteFile(orphan, []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.PruneCovers(true); err != nil || n != 1 {
+		t.Errorf("PruneCovers(dry run) = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("dry run removed the orphan: %v", err)
+	}
+
+	// Refresh prunes the orphan and the cover of the book whose file vanished.
+	if err := os.Remove(vanishing); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	for _, bk := range books {
+		_, err := os.Stat(filepath.Join(coversDir, bk.ID+".webp"))
+		if gone := bk.Title == "Vanishing"; gone != os.IsNotExist(err) {
+			t.Errorf("%s: cover removed = %v, want %v", bk.Title, os.IsNotExist(err), gone)
+		}
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphan cover survived Refresh")
+	}
+}
+
 func TestBackend_BooksByAuthor(t *testing.T) {
 	dir := t.TempDir()
 	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Common Author", "")
@@ -213,6 +357,60 @@ func TestBackend_BooksByAuthor(t *testing.T) {
 	_ = books
 }
 
+func TestBackend_AuthorAliases(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Richard Bachman", "")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Other Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	// An alias of an author without books of its own is listed under the
+	// name the alias was defined with.
+	if err := b.SetAuthorAlias("Richard Bachman", "Stephen King"); err != nil {
+		t.Fatalf("SetAuthorAlias() error: %v", err)
+	}
+	authors, total, err := b.Authors(0, 50)
+	if err != nil {
+		t.Fatalf("Authors() error: %v", err)
+	}
+	if total != 2 || strings.Join(authors, "|") != "Other Author|Stephen King" {
+		t.Errorf("Authors() = %q (total %d), want Other Author and Stephen King", authors, total)
+	}
+	if _, total, _ := b.BooksByAuthor("stephen king", 0, 50); total != 1 {
+		t.Errorf("BooksByAuthor(Stephen King): got %d books, want 1", total)
+	}
+
+	// Making the canonical author an alias moves its aliases along.
+	if err := b.SetAuthorAlias("Stephen King", "Other Author"); err != nil {
+		t.Fatalf("SetAuthorAlias() error: %v", err)
+	}
+	if err := b.SetAuthorAlias("Other Author", "Richard Bachman"); err == nil {
+		t.Error("expected an error aliasing an author to its own alias")
+	}
+
+	b2, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	authors, total, err = b2.Authors(0, 50)
+	if err != nil {
+		t.Fatalf("Authors() error: %v", err)
+	}
+	if total != 1 || strings.Join(authors, "|") != "Other Author" {
+		t.Errorf("Authors() after reopening = %q (total %d), want Other Author", authors, total)
+	}
+	books, total, err := b2.BooksByAuthor("Richard Bachman", 0, 50)
+	if err != nil {
+		t.Fatalf("BooksByAuthor() error: %v", err)
+	}
+	if total != 2 || len(books) != 2 {
+		t.Errorf("BooksByAuthor(Richard Bachman): got %d books, want 2", total)
+	}
+}
+
 func TestBackend_Pagination(t *testing.T) {
 	dir := t.TempDir()
 	for i := 0; i < 5; i++ {
@@ -258,3 +456,531 @@ func TestPathToID_Stable(t *testing.T) {
 		t.Error("different paths produced same ID")
 	}
 }
+
+func TestBackend_RetriesPartiallyCopiedFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// Build a complete EPUB elsewhere, then write only its first half into
+	// the books dir to simulate a copy still in progress.
+	full := filepath.Join(t.TempDir(), "full.epub")
+	createMinimalEPUB(t, full, "Late Arrival", "Author", "")
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "late.epub")
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewWithOptions(dir, Options{PendingRetryDelay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+	if _, total, _ := b.AllBooks(0, 10); total != 0 {
+		t.Fatalf("expected truncated file to be skipped, got %d books", total)
+	}
+	if pending := b.Pending(); len(pending) != 1 || pending[0] != path {
+		t.Fatalf("Pending() = %v, want [%s]", pending, path)
+	}
+
+	// Finish the "copy"; the scheduled retry must pick it up on its own.
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		books, total, _ := b.AllBooks(0, 10)
+		if total == 1 && books[0].Title == "Late Arrival" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("file was not indexed by the automatic retry (total=%d)", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := b.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() after retry = %v, want empty", pending)
+	}
+}
+
+func TestBackend_PaginationStableForIdenticalTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("dup%02d.epub", i))
+		createMinimalEPUB(t, path, "Same Title", "Same Author", "Bulk")
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	pages := func() []string {
+		var ids []string
+		for offset := 0; offset < 12; offset += 5 {
+			books, _, err := b.AllBooks(offset, 5)
+			if err != nil {
+				t.Fatalf("AllBooks(%d) error: %v", offset, err)
+			}
+			for _, bk := range books {
+				ids = append(ids, bk.ID)
+			}
+		}
+		return ids
+	}
+
+	first := pages()
+	if len(first) != 12 {
+		t.Fatalf("expected 12 books across pages, got %d", len(first))
+	}
+	seen := map[string]bool{}
+	for i, id := range first {
+		if seen[id] {
+			t.Errorf("book %s returned on two pages", id)
+		}
+		seen[id] = true
+		if i > 0 && first[i-1] > id {
+			t.Errorf("ties not broken by ID: %s before %s", first[i-1], id)
+		}
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if again := pages(); strings.Join(again, ",") != strings.Join(first, ",") {
+		t.Errorf("order changed between requests:\n%v\n%v", first, again)
+	}
+}
+
+func TestBackend_DownloadCountsPersist(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	all, _, _ := b.AllBooks(0, 10)
+	ids := map[string]string{}
+	for _, bk := range all {
+		ids[bk.Title] = bk.ID
+	}
+	idA, idB := ids["Book A"], ids["Book B"]
+	for _, id := range []string{idB, idA, idB} {
+		if err := b.RecordDownload(id); err != nil {
+			t.Fatalf("RecordDownload(%s): %v", id, err)
+		}
+	}
+	if err := b.RecordDownload("missing"); err == nil {
+		t.Error("RecordDownload on unknown book: expected an error")
+	}
+
+	// Counts survive a restart through .downloads.json.
+	b, err = New(dir)
+	if err != nil {
+		t.Fatalf("New() after restart: %v", err)
+	}
+	if n, err := b.DownloadCount(idB); err != nil || n != 2 {
+		t.Errorf("DownloadCount(b) = %d, %v; want 2", n, err)
+	}
+	books, total, err := b.PopularBooks(0, 10)
+	if err != nil {
+		t.Fatalf("PopularBooks: %v", err)
+	}
+	if total != 2 || len(books) != 2 || books[0].ID != idB || books[1].ID != idA {
+		t.Errorf("PopularBooks = %v (total %d); want [b a]", books, total)
+	}
+}
+
+func TestBackend_HiddenPersists(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author A", "Drafts")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author B", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	res, _, _ := b.Search(catalog.SearchQuery{Query: "Book A"})
+	if len(res) != 1 {
+		t.Fatalf("Search(Book A) = %d books, want 1", len(res))
+	}
+	id := res[0].ID
+	hidden := true
+	if _, err := b.UpdateBook(id, catalog.BookUpdate{Hidden: &hidden}); err != nil {
+		t.Fatalf("UpdateBook: %v", err)
+	}
+
+	// The override survives a restart through .metadata.json.
+	b, err = New(dir)
+	if err != nil {
+		t.Fatalf("New() after restart: %v", err)
+	}
+	if bk, err := b.BookByID(id); err != nil || !bk.Hidden {
+		t.Fatalf("BookByID after restart = %+v, %v; want a hidden book", bk, err)
+	}
+	if books, total, _ := b.AllBooks(0, 10); total != 1 || books[0].Title != "Book B" {
+		t.Errorf("AllBooks = %d books (total %d), want only Book B", len(books), total)
+	}
+	if tags, _, _ := b.Tags(0, 10); len(tags) != 0 {
+		t.Errorf("Tags = %v, want none", tags)
+	}
+	if _, total, _ := b.Search(catalog.SearchQuery{IncludeHidden: true}); total != 2 {
+		t.Errorf("Search with IncludeHidden: total %d, want 2", total)
+	}
+}
+
+func TestBackend_RefreshWithStats(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	refresh := func(want catalog.RefreshStats) {
+		t.Helper()
+		stats, err := b.RefreshWithStats()
+		if err != nil {
+			t.Fatalf("RefreshWithStats() error: %v", err)
+		}
+		if stats != want {
+			t.Errorf("RefreshWithStats() = %+v, want %+v", stats, want)
+		}
+	}
+	refresh(catalog.RefreshStats{})
+
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, "c.epub"), "Book C", "Author", "")
+	refresh(catalog.RefreshStats{Added: 2})
+
+	if err := os.Remove(filepath.Join(dir, "a.epub")); err != nil {
+		t.Fatal(err)
+	}
+	refresh(catalog.RefreshStats{Removed: 1})
+}
+
+func TestBackend_SkipsHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "visible.epub"), "Visible", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, ".hidden.epub"), "Dotfile", "Author", "")
+	if err := os.Mkdir(filepath.Join(dir, ".staging"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMinimalEPUB(t, filepath.Join(dir, ".staging", "book.epub"), "Staged", "Author", "")
+
+	for _, tc := range []struct {
+		scanHidden bool
+		want       int
+	}{
+		{false, 1},
+		{true, 3},
+	} {
+		b, err := NewWithOptions(dir, Options{ScanHiddenFiles: tc.scanHidden})
+		if err != nil {
+			t.Fatalf("NewWithOptions() error: %v", err)
+		}
+		books, total, _ := b.AllBooks(0, 10)
+		if total != tc.want {
+			t.Errorf("ScanHiddenFiles %v: got %d books, want %d", tc.scanHidden, total, tc.want)
+		}
+		if !tc.scanHidden && total == 1 && books[0].Title != "Visible" {
+			t.Errorf("ScanHiddenFiles false: indexed %q, want only Visible", books[0].Title)
+		}
+	}
+}
+
+func TestBackend_RefreshReparsesChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createMinimalEPUB(t, path, "Old Title", "Author", "Fiction")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	books, _, _ := b.AllBooks(0, 10)
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book, got %d", len(books))
+	}
+	id := books[0].ID
+	tags, rating := []string{"Edited"}, 4
+	if _, err := b.UpdateBook(id, catalog.BookUpdate{Tags: tags, Rating: &rating}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+
+	// Rewrite the file with new metadata, as an external editor would.
+	createMinimalEPUB(t, path, "New Title", "Author", "Fiction")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := b.RefreshWithStats()
+	if err != nil {
+		t.Fatalf("RefreshWithStats() error: %v", err)
+	}
+	if want := (catalog.RefreshStats{Updated: 1}); stats != want {
+		t.Errorf("RefreshWithStats() = %+v, want %+v", stats, want)
+	}
+
+	bk, err := b.BookByID(id)
+	if err != nil {
+		t.Fatalf("BookByID() error: %v", err)
+	}
+	if bk.Title != "New Title" {
+		t.Errorf("Title = %q, want the re-parsed %q", bk.Title, "New Title")
+	}
+	if len(bk.Tags) != 1 || bk.Tags[0] != "Edited" {
+		t.Errorf("Tags = %v, want the edited [Edited]", bk.Tags)
+	}
+	if bk.Rating != 4 {
+		t.Errorf("Rating = %d, want 4", bk.Rating)
+	}
+
+	if stats, err := b.RefreshWithStats(); err != nil || stats != (catalog.RefreshStats{}) {
+		t.Errorf("unchanged RefreshWithStats() = %+v, %v; want no changes", stats, err)
+	}
+}
+
+func TestBackend_AddedAtIndexing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.epub")
+	createMinimalEPUB(t, path, "Restored Book", "Author", "")
+	old := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	opts := Options{EPUB: epub.Options{AddedAt: epub.AddedAtIndexing}}
+	b, err := NewWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+
+	books, _, _ := b.AllBooks(0, 10)
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book, got %d", len(books))
+	}
+	if books[0].AddedAt.Before(start) {
+		t.Errorf("AddedAt = %v, want the indexing time, not the file's %v", books[0].AddedAt, old)
+	}
+
+	// The date is kept by later scans and across restarts.
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	b2, err := NewWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen NewWithOptions() error: %v", err)
+	}
+	bk, err := b2.BookByID(books[0].ID)
+	if err != nil {
+		t.Fatalf("BookByID() error: %v", err)
+	}
+	if !bk.AddedAt.Equal(books[0].AddedAt) {
+		t.Errorf("AddedAt after restart = %v, want %v", bk.AddedAt, books[0].AddedAt)
+	}
+}
+
+func TestBackend_LastModified(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if lm, err := b.LastModified(); err != nil || !lm.IsZero() {
+		t.Fatalf("empty catalog: LastModified() = %v, %v; want zero time", lm, err)
+	}
+
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "")
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	added, err := b.LastModified()
+	if err != nil || added.IsZero() {
+		t.Fatalf("after add: LastModified() = %v, %v; want non-zero", added, err)
+	}
+
+	books, _, _ := b.AllBooks(0, 1)
+	before := time.Now()
+	if err := b.DeleteBook(books[0].ID); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if lm, _ := b.LastModified(); lm.Before(before) {
+		t.Errorf("after delete: LastModified() = %v, want >= %v", lm, before)
+	}
+
+	// A book added with dates older than the last change still moves
+	// LastModified forward.
+	old := filepath.Join(dir, "old.epub")
+	createMinimalEPUB(t, old, "Old Book", "Author",
+		`</dc:subject><meta property="dcterms:modified">2001-01-01T00:00:00Z</meta><dc:subject>`)
+	past := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+	before = time.Now().Truncate(time.Second)
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if lm, _ := b.LastModified(); lm.Before(before) {
+		t.Errorf("after adding an old book: LastModified() = %v, want >= %v", lm, before)
+	}
+}
+
+func TestBackend_ScanOptionsKeepSizesAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	want := make(map[string]os.FileInfo)
+	for i := range 12 {
+		path := filepath.Join(dir, fmt.Sprintf("doc%02d.pdf", i))
+		if i%3 == 0 {
+			path = filepath.Join(dir, fmt.Sprintf("book%02d.epub", i))
+			createMinimalEPUB(t, path, fmt.Sprintf("Book %d", i), "Author", "")
+		} else if err := os.WriteFile(path, make([]byte, 100*i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mod := time.Date(2021, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The listing describes a symbolic link, not its target: its size and
+	// time must still come from the target.
+	if err := os.Symlink(filepath.Join(dir, "doc01.pdf"), filepath.Join(dir, "link.pdf")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[path] = info
+	}
+
+	b, err := NewWithOptions(dir, Options{ScanConcurrency: 4, TrustDirListing: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	books, total, err := b.AllBooks(0, 100)
+	if err != nil {
+		t.Fatalf("AllBooks() error: %v", err)
+	}
+	if total != len(want) {
+		t.Fatalf("total = %d, want %d", total, len(want))
+	}
+	for _, bk := range books {
+		f := bk.Files[0]
+		info := want[f.Path]
+		if info == nil {
+			t.Errorf("unexpected book file %q", f.Path)
+			continue
+		}
+		if f.Size != info.Size() || !bk.AddedAt.Equal(info.ModTime()) {
+			t.Errorf("%s: size %d, added %v; want %d, %v", filepath.Base(f.Path), f.Size, bk.AddedAt, info.Size(), info.ModTime())
+		}
+	}
+}
+
+func TestBackend_DeleteBookRemovesEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "Fiction", "Asimov")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMinimalEPUB(t, filepath.Join(nested, "foundation.epub"), "Foundation", "Isaac Asimov", "")
+	createMinimalEPUB(t, filepath.Join(dir, "Fiction", "dune.epub"), "Dune", "Frank Herbert", "")
+
+	b, err := NewWithOptions(dir, Options{RemoveEmptyDirs: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	ids := map[string]string{}
+	books, _, _ := b.AllBooks(0, 10)
+	for _, bk := range books {
+		ids[bk.Title] = bk.ID
+	}
+
+	if err := b.DeleteBook(ids["Foundation"]); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction", "Asimov")); !os.IsNotExist(err) {
+		t.Errorf("Fiction/Asimov still exists after deleting its only book (err %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction")); err != nil {
+		t.Errorf("Fiction, which still holds a book, was removed: %v", err)
+	}
+
+	if err := b.DeleteBook(ids["Dune"]); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction")); !os.IsNotExist(err) {
+		t.Errorf("Fiction still exists after deleting its last book (err %v)", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("books directory removed: %v", err)
+	}
+}
+
+func TestBackend_DedupeByHash(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "a-dune.epub")
+	createMinimalEPUB(t, orig, "Dune", "Frank Herbert", "")
+	createMinimalEPUB(t, filepath.Join(dir, "c-other.epub"), "Other", "Someone Else", "")
+	data, err := os.ReadFile(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyTo := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyTo("b-dune-copy.epub")
+
+	b, err := NewWithOptions(dir, Options{DedupeByHash: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+
+	dune := func(wantFiles int) {
+		t.Helper()
+		books, total, err := b.AllBooks(0, 50)
+		if err != nil || total != 2 {
+			t.Fatalf("AllBooks() = %d books, err %v; want 2", total, err)
+		}
+		for _, bk := range books {
+			if bk.Title == "Dune" && len(bk.Files) != wantFiles {
+				t.Errorf("Dune has %d files, want %d: %+v", len(bk.Files), wantFiles, bk.Files)
+			}
+		}
+	}
+	dune(2)
+
+	// A copy found by a later scan joins the same book.
+	copyTo("d-dune-again.epub")
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	dune(3)
+
+	// The book outlives the removal of its first file.
+	if err := os.Remove(orig); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	dune(2)
+}
@@ -5,16 +5,24 @@
 package sqlite
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/banux/nxt-opds/internal/backend/bookfile"
 	"github.com/banux/nxt-opds/internal/catalog"
 	"github.com/banux/nxt-opds/internal/epub"
 	_ "modernc.org/sqlite" // register "sqlite" driver
@@ -22,19 +30,116 @@ import (
 
 const dbFilename = ".catalog.db"
 
+// The SQLite backend supports every optional catalog capability.
+var _ catalog.FullCatalog = (*Backend)(nil)
+
 // Backend is a SQLite-backed catalog backend.
 type Backend struct {
-	root      string
-	coversDir string
-	db        *sql.DB
+	root            string
+	coversDir       string
+	epubOpts        epub.Options
+	organizeUploads bool  // file uploads under Author/Series/Title
+	maxUploadSize   int64 // StoreBook rejects larger files; see catalog.UploadLimit
+	removeEmptyDirs bool  // DeleteBook removes the folders it empties
+	scanConcurrency int   // new files parsed at once by a scan
+	trustDirListing bool  // take file sizes and times from the scan's listing
+	dedupeByHash    bool  // attach files with known content to their book
+	scanHiddenFiles bool  // index dotfiles and files in dot-directories
+	searchCountCap  int   // matches Search counts at most; 0 = all
+	db              *sql.DB
+
+	mu                sync.Mutex // guards changedAt
+	pendingRetryDelay time.Duration
+	pending           bookfile.Pending
+	changedAt         time.Time // last change no book row dates record (not persisted)
+
+	backupLoc *time.Location
+	now       func() time.Time // clock used for backup names; replaced in tests
+
+	fts bool // books_fts exists; false when the sqlite build lacks FTS5
+}
+
+// Options holds optional settings for the SQLite backend.
+type Options struct {
+	// CoversDir is where extracted and uploaded cover images are stored.
+	// Defaults to {dir}/.covers when empty. If it cannot be written, covers
+	// are not cached and are read from the EPUB files when requested.
+	CoversDir string
+
+	// EPUB controls EPUB parsing (e.g. strict vs. lenient handling of
+	// malformed files).
+	EPUB epub.Options
+
+	// PendingRetryDelay is how long to wait before rescanning when some
+	// files could not be opened (e.g. they were still being copied).
+	// 0 disables the automatic retry; such files are then picked up by the
+	// next Refresh.
+	PendingRetryDelay time.Duration
+
+	// OrganizeUploads files uploaded books under Author/Series/Title.ext
+	// (see epub.OrganizedPath) instead of flat in the root directory.
+	OrganizeUploads bool
+
+	// MaxUploadSize is the largest file, in bytes, StoreBook accepts;
+	// longer ones fail with catalog.ErrTooLarge. 0 means
+	// catalog.DefaultMaxUploadSize and a negative value means no limit.
+	MaxUploadSize int64
+
+	// RemoveEmptyDirs makes DeleteBook also remove the folders the book's
+	// files leave empty, up to but excluding the root directory.
+	RemoveEmptyDirs bool
+
+	// ScanConcurrency is how many newly found files a scan parses at once,
+	// which hides the latency of network file systems. Values below 1
+	// mean 1.
+	ScanConcurrency int
+
+	// TrustDirListing takes file sizes and modification times from the
+	// directory listing a scan walks instead of a separate stat of every
+	// new file. Where listings carry this information (Windows, SMB
+	// shares) it saves a round trip per file.
+	TrustDirListing bool
+
+	// BackupLocation is the time zone used for backup file timestamps.
+	// Defaults to UTC when nil.
+	BackupLocation *time.Location
+
+	// ScanHiddenFiles indexes files whose name starts with a dot and those
+	// inside dot-directories, which scans skip by default: they are the
+	// catalog's own data (covers, backups) or temporary files of uploads
+	// and sync clients.
+	ScanHiddenFiles bool
+
+	// DedupeByHash stores the SHA-256 of every book file and attaches a
+	// newly found file whose content matches an indexed one to that book,
+	// as an extra format, instead of indexing it as a new book.
+	DedupeByHash bool
+
+	// SearchCountCap bounds how many matches SearchPage counts to compute
+	// its total, which saves scanning every match of broad queries on large
+	// catalogs. Beyond the cap the total is reported as approximate. Search,
+	// which also backs the plain listings, always counts exactly. 0 counts
+	// every match.
+	SearchCountCap int
 }
 
 // New opens (or creates) the SQLite catalog at {dir}/.catalog.db, applies
 // schema migrations, syncs the filesystem, and returns the Backend.
 func New(dir string) (*Backend, error) {
-	coversDir := filepath.Join(dir, ".covers")
-	if err := os.MkdirAll(coversDir, 0755); err != nil {
-		return nil, fmt.Errorf("create covers dir: %w", err)
+	return NewWithOptions(dir, Options{})
+}
+
+// NewWithOptions is like New but applies the given Options.
+func NewWithOptions(dir string, opts Options) (*Backend, error) {
+	coversDir := opts.CoversDir
+	if coversDir == "" {
+		coversDir = filepath.Join(dir, ".covers")
+	}
+	// A covers directory that cannot be written (e.g. a read-only mount) is
+	// detected once here; covers are then read from the books on demand.
+	epubOpts := opts.EPUB
+	if !epub.DirWritable(coversDir) {
+		epubOpts.InMemoryCovers = true
 	}
 
 	dbPath := filepath.Join(dir, dbFilename)
@@ -49,11 +154,34 @@ func New(dir string) (*Backend, error) {
 		return nil, fmt.Errorf("configure database: %w", err)
 	}
 
-	b := &Backend{root: dir, coversDir: coversDir, db: db}
+	b := &Backend{
+		root:              dir,
+		coversDir:         coversDir,
+		epubOpts:          epubOpts,
+		organizeUploads:   opts.OrganizeUploads,
+		maxUploadSize:     opts.MaxUploadSize,
+		removeEmptyDirs:   opts.RemoveEmptyDirs,
+		scanConcurrency:   max(opts.ScanConcurrency, 1),
+		trustDirListing:   opts.TrustDirListing,
+		dedupeByHash:      opts.DedupeByHash,
+		scanHiddenFiles:   opts.ScanHiddenFiles,
+		searchCountCap:    max(opts.SearchCountCap, 0),
+		db:                db,
+		pendingRetryDelay: opts.PendingRetryDelay,
+		backupLoc:         opts.BackupLocation,
+		now:               time.Now,
+	}
+	if b.backupLoc == nil {
+		b.backupLoc = time.UTC
+	}
 	if err := b.migrateSchema(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrate schema: %w", err)
 	}
+	if err := db.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE name = 'books_fts'`).Scan(&b.fts); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("check full-text index: %w", err)
+	}
 	if err := b.Refresh(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("initial scan: %w", err)
@@ -61,15 +189,16 @@ func New(dir string) (*Backend, error) {
 	return b, nil
 }
 
-// Close releases database resources.
+// Close cancels any scheduled pending retry and releases database resources.
 func (b *Backend) Close() error {
+	b.pending.Stop()
 	return b.db.Close()
 }
 
 // currentSchemaVersion is the latest schema version this binary expects.
 // Increment this constant and add a new entry to schemaMigrations whenever
 // the database schema changes.
-const currentSchemaVersion = 2
+const currentSchemaVersion = 19
 
 // schemaMigration describes a single, idempotent database migration.
 type schemaMigration struct {
@@ -82,13 +211,30 @@ type schemaMigration struct {
 var schemaMigrations = []schemaMigration{
 	{version: 1, apply: migration1},
 	{version: 2, apply: migration2},
+	{version: 3, apply: migration3},
+	{version: 4, apply: migration4},
+	{version: 5, apply: migration5},
+	{version: 6, apply: migration6},
+	{version: 7, apply: migration7},
+	{version: 8, apply: migration8},
+	{version: 9, apply: migration9},
+	{version: 10, apply: migration10},
+	{version: 11, apply: migration11},
+	{version: 12, apply: migration12},
+	{version: 13, apply: migration13},
+	{version: 14, apply: migration14},
+	{version: 15, apply: migration15},
+	{version: 16, apply: migration16},
+	{version: 17, apply: migration17},
+	{version: 18, apply: migration18},
+	{version: 19, apply: migration19},
 }
 
 // migration1 sets up the initial schema (version 0 → 1).
 // It uses CREATE TABLE IF NOT EXISTS so it is safe to run on an existing
 // pre-migration database (user_version was never set, so it is 0).
 // For pre-migration databases that may be missing columns added incrementally
-// (added_at, series_total, rating), it also attempts safe ALTER TABLE
+// (added_at, series_total, rating, …), it also attempts safe ALTER TABLE
 // statements; "duplicate column" errors are intentionally ignored.
 func migration1(db *sql.DB) error {
 	_, err := db.Exec(`
@@ -141,9 +287,14 @@ CREATE INDEX IF NOT EXISTS idx_books_added_at    ON books(added_at DESC);
 	// exist and these statements will return "duplicate column name" errors
 	// which are intentionally swallowed.
 	for _, alterSQL := range []string{
-		`ALTER TABLE books ADD COLUMN added_at     INTEGER NOT NULL DEFAULT 0`,
-		`ALTER TABLE books ADD COLUMN series_total TEXT    NOT NULL DEFAULT ''`,
-		`ALTER TABLE books ADD COLUMN rating       INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN added_at      INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN series_total  TEXT    NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN rating        INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE books ADD COLUMN summary       TEXT    NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN language      TEXT    NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN publisher     TEXT    NOT NULL DEFAULT ''`,
+		`ALTER TABLE books ADD COLUMN published_at  INTEGER`,
+		`ALTER TABLE books ADD COLUMN thumbnail_url TEXT    NOT NULL DEFAULT ''`,
 	} {
 		_, _ = db.Exec(alterSQL)
 	}
@@ -156,15 +307,39 @@ func migration2(db *sql.DB) error {
 	return nil
 }
 
+// migration3 adds the book_files table holding additional formats attached
+// to a book (e.g. by merging duplicates); the primary file stays in the
+// books table (version 2 → 3).
+func migration3(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS book_files (
+    file_path TEXT PRIMARY KEY,
+    book_id   TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    file_mime TEXT NOT NULL DEFAULT '',
+    file_size INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_book_files_book ON book_files(book_id);
+`)
+	return err
+}
+
 // migrateSchema reads PRAGMA user_version, applies every outstanding migration
 // in order, and updates user_version after each successful migration.
 // This ensures the database schema is always brought up to currentSchemaVersion
 // without data loss.
+//
+// A database whose user_version is newer than currentSchemaVersion was written
+// by a more recent binary; it is rejected rather than opened, since this
+// binary cannot know what the newer schema changed.
 func (b *Backend) migrateSchema() error {
 	var version int
 	if err := b.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
 		return fmt.Errorf("read schema version: %w", err)
 	}
+	if version > currentSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the version supported by this binary (%d); upgrade nxt-opds to open this catalog",
+			version, currentSchemaVersion)
+	}
 
 	for _, m := range schemaMigrations {
 		if m.version <= version {
@@ -181,79 +356,495 @@ func (b *Backend) migrateSchema() error {
 	return nil
 }
 
-// Refresh scans the root directory for EPUB/PDF files, inserts newly
-// discovered books, and removes DB entries whose files no longer exist.
-// Existing books in the DB are not re-parsed (metadata is preserved).
+// Refresh scans the root directory for EPUB/PDF/MOBI/AZW3/FB2 files, inserts newly
+// discovered books, re-parses those whose file changed size or modification
+// time, and removes DB entries whose files no longer exist. A re-parse keeps
+// the metadata fields edited through UpdateBook or MergeBooks.
 func (b *Backend) Refresh() error {
+	_, err := b.RefreshWithStats()
+	return err
+}
+
+// RefreshWithStats is Refresh, reporting the books inserted, re-parsed and
+// deleted. It implements catalog.StatsRefresher.
+func (b *Backend) RefreshWithStats() (catalog.RefreshStats, error) {
+	var stats catalog.RefreshStats
+
 	// Build a set of file paths currently on disk.
 	onDisk := make(map[string]bool)
+	entries := make(map[string]fs.DirEntry)
 	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
 		}
 		if d.IsDir() {
+			if sameDir(path, b.coversDir) {
+				return filepath.SkipDir
+			}
+			if path != b.root && b.skipHidden(d.Name()) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".epub" || ext == ".pdf" {
+		if b.skipHidden(d.Name()) {
+			return nil
+		}
+		ext := epub.FileExt(path)
+		switch ext {
+		case ".epub", ".kepub.epub", ".pdf", ".mobi", ".azw3", ".fb2", ".fb2.zip", ".cbz", ".cbr":
 			onDisk[path] = true
+			entries[path] = d
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("scanning directory %q: %w", b.root, err)
+		return stats, fmt.Errorf("scanning directory %q: %w", b.root, err)
 	}
 
 	// Fetch the file paths already in the DB.
-	rows, err := b.db.Query(`SELECT id, file_path FROM books`)
+	rows, err := b.db.Query(`SELECT id, file_path, file_size, file_mtime FROM books`)
 	if err != nil {
-		return fmt.Errorf("query books: %w", err)
+		return stats, fmt.Errorf("query books: %w", err)
 	}
-	inDB := make(map[string]string) // file_path -> id
+	inDB := make(map[string]string)         // file_path -> id
+	indexed := make(map[string]indexedFile) // file_path -> size and mtime when parsed
 	for rows.Next() {
 		var id, fp string
-		if err := rows.Scan(&id, &fp); err != nil {
+		var f indexedFile
+		if err := rows.Scan(&id, &fp, &f.size, &f.mtime); err != nil {
 			rows.Close()
-			return err
+			return stats, err
 		}
 		inDB[fp] = id
+		indexed[fp] = f
 	}
 	rows.Close()
 	if err := rows.Err(); err != nil {
-		return err
+		return stats, err
+	}
+
+	// Extra formats attached to existing books (see MergeBooks).
+	extraInDB, err := b.extraFilePaths()
+	if err != nil {
+		return stats, err
 	}
 
-	// Insert newly discovered files.
+	// Insert newly discovered files. They are parsed concurrently but
+	// inserted one at a time: SQLite has a single writer.
+	var newPaths []string
 	for path := range onDisk {
 		if _, exists := inDB[path]; exists {
 			continue // already indexed
 		}
-		var bk catalog.Book
-		ext := strings.ToLower(filepath.Ext(path))
-		switch ext {
-		case ".epub":
-			bk, err = epub.ParseBook(path, b.coversDir)
-			if err != nil {
-				continue // skip unreadable EPUBs
-			}
-		case ".pdf":
-			bk = epub.ParsePath(path)
+		if extraInDB[path] {
+			continue // already attached to another book
+		}
+		newPaths = append(newPaths, path)
+	}
+	sort.Strings(newPaths)
+	opts := b.epubOpts
+	if b.trustDirListing {
+		opts.Stat = bookfile.ListingStat(entries)
+	}
+	stat := os.Stat
+	if opts.Stat != nil {
+		stat = opts.Stat
+	}
+	if b.dedupeByHash {
+		if err := b.backfillHashes(); err != nil {
+			return stats, err
+		}
+	}
+	parse := func(path string) bookfile.Result {
+		// Stat first: a file changing while it is parsed is parsed again
+		// by the next refresh.
+		info, _ := stat(path)
+		res := bookfile.ParseFile(path, b.coversDir, opts)
+		res.Info = info
+		if res.OK && b.dedupeByHash {
+			res.Hash, _ = epub.HashFile(path)
+		}
+		return res
+	}
+	var unreadable []string
+	for i, res := range bookfile.ParseFiles(newPaths, b.scanConcurrency, parse) {
+		if res.Unreadable {
+			unreadable = append(unreadable, newPaths[i])
+		}
+		if !res.OK {
+			continue
 		}
-		if err := b.insertBook(bk); err != nil {
+		owner, err := b.indexBook(res.Book, res.Hash)
+		if err != nil {
 			// Log but don't abort; best-effort indexing.
 			continue
 		}
+		if owner != "" {
+			b.markChanged()
+			continue
+		}
+		if err := b.setFileMTime(newPaths[i], res.Info); err != nil {
+			return stats, err
+		}
+		stats.Added++
+	}
+
+	// Re-parse indexed files whose size or modification time changed, e.g.
+	// after their metadata was edited with another tool. Files indexed
+	// before modification times were recorded only get theirs recorded.
+	var changedPaths []string
+	for fp, f := range indexed {
+		if !onDisk[fp] {
+			continue
+		}
+		info, err := stat(fp)
+		if err != nil {
+			continue
+		}
+		switch {
+		case f.size != info.Size() || f.mtime != nil && *f.mtime != info.ModTime().UnixNano():
+			changedPaths = append(changedPaths, fp)
+		case f.mtime == nil:
+			if err := b.setFileMTime(fp, info); err != nil {
+				return stats, err
+			}
+		}
+	}
+	sort.Strings(changedPaths)
+	for i, res := range bookfile.ParseFiles(changedPaths, b.scanConcurrency, parse) {
+		if res.Unreadable {
+			unreadable = append(unreadable, changedPaths[i])
+		}
+		if !res.OK {
+			continue
+		}
+		if err := b.reparseBook(inDB[changedPaths[i]], res); err != nil {
+			return stats, fmt.Errorf("update book %q: %w", inDB[changedPaths[i]], err)
+		}
+		stats.Updated++
+	}
+	// A book's dates come from its file and metadata, which may be older
+	// than the last change already served.
+	if stats.Added > 0 || stats.Updated > 0 {
+		b.markChanged()
+	}
+
+	// Drop extra formats whose files have been removed from disk.
+	for fp := range extraInDB {
+		if !onDisk[fp] {
+			if _, err := b.db.Exec(`DELETE FROM book_files WHERE file_path = ?`, fp); err != nil {
+				return stats, fmt.Errorf("delete stale file %q: %w", fp, err)
+			}
+		}
 	}
 
-	// Delete books whose files have been removed from disk.
+	// Delete books whose files have been removed from disk. A book that
+	// still has another format keeps its metadata: that format is promoted
+	// to be its primary file instead.
 	for fp, id := range inDB {
 		if !onDisk[fp] {
+			promoted, err := b.promoteExtraFile(id)
+			if err != nil {
+				return stats, fmt.Errorf("promote file for book %q: %w", id, err)
+			}
+			if promoted {
+				continue
+			}
 			if _, err := b.db.Exec(`DELETE FROM books WHERE id = ?`, id); err != nil {
-				return fmt.Errorf("delete stale book %q: %w", id, err)
+				return stats, fmt.Errorf("delete stale book %q: %w", id, err)
 			}
+			b.markChanged()
+			stats.Removed++
 		}
 	}
 
+	b.pending.Update(unreadable, b.pendingRetryDelay, func() { _ = b.Refresh() })
+
+	// Best-effort: drop the covers of books that disappeared.
+	_, _ = b.PruneCovers(false)
+	return stats, nil
+}
+
+// indexedFile is the size and modification time (in nanoseconds, NULL for
+// books indexed before they were recorded) of a book's primary file when
+// it was last parsed.
+type indexedFile struct {
+	size  int64
+	mtime *int64
+}
+
+// setFileMTime records the modification time of the primary file at path
+// as parsed. A nil info records nothing.
+func (b *Backend) setFileMTime(path string, info fs.FileInfo) error {
+	if info == nil {
+		return nil
+	}
+	if _, err := b.db.Exec(`UPDATE books SET file_mtime = ? WHERE file_path = ?`,
+		info.ModTime().UnixNano(), path); err != nil {
+		return fmt.Errorf("record modification time of %q: %w", path, err)
+	}
+	return nil
+}
+
+// reparseBook replaces the metadata of book id with res, its primary file
+// parsed again after a change on disk. The metadata fields the user edited
+// are kept, as are the read state, rating, hidden flag, added date, extra
+// formats and the cached cover.
+func (b *Backend) reparseBook(id string, res bookfile.Result) error {
+	cur, err := b.BookByID(id)
+	if err != nil {
+		return err
+	}
+	var edited string
+	if err := b.db.QueryRow(`SELECT edited FROM books WHERE id = ?`, id).Scan(&edited); err != nil {
+		return err
+	}
+	bk := res.Book
+	keepEdited(&bk, *cur, edited)
+	// A cover is extracted under the ID derived from the path, which a
+	// promoted extra format (see promoteExtraFile) does not share.
+	if cur.CoverURL != "" || bk.ID != id {
+		bk.CoverURL, bk.ThumbnailURL = cur.CoverURL, cur.ThumbnailURL
+	}
+	var mtime *int64
+	if res.Info != nil {
+		t := res.Info.ModTime().UnixNano()
+		mtime = &t
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	_, err = tx.Exec(`
+UPDATE books SET
+    title=?, title_sort=?, summary=?, language=?, languages=?, publisher=?, published_at=?, updated_at=?,
+    series=?, series_index=?, series_total=?, collection=?, source=?, cover_url=?, thumbnail_url=?,
+    file_mime=?, file_size=?, file_mtime=?
+WHERE id=?`,
+		bk.Title, bk.TitleSort, bk.Summary, bk.Language, strings.Join(bk.Languages, " "), bk.Publisher,
+		unixOrNil(bk.PublishedAt), time.Now().Unix(),
+		bk.Series, bk.SeriesIndex, bk.SeriesTotal, bk.Collection, bk.Source, bk.CoverURL, bk.ThumbnailURL,
+		bk.Files[0].MIMEType, bk.Files[0].Size, mtime,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if res.Hash != "" {
+		if _, err := tx.Exec(`UPDATE books SET content_hash = ? WHERE id = ?`, res.Hash, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM book_authors WHERE book_id=?`, id); err != nil {
+		return err
+	}
+	for i, a := range bk.Authors {
+		if _, err := tx.Exec(`INSERT INTO book_authors (book_id, author_name, author_key, author_uri, author_sort, position) VALUES (?,?,?,?,?,?)`,
+			id, a.Name, catalog.AuthorKey(a.Name), a.URI, a.SortName, i); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM book_tags WHERE book_id=?`, id); err != nil {
+		return err
+	}
+	for _, t := range bk.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO book_tags (book_id, tag) VALUES (?,?)`, id, t); err != nil {
+			return err
+		}
+	}
+	// Identifiers merged in from other books stay; the file's replace
+	// those of the same scheme.
+	for scheme, value := range bk.Identifiers {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO book_identifiers (book_id, scheme, value) VALUES (?,?,?)`,
+			id, scheme, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// metadataFields are the user-editable metadata fields, under the names
+// the edited column records: value reads a field to detect an edit, keep
+// copies it from one book to another.
+var metadataFields = []struct {
+	name  string
+	value func(bk catalog.Book) string
+	keep  func(dst *catalog.Book, src catalog.Book)
+}{
+	{"title", func(bk catalog.Book) string { return bk.Title },
+		func(dst *catalog.Book, src catalog.Book) { dst.Title, dst.TitleSort = src.Title, src.TitleSort }},
+	{"authors", func(bk catalog.Book) string {
+		names := make([]string, len(bk.Authors))
+		for i, a := range bk.Authors {
+			names[i] = a.Name
+		}
+		return strings.Join(names, "\x00")
+	}, func(dst *catalog.Book, src catalog.Book) { dst.Authors = src.Authors }},
+	{"tags", func(bk catalog.Book) string { return strings.Join(bk.Tags, "\x00") },
+		func(dst *catalog.Book, src catalog.Book) { dst.Tags = src.Tags }},
+	{"summary", func(bk catalog.Book) string { return bk.Summary },
+		func(dst *catalog.Book, src catalog.Book) { dst.Summary = src.Summary }},
+	{"publisher", func(bk catalog.Book) string { return bk.Publisher },
+		func(dst *catalog.Book, src catalog.Book) { dst.Publisher = src.Publisher }},
+	{"language", func(bk catalog.Book) string { return bk.Language },
+		func(dst *catalog.Book, src catalog.Book) { dst.Language, dst.Languages = src.Language, src.Languages }},
+	{"series", func(bk catalog.Book) string { return bk.Series },
+		func(dst *catalog.Book, src catalog.Book) { dst.Series = src.Series }},
+	{"series_index", func(bk catalog.Book) string { return bk.SeriesIndex },
+		func(dst *catalog.Book, src catalog.Book) { dst.SeriesIndex = src.SeriesIndex }},
+	{"series_total", func(bk catalog.Book) string { return bk.SeriesTotal },
+		func(dst *catalog.Book, src catalog.Book) { dst.SeriesTotal = src.SeriesTotal }},
+	{"collection", func(bk catalog.Book) string { return bk.Collection },
+		func(dst *catalog.Book, src catalog.Book) { dst.Collection = src.Collection }},
+	{"source", func(bk catalog.Book) string { return bk.Source },
+		func(dst *catalog.Book, src catalog.Book) { dst.Source = src.Source }},
+}
+
+// editedFields adds to edited, a space-separated list of metadataFields
+// names, those of the fields that differ between before and after.
+func editedFields(edited string, before, after catalog.Book) string {
+	names := strings.Fields(edited)
+	for _, f := range metadataFields {
+		if f.value(before) != f.value(after) && !slices.Contains(names, f.name) {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// keepEdited copies from src to dst the metadata fields named in edited.
+func keepEdited(dst *catalog.Book, src catalog.Book, edited string) {
+	names := strings.Fields(edited)
+	for _, f := range metadataFields {
+		if slices.Contains(names, f.name) {
+			f.keep(dst, src)
+		}
+	}
+}
+
+// Pending returns the paths of files that could not be opened during the
+// last scan, sorted. They are retried automatically after
+// Options.PendingRetryDelay and on every Refresh.
+func (b *Backend) Pending() []string {
+	return b.pending.Paths()
+}
+
+// extraFilePaths returns the set of paths stored in book_files.
+func (b *Backend) extraFilePaths() (map[string]bool, error) {
+	rows, err := b.db.Query(`SELECT file_path FROM book_files`)
+	if err != nil {
+		return nil, fmt.Errorf("query book files: %w", err)
+	}
+	defer rows.Close()
+	paths := make(map[string]bool)
+	for rows.Next() {
+		var fp string
+		if err := rows.Scan(&fp); err != nil {
+			return nil, err
+		}
+		paths[fp] = true
+	}
+	return paths, rows.Err()
+}
+
+// promoteExtraFile makes one of the book's extra formats its primary file.
+// It reports false if the book has no extra formats.
+func (b *Backend) promoteExtraFile(id string) (bool, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var fp, mimeType string
+	var size int64
+	var hash *string
+	err = tx.QueryRow(`SELECT file_path, file_mime, file_size, content_hash FROM book_files WHERE book_id = ? ORDER BY file_path LIMIT 1`, id).
+		Scan(&fp, &mimeType, &size, &hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE books SET file_path=?, file_mime=?, file_size=?, file_mtime=NULL, content_hash=? WHERE id=?`, fp, mimeType, size, hash, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM book_files WHERE file_path = ?`, fp); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// indexBook adds a newly found book to the database. With a non-empty
+// content hash matching a file already indexed, the book's file is attached
+// to that file's book as an extra format instead, and owner is that book's
+// ID.
+func (b *Backend) indexBook(bk catalog.Book, hash string) (owner string, err error) {
+	if hash != "" && len(bk.Files) > 0 {
+		err := b.db.QueryRow(`
+SELECT id FROM books WHERE content_hash = ?
+UNION ALL
+SELECT book_id FROM book_files WHERE content_hash = ?
+LIMIT 1`, hash, hash).Scan(&owner)
+		switch {
+		case err == nil:
+			f := bk.Files[0]
+			if _, err := b.db.Exec(`INSERT OR REPLACE INTO book_files (file_path, book_id, file_mime, file_size, content_hash) VALUES (?,?,?,?,?)`,
+				f.Path, owner, f.MIMEType, f.Size, hash); err != nil {
+				return "", fmt.Errorf("attach duplicate %q: %w", f.Path, err)
+			}
+			return owner, nil
+		case err != sql.ErrNoRows:
+			return "", fmt.Errorf("look up content hash: %w", err)
+		}
+	}
+	if err := b.insertBook(bk); err != nil {
+		return "", err
+	}
+	if hash != "" {
+		if _, err := b.db.Exec(`UPDATE books SET content_hash = ? WHERE id = ?`, hash, bk.ID); err != nil {
+			return "", fmt.Errorf("store content hash: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// backfillHashes hashes the indexed files that have no content hash yet,
+// e.g. those indexed before Options.DedupeByHash was set, so that new
+// copies of them are recognized. Files that cannot be read are skipped.
+func (b *Backend) backfillHashes() error {
+	for _, table := range []string{"books", "book_files"} {
+		rows, err := b.db.Query(`SELECT file_path FROM ` + table + ` WHERE content_hash IS NULL`)
+		if err != nil {
+			return fmt.Errorf("query unhashed files: %w", err)
+		}
+		var paths []string
+		for rows.Next() {
+			var fp string
+			if err := rows.Scan(&fp); err != nil {
+				rows.Close()
+				return err
+			}
+			paths = append(paths, fp)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, fp := range paths {
+			hash, err := epub.HashFile(fp)
+			if err != nil {
+				continue
+			}
+			if _, err := b.db.Exec(`UPDATE `+table+` SET content_hash = ? WHERE file_path = ?`, hash, fp); err != nil {
+				return fmt.Errorf("store content hash: %w", err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -287,13 +878,13 @@ func (b *Backend) insertBook(bk catalog.Book) error {
 
 	_, err = tx.Exec(`
 INSERT OR IGNORE INTO books
-    (id, title, summary, language, publisher, published_at, updated_at, added_at,
-     series, series_index, series_total, collection, is_read, rating, cover_url, thumbnail_url,
+    (id, title, title_sort, summary, language, languages, publisher, published_at, updated_at, added_at,
+     series, series_index, series_total, collection, source, is_read, rating, cover_url, thumbnail_url,
      file_path, file_mime, file_size)
-VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
-		bk.ID, bk.Title, bk.Summary, bk.Language, bk.Publisher,
+VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		bk.ID, bk.Title, bk.TitleSort, bk.Summary, bk.Language, strings.Join(bk.Languages, " "), bk.Publisher,
 		pubAt, updAt, addedAt,
-		bk.Series, bk.SeriesIndex, bk.SeriesTotal, bk.Collection, boolToInt(bk.IsRead), bk.Rating,
+		bk.Series, bk.SeriesIndex, bk.SeriesTotal, bk.Collection, bk.Source, boolToInt(bk.IsRead), bk.Rating,
 		bk.CoverURL, bk.ThumbnailURL,
 		filePath, fileMIME, fileSize,
 	)
@@ -302,8 +893,8 @@ VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 	}
 
 	for i, a := range bk.Authors {
-		if _, err := tx.Exec(`INSERT OR IGNORE INTO book_authors (book_id, author_name, author_uri, position) VALUES (?,?,?,?)`,
-			bk.ID, a.Name, a.URI, i); err != nil {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO book_authors (book_id, author_name, author_key, author_uri, author_sort, position) VALUES (?,?,?,?,?,?)`,
+			bk.ID, a.Name, catalog.AuthorKey(a.Name), a.URI, a.SortName, i); err != nil {
 			return err
 		}
 	}
@@ -312,6 +903,12 @@ VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 			return err
 		}
 	}
+	for scheme, value := range bk.Identifiers {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO book_identifiers (book_id, scheme, value) VALUES (?,?,?)`,
+			bk.ID, scheme, value); err != nil {
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
@@ -321,6 +918,12 @@ func (b *Backend) CoverPath(id string) (string, error) {
 	return epub.CoverPath(b.coversDir, id)
 }
 
+// ThumbnailPath returns the filesystem path to the cover thumbnail for the
+// given book ID. It implements catalog.ThumbnailProvider.
+func (b *Backend) ThumbnailPath(id string) (string, error) {
+	return epub.Thumbnail(b.coversDir, id)
+}
+
 // UpdateCover replaces the cover image for the given book ID with the data
 // from src, updates the cover_url and thumbnail_url columns in the database,
 // and removes any previously cached cover files for that ID.
@@ -328,11 +931,13 @@ func (b *Backend) CoverPath(id string) (string, error) {
 func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	defer src.Close()
 
-	// Remove existing cover files for this book (any extension).
-	for _, oldExt := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
-		_ = os.Remove(filepath.Join(b.coversDir, id+oldExt))
+	if !epub.IsCoverExt(ext) {
+		return fmt.Errorf("unsupported cover image type %q", ext)
 	}
 
+	// Remove existing cover files for this book (any extension).
+	epub.RemoveCovers(b.coversDir, id)
+
 	destPath := filepath.Join(b.coversDir, id+ext)
 	out, err := os.Create(destPath)
 	if err != nil {
@@ -349,7 +954,7 @@ func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	coverURL := "/covers/" + id
 	_, err = b.db.Exec(
 		`UPDATE books SET cover_url=?, thumbnail_url=? WHERE id=?`,
-		coverURL, coverURL, id,
+		coverURL, coverURL+"/thumb", id,
 	)
 	if err != nil {
 		return fmt.Errorf("update cover_url: %w", err)
@@ -357,28 +962,59 @@ func (b *Backend) UpdateCover(id string, src io.ReadCloser, ext string) error {
 	return nil
 }
 
+// LastModified returns the latest of the books' updated_at and added_at
+// and of the last change markChanged recorded since the backend was
+// opened. It implements
+// catalog.LastModifier.
+func (b *Backend) LastModified() (time.Time, error) {
+	var updated, added int64
+	if err := b.db.QueryRow(`SELECT COALESCE(MAX(updated_at), 0), COALESCE(MAX(added_at), 0) FROM books`).Scan(&updated, &added); err != nil {
+		return time.Time{}, fmt.Errorf("query last modified: %w", err)
+	}
+	var latest time.Time
+	if v := max(updated, added); v > 0 {
+		latest = time.Unix(v, 0)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.changedAt.After(latest) {
+		latest = b.changedAt
+	}
+	return latest, nil
+}
+
+// markChanged records that the catalog just changed in a way no book row's
+// dates reflect (a row was deleted, a book with old dates was added), so
+// that LastModified moves forward.
+func (b *Backend) markChanged() {
+	b.mu.Lock()
+	b.changedAt = time.Now()
+	b.mu.Unlock()
+}
+
 // DeleteBook removes the book with the given ID from the DB and deletes its
 // file and cover image from disk. It implements catalog.Deleter.
 func (b *Backend) DeleteBook(id string) error {
-	// Look up the file path before deleting the row.
-	var filePath string
-	err := b.db.QueryRow(`SELECT file_path FROM books WHERE id = ?`, id).Scan(&filePath)
-	if err == sql.ErrNoRows {
-		return fmt.Errorf("book %q not found", id)
-	}
+	// Look up the file paths before deleting the row.
+	bk, err := b.BookByID(id)
 	if err != nil {
-		return fmt.Errorf("query book %q: %w", id, err)
+		return err
 	}
 
-	// Delete the DB row (CASCADE removes book_authors and book_tags).
+	// Delete the DB row (CASCADE removes book_authors, book_tags and book_files).
 	if _, err := b.db.Exec(`DELETE FROM books WHERE id = ?`, id); err != nil {
 		return fmt.Errorf("delete book %q from DB: %w", id, err)
 	}
+	b.markChanged()
 
-	// Best-effort: delete file and cover from disk.
-	_ = os.Remove(filePath)
-	coverPath := filepath.Join(b.coversDir, id+".jpg")
-	_ = os.Remove(coverPath)
+	// Best-effort: delete files and cover from disk.
+	for _, f := range bk.Files {
+		_ = os.Remove(f.Path)
+		if b.removeEmptyDirs {
+			epub.RemoveEmptyDirs(b.root, filepath.Dir(f.Path))
+		}
+	}
+	epub.RemoveCovers(b.coversDir, id)
 
 	return nil
 }
@@ -410,13 +1046,17 @@ func (b *Backend) Root() ([]catalog.NavEntry, error) {
 	}, nil
 }
 
+// visible is the condition on "books b" leaving out hidden books, which no
+// listing returns.
+const visible = `b.hidden = 0`
+
 // AllBooks returns all books ordered by added_at descending with pagination.
 func (b *Backend) AllBooks(offset, limit int) ([]catalog.Book, int, error) {
-	total, err := b.countBooks(`SELECT COUNT(*) FROM books`)
+	total, err := b.countBooks(`WHERE ` + visible)
 	if err != nil {
 		return nil, 0, err
 	}
-	books, err := b.queryBooks(`ORDER BY added_at DESC, LOWER(title) LIMIT ? OFFSET ?`, limit, offset)
+	books, err := b.queryBooks(`WHERE `+visible+` ORDER BY added_at DESC, LOWER(title), id LIMIT ? OFFSET ?`, limit, offset)
 	return books, total, err
 }
 
@@ -432,41 +1072,373 @@ func (b *Backend) BookByID(id string) (*catalog.Book, error) {
 	return &books[0], nil
 }
 
+// RandomBook returns a book chosen at random, or nil if the catalog is
+// empty. It implements catalog.RandomPicker.
+func (b *Backend) RandomBook() (*catalog.Book, error) {
+	books, err := b.queryBooks(`WHERE ` + visible + ` ORDER BY RANDOM() LIMIT 1`)
+	if err != nil || len(books) == 0 {
+		return nil, err
+	}
+	return &books[0], nil
+}
+
+// RandomBooks returns up to n distinct books chosen at random. It
+// implements catalog.RandomPicker.
+func (b *Backend) RandomBooks(n int) ([]catalog.Book, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	return b.queryBooks(`WHERE `+visible+` ORDER BY RANDOM() LIMIT ?`, n)
+}
+
+// migration4 adds the nullable read_at column recording when a book was
+// marked as read (version 3 → 4).
+func migration4(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN read_at INTEGER`)
+	return nil
+}
+
+// migration5 adds user-curated reading lists (version 4 → 5).
+func migration5(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS lists (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS list_books (
+    list_id  TEXT NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+    book_id  TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (list_id, book_id)
+);
+CREATE INDEX IF NOT EXISTS idx_list_books_book ON list_books(book_id);
+`)
+	return err
+}
+
+// migration6 adds the book_identifiers table holding ISBNs, UUIDs and other
+// identifiers from the publication metadata (version 5 → 6).
+func migration6(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS book_identifiers (
+    book_id TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    scheme  TEXT NOT NULL,
+    value   TEXT NOT NULL,
+    PRIMARY KEY (book_id, scheme)
+);
+CREATE INDEX IF NOT EXISTS idx_book_identifiers_value ON book_identifiers(value);
+`)
+	return err
+}
+
+// migration7 adds title and author sort names taken from EPUB file-as
+// metadata (version 6 → 7).
+func migration7(db *sql.DB) error {
+	for _, alterSQL := range []string{
+		`ALTER TABLE books ADD COLUMN title_sort TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE book_authors ADD COLUMN author_sort TEXT NOT NULL DEFAULT ''`,
+	} {
+		if _, err := db.Exec(alterSQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration8 adds books_fts, an FTS5 full-text index over title, authors,
+// tags, summary and publisher kept in sync by triggers (version 7 → 8).
+// Index rows share their rowid with the books row they describe. When the
+// linked sqlite build has no FTS5 module the index is skipped and Search
+// keeps using LIKE.
+func migration8(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+    title, authors, tags, summary, publisher,
+    tokenize = 'unicode61 remove_diacritics 2'
+)`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return nil
+		}
+		return err
+	}
+	_, err = db.Exec(`
+CREATE TRIGGER IF NOT EXISTS books_fts_insert AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts (rowid, title, authors, tags, summary, publisher) VALUES (
+        new.rowid, new.title,
+        (SELECT COALESCE(group_concat(author_name, ' '), '') FROM book_authors WHERE book_id = new.id),
+        (SELECT COALESCE(group_concat(tag, ' '), '') FROM book_tags WHERE book_id = new.id),
+        new.summary, new.publisher);
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_update AFTER UPDATE OF title, summary, publisher ON books BEGIN
+    UPDATE books_fts SET title = new.title, summary = new.summary, publisher = new.publisher
+    WHERE rowid = new.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_delete AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = old.rowid;
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_author_insert AFTER INSERT ON book_authors BEGIN
+    UPDATE books_fts SET authors = (SELECT group_concat(author_name, ' ') FROM book_authors WHERE book_id = new.book_id)
+    WHERE rowid = (SELECT rowid FROM books WHERE id = new.book_id);
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_author_delete AFTER DELETE ON book_authors BEGIN
+    UPDATE books_fts SET authors = (SELECT COALESCE(group_concat(author_name, ' '), '') FROM book_authors WHERE book_id = old.book_id)
+    WHERE rowid = (SELECT rowid FROM books WHERE id = old.book_id);
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_tag_insert AFTER INSERT ON book_tags BEGIN
+    UPDATE books_fts SET tags = (SELECT group_concat(tag, ' ') FROM book_tags WHERE book_id = new.book_id)
+    WHERE rowid = (SELECT rowid FROM books WHERE id = new.book_id);
+END;
+CREATE TRIGGER IF NOT EXISTS books_fts_tag_delete AFTER DELETE ON book_tags BEGIN
+    UPDATE books_fts SET tags = (SELECT COALESCE(group_concat(tag, ' '), '') FROM book_tags WHERE book_id = old.book_id)
+    WHERE rowid = (SELECT rowid FROM books WHERE id = old.book_id);
+END;
+
+DELETE FROM books_fts;
+INSERT INTO books_fts (rowid, title, authors, tags, summary, publisher)
+SELECT b.rowid, b.title,
+    (SELECT COALESCE(group_concat(author_name, ' '), '') FROM book_authors WHERE book_id = b.id),
+    (SELECT COALESCE(group_concat(tag, ' '), '') FROM book_tags WHERE book_id = b.id),
+    b.summary, b.publisher
+FROM books b;
+`)
+	return err
+}
+
+// migration9 points thumbnail_url at the dedicated thumbnail endpoint for
+// books that have a cover (version 8 → 9).
+func migration9(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE books SET thumbnail_url = cover_url || '/thumb' WHERE cover_url != ''`)
+	return err
+}
+
+// migration10 adds reading_progress, the per-book reading position used to
+// resume across devices (version 9 → 10).
+func migration10(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS reading_progress (
+    book_id    TEXT PRIMARY KEY REFERENCES books(id) ON DELETE CASCADE,
+    position   REAL NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+`)
+	return err
+}
+
+// migration11 adds download_counts, the per-book download tally behind the
+// popular feed (version 10 → 11).
+func migration11(db *sql.DB) error {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS download_counts (
+    book_id TEXT PRIMARY KEY REFERENCES books(id) ON DELETE CASCADE,
+    count   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_download_counts_count ON download_counts(count);
+`)
+	return err
+}
+
+// migration12 adds book_authors.author_key, the catalog.AuthorKey grouping
+// spellings of an author name, and fills it in for existing rows
+// (version 11 → 12).
+func migration12(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE book_authors ADD COLUMN author_key TEXT NOT NULL DEFAULT ''`)
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_book_authors_key ON book_authors(author_key)`); err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT DISTINCT author_name FROM book_authors WHERE author_key = ''`)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := db.Exec(`UPDATE book_authors SET author_key = ? WHERE author_name = ?`, catalog.AuthorKey(name), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration13 adds the source column recording what a book was derived
+// from, e.g. the original format of a converted book (version 12 → 13).
+func migration13(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN source TEXT NOT NULL DEFAULT ''`)
+	return nil
+}
+
+// migration14 adds the author_aliases table mapping pseudonyms to their
+// canonical author (version 13 → 14).
+func migration14(db *sql.DB) error {
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS author_aliases (
+    alias_key   TEXT PRIMARY KEY,
+    alias       TEXT NOT NULL,
+    author_key  TEXT NOT NULL,
+    author_name TEXT NOT NULL
+)`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_author_aliases_author ON author_aliases(author_key)`)
+	return err
+}
+
+// migration15 adds the languages column listing, space-separated, every
+// language of a multilingual book (version 14 → 15).
+func migration15(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN languages TEXT NOT NULL DEFAULT ''`)
+	return nil
+}
+
+// migration16 adds the content_hash columns holding the SHA-256 of each
+// book file, filled when Options.DedupeByHash is set (version 15 → 16).
+func migration16(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN content_hash TEXT`)
+	_, _ = db.Exec(`ALTER TABLE book_files ADD COLUMN content_hash TEXT`)
+	_, err := db.Exec(`
+CREATE INDEX IF NOT EXISTS idx_books_content_hash ON books(content_hash);
+CREATE INDEX IF NOT EXISTS idx_book_files_content_hash ON book_files(content_hash);
+`)
+	return err
+}
+
+// migration17 adds the hidden column marking draft books left out of
+// listings (version 16 → 17).
+func migration17(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN hidden INTEGER NOT NULL DEFAULT 0`)
+	return nil
+}
+
+// migration18 adds file_mtime, the modification time in nanoseconds of the
+// primary file when it was parsed, and edited, the metadata fields changed
+// by the user, so that a refresh can re-parse changed files without losing
+// edits (version 17 → 18).
+func migration18(db *sql.DB) error {
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN file_mtime INTEGER`)
+	_, _ = db.Exec(`ALTER TABLE books ADD COLUMN edited TEXT NOT NULL DEFAULT ''`)
+	return nil
+}
+
+// migration19 repeats migration9 for books indexed from the parse cache
+// since, whose thumbnail_url was stored as the full-size cover URL
+// (version 18 → 19).
+func migration19(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE books SET thumbnail_url = cover_url || '/thumb' WHERE cover_url != '' AND thumbnail_url = cover_url`)
+	return err
+}
+
+// ftsQuery turns a user search string into an FTS5 MATCH expression: every
+// whitespace-separated word must appear, each as a quoted prefix phrase so
+// that punctuation cannot break the query syntax ("sci-fi robot" becomes
+// "sci-fi"* "robot"*). It returns "" if the search has no word characters.
+func ftsQuery(query string) string {
+	var terms []string
+	for _, w := range strings.Fields(query) {
+		if !strings.ContainsFunc(w, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// titleSortKey orders books by their title sort name, falling back to the
+// display title when the book has none.
+const titleSortKey = "LOWER(COALESCE(NULLIF(b.title_sort, ''), b.title))"
+
 // sortClause returns the SQL ORDER BY clause for the given SearchQuery.
 func sortClause(q catalog.SearchQuery) string {
 	switch q.SortBy {
 	case "series_index":
 		// Numeric sort by series_index (stored as text), fallback to title.
-		return "CAST(b.series_index AS REAL), b.series_index, LOWER(b.title)"
+		return "CAST(b.series_index AS REAL), b.series_index, " + titleSortKey + ", b.id"
 	case "title":
 		if q.SortOrder == "desc" {
-			return "LOWER(b.title) DESC"
+			return titleSortKey + " DESC, b.id"
+		}
+		return titleSortKey + " ASC, b.id"
+	case "size":
+		// Primary file plus any extra formats in book_files.
+		total := "(b.file_size + COALESCE((SELECT SUM(bf.file_size) FROM book_files bf WHERE bf.book_id = b.id), 0))"
+		if q.SortOrder == "asc" {
+			return total + " ASC, " + titleSortKey + ", b.id"
+		}
+		return total + " DESC, " + titleSortKey + ", b.id"
+	case "updated":
+		if q.SortOrder == "asc" {
+			return "b.updated_at ASC, " + titleSortKey + ", b.id"
+		}
+		return "b.updated_at DESC, " + titleSortKey + ", b.id"
+	case "read_at":
+		if q.SortOrder == "asc" {
+			return "b.read_at ASC, " + titleSortKey + ", b.id"
 		}
-		return "LOWER(b.title) ASC"
+		return "b.read_at DESC, " + titleSortKey + ", b.id"
+	case "rating":
+		if q.SortOrder == "asc" {
+			return "b.rating ASC, b.added_at DESC, b.id"
+		}
+		return "b.rating DESC, b.added_at DESC, b.id"
 	default: // "added" or ""
 		if q.SortOrder == "asc" {
-			return "b.added_at ASC, LOWER(b.title)"
+			return "b.added_at ASC, " + titleSortKey + ", b.id"
 		}
-		return "b.added_at DESC, LOWER(b.title)"
+		return "b.added_at DESC, " + titleSortKey + ", b.id"
 	}
 }
 
-// Search performs a case-insensitive substring search over title and authors.
+// Search matches q.Query against the books_fts full-text index (title,
+// authors, tags, summary, publisher) and identifiers; every word must match,
+// as a prefix. Without FTS5 it falls back to a case-insensitive substring
+// search over title, authors and identifiers.
 // If q.Query is empty all books are candidates (filtered only by q.UnreadOnly / q.Series).
+// Search always counts every match, as it also backs the plain listings
+// (all books, unread, series) whose totals must be exact.
 func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
+	page, err := b.search(q, 0)
+	return page.Items, page.Total, err
+}
+
+// SearchPage is Search, counting at most Options.SearchCountCap matches and
+// reporting in the returned Page whether the total was cut there. It
+// implements catalog.PageSearcher.
+func (b *Backend) SearchPage(q catalog.SearchQuery) (catalog.Page, error) {
+	return b.search(q, b.searchCountCap)
+}
+
+// search runs q, counting at most countCap matches (0 counts them all).
+func (b *Backend) search(q catalog.SearchQuery, countCap int) (catalog.Page, error) {
 	var extraClauses []string
 	var extraArgs []any
 
+	if !q.IncludeHidden {
+		extraClauses = append(extraClauses, visible)
+	}
 	if q.UnreadOnly {
 		extraClauses = append(extraClauses, "b.is_read = 0")
 	}
+	if q.ReadOnly {
+		extraClauses = append(extraClauses, "b.is_read = 1")
+	}
 	if q.Series != "" {
 		extraClauses = append(extraClauses, "b.series = ?")
 		extraArgs = append(extraArgs, q.Series)
 	}
 	if q.Author != "" {
-		extraClauses = append(extraClauses, "EXISTS (SELECT 1 FROM book_authors _ba WHERE _ba.book_id = b.id AND LOWER(_ba.author_name) = LOWER(?))")
-		extraArgs = append(extraArgs, q.Author)
+		extraClauses = append(extraClauses, "EXISTS (SELECT 1 FROM book_authors _ba WHERE _ba.book_id = b.id AND _ba.author_key = ?)")
+		extraArgs = append(extraArgs, catalog.AuthorKey(q.Author))
 	}
 	if q.Tag != "" {
 		extraClauses = append(extraClauses, "EXISTS (SELECT 1 FROM book_tags _bt WHERE _bt.book_id = b.id AND LOWER(_bt.tag) = LOWER(?))")
@@ -480,6 +1452,14 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 		extraClauses = append(extraClauses, "LOWER(b.collection) = LOWER(?)")
 		extraArgs = append(extraArgs, q.Collection)
 	}
+	if q.Language != "" {
+		extraClauses = append(extraClauses, "LOWER(b.language) = LOWER(?)")
+		extraArgs = append(extraArgs, q.Language)
+	}
+	if q.MinRating > 0 {
+		extraClauses = append(extraClauses, "b.rating >= ?")
+		extraArgs = append(extraArgs, q.MinRating)
+	}
 
 	extraWhere := ""
 	for _, c := range extraClauses {
@@ -489,128 +1469,397 @@ func (b *Backend) Search(q catalog.SearchQuery) ([]catalog.Book, int, error) {
 	orderBy := "ORDER BY " + sortClause(q)
 
 	if q.Query == "" {
-		total, err := b.countBooks(`SELECT COUNT(*) FROM books b WHERE 1=1`+extraWhere, extraArgs...)
+		total, capped, err := b.countMatches(countCap, false, `FROM books b WHERE 1=1`+extraWhere, extraArgs...)
 		if err != nil {
-			return nil, 0, err
+			return catalog.Page{}, err
 		}
 		args := append(extraArgs, q.Limit, q.Offset)
 		books, err := b.queryBooks(`WHERE 1=1`+extraWhere+` `+orderBy+` LIMIT ? OFFSET ?`, args...)
-		return books, total, err
+		return searchPage(books, total, capped, q), err
+	}
+
+	// Identifiers match with hyphens ignored, so "9782070368228" finds
+	// "978-2-07-036822-8".
+	idLike := "%" + strings.ReplaceAll(strings.ToLower(q.Query), "-", "") + "%"
+	idMatch := "SELECT bi.book_id FROM book_identifiers bi WHERE REPLACE(LOWER(bi.value), '-', '') LIKE ?"
+
+	if match := ftsQuery(q.Query); b.fts && match != "" {
+		// Full-text matches are ranked by bm25 (lower is better), weighting
+		// title over authors, tags, publisher and summary; identifier-only
+		// matches come after them.
+		matched := `
+    SELECT b2.id, bm25(books_fts, 10.0, 5.0, 3.0, 1.0, 2.0) AS score
+    FROM books_fts JOIN books b2 ON b2.rowid = books_fts.rowid
+    WHERE books_fts MATCH ?
+    UNION ALL
+    SELECT bi.book_id, 0 FROM book_identifiers bi WHERE REPLACE(LOWER(bi.value), '-', '') LIKE ?`
+		if q.SortBy == "relevance" {
+			orderBy = "ORDER BY matched.score, " + titleSortKey + ", b.id"
+		}
+		countArgs := append([]any{match, idLike}, extraArgs...)
+		total, capped, err := b.countMatches(countCap, false, `
+FROM books b
+JOIN (SELECT id FROM (`+matched+`) GROUP BY id) AS matched ON b.id = matched.id
+WHERE 1=1`+extraWhere, countArgs...)
+		if err != nil {
+			return catalog.Page{}, err
+		}
+		queryArgs := append([]any{match, idLike}, extraArgs...)
+		queryArgs = append(queryArgs, q.Limit, q.Offset)
+		books, err := b.queryBooks(`
+JOIN (SELECT id, MIN(score) AS score FROM (`+matched+`) GROUP BY id) AS matched ON b.id = matched.id
+WHERE 1=1`+extraWhere+`
+`+orderBy+` LIMIT ? OFFSET ?`, queryArgs...)
+		return searchPage(books, total, capped, q), err
 	}
 
+	// Fallback without FTS5: substring match over title, authors and
+	// identifiers.
 	like := "%" + strings.ToLower(q.Query) + "%"
 
-	countArgs := append([]any{like, like}, extraArgs...)
-	total, err := b.countBooks(`
-SELECT COUNT(DISTINCT b.id) FROM books b
+	countArgs := append([]any{like, like, idLike}, extraArgs...)
+	total, capped, err := b.countMatches(countCap, true, `
+FROM books b
 LEFT JOIN book_authors ba ON ba.book_id = b.id
-WHERE (LOWER(b.title) LIKE ? OR LOWER(ba.author_name) LIKE ?)`+extraWhere, countArgs...)
+WHERE (LOWER(b.title) LIKE ? OR LOWER(ba.author_name) LIKE ?
+    OR b.id IN (`+idMatch+`))`+extraWhere, countArgs...)
 	if err != nil {
-		return nil, 0, err
+		return catalog.Page{}, err
 	}
 
-	queryArgs := append([]any{like, like}, extraArgs...)
+	queryArgs := append([]any{like, like, idLike}, extraArgs...)
 	queryArgs = append(queryArgs, q.Limit, q.Offset)
 	books, err := b.queryBooks(`
 JOIN (
     SELECT DISTINCT b2.id FROM books b2
     LEFT JOIN book_authors ba2 ON ba2.book_id = b2.id
-    WHERE (LOWER(b2.title) LIKE ? OR LOWER(ba2.author_name) LIKE ?)
+    WHERE (LOWER(b2.title) LIKE ? OR LOWER(ba2.author_name) LIKE ?
+        OR b2.id IN (`+idMatch+`))
 ) AS matched ON b.id = matched.id
 WHERE 1=1`+extraWhere+`
 `+orderBy+` LIMIT ? OFFSET ?`, queryArgs...)
-	return books, total, err
+	return searchPage(books, total, capped, q), err
+}
+
+// countMatches counts the rows of "SELECT ... <from>", or the distinct
+// books among them, stopping at countCap (0 counts them all): capped then
+// reports that there are more than the returned count.
+func (b *Backend) countMatches(countCap int, distinct bool, from string, args ...any) (n int, capped bool, err error) {
+	count, sel := "*", "1"
+	if distinct {
+		count, sel = "DISTINCT b.id", "DISTINCT b.id"
+	}
+	if countCap <= 0 {
+		n, err = b.countBooks(`SELECT COUNT(`+count+`) `+from, args...)
+		return n, false, err
+	}
+	args = append(args, countCap+1)
+	n, err = b.countBooks(`SELECT COUNT(*) FROM (SELECT `+sel+` `+from+` LIMIT ?)`, args...)
+	if n > countCap {
+		return countCap, true, err
+	}
+	return n, false, err
+}
+
+// searchPage builds the Page of a search whose count may have been capped.
+// A capped total is raised to cover the page itself, so that it stays a
+// lower bound of the matches however deep the page.
+func searchPage(books []catalog.Book, total int, capped bool, q catalog.SearchQuery) catalog.Page {
+	page := catalog.Page{Items: books, Total: total}
+	if capped {
+		page.Total = max(total, q.Offset+len(books))
+		page.Approximate, page.Capped = true, true
+	}
+	return page
 }
 
-// BooksByAuthor returns books by a specific author with pagination.
+// BooksByAuthor returns books by a specific author with pagination. Any
+// spelling with the same catalog.AuthorKey matches, and the books of an
+// author's aliases are included with its own.
 func (b *Backend) BooksByAuthor(author string, offset, limit int) ([]catalog.Book, int, error) {
-	total, err := b.countBooks(`
-SELECT COUNT(*) FROM books b
-JOIN book_authors ba ON ba.book_id = b.id
-WHERE ba.author_name = ?`, author)
+	key, err := b.canonicalAuthorKey(catalog.AuthorKey(author))
 	if err != nil {
 		return nil, 0, err
 	}
-	books, err := b.queryBooks(`
-JOIN book_authors ba ON ba.book_id = b.id
-WHERE ba.author_name = ?
-ORDER BY LOWER(b.title) LIMIT ? OFFSET ?`, author, limit, offset)
+	const inGroup = `author_key = ? OR author_key IN (SELECT alias_key FROM author_aliases WHERE author_key = ?)`
+	where := `WHERE b.id IN (SELECT book_id FROM book_authors WHERE ` + inGroup + `) AND ` + visible
+	total, err := b.countBooks(where, key, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	books, err := b.queryBooks(where+`
+ORDER BY `+titleSortKey+`, b.id LIMIT ? OFFSET ?`, key, key, limit, offset)
 	return books, total, err
 }
 
+// canonicalAuthorKey returns the key of the author the author with the
+// given key is an alias of, or key itself.
+func (b *Backend) canonicalAuthorKey(key string) (string, error) {
+	var canonical string
+	err := b.db.QueryRow(`SELECT author_key FROM author_aliases WHERE alias_key = ?`, key).Scan(&canonical)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, nil
+	}
+	return canonical, err
+}
+
 // BooksByTag returns books with a specific tag with pagination.
 func (b *Backend) BooksByTag(tag string, offset, limit int) ([]catalog.Book, int, error) {
 	total, err := b.countBooks(`
 SELECT COUNT(*) FROM books b
 JOIN book_tags bt ON bt.book_id = b.id
-WHERE bt.tag = ?`, tag)
+WHERE bt.tag = ? AND `+visible, tag)
 	if err != nil {
 		return nil, 0, err
 	}
 	books, err := b.queryBooks(`
 JOIN book_tags bt ON bt.book_id = b.id
-WHERE bt.tag = ?
-ORDER BY LOWER(b.title) LIMIT ? OFFSET ?`, tag, limit, offset)
+WHERE bt.tag = ? AND `+visible+`
+ORDER BY `+titleSortKey+`, b.id LIMIT ? OFFSET ?`, tag, limit, offset)
 	return books, total, err
 }
 
-// Authors returns all distinct author names with pagination.
+// Authors returns all distinct authors with pagination. Spellings sharing a
+// catalog.AuthorKey are one author, shown under the spelling most books
+// use (the first in byte order on a tie) with its whitespace collapsed.
+// Aliases are listed under their canonical author, named as printed on its
+// own books or, when it has none, as the alias was defined.
 func (b *Backend) Authors(offset, limit int) ([]string, int, error) {
+	entries, total, err := b.AuthorsWithCounts(offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names, total, nil
+}
+
+// visibleAuthors and visibleTags are the "ba" and "bt" rows of books that
+// are not hidden, for the author and tag lists.
+const (
+	visibleAuthors = `book_authors ba JOIN books b ON b.id = ba.book_id AND ` + visible
+	visibleTags    = `book_tags bt JOIN books b ON b.id = bt.book_id AND ` + visible
+)
+
+// AuthorsWithCounts is Authors with the number of books of each author,
+// its aliases' included. It implements catalog.AuthorCounter.
+func (b *Backend) AuthorsWithCounts(offset, limit int) ([]catalog.AuthorEntry, int, error) {
 	var total int
-	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT author_name) FROM book_authors`).Scan(&total); err != nil {
+	if err := b.db.QueryRow(`
+SELECT COUNT(DISTINCT COALESCE(al.author_key, ba.author_key))
+FROM ` + visibleAuthors + ` LEFT JOIN author_aliases al ON al.alias_key = ba.author_key`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	entries, err := b.authorGroups(`r.rank = 1`, nil, offset, limit)
+	return entries, total, err
+}
+
+// AuthorsByLetter returns the authors whose sort name (or name) starts with
+// letter, with their book counts. It implements catalog.AuthorIndexer.
+func (b *Backend) AuthorsByLetter(letter string, offset, limit int) ([]catalog.AuthorEntry, int, error) {
+	// SQLite's UPPER only folds ASCII letters, as catalog.IndexLetter does.
+	const initial = `UPPER(SUBSTR(TRIM(COALESCE(NULLIF(r.sort, ''), r.author_name)), 1, 1))`
+	where, args := `r.rank = 1 AND `+initial+` = ?`, []any{letter}
+	if letter == "#" {
+		where, args = `r.rank = 1 AND NOT (`+initial+` BETWEEN 'A' AND 'Z')`, nil
+	}
+	var total int
+	if err := b.db.QueryRow(authorGroupsQuery+`
+SELECT COUNT(*) FROM ranked r WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	entries, err := b.authorGroups(where, args, offset, limit)
+	return entries, total, err
+}
+
+// authorGroupsQuery defines the author groups listed by the navigation
+// feeds: ranked holds the spellings of each group (authors and their
+// aliases) with the one to display ranked 1 and the group's sort name,
+// counts the number of books of each group.
+const authorGroupsQuery = `
+WITH spellings AS (
+    SELECT COALESCE(al.author_key, ba.author_key) AS group_key,
+           CASE WHEN al.alias_key IS NULL THEN ba.author_name ELSE al.author_name END AS spelling,
+           al.alias_key IS NULL AS own,
+           COUNT(*) AS n,
+           CASE WHEN al.alias_key IS NULL THEN MAX(ba.author_sort) ELSE '' END AS sort
+    FROM ` + visibleAuthors + ` LEFT JOIN author_aliases al ON al.alias_key = ba.author_key
+    GROUP BY group_key, own, ba.author_key, spelling
+), ranked AS (
+    SELECT group_key, spelling AS author_name,
+           ROW_NUMBER() OVER (PARTITION BY group_key ORDER BY own DESC, n DESC, spelling) AS rank,
+           MAX(sort) OVER (PARTITION BY group_key) AS sort
+    FROM spellings
+), counts AS (
+    SELECT COALESCE(al.author_key, ba.author_key) AS group_key, COUNT(DISTINCT ba.book_id) AS books
+    FROM ` + visibleAuthors + ` LEFT JOIN author_aliases al ON al.alias_key = ba.author_key
+    GROUP BY group_key
+)`
+
+// authorGroups returns a page of the author groups of authorGroupsQuery
+// matching where (on ranked r), sorted by sort name.
+func (b *Backend) authorGroups(where string, args []any, offset, limit int) ([]catalog.AuthorEntry, error) {
+	rows, err := b.db.Query(authorGroupsQuery+`
+SELECT r.author_name, c.books FROM ranked r JOIN counts c ON c.group_key = r.group_key
+WHERE `+where+`
+ORDER BY LOWER(COALESCE(NULLIF(r.sort, ''), r.author_name)), r.author_name
+LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []catalog.AuthorEntry
+	for rows.Next() {
+		var e catalog.AuthorEntry
+		if err := rows.Scan(&e.Name, &e.Count); err != nil {
+			return nil, err
+		}
+		e.Name = strings.Join(strings.Fields(e.Name), " ")
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SetAuthorAlias makes alias a pseudonym of author. It implements
+// catalog.AuthorAliaser.
+func (b *Backend) SetAuthorAlias(alias, author string) error {
+	aliasKey := catalog.AuthorKey(alias)
+	if aliasKey == "" {
+		return fmt.Errorf("alias is required")
+	}
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if author == "" {
+		if _, err := tx.Exec(`DELETE FROM author_aliases WHERE alias_key = ?`, aliasKey); err != nil {
+			return fmt.Errorf("remove author alias: %w", err)
+		}
+		return b.commitAlias(tx)
+	}
+
+	var canonical string
+	err = tx.QueryRow(`SELECT author_name FROM author_aliases WHERE alias_key = ?`, catalog.AuthorKey(author)).Scan(&canonical)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		canonical = strings.Join(strings.Fields(author), " ")
+	case err != nil:
+		return err
+	}
+	authorKey := catalog.AuthorKey(canonical)
+	if authorKey == aliasKey {
+		return fmt.Errorf("%q cannot be an alias of itself", alias)
+	}
+	if _, err := tx.Exec(`
+UPDATE author_aliases SET author_key = ?, author_name = ? WHERE author_key = ?`,
+		authorKey, canonical, aliasKey); err != nil {
+		return fmt.Errorf("move author aliases: %w", err)
+	}
+	if _, err := tx.Exec(`
+INSERT INTO author_aliases (alias_key, alias, author_key, author_name) VALUES (?, ?, ?, ?)
+ON CONFLICT(alias_key) DO UPDATE SET
+    alias = excluded.alias, author_key = excluded.author_key, author_name = excluded.author_name`,
+		aliasKey, strings.Join(strings.Fields(alias), " "), authorKey, canonical); err != nil {
+		return fmt.Errorf("set author alias: %w", err)
+	}
+	return b.commitAlias(tx)
+}
+
+// commitAlias commits a change of author aliases. No book row changes, so
+// it is recorded with markChanged: the author feeds differ.
+func (b *Backend) commitAlias(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.markChanged()
+	return nil
+}
+
+// Tags returns all distinct tags with pagination.
+func (b *Backend) Tags(offset, limit int) ([]string, int, error) {
+	var total int
+	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT bt.tag) FROM ` + visibleTags).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 	rows, err := b.db.Query(`
-SELECT DISTINCT author_name FROM book_authors
-ORDER BY LOWER(author_name) LIMIT ? OFFSET ?`, limit, offset)
+SELECT DISTINCT bt.tag FROM `+visibleTags+`
+ORDER BY LOWER(bt.tag) LIMIT ? OFFSET ?`, limit, offset)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer rows.Close()
-	var names []string
+	var tags []string
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
 			return nil, 0, err
 		}
-		names = append(names, name)
+		tags = append(tags, tag)
+	}
+	return tags, total, rows.Err()
+}
+
+// TagsWithCounts is Tags with the number of books carrying each tag. It
+// implements catalog.TagCounter.
+func (b *Backend) TagsWithCounts(offset, limit int) ([]catalog.TagEntry, int, error) {
+	var total int
+	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT bt.tag) FROM ` + visibleTags).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := b.db.Query(`
+SELECT bt.tag, COUNT(DISTINCT bt.book_id) FROM `+visibleTags+`
+GROUP BY bt.tag ORDER BY LOWER(bt.tag), bt.tag LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var entries []catalog.TagEntry
+	for rows.Next() {
+		var e catalog.TagEntry
+		if err := rows.Scan(&e.Name, &e.Count); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
 	}
-	return names, total, rows.Err()
+	return entries, total, rows.Err()
 }
 
-// Tags returns all distinct tags with pagination.
-func (b *Backend) Tags(offset, limit int) ([]string, int, error) {
-	var total int
-	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT tag) FROM book_tags`).Scan(&total); err != nil {
-		return nil, 0, err
-	}
+// RelatedTags returns the tags co-occurring with tag on visible books, most
+// shared books first. It implements catalog.TagRelater.
+func (b *Backend) RelatedTags(tag string, limit int) ([]catalog.TagEntry, error) {
 	rows, err := b.db.Query(`
-SELECT DISTINCT tag FROM book_tags
-ORDER BY LOWER(tag) LIMIT ? OFFSET ?`, limit, offset)
+SELECT other.tag, COUNT(DISTINCT other.book_id) FROM `+visibleTags+`
+JOIN book_tags other ON other.book_id = bt.book_id AND other.tag != bt.tag
+WHERE bt.tag = ?
+GROUP BY other.tag ORDER BY COUNT(DISTINCT other.book_id) DESC, LOWER(other.tag), other.tag LIMIT ?`, tag, limit)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
-	var tags []string
+	var entries []catalog.TagEntry
 	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err != nil {
-			return nil, 0, err
+		var e catalog.TagEntry
+		if err := rows.Scan(&e.Name, &e.Count); err != nil {
+			return nil, err
 		}
-		tags = append(tags, tag)
+		entries = append(entries, e)
 	}
-	return tags, total, rows.Err()
+	return entries, rows.Err()
 }
 
 // Publishers returns all distinct non-empty publisher names sorted alphabetically with pagination.
 func (b *Backend) Publishers(offset, limit int) ([]string, int, error) {
 	var total int
-	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT publisher) FROM books WHERE publisher != ''`).Scan(&total); err != nil {
+	if err := b.db.QueryRow(`SELECT COUNT(DISTINCT publisher) FROM books b WHERE publisher != '' AND ` + visible).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 	rows, err := b.db.Query(`
-SELECT DISTINCT publisher FROM books
-WHERE publisher != ''
+SELECT DISTINCT publisher FROM books b
+WHERE publisher != '' AND `+visible+`
 ORDER BY LOWER(publisher) LIMIT ? OFFSET ?`, limit, offset)
 	if err != nil {
 		return nil, 0, err
@@ -631,13 +1880,13 @@ ORDER BY LOWER(publisher) LIMIT ? OFFSET ?`, limit, offset)
 func (b *Backend) BooksByPublisher(publisher string, offset, limit int) ([]catalog.Book, int, error) {
 	total, err := b.countBooks(`
 SELECT COUNT(*) FROM books b
-WHERE b.publisher = ?`, publisher)
+WHERE b.publisher = ? AND `+visible, publisher)
 	if err != nil {
 		return nil, 0, err
 	}
 	books, err := b.queryBooks(`
-WHERE b.publisher = ?
-ORDER BY LOWER(b.title) LIMIT ? OFFSET ?`, publisher, limit, offset)
+WHERE b.publisher = ? AND `+visible+`
+ORDER BY `+titleSortKey+`, b.id LIMIT ? OFFSET ?`, publisher, limit, offset)
 	return books, total, err
 }
 
@@ -645,8 +1894,8 @@ ORDER BY LOWER(b.title) LIMIT ? OFFSET ?`, publisher, limit, offset)
 // with the number of books in each. It implements catalog.SeriesLister.
 func (b *Backend) Series() ([]catalog.SeriesEntry, error) {
 	rows, err := b.db.Query(`
-SELECT series, COUNT(*) FROM books
-WHERE series != ''
+SELECT series, COUNT(*) FROM books b
+WHERE series != '' AND ` + visible + `
 GROUP BY series
 ORDER BY LOWER(series)`)
 	if err != nil {
@@ -664,6 +1913,68 @@ ORDER BY LOWER(series)`)
 	return entries, rows.Err()
 }
 
+// Languages returns every distinct language tag, lower-cased, with the number
+// of books in each. It implements catalog.LanguageLister.
+func (b *Backend) Languages() ([]catalog.LanguageEntry, error) {
+	rows, err := b.db.Query(`
+SELECT LOWER(language) AS lang, COUNT(*) FROM books b
+WHERE language != '' AND ` + visible + `
+GROUP BY lang
+ORDER BY lang`)
+	if err != nil {
+		return nil, fmt.Errorf("query languages: %w", err)
+	}
+	defer rows.Close()
+	var entries []catalog.LanguageEntry
+	for rows.Next() {
+		var e catalog.LanguageEntry
+		if err := rows.Scan(&e.Language, &e.Count); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// publishedYearExpr extracts the (UTC) publication year from published_at.
+const publishedYearExpr = `CAST(strftime('%Y', b.published_at, 'unixepoch') AS INTEGER)`
+
+// Decades returns every decade that contains at least one dated book, oldest
+// first, with the number of books in each. It implements catalog.YearBrowser.
+func (b *Backend) Decades() ([]catalog.DecadeEntry, error) {
+	rows, err := b.db.Query(`
+SELECT (` + publishedYearExpr + ` / 10) * 10 AS decade, COUNT(*) FROM books b
+WHERE b.published_at IS NOT NULL AND ` + visible + `
+GROUP BY decade
+ORDER BY decade`)
+	if err != nil {
+		return nil, fmt.Errorf("query decades: %w", err)
+	}
+	defer rows.Close()
+	var entries []catalog.DecadeEntry
+	for rows.Next() {
+		var e catalog.DecadeEntry
+		if err := rows.Scan(&e.Decade, &e.Count); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// BooksByYearRange returns books published between the years from and to
+// (inclusive) ordered by publication date. It implements catalog.YearBrowser.
+func (b *Backend) BooksByYearRange(from, to, offset, limit int) ([]catalog.Book, int, error) {
+	where := `WHERE b.published_at IS NOT NULL AND ` + publishedYearExpr + ` BETWEEN ? AND ? AND ` + visible
+	total, err := b.countBooks(where, from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	books, err := b.queryBooks(where+`
+ORDER BY b.published_at, `+titleSortKey+`, b.id LIMIT ? OFFSET ?`, from, to, limit, offset)
+	return books, total, err
+}
+
 // UpdateBook applies the given update to the book and persists it to the DB.
 // It implements catalog.Updater.
 func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Book, error) {
@@ -671,19 +1982,32 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 	if err != nil {
 		return nil, err
 	}
+	before := *bk
+	var edited string
+	if err := b.db.QueryRow(`SELECT edited FROM books WHERE id = ?`, id).Scan(&edited); err != nil {
+		return nil, err
+	}
 
-	// Apply updates to the in-memory copy.
+	// Apply updates to the in-memory copy. A sort name only survives while
+	// the name it sorts stays the same.
 	if update.Title != nil {
+		if *update.Title != bk.Title {
+			bk.TitleSort = ""
+		}
 		bk.Title = *update.Title
 	}
 	if update.Authors != nil {
+		sortNames := make(map[string]string, len(bk.Authors))
+		for _, a := range bk.Authors {
+			sortNames[a.Name] = a.SortName
+		}
 		bk.Authors = make([]catalog.Author, 0, len(update.Authors))
 		for _, name := range update.Authors {
-			bk.Authors = append(bk.Authors, catalog.Author{Name: name})
+			bk.Authors = append(bk.Authors, catalog.Author{Name: name, SortName: sortNames[name]})
 		}
 	}
 	if update.Tags != nil {
-		bk.Tags = update.Tags
+		bk.Tags = catalog.UniqueTags(update.Tags)
 	}
 	if update.Summary != nil {
 		bk.Summary = *update.Summary
@@ -692,6 +2016,9 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 		bk.Publisher = *update.Publisher
 	}
 	if update.Language != nil {
+		if *update.Language != bk.Language {
+			bk.Languages = nil
+		}
 		bk.Language = *update.Language
 	}
 	if update.Series != nil {
@@ -706,12 +2033,24 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 	if update.Collection != nil {
 		bk.Collection = *update.Collection
 	}
+	if update.Source != nil {
+		bk.Source = *update.Source
+	}
 	if update.IsRead != nil {
+		switch {
+		case !*update.IsRead:
+			bk.ReadAt = time.Time{}
+		case !bk.IsRead || bk.ReadAt.IsZero():
+			bk.ReadAt = b.now()
+		}
 		bk.IsRead = *update.IsRead
 	}
 	if update.Rating != nil {
 		bk.Rating = *update.Rating
 	}
+	if update.Hidden != nil {
+		bk.Hidden = *update.Hidden
+	}
 	bk.UpdatedAt = time.Now()
 
 	// Persist to DB.
@@ -723,11 +2062,13 @@ func (b *Backend) UpdateBook(id string, update catalog.BookUpdate) (*catalog.Boo
 
 	_, err = tx.Exec(`
 UPDATE books SET
-    title=?, summary=?, language=?, publisher=?,
-    updated_at=?, series=?, series_index=?, series_total=?, collection=?, is_read=?, rating=?
+    title=?, title_sort=?, summary=?, language=?, languages=?, publisher=?,
+    updated_at=?, series=?, series_index=?, series_total=?, collection=?, source=?, is_read=?, read_at=?, rating=?, hidden=?,
+    edited=?
 WHERE id=?`,
-		bk.Title, bk.Summary, bk.Language, bk.Publisher,
-		bk.UpdatedAt.Unix(), bk.Series, bk.SeriesIndex, bk.SeriesTotal, bk.Collection, boolToInt(bk.IsRead), bk.Rating,
+		bk.Title, bk.TitleSort, bk.Summary, bk.Language, strings.Join(bk.Languages, " "), bk.Publisher,
+		bk.UpdatedAt.Unix(), bk.Series, bk.SeriesIndex, bk.SeriesTotal, bk.Collection, bk.Source, boolToInt(bk.IsRead), unixOrNil(bk.ReadAt), bk.Rating, boolToInt(bk.Hidden),
+		editedFields(edited, before, *bk),
 		id,
 	)
 	if err != nil {
@@ -739,8 +2080,8 @@ WHERE id=?`,
 		return nil, err
 	}
 	for i, a := range bk.Authors {
-		if _, err := tx.Exec(`INSERT INTO book_authors (book_id, author_name, author_uri, position) VALUES (?,?,?,?)`,
-			id, a.Name, a.URI, i); err != nil {
+		if _, err := tx.Exec(`INSERT INTO book_authors (book_id, author_name, author_key, author_uri, author_sort, position) VALUES (?,?,?,?,?,?)`,
+			id, a.Name, catalog.AuthorKey(a.Name), a.URI, a.SortName, i); err != nil {
 			return nil, err
 		}
 	}
@@ -761,21 +2102,185 @@ WHERE id=?`,
 	return bk, nil
 }
 
+// MergeBooks folds the source book into the target: every file of the
+// source becomes an extra format of the target, metadata missing from the
+// target is taken from the source, tags are unioned, and the source entry
+// is removed. No files are deleted from disk. It implements catalog.Merger.
+func (b *Backend) MergeBooks(targetID, sourceID string) (*catalog.Book, error) {
+	if targetID == sourceID {
+		return nil, fmt.Errorf("cannot merge book %q into itself", targetID)
+	}
+	target, err := b.BookByID(targetID)
+	if err != nil {
+		return nil, err
+	}
+	source, err := b.BookByID(sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := mergeMetadata(*target, *source)
+	var edited string
+	if err := b.db.QueryRow(`SELECT edited FROM books WHERE id = ?`, targetID).Scan(&edited); err != nil {
+		return nil, err
+	}
+	srcCover, _ := epub.CoverPath(b.coversDir, sourceID)
+	moveCover := target.CoverURL == "" && srcCover != ""
+	if moveCover {
+		merged.CoverURL = "/covers/" + targetID
+		merged.ThumbnailURL = merged.CoverURL + "/thumb"
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var pubAt *int64
+	if !merged.PublishedAt.IsZero() {
+		t := merged.PublishedAt.Unix()
+		pubAt = &t
+	}
+	_, err = tx.Exec(`
+UPDATE books SET
+    title=?, title_sort=?, summary=?, language=?, languages=?, publisher=?, published_at=?, updated_at=?, added_at=?,
+    series=?, series_index=?, series_total=?, collection=?, source=?, is_read=?, read_at=?, rating=?,
+    cover_url=?, thumbnail_url=?, edited=?
+WHERE id=?`,
+		merged.Title, merged.TitleSort, merged.Summary, merged.Language, strings.Join(merged.Languages, " "), merged.Publisher, pubAt,
+		time.Now().Unix(), merged.AddedAt.Unix(),
+		merged.Series, merged.SeriesIndex, merged.SeriesTotal, merged.Collection, merged.Source,
+		boolToInt(merged.IsRead), unixOrNil(merged.ReadAt), merged.Rating,
+		merged.CoverURL, merged.ThumbnailURL, editedFields(edited, *target, merged),
+		targetID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update book: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM book_authors WHERE book_id=?`, targetID); err != nil {
+		return nil, err
+	}
+	for i, a := range merged.Authors {
+		if _, err := tx.Exec(`INSERT INTO book_authors (book_id, author_name, author_key, author_uri, author_sort, position) VALUES (?,?,?,?,?,?)`,
+			targetID, a.Name, catalog.AuthorKey(a.Name), a.URI, a.SortName, i); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range merged.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO book_tags (book_id, tag) VALUES (?,?)`, targetID, t); err != nil {
+			return nil, err
+		}
+	}
+
+	// Carry over identifiers the target lacks, then keep the source's list
+	// memberships on the target before the cascade below drops them.
+	if _, err := tx.Exec(`
+INSERT OR IGNORE INTO book_identifiers (book_id, scheme, value)
+SELECT ?, scheme, value FROM book_identifiers WHERE book_id = ?`, targetID, sourceID); err != nil {
+		return nil, fmt.Errorf("move identifiers: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+INSERT OR IGNORE INTO list_books (list_id, book_id, position)
+SELECT list_id, ?, position FROM list_books WHERE book_id = ?`, targetID, sourceID); err != nil {
+		return nil, fmt.Errorf("move list entries: %w", err)
+	}
+
+	// Removing the source also cascades to its own extra files, which are
+	// re-attached to the target below.
+	if _, err := tx.Exec(`DELETE FROM books WHERE id=?`, sourceID); err != nil {
+		return nil, fmt.Errorf("delete source book: %w", err)
+	}
+	for _, f := range source.Files {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO book_files (file_path, book_id, file_mime, file_size) VALUES (?,?,?,?)`,
+			f.Path, targetID, f.MIMEType, f.Size); err != nil {
+			return nil, fmt.Errorf("attach file %q: %w", f.Path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	b.markChanged()
+
+	// Best-effort cover housekeeping.
+	if moveCover {
+		_ = os.Rename(srcCover, filepath.Join(b.coversDir, targetID+filepath.Ext(srcCover)))
+	} else if srcCover != "" {
+		_ = os.Remove(srcCover)
+	}
+	_ = os.Remove(epub.ThumbnailPath(b.coversDir, sourceID))
+
+	return b.BookByID(targetID)
+}
+
+// mergeMetadata returns target with empty fields filled from source, the
+// longer of the two summaries, the union of their tags, the earliest added
+// date, the higher rating, and read if either copy was read.
+func mergeMetadata(target, source catalog.Book) catalog.Book {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	if target.Title == "" {
+		target.Title, target.TitleSort = source.Title, source.TitleSort
+	}
+	if target.Language == "" {
+		target.Language, target.Languages = source.Language, source.Languages
+	}
+	fill(&target.Publisher, source.Publisher)
+	fill(&target.Series, source.Series)
+	fill(&target.SeriesIndex, source.SeriesIndex)
+	fill(&target.SeriesTotal, source.SeriesTotal)
+	fill(&target.Collection, source.Collection)
+	fill(&target.Source, source.Source)
+	if len(source.Summary) > len(target.Summary) {
+		target.Summary = source.Summary
+	}
+	if len(target.Authors) == 0 {
+		target.Authors = source.Authors
+	}
+	if target.PublishedAt.IsZero() {
+		target.PublishedAt = source.PublishedAt
+	}
+	if !source.AddedAt.IsZero() && source.AddedAt.Before(target.AddedAt) {
+		target.AddedAt = source.AddedAt
+	}
+	if source.Rating > target.Rating {
+		target.Rating = source.Rating
+	}
+	target.IsRead = target.IsRead || source.IsRead
+	if source.ReadAt.After(target.ReadAt) {
+		target.ReadAt = source.ReadAt
+	}
+
+	if len(source.Tags) > 0 {
+		target.Tags = catalog.UniqueTags(append(append([]string{}, target.Tags...), source.Tags...))
+	}
+	return target
+}
+
 // StoreBook saves the uploaded file to the books directory, indexes it, and
 // returns the resulting Book. It implements catalog.Uploader.
 func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book, error) {
 	defer src.Close()
 
-	filename = filepath.Base(filename)
-	ext := strings.ToLower(filepath.Ext(filename))
+	// A leading dot would hide the file from the next scan.
+	filename = strings.TrimLeft(filepath.Base(filename), ".")
+	ext := epub.FileExt(filename)
 	switch ext {
-	case ".epub", ".pdf":
+	case ".epub", ".kepub.epub", ".pdf", ".mobi", ".azw3", ".fb2", ".fb2.zip", ".cbz", ".cbr":
 	default:
-		return nil, fmt.Errorf("unsupported file type %q (only .epub and .pdf are accepted)", ext)
+		return nil, fmt.Errorf("unsupported file type %q (only .epub, .pdf, .mobi, .azw3, .fb2, .fb2.zip, .cbz and .cbr are accepted)", ext)
 	}
 
 	destPath := filepath.Join(b.root, filename)
-	if _, err := os.Stat(destPath); err == nil {
+	if b.organizeUploads {
+		// Staging name only; the file moves once its metadata is known.
+		destPath = epub.UniquePath(destPath)
+	} else if _, err := os.Stat(destPath); err == nil {
 		return nil, fmt.Errorf("file %q already exists in the catalog", filename)
 	}
 
@@ -786,7 +2291,7 @@ func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book,
 	tmpPath := tmp.Name()
 	defer func() { _ = os.Remove(tmpPath) }()
 
-	if _, err := io.Copy(tmp, src); err != nil {
+	if _, err := catalog.CopyLimited(tmp, src, b.maxUploadSize); err != nil {
 		tmp.Close()
 		return nil, fmt.Errorf("write upload: %w", err)
 	}
@@ -797,35 +2302,282 @@ func (b *Backend) StoreBook(filename string, src io.ReadCloser) (*catalog.Book,
 		return nil, fmt.Errorf("rename upload: %w", err)
 	}
 
-	var bk catalog.Book
-	switch ext {
-	case ".epub":
-		bk, err = epub.ParseBook(destPath, b.coversDir)
-		if err != nil {
-			return nil, fmt.Errorf("parse epub %q: %w", filename, err)
+	bk, err := bookfile.ParseBook(destPath, b.coversDir, b.epubOpts)
+	if err != nil {
+		return nil, err
+	}
+	if b.organizeUploads {
+		if bk, err = bookfile.OrganizeUpload(b.root, b.coversDir, destPath, bk, b.epubOpts); err != nil {
+			return nil, err
 		}
-	case ".pdf":
-		bk = epub.ParsePath(destPath)
 	}
 
-	if err := b.insertBook(bk); err != nil {
+	var hash string
+	if b.dedupeByHash {
+		hash, _ = epub.HashFile(bk.Files[0].Path)
+	}
+	owner, err := b.indexBook(bk, hash)
+	if err != nil {
 		return nil, fmt.Errorf("index uploaded book: %w", err)
 	}
+	if owner != "" {
+		// A copy of a book already in the catalog: the upload became one
+		// of its formats.
+		b.markChanged()
+		return b.BookByID(owner)
+	}
 	return &bk, nil
 }
 
+// CreateList creates an empty reading list. It implements catalog.ListManager.
+func (b *Backend) CreateList(name string) (*catalog.List, error) {
+	id, err := newListID()
+	if err != nil {
+		return nil, err
+	}
+	l := catalog.List{ID: id, Name: name, CreatedAt: time.Now().Truncate(time.Second)}
+	if _, err := b.db.Exec(`INSERT INTO lists (id, name, created_at) VALUES (?,?,?)`,
+		l.ID, l.Name, l.CreatedAt.Unix()); err != nil {
+		return nil, fmt.Errorf("create list: %w", err)
+	}
+	return &l, nil
+}
+
+// listSelect selects lists with their counts of visible books; append
+// WHERE/ORDER BY.
+const listSelect = `
+SELECT l.id, l.name, l.created_at,
+    (SELECT COUNT(*) FROM list_books lb JOIN books b ON b.id = lb.book_id
+     WHERE lb.list_id = l.id AND ` + visible + `)
+FROM lists l `
+
+// Lists returns all reading lists sorted by name. It implements catalog.ListManager.
+func (b *Backend) Lists() ([]catalog.List, error) {
+	rows, err := b.db.Query(listSelect + `ORDER BY LOWER(l.name), l.id`)
+	if err != nil {
+		return nil, fmt.Errorf("query lists: %w", err)
+	}
+	defer rows.Close()
+
+	var lists []catalog.List
+	for rows.Next() {
+		var l catalog.List
+		var created int64
+		if err := rows.Scan(&l.ID, &l.Name, &created, &l.BookCount); err != nil {
+			return nil, err
+		}
+		l.CreatedAt = time.Unix(created, 0)
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// ListByID returns a single reading list. It implements catalog.ListManager.
+func (b *Backend) ListByID(id string) (*catalog.List, error) {
+	var l catalog.List
+	var created int64
+	err := b.db.QueryRow(listSelect+`WHERE l.id = ?`, id).Scan(&l.ID, &l.Name, &created, &l.BookCount)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("list %q not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.CreatedAt = time.Unix(created, 0)
+	return &l, nil
+}
+
+// AddToList appends a book to a reading list; re-adding is a no-op.
+// It implements catalog.ListManager.
+func (b *Backend) AddToList(listID, bookID string) error {
+	if _, err := b.ListByID(listID); err != nil {
+		return err
+	}
+	if _, err := b.BookByID(bookID); err != nil {
+		return err
+	}
+	_, err := b.db.Exec(`
+INSERT OR IGNORE INTO list_books (list_id, book_id, position)
+SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM list_books WHERE list_id = ?`,
+		listID, bookID, listID)
+	if err != nil {
+		return fmt.Errorf("add to list: %w", err)
+	}
+	return nil
+}
+
+// ListBooks returns the books on a reading list in the order they were
+// added. It implements catalog.ListManager.
+func (b *Backend) ListBooks(listID string, offset, limit int) ([]catalog.Book, int, error) {
+	l, err := b.ListByID(listID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	books, err := b.queryBooks(`JOIN list_books lb ON lb.book_id = b.id
+WHERE lb.list_id = ? AND `+visible+`
+ORDER BY lb.position, b.id LIMIT ? OFFSET ?`, listID, limit, offset)
+	return books, l.BookCount, err
+}
+
+// GetProgress returns the recorded reading position of a book, or 0 if none
+// was recorded. It implements catalog.ProgressTracker.
+func (b *Backend) GetProgress(bookID string) (float64, error) {
+	if _, err := b.BookByID(bookID); err != nil {
+		return 0, err
+	}
+	var position float64
+	err := b.db.QueryRow(`SELECT position FROM reading_progress WHERE book_id = ?`, bookID).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get progress: %w", err)
+	}
+	return position, nil
+}
+
+// SetProgress records the reading position of a book, replacing any earlier
+// one. It implements catalog.ProgressTracker.
+func (b *Backend) SetProgress(bookID string, position float64) error {
+	if !(position >= 0 && position <= 1) {
+		return fmt.Errorf("progress %v out of range [0, 1]", position)
+	}
+	if _, err := b.BookByID(bookID); err != nil {
+		return err
+	}
+	_, err := b.db.Exec(`
+INSERT INTO reading_progress (book_id, position, updated_at) VALUES (?, ?, ?)
+ON CONFLICT(book_id) DO UPDATE SET position = excluded.position, updated_at = excluded.updated_at`,
+		bookID, position, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("set progress: %w", err)
+	}
+	return nil
+}
+
+// ResetPersonalData clears is_read, read_at and rating on every book in a
+// single UPDATE and drops all reading progress. It implements
+// catalog.PersonalDataResetter.
+func (b *Backend) ResetPersonalData() (int, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	res, err := tx.Exec(`
+UPDATE books SET is_read = 0, read_at = NULL, rating = 0, updated_at = ?
+WHERE is_read <> 0 OR read_at IS NOT NULL OR rating <> 0
+   OR id IN (SELECT book_id FROM reading_progress)`, time.Now().Unix())
+	if err != nil {
+		return 0, fmt.Errorf("reset personal data: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM reading_progress`); err != nil {
+		return 0, fmt.Errorf("reset reading progress: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// PruneCovers removes cached covers of books that are no longer in the
+// catalog. It implements catalog.CoverPurger.
+func (b *Backend) PruneCovers(dryRun bool) (int, error) {
+	rows, err := b.db.Query(`SELECT id FROM books`)
+	if err != nil {
+		return 0, fmt.Errorf("list book ids: %w", err)
+	}
+	defer rows.Close()
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return epub.PurgeCovers(b.coversDir, func(id string) bool { return ids[id] }, dryRun)
+}
+
+// RecordDownload adds one to the download count of a book. It implements
+// catalog.DownloadCounter.
+func (b *Backend) RecordDownload(id string) error {
+	if _, err := b.BookByID(id); err != nil {
+		return err
+	}
+	_, err := b.db.Exec(`
+INSERT INTO download_counts (book_id, count) VALUES (?, 1)
+ON CONFLICT(book_id) DO UPDATE SET count = count + 1`, id)
+	if err != nil {
+		return fmt.Errorf("record download: %w", err)
+	}
+	return nil
+}
+
+// DownloadCount returns how many times a book has been downloaded. It
+// implements catalog.DownloadCounter.
+func (b *Backend) DownloadCount(id string) (int, error) {
+	if _, err := b.BookByID(id); err != nil {
+		return 0, err
+	}
+	var n int
+	err := b.db.QueryRow(`SELECT count FROM download_counts WHERE book_id = ?`, id).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("download count: %w", err)
+	}
+	return n, nil
+}
+
+// PopularBooks returns the downloaded books, most downloaded first. It
+// implements catalog.DownloadCounter.
+func (b *Backend) PopularBooks(offset, limit int) ([]catalog.Book, int, error) {
+	total, err := b.countBooks(`JOIN download_counts dc ON dc.book_id = b.id WHERE ` + visible)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count popular books: %w", err)
+	}
+	books, err := b.queryBooks(`JOIN download_counts dc ON dc.book_id = b.id
+WHERE `+visible+`
+ORDER BY dc.count DESC, b.added_at DESC, b.id LIMIT ? OFFSET ?`, limit, offset)
+	return books, total, err
+}
+
+// newListID returns a random 16-character hex identifier for a list.
+func newListID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate list id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 // Backup creates a consistent snapshot of the catalog database in destDir
 // using SQLite's VACUUM INTO statement, which produces a defragmented copy
 // even while the database is in use.  The backup file is named
-// "catalog-YYYYMMDD-HHMMSS.db".  Afterwards the oldest backups in destDir
-// are pruned so that at most keep files remain (keep ≤ 0 = unlimited).
+// "catalog-YYYYMMDD-HHMMSSZ.db" in UTC, or with the numeric offset of
+// Options.BackupLocation (e.g. "catalog-20241027-021000+0100.db").
+// Afterwards the oldest backups in destDir are pruned so that at most keep
+// files remain (keep ≤ 0 = unlimited).
 // It implements catalog.Backupper.
 func (b *Backend) Backup(destDir string, keep int) (string, error) {
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return "", fmt.Errorf("create backup dir %q: %w", destDir, err)
 	}
 
-	name := "catalog-" + time.Now().Format("20060102-150405") + ".db"
+	name := "catalog-" + b.now().In(b.backupLoc).Format(backupTimeFormat) + ".db"
 	destPath := filepath.Join(destDir, name)
 
 	if _, err := b.db.Exec(`VACUUM INTO ?`, destPath); err != nil {
@@ -841,8 +2593,32 @@ func (b *Backend) Backup(destDir string, keep int) (string, error) {
 	return destPath, nil
 }
 
+// backupTimeFormat is the timestamp embedded in backup file names. The
+// trailing zone ("Z" for UTC, otherwise e.g. "+0200") makes every name an
+// unambiguous instant even across DST changes.
+const backupTimeFormat = "20060102-150405Z0700"
+
+// legacyBackupTimeFormat is the zone-less local-time format used by older
+// versions; such names are interpreted in the local time zone.
+const legacyBackupTimeFormat = "20060102-150405"
+
+// backupTime extracts the creation instant from a backup file name.
+// Unparseable names yield the zero time so they are pruned first.
+func backupTime(name string) time.Time {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, "catalog-"), ".db")
+	if t, err := time.Parse(backupTimeFormat, stamp); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation(legacyBackupTimeFormat, stamp, time.Local); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
 // pruneBackups keeps only the most recent keep files matching the backup
-// naming pattern "catalog-*.db" in dir, deleting older ones.
+// naming pattern "catalog-*.db" in dir, deleting older ones. Age is taken
+// from the timestamp in the name, not from the name's sort order, so
+// backups made under different UTC offsets are ordered correctly.
 func pruneBackups(dir string, keep int) error {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -861,8 +2637,10 @@ func pruneBackups(dir string, keep int) error {
 		}
 	}
 
-	// os.ReadDir returns entries sorted by name, and our timestamp-named
-	// files sort chronologically, so oldest entries are first.
+	// Oldest first.
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backupTime(filepath.Base(backups[i])).Before(backupTime(filepath.Base(backups[j])))
+	})
 	if len(backups) > keep {
 		for _, old := range backups[:len(backups)-keep] {
 			_ = os.Remove(old) // best-effort
@@ -877,8 +2655,10 @@ func pruneBackups(dir string, keep int) error {
 type bookRow struct {
 	ID           string
 	Title        string
+	TitleSort    string
 	Summary      string
 	Language     string
+	Languages    string // space-separated
 	Publisher    string
 	PublishedAt  *int64
 	UpdatedAt    int64
@@ -887,30 +2667,39 @@ type bookRow struct {
 	SeriesIndex  string
 	SeriesTotal  string
 	Collection   string
+	Source       string
 	IsRead       int
+	ReadAt       *int64
 	Rating       int
+	Hidden       int
 	CoverURL     string
 	ThumbnailURL string
 	FilePath     string
 	FileMIME     string
 	FileSize     int64
+	FilesJSON    *string // JSON array of extra {path,mime,size} files, may be NULL
 	AuthorsJSON  *string // JSON array of {name,uri} objects, may be NULL
 	TagsJSON     *string // JSON array of strings, may be NULL
+	IdentsJSON   *string // JSON object of scheme -> value, may be NULL
 }
 
 func (r bookRow) toBook() catalog.Book {
 	bk := catalog.Book{
 		ID:           r.ID,
 		Title:        r.Title,
+		TitleSort:    r.TitleSort,
 		Summary:      r.Summary,
 		Language:     r.Language,
+		Languages:    strings.Fields(r.Languages),
 		Publisher:    r.Publisher,
 		Series:       r.Series,
 		SeriesIndex:  r.SeriesIndex,
 		SeriesTotal:  r.SeriesTotal,
 		Collection:   r.Collection,
+		Source:       r.Source,
 		IsRead:       r.IsRead != 0,
 		Rating:       r.Rating,
+		Hidden:       r.Hidden != 0,
 		CoverURL:     r.CoverURL,
 		ThumbnailURL: r.ThumbnailURL,
 		UpdatedAt:    time.Unix(r.UpdatedAt, 0),
@@ -922,14 +2711,30 @@ func (r bookRow) toBook() catalog.Book {
 	if r.PublishedAt != nil {
 		bk.PublishedAt = time.Unix(*r.PublishedAt, 0)
 	}
+	if r.ReadAt != nil {
+		bk.ReadAt = time.Unix(*r.ReadAt, 0)
+	}
+	if r.FilesJSON != nil && *r.FilesJSON != "" {
+		var extra []struct {
+			Path string `json:"path"`
+			MIME string `json:"mime"`
+			Size int64  `json:"size"`
+		}
+		if err := json.Unmarshal([]byte(*r.FilesJSON), &extra); err == nil {
+			for _, f := range extra {
+				bk.Files = append(bk.Files, catalog.File{MIMEType: f.MIME, Path: f.Path, Size: f.Size})
+			}
+		}
+	}
 	if r.AuthorsJSON != nil && *r.AuthorsJSON != "" {
 		var raw []struct {
 			Name string `json:"name"`
 			URI  string `json:"uri"`
+			Sort string `json:"sort"`
 		}
 		if err := json.Unmarshal([]byte(*r.AuthorsJSON), &raw); err == nil {
 			for _, a := range raw {
-				bk.Authors = append(bk.Authors, catalog.Author{Name: a.Name, URI: a.URI})
+				bk.Authors = append(bk.Authors, catalog.Author{Name: a.Name, URI: a.URI, SortName: a.Sort})
 			}
 		}
 	}
@@ -939,18 +2744,28 @@ func (r bookRow) toBook() catalog.Book {
 			bk.Tags = tags
 		}
 	}
+	if r.IdentsJSON != nil && *r.IdentsJSON != "" && *r.IdentsJSON != "{}" {
+		var ids map[string]string
+		if err := json.Unmarshal([]byte(*r.IdentsJSON), &ids); err == nil {
+			bk.Identifiers = ids
+		}
+	}
 	return bk
 }
 
 // bookSelectColumns is the SELECT list for querying full book records.
 const bookSelectColumns = `
-    b.id, b.title, b.summary, b.language, b.publisher,
-    b.published_at, b.updated_at, b.added_at, b.series, b.series_index, b.series_total, b.collection, b.is_read, b.rating,
+    b.id, b.title, b.title_sort, b.summary, b.language, b.languages, b.publisher,
+    b.published_at, b.updated_at, b.added_at, b.series, b.series_index, b.series_total, b.collection, b.source, b.is_read, b.read_at, b.rating, b.hidden,
     b.cover_url, b.thumbnail_url, b.file_path, b.file_mime, b.file_size,
-    (SELECT json_group_array(json_object('name',ba.author_name,'uri',ba.author_uri))
+    (SELECT json_group_array(json_object('path',bf.file_path,'mime',bf.file_mime,'size',bf.file_size))
+       FROM book_files bf WHERE bf.book_id = b.id) AS files_json,
+    (SELECT json_group_array(json_object('name',ba.author_name,'uri',ba.author_uri,'sort',ba.author_sort))
        FROM book_authors ba WHERE ba.book_id = b.id) AS authors_json,
     (SELECT json_group_array(bt.tag)
-       FROM book_tags bt WHERE bt.book_id = b.id) AS tags_json`
+       FROM book_tags bt WHERE bt.book_id = b.id) AS tags_json,
+    (SELECT json_group_object(bi.scheme, bi.value)
+       FROM book_identifiers bi WHERE bi.book_id = b.id) AS identifiers_json`
 
 // queryBooks executes a SELECT with the given WHERE/JOIN/ORDER/LIMIT clause
 // appended after "FROM books b". The clause may use positional ? args.
@@ -966,10 +2781,10 @@ func (b *Backend) queryBooks(clause string, args ...any) ([]catalog.Book, error)
 	for rows.Next() {
 		var r bookRow
 		if err := rows.Scan(
-			&r.ID, &r.Title, &r.Summary, &r.Language, &r.Publisher,
-			&r.PublishedAt, &r.UpdatedAt, &r.AddedAt, &r.Series, &r.SeriesIndex, &r.SeriesTotal, &r.Collection, &r.IsRead, &r.Rating,
+			&r.ID, &r.Title, &r.TitleSort, &r.Summary, &r.Language, &r.Languages, &r.Publisher,
+			&r.PublishedAt, &r.UpdatedAt, &r.AddedAt, &r.Series, &r.SeriesIndex, &r.SeriesTotal, &r.Collection, &r.Source, &r.IsRead, &r.ReadAt, &r.Rating, &r.Hidden,
 			&r.CoverURL, &r.ThumbnailURL, &r.FilePath, &r.FileMIME, &r.FileSize,
-			&r.AuthorsJSON, &r.TagsJSON,
+			&r.FilesJSON, &r.AuthorsJSON, &r.TagsJSON, &r.IdentsJSON,
 		); err != nil {
 			return nil, err
 		}
@@ -998,3 +2813,28 @@ func boolToInt(b bool) int {
 	}
 	return 0
 }
+
+// unixOrNil returns t as Unix seconds, or nil (SQL NULL) when t is zero.
+func unixOrNil(t time.Time) *int64 {
+	if t.IsZero() {
+		return nil
+	}
+	u := t.Unix()
+	return &u
+}
+
+// skipHidden reports whether a scan skips the file or directory called
+// name: a dotfile or dot-directory, unless ScanHiddenFiles is set.
+func (b *Backend) skipHidden(name string) bool {
+	return !b.scanHiddenFiles && strings.HasPrefix(name, ".")
+}
+
+// sameDir reports whether a and b refer to the same directory path.
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
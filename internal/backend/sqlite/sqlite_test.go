@@ -4,11 +4,18 @@ import (
 	"archive/zip"
 	"bytes"
 	"database/sql"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/epub"
 	_ "modernc.org/sqlite"
 )
 
@@ -171,6 +178,87 @@ func TestSQLiteBackend_Search(t *testing.T) {
 	}
 }
 
+func TestSQLiteBackend_FullTextSearch(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+	if !b.fts {
+		t.Skip("sqlite build without FTS5")
+	}
+
+	now := time.Now()
+	for _, bk := range []catalog.Book{
+		{ID: "summary", Title: "Steel Dawn", Summary: "A sci-fi tale of a lonely robot.", Authors: []catalog.Author{{Name: "Ann Leckie"}}},
+		{ID: "title", Title: "Robot Dreams", Summary: "Short sci-fi stories.", Authors: []catalog.Author{{Name: "Isaac Asimov"}}},
+		{ID: "tags", Title: "Gears", Tags: []string{"Sci-Fi", "Robots"}, Publisher: "Orbit"},
+		{ID: "other", Title: "Cooking", Summary: "Recipes for robots? No, for people."},
+	} {
+		bk.UpdatedAt, bk.AddedAt = now, now
+		if err := b.insertBook(bk); err != nil {
+			t.Fatalf("insertBook(%s): %v", bk.ID, err)
+		}
+	}
+	ids := func(q catalog.SearchQuery) []string {
+		t.Helper()
+		q.Limit = 10
+		books, total, err := b.Search(q)
+		if err != nil {
+			t.Fatalf("Search(%q) error: %v", q.Query, err)
+		}
+		var out []string
+		for _, bk := range books {
+			out = append(out, bk.ID)
+		}
+		if total != len(out) {
+			t.Errorf("Search(%q): total %d, got %d books", q.Query, total, len(out))
+		}
+		return out
+	}
+
+	// Words from the summary and tags match, as prefixes; matches in the
+	// title or tags outrank one found only in the description.
+	got := ids(catalog.SearchQuery{Query: "sci-fi robot", SortBy: "relevance"})
+	if len(got) != 3 || got[2] != "summary" {
+		t.Errorf("sci-fi robot = %v, want title and tags before summary", got)
+	}
+	if got := ids(catalog.SearchQuery{Query: "orbit"}); len(got) != 1 || got[0] != "tags" {
+		t.Errorf("publisher search = %v, want [tags]", got)
+	}
+	if got := ids(catalog.SearchQuery{Query: "asimov"}); len(got) != 1 || got[0] != "title" {
+		t.Errorf("author search = %v, want [title]", got)
+	}
+	if got := ids(catalog.SearchQuery{Query: `"(`}); len(got) != 0 {
+		t.Errorf("punctuation-only search = %v, want none", got)
+	}
+
+	// The index follows metadata edits and deletions.
+	summary := "A cosy mystery."
+	if _, err := b.UpdateBook("summary", catalog.BookUpdate{Summary: &summary, Authors: []string{"Agatha Christie"}}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+	if got := ids(catalog.SearchQuery{Query: "lonely"}); len(got) != 0 {
+		t.Errorf("old summary still indexed: %v", got)
+	}
+	if got := ids(catalog.SearchQuery{Query: "mystery christie"}); len(got) != 1 || got[0] != "summary" {
+		t.Errorf("updated metadata search = %v, want [summary]", got)
+	}
+	if _, err := b.db.Exec(`DELETE FROM books WHERE id = 'tags'`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got := ids(catalog.SearchQuery{Query: "orbit"}); len(got) != 0 {
+		t.Errorf("deleted book still indexed: %v", got)
+	}
+
+	// Without FTS5 the substring search over titles still works.
+	b.fts = false
+	if got := ids(catalog.SearchQuery{Query: "dream"}); len(got) != 1 || got[0] != "title" {
+		t.Errorf("LIKE fallback = %v, want [title]", got)
+	}
+}
+
 func TestSQLiteBackend_AuthorsAndTags(t *testing.T) {
 	dir := t.TempDir()
 	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author One", "SciFi")
@@ -201,6 +289,37 @@ func TestSQLiteBackend_AuthorsAndTags(t *testing.T) {
 	_ = tags
 }
 
+func TestSQLiteBackend_AuthorsAndTags_Counts(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author One", "SciFi")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author One", "SciFi")
+	createMinimalEPUB(t, filepath.Join(dir, "c.epub"), "Book C", "Author Two", "Fantasy")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	authors, total, err := b.AuthorsWithCounts(0, 50)
+	if err != nil {
+		t.Fatalf("AuthorsWithCounts() error: %v", err)
+	}
+	want := []catalog.AuthorEntry{{Name: "Author One", Count: 2}, {Name: "Author Two", Count: 1}}
+	if total != 2 || !reflect.DeepEqual(authors, want) {
+		t.Errorf("AuthorsWithCounts() = %v (total %d), want %v", authors, total, want)
+	}
+
+	tags, total, err := b.TagsWithCounts(0, 50)
+	if err != nil {
+		t.Fatalf("TagsWithCounts() error: %v", err)
+	}
+	wantTags := []catalog.TagEntry{{Name: "Fantasy", Count: 1}, {Name: "SciFi", Count: 2}}
+	if total != 2 || !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("TagsWithCounts() = %v (total %d), want %v", tags, total, wantTags)
+	}
+}
+
 func TestSQLiteBackend_BooksByAuthor(t *testing.T) {
 	dir := t.TempDir()
 	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Common Author", "")
@@ -223,6 +342,62 @@ func TestSQLiteBackend_BooksByAuthor(t *testing.T) {
 	_ = books
 }
 
+func TestSQLiteBackend_AuthorAliases(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Richard Bachman", "")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Other Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	// An alias of an author without books of its own is listed under the
+	// name the alias was defined with.
+	if err := b.SetAuthorAlias("Richard Bachman", "Stephen King"); err != nil {
+		t.Fatalf("SetAuthorAlias() error: %v", err)
+	}
+	authors, total, err := b.Authors(0, 50)
+	if err != nil {
+		t.Fatalf("Authors() error: %v", err)
+	}
+	if total != 2 || strings.Join(authors, "|") != "Other Author|Stephen King" {
+		t.Errorf("Authors() = %q (total %d), want Other Author and Stephen King", authors, total)
+	}
+	if _, total, _ := b.BooksByAuthor("stephen king", 0, 50); total != 1 {
+		t.Errorf("BooksByAuthor(Stephen King): got %d books, want 1", total)
+	}
+
+	// Making the canonical author an alias moves its aliases along.
+	if err := b.SetAuthorAlias("Stephen King", "Other Author"); err != nil {
+		t.Fatalf("SetAuthorAlias() error: %v", err)
+	}
+	if err := b.SetAuthorAlias("Other Author", "Richard Bachman"); err == nil {
+		t.Error("expected an error aliasing an author to its own alias")
+	}
+
+	b2, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b2.Close()
+	authors, total, err = b2.Authors(0, 50)
+	if err != nil {
+		t.Fatalf("Authors() error: %v", err)
+	}
+	if total != 1 || strings.Join(authors, "|") != "Other Author" {
+		t.Errorf("Authors() after reopening = %q (total %d), want Other Author", authors, total)
+	}
+	books, total, err := b2.BooksByAuthor("Richard Bachman", 0, 50)
+	if err != nil {
+		t.Fatalf("BooksByAuthor() error: %v", err)
+	}
+	if total != 2 || len(books) != 2 {
+		t.Errorf("BooksByAuthor(Richard Bachman): got %d books, want 2", total)
+	}
+}
+
 func TestSQLiteBackend_Pagination(t *testing.T) {
 	dir := t.TempDir()
 	for i := 0; i < 5; i++ {
@@ -321,6 +496,68 @@ func TestSQLiteBackend_UpdateBook(t *testing.T) {
 	}
 }
 
+func TestSQLiteBackend_UpdateBook_TracksReadAt(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "Sci-Fi")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author", "Sci-Fi")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	books, _, _ := b.AllBooks(0, 50)
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books, got %d", len(books))
+	}
+	ids := map[string]string{}
+	for _, bk := range books {
+		ids[bk.Title] = bk.ID
+	}
+
+	read, unread := true, false
+	base := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return base }
+	updated, err := b.UpdateBook(ids["Book A"], catalog.BookUpdate{IsRead: &read})
+	if err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+	if !updated.ReadAt.Equal(base) {
+		t.Errorf("ReadAt: got %v, want %v", updated.ReadAt, base)
+	}
+
+	// Marking an already-read book read again keeps the original date.
+	b.now = func() time.Time { return base.Add(time.Hour) }
+	if updated, err = b.UpdateBook(ids["Book A"], catalog.BookUpdate{IsRead: &read}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+	if !updated.ReadAt.Equal(base) {
+		t.Errorf("ReadAt after re-marking: got %v, want %v", updated.ReadAt, base)
+	}
+	if _, err := b.UpdateBook(ids["Book B"], catalog.BookUpdate{IsRead: &read}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+
+	got, total, err := b.Search(catalog.SearchQuery{ReadOnly: true, SortBy: "read_at", SortOrder: "desc", Limit: 10})
+	if err != nil {
+		t.Fatalf("Search() error: %v", err)
+	}
+	if total != 2 || got[0].Title != "Book B" || got[1].Title != "Book A" {
+		t.Errorf("recently read order: got %v (total %d), want [Book B, Book A]", got, total)
+	}
+
+	if updated, err = b.UpdateBook(ids["Book A"], catalog.BookUpdate{IsRead: &unread}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+	if !updated.ReadAt.IsZero() {
+		t.Errorf("ReadAt should be cleared when unread, got %v", updated.ReadAt)
+	}
+	if _, total, _ := b.Search(catalog.SearchQuery{ReadOnly: true, Limit: 10}); total != 1 {
+		t.Errorf("ReadOnly total: got %d, want 1", total)
+	}
+}
+
 func TestSQLiteBackend_Refresh_RemovesDeletedFiles(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "book.epub")
@@ -351,6 +588,56 @@ func TestSQLiteBackend_Refresh_RemovesDeletedFiles(t *testing.T) {
 	}
 }
 
+func TestSQLiteBackend_CoverCleanup(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "deleted.epub"), "Deleted", "Author", "")
+	vanishing := filepath.Join(dir, "vanishing.epub")
+	createMinimalEPUB(t, vanishing, "Vanishing", "Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	books, _, _ := b.AllBooks(0, 50)
+	coversDir := filepath.Join(dir, ".covers")
+	cover := func(id, ext string) string { return filepath.Join(coversDir, id+ext) }
+	for _, bk := range books {
+		if err := b.UpdateCover(bk.ID, io.NopCloser(strings.NewReader("png")), ".png"); err != nil {
+			t.Fatalf("UpdateCover() error: %v", err)
+		}
+		if err := os.WriteFile(epub.ThumbnailPath(coversDir, bk.ID), []byte("thumb"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted, gone := books[0], books[1]
+	if deleted.Title != "Deleted" {
+		deleted, gone = gone, deleted
+	}
+
+	// DeleteBook removes a cover of any image type, not just .jpg.
+	if err := b.DeleteBook(deleted.ID); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	for _, p := range []string{cover(deleted.ID, ".png"), epub.ThumbnailPath(coversDir, deleted.ID)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after DeleteBook", filepath.Base(p))
+		}
+	}
+
+	// Refresh prunes the covers of books whose files vanished.
+	if err := os.Remove(vanishing); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if _, err := os.Stat(cover(gone.ID, ".png")); !os.IsNotExist(err) {
+		t.Error("cover of a vanished book survived Refresh")
+	}
+}
+
 // TestMigrateSchema_FreshDB verifies that migrateSchema sets PRAGMA user_version
 // to currentSchemaVersion on a brand-new database.
 func TestMigrateSchema_FreshDB(t *testing.T) {
@@ -457,6 +744,35 @@ CREATE TABLE book_tags   (book_id TEXT, tag TEXT, PRIMARY KEY(book_id,tag));
 	}
 }
 
+// TestMigration19_FixesCachedThumbnailURLs verifies that thumbnail URLs
+// stored as the full-size cover URL point at the thumbnail endpoint.
+func TestMigration19_FixesCachedThumbnailURLs(t *testing.T) {
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	if _, err := b.db.Exec(`INSERT INTO books (id, title, updated_at, file_path, cover_url, thumbnail_url) VALUES
+    ('a', 'A', 0, 'a.epub', '/covers/a', '/covers/a'),
+    ('b', 'B', 0, 'b.epub', '/covers/b', '/covers/b/thumb'),
+    ('c', 'C', 0, 'c.epub', '', '')`); err != nil {
+		t.Fatalf("insert books: %v", err)
+	}
+	if err := migration19(b.db); err != nil {
+		t.Fatalf("migration19: %v", err)
+	}
+	for id, want := range map[string]string{"a": "/covers/a/thumb", "b": "/covers/b/thumb", "c": ""} {
+		var got string
+		if err := b.db.QueryRow(`SELECT thumbnail_url FROM books WHERE id = ?`, id).Scan(&got); err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		if got != want {
+			t.Errorf("book %s: thumbnail_url %q, want %q", id, got, want)
+		}
+	}
+}
+
 // TestBackup_CreatesFile verifies that Backup() creates a non-empty .db file
 // in the specified directory and returns its path.
 func TestBackup_CreatesFile(t *testing.T) {
@@ -540,3 +856,786 @@ func TestBackup_PrunesOldFiles(t *testing.T) {
 		t.Errorf("expected %d backups after pruning, got %d", keep, count)
 	}
 }
+
+// TestMigrateSchema_RejectsNewerDB verifies that New() refuses to open a
+// database whose user_version is ahead of currentSchemaVersion.
+func TestMigrateSchema_RejectsNewerDB(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, dbFilename)
+
+	db, err := openSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, currentSchemaVersion+1)); err != nil {
+		db.Close()
+		t.Fatalf("set user_version: %v", err)
+	}
+	db.Close()
+
+	b, err := New(dir)
+	if err == nil {
+		b.Close()
+		t.Fatal("expected New() to fail on a database from a newer binary")
+	}
+	if !strings.Contains(err.Error(), "upgrade nxt-opds") {
+		t.Errorf("expected error advising an upgrade, got: %v", err)
+	}
+}
+
+// TestSQLiteBackend_Decades verifies decade bucketing and year-range filtering.
+func TestSQLiteBackend_Decades(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "Fiction")
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author", "Fiction")
+	createMinimalEPUB(t, filepath.Join(dir, "c.epub"), "Book C", "Author", "Fiction")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	// createMinimalEPUB dates every book 2024-01-01; move two of them back.
+	for title, date := range map[string]string{"Book A": "1984-07-01", "Book B": "1992-06-01"} {
+		if _, err := b.db.Exec(`UPDATE books SET published_at = unixepoch(?) WHERE title = ?`, date, title); err != nil {
+			t.Fatalf("set published_at: %v", err)
+		}
+	}
+
+	decades, err := b.Decades()
+	if err != nil {
+		t.Fatalf("Decades() error: %v", err)
+	}
+	want := []catalog.DecadeEntry{{Decade: 1980, Count: 1}, {Decade: 1990, Count: 1}, {Decade: 2020, Count: 1}}
+	if len(decades) != len(want) {
+		t.Fatalf("Decades(): got %v, want %v", decades, want)
+	}
+	for i := range want {
+		if decades[i] != want[i] {
+			t.Errorf("decade %d: got %+v, want %+v", i, decades[i], want[i])
+		}
+	}
+
+	books, total, err := b.BooksByYearRange(1980, 1999, 0, 50)
+	if err != nil {
+		t.Fatalf("BooksByYearRange() error: %v", err)
+	}
+	if total != 2 || len(books) != 2 {
+		t.Fatalf("expected 2 books in 1980–1999, got total=%d len=%d", total, len(books))
+	}
+	if books[0].Title != "Book A" {
+		t.Errorf("expected oldest book first, got %q", books[0].Title)
+	}
+}
+
+func TestSQLiteBackend_CoversDirInsideRootIsNotScanned(t *testing.T) {
+	dir := t.TempDir()
+	coversDir := filepath.Join(dir, "artwork")
+	if err := os.MkdirAll(coversDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMinimalEPUB(t, filepath.Join(dir, "book.epub"), "Real Book", "Author", "")
+	// A stray EPUB inside the covers dir must not be indexed.
+	createMinimalEPUB(t, filepath.Join(coversDir, "stray.epub"), "Stray", "Author", "")
+
+	b, err := NewWithOptions(dir, Options{CoversDir: coversDir})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+
+	books, total, err := b.AllBooks(0, 50)
+	if err != nil {
+		t.Fatalf("AllBooks() error: %v", err)
+	}
+	if total != 1 || books[0].Title != "Real Book" {
+		t.Fatalf("expected only Real Book, got %d books: %+v", total, books)
+	}
+
+	id := books[0].ID
+	if err := b.UpdateCover(id, io.NopCloser(strings.NewReader("jpeg")), ".jpg"); err != nil {
+		t.Fatalf("UpdateCover() error: %v", err)
+	}
+	path, err := b.CoverPath(id)
+	if err != nil {
+		t.Fatalf("CoverPath() error: %v", err)
+	}
+	if filepath.Dir(path) != coversDir {
+		t.Errorf("CoverPath() = %q, want a file in %q", path, coversDir)
+	}
+
+	if err := b.UpdateCover(id, io.NopCloser(strings.NewReader("<html>")), ".html"); err == nil {
+		t.Error("UpdateCover() with a non-image extension: expected an error")
+	}
+	if got, _ := b.CoverPath(id); got != path {
+		t.Errorf("rejected cover replaced the existing one: CoverPath() = %q", got)
+	}
+}
+
+func TestSQLiteBackend_RetriesPartiallyCopiedFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// Build a complete EPUB elsewhere, then write only its first half into
+	// the books dir to simulate a copy still in progress.
+	full := filepath.Join(t.TempDir(), "full.epub")
+	createMinimalEPUB(t, full, "Late Arrival", "Author", "")
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "late.epub")
+	if err := os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewWithOptions(dir, Options{PendingRetryDelay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+	if pending := b.Pending(); len(pending) != 1 || pending[0] != path {
+		t.Fatalf("Pending() = %v, want [%s]", pending, path)
+	}
+
+	// Finish the "copy"; the scheduled retry must pick it up on its own.
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The book is visible as soon as it is inserted, before the retry's
+	// Refresh finishes and clears the pending list.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, total, _ := b.AllBooks(0, 10)
+		pending := b.Pending()
+		if total == 1 && len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after the automatic retry: %d books, pending %v; want 1 and none", total, pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBackup_OrderedAcrossDST verifies that backups taken on either side of a
+// DST change are named unambiguously and that pruning keeps the newest ones.
+func TestBackup_OrderedAcrossDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	// Paris falls back from 03:00 CEST to 02:00 CET on 2024-10-27, so the
+	// wall clock reads 02:30 before 02:10.
+	times := []time.Time{
+		time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+		time.Date(2024, 10, 27, 1, 10, 0, 0, time.UTC), // 02:10 CET
+		time.Date(2024, 10, 27, 1, 40, 0, 0, time.UTC), // 02:40 CET
+	}
+
+	for _, tc := range []struct {
+		name string
+		loc  *time.Location
+	}{
+		{"UTC", nil},
+		{"Europe/Paris", paris},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			b, err := NewWithOptions(dir, Options{BackupLocation: tc.loc})
+			if err != nil {
+				t.Fatalf("NewWithOptions() error: %v", err)
+			}
+			defer b.Close()
+			backupDir := filepath.Join(dir, "backups")
+
+			var names []string
+			for _, now := range times {
+				now := now
+				b.now = func() time.Time { return now }
+				path, err := b.Backup(backupDir, 2)
+				if err != nil {
+					t.Fatalf("Backup() error: %v", err)
+				}
+				names = append(names, filepath.Base(path))
+			}
+
+			for i, n := range names {
+				if got := backupTime(n); !got.Equal(times[i]) {
+					t.Errorf("backupTime(%q) = %v, want %v", n, got, times[i])
+				}
+			}
+			if tc.loc == nil {
+				sorted := append([]string(nil), names...)
+				sort.Strings(sorted)
+				if strings.Join(sorted, ",") != strings.Join(names, ",") {
+					t.Errorf("UTC backup names do not sort chronologically: %v", names)
+				}
+			}
+
+			entries, err := os.ReadDir(backupDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, e := range entries {
+				kept = append(kept, e.Name())
+			}
+			sort.Strings(kept)
+			want := []string{names[1], names[2]}
+			sort.Strings(want)
+			if strings.Join(kept, ",") != strings.Join(want, ",") {
+				t.Errorf("kept %v, want the two newest %v", kept, want)
+			}
+		})
+	}
+}
+
+func TestSQLiteBackend_PaginationStableForIdenticalTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("dup%02d.epub", i))
+		createMinimalEPUB(t, path, "Same Title", "Same Author", "Bulk")
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	pages := func() []string {
+		var ids []string
+		for offset := 0; offset < 12; offset += 5 {
+			books, _, err := b.AllBooks(offset, 5)
+			if err != nil {
+				t.Fatalf("AllBooks(%d) error: %v", offset, err)
+			}
+			for _, bk := range books {
+				ids = append(ids, bk.ID)
+			}
+		}
+		return ids
+	}
+
+	first := pages()
+	if len(first) != 12 {
+		t.Fatalf("expected 12 books across pages, got %d", len(first))
+	}
+	seen := map[string]bool{}
+	for i, id := range first {
+		if seen[id] {
+			t.Errorf("book %s returned on two pages", id)
+		}
+		seen[id] = true
+		if i > 0 && first[i-1] > id {
+			t.Errorf("ties not broken by ID: %s before %s", first[i-1], id)
+		}
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if again := pages(); strings.Join(again, ",") != strings.Join(first, ",") {
+		t.Errorf("order changed between requests:\n%v\n%v", first, again)
+	}
+}
+
+func TestSQLiteBackend_Progress(t *testing.T) {
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+	now := time.Now()
+	if err := b.insertBook(catalog.Book{ID: "b1", Title: "Book", UpdatedAt: now, AddedAt: now}); err != nil {
+		t.Fatalf("insertBook: %v", err)
+	}
+
+	if p, err := b.GetProgress("b1"); err != nil || p != 0 {
+		t.Fatalf("GetProgress before any update = %v, %v; want 0", p, err)
+	}
+	for _, want := range []float64{0.25, 1} {
+		if err := b.SetProgress("b1", want); err != nil {
+			t.Fatalf("SetProgress(%v): %v", want, err)
+		}
+		if p, err := b.GetProgress("b1"); err != nil || p != want {
+			t.Errorf("GetProgress = %v, %v; want %v", p, err, want)
+		}
+	}
+	if err := b.SetProgress("b1", 1.01); err == nil {
+		t.Error("SetProgress(1.01): expected an error")
+	}
+	if err := b.SetProgress("missing", 0.5); err == nil {
+		t.Error("SetProgress on unknown book: expected an error")
+	}
+
+	if err := b.DeleteBook("b1"); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	var n int
+	if err := b.db.QueryRow(`SELECT COUNT(*) FROM reading_progress`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("progress rows after delete = %d, %v; want 0", n, err)
+	}
+}
+
+func TestSQLiteBackend_DownloadCounts(t *testing.T) {
+	b, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+	now := time.Now()
+	for _, id := range []string{"b1", "b2", "b3"} {
+		if err := b.insertBook(catalog.Book{ID: id, Title: id, UpdatedAt: now, AddedAt: now}); err != nil {
+			t.Fatalf("insertBook: %v", err)
+		}
+	}
+
+	for _, id := range []string{"b2", "b1", "b2"} {
+		if err := b.RecordDownload(id); err != nil {
+			t.Fatalf("RecordDownload(%s): %v", id, err)
+		}
+	}
+	if n, err := b.DownloadCount("b2"); err != nil || n != 2 {
+		t.Errorf("DownloadCount(b2) = %d, %v; want 2", n, err)
+	}
+	if n, err := b.DownloadCount("b3"); err != nil || n != 0 {
+		t.Errorf("DownloadCount(b3) = %d, %v; want 0", n, err)
+	}
+	if err := b.RecordDownload("missing"); err == nil {
+		t.Error("RecordDownload on unknown book: expected an error")
+	}
+
+	books, total, err := b.PopularBooks(0, 10)
+	if err != nil {
+		t.Fatalf("PopularBooks: %v", err)
+	}
+	if total != 2 || len(books) != 2 || books[0].ID != "b2" || books[1].ID != "b1" {
+		t.Errorf("PopularBooks = %v (total %d); want [b2 b1] (total 2)", books, total)
+	}
+
+	if err := b.DeleteBook("b2"); err != nil {
+		t.Fatalf("DeleteBook: %v", err)
+	}
+	if _, total, err := b.PopularBooks(0, 10); err != nil || total != 1 {
+		t.Errorf("PopularBooks total after delete = %d, %v; want 1", total, err)
+	}
+}
+
+func TestSQLiteBackend_RefreshWithStats(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	refresh := func(want catalog.RefreshStats) {
+		t.Helper()
+		stats, err := b.RefreshWithStats()
+		if err != nil {
+			t.Fatalf("RefreshWithStats() error: %v", err)
+		}
+		if stats != want {
+			t.Errorf("RefreshWithStats() = %+v, want %+v", stats, want)
+		}
+	}
+	refresh(catalog.RefreshStats{})
+
+	createMinimalEPUB(t, filepath.Join(dir, "b.epub"), "Book B", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, "c.epub"), "Book C", "Author", "")
+	refresh(catalog.RefreshStats{Added: 2})
+
+	if err := os.Remove(filepath.Join(dir, "a.epub")); err != nil {
+		t.Fatal(err)
+	}
+	refresh(catalog.RefreshStats{Removed: 1})
+}
+
+func TestSQLiteBackend_SkipsHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	createMinimalEPUB(t, filepath.Join(dir, "visible.epub"), "Visible", "Author", "")
+	createMinimalEPUB(t, filepath.Join(dir, ".hidden.epub"), "Dotfile", "Author", "")
+	if err := os.Mkdir(filepath.Join(dir, ".staging"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMinimalEPUB(t, filepath.Join(dir, ".staging", "book.epub"), "Staged", "Author", "")
+
+	for _, tc := range []struct {
+		scanHidden bool
+		want       int
+	}{
+		{false, 1},
+		{true, 3},
+	} {
+		b, err := NewWithOptions(dir, Options{ScanHiddenFiles: tc.scanHidden})
+		if err != nil {
+			t.Fatalf("NewWithOptions() error: %v", err)
+		}
+		defer b.Close()
+		books, total, _ := b.AllBooks(0, 10)
+		if total != tc.want {
+			t.Errorf("ScanHiddenFiles %v: got %d books, want %d", tc.scanHidden, total, tc.want)
+		}
+		if !tc.scanHidden && total == 1 && books[0].Title != "Visible" {
+			t.Errorf("ScanHiddenFiles false: indexed %q, want only Visible", books[0].Title)
+		}
+	}
+}
+
+func TestSQLiteBackend_RefreshReparsesChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "book.epub")
+	createMinimalEPUB(t, path, "Old Title", "Author", "Fiction")
+
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+
+	books, _, _ := b.AllBooks(0, 10)
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book, got %d", len(books))
+	}
+	id := books[0].ID
+	tags, rating := []string{"Edited"}, 4
+	if _, err := b.UpdateBook(id, catalog.BookUpdate{Tags: tags, Rating: &rating}); err != nil {
+		t.Fatalf("UpdateBook() error: %v", err)
+	}
+
+	// Rewrite the file with new metadata, as an external editor would.
+	createMinimalEPUB(t, path, "New Title", "Author", "Fiction")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := b.RefreshWithStats()
+	if err != nil {
+		t.Fatalf("RefreshWithStats() error: %v", err)
+	}
+	if want := (catalog.RefreshStats{Updated: 1}); stats != want {
+		t.Errorf("RefreshWithStats() = %+v, want %+v", stats, want)
+	}
+
+	bk, err := b.BookByID(id)
+	if err != nil {
+		t.Fatalf("BookByID() error: %v", err)
+	}
+	if bk.Title != "New Title" {
+		t.Errorf("Title = %q, want the re-parsed %q", bk.Title, "New Title")
+	}
+	if len(bk.Tags) != 1 || bk.Tags[0] != "Edited" {
+		t.Errorf("Tags = %v, want the edited [Edited]", bk.Tags)
+	}
+	if bk.Rating != 4 {
+		t.Errorf("Rating = %d, want 4", bk.Rating)
+	}
+
+	if stats, err := b.RefreshWithStats(); err != nil || stats != (catalog.RefreshStats{}) {
+		t.Errorf("unchanged RefreshWithStats() = %+v, %v; want no changes", stats, err)
+	}
+}
+
+func TestSQLiteBackend_AddedAtIndexing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.epub")
+	createMinimalEPUB(t, path, "Restored Book", "Author", "")
+	old := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	opts := Options{EPUB: epub.Options{AddedAt: epub.AddedAtIndexing}}
+	b, err := NewWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+
+	books, _, _ := b.AllBooks(0, 10)
+	if len(books) != 1 {
+		t.Fatalf("expected 1 book, got %d", len(books))
+	}
+	if books[0].AddedAt.Before(start) {
+		t.Errorf("AddedAt = %v, want the indexing time, not the file's %v", books[0].AddedAt, old)
+	}
+
+	// The date is kept by later scans and across restarts.
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	b2, err := NewWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen NewWithOptions() error: %v", err)
+	}
+	defer b2.Close()
+	bk, err := b2.BookByID(books[0].ID)
+	if err != nil {
+		t.Fatalf("BookByID() error: %v", err)
+	}
+	if bk.AddedAt.Unix() != books[0].AddedAt.Unix() {
+		t.Errorf("AddedAt after restart = %v, want %v", bk.AddedAt, books[0].AddedAt)
+	}
+}
+
+func TestSQLiteBackend_LastModified(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(dir)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer b.Close()
+	if lm, err := b.LastModified(); err != nil || !lm.IsZero() {
+		t.Fatalf("empty catalog: LastModified() = %v, %v; want zero time", lm, err)
+	}
+
+	start := time.Now().Truncate(time.Second)
+	createMinimalEPUB(t, filepath.Join(dir, "a.epub"), "Book A", "Author", "")
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if lm, err := b.LastModified(); err != nil || lm.Before(start) {
+		t.Fatalf("after add: LastModified() = %v, %v; want >= %v", lm, err, start)
+	}
+
+	books, _, _ := b.AllBooks(0, 1)
+	before := time.Now()
+	if err := b.DeleteBook(books[0].ID); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if lm, _ := b.LastModified(); lm.Before(before) {
+		t.Errorf("after delete: LastModified() = %v, want >= %v", lm, before)
+	}
+
+	// A book added with dates older than the last change still moves
+	// LastModified forward.
+	old := filepath.Join(dir, "old.epub")
+	createMinimalEPUB(t, old, "Old Book", "Author",
+		`</dc:subject><meta property="dcterms:modified">2001-01-01T00:00:00Z</meta><dc:subject>`)
+	past := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+	before = time.Now().Truncate(time.Second)
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	if lm, _ := b.LastModified(); lm.Before(before) {
+		t.Errorf("after adding an old book: LastModified() = %v, want >= %v", lm, before)
+	}
+}
+
+func TestSQLiteBackend_ScanOptionsKeepSizesAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	want := make(map[string]os.FileInfo)
+	for i := range 12 {
+		path := filepath.Join(dir, fmt.Sprintf("doc%02d.pdf", i))
+		if i%3 == 0 {
+			path = filepath.Join(dir, fmt.Sprintf("book%02d.epub", i))
+			createMinimalEPUB(t, path, fmt.Sprintf("Book %d", i), "Author", "")
+		} else if err := os.WriteFile(path, make([]byte, 100*i), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mod := time.Date(2021, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The listing describes a symbolic link, not its target: its size and
+	// time must still come from the target.
+	if err := os.Symlink(filepath.Join(dir, "doc01.pdf"), filepath.Join(dir, "link.pdf")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[path] = info
+	}
+
+	b, err := NewWithOptions(dir, Options{ScanConcurrency: 4, TrustDirListing: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+	books, total, err := b.AllBooks(0, 100)
+	if err != nil {
+		t.Fatalf("AllBooks() error: %v", err)
+	}
+	if total != len(want) {
+		t.Fatalf("total = %d, want %d", total, len(want))
+	}
+	for _, bk := range books {
+		f := bk.Files[0]
+		info := want[f.Path]
+		if info == nil {
+			t.Errorf("unexpected book file %q", f.Path)
+			continue
+		}
+		if f.Size != info.Size() || !bk.AddedAt.Equal(info.ModTime()) {
+			t.Errorf("%s: size %d, added %v; want %d, %v", filepath.Base(f.Path), f.Size, bk.AddedAt, info.Size(), info.ModTime())
+		}
+	}
+}
+
+func TestSQLiteBackend_DeleteBookRemovesEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "Fiction", "Asimov")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createMinimalEPUB(t, filepath.Join(nested, "foundation.epub"), "Foundation", "Isaac Asimov", "")
+	createMinimalEPUB(t, filepath.Join(dir, "Fiction", "dune.epub"), "Dune", "Frank Herbert", "")
+
+	b, err := NewWithOptions(dir, Options{RemoveEmptyDirs: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+	ids := map[string]string{}
+	books, _, _ := b.AllBooks(0, 10)
+	for _, bk := range books {
+		ids[bk.Title] = bk.ID
+	}
+
+	if err := b.DeleteBook(ids["Foundation"]); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction", "Asimov")); !os.IsNotExist(err) {
+		t.Errorf("Fiction/Asimov still exists after deleting its only book (err %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction")); err != nil {
+		t.Errorf("Fiction, which still holds a book, was removed: %v", err)
+	}
+
+	if err := b.DeleteBook(ids["Dune"]); err != nil {
+		t.Fatalf("DeleteBook() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Fiction")); !os.IsNotExist(err) {
+		t.Errorf("Fiction still exists after deleting its last book (err %v)", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("books directory removed: %v", err)
+	}
+}
+
+func TestSQLiteBackend_DedupeByHash(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "a-dune.epub")
+	createMinimalEPUB(t, orig, "Dune", "Frank Herbert", "")
+	createMinimalEPUB(t, filepath.Join(dir, "c-other.epub"), "Other", "Someone Else", "")
+	data, err := os.ReadFile(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyTo := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyTo("b-dune-copy.epub")
+
+	b, err := NewWithOptions(dir, Options{DedupeByHash: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+
+	dune := func(wantFiles int) {
+		t.Helper()
+		books, total, err := b.AllBooks(0, 50)
+		if err != nil || total != 2 {
+			t.Fatalf("AllBooks() = %d books, err %v; want 2", total, err)
+		}
+		for _, bk := range books {
+			if bk.Title == "Dune" && len(bk.Files) != wantFiles {
+				t.Errorf("Dune has %d files, want %d: %+v", len(bk.Files), wantFiles, bk.Files)
+			}
+		}
+	}
+	dune(2)
+
+	// A copy found by a later scan joins the same book.
+	copyTo("d-dune-again.epub")
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	dune(3)
+
+	var books, files int
+	if err := b.db.QueryRow(`SELECT COUNT(*) FROM books WHERE content_hash IS NOT NULL`).Scan(&books); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.QueryRow(`SELECT COUNT(*) FROM book_files WHERE content_hash IS NOT NULL`).Scan(&files); err != nil {
+		t.Fatal(err)
+	}
+	if books != 2 || files != 2 {
+		t.Errorf("hashed rows: %d books, %d extra files; want 2 and 2", books, files)
+	}
+
+	// The book outlives the removal of its first file.
+	if err := os.Remove(orig); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("Refresh() error: %v", err)
+	}
+	dune(2)
+}
+
+func TestSQLiteBackend_SearchPageCountCap(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		createMinimalEPUB(t, filepath.Join(dir, fmt.Sprintf("%d.epub", i)), fmt.Sprintf("Dragon %d", i), "Author", "")
+	}
+	b, err := NewWithOptions(dir, Options{SearchCountCap: 3})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error: %v", err)
+	}
+	defer b.Close()
+
+	for _, fts := range []bool{true, false} {
+		b.fts = fts
+		for _, tc := range []struct {
+			q            catalog.SearchQuery
+			items, total int
+			approximate  bool
+		}{
+			{catalog.SearchQuery{Limit: 2}, 2, 3, true},
+			{catalog.SearchQuery{Query: "dragon", Limit: 2}, 2, 3, true},
+			// Deep pages raise the lower bound to cover themselves.
+			{catalog.SearchQuery{Query: "dragon", Offset: 4, Limit: 2}, 1, 5, true},
+			// Below the cap the count is exact.
+			{catalog.SearchQuery{Query: "dragon 4", Limit: 2}, 1, 1, false},
+		} {
+			page, err := b.SearchPage(tc.q)
+			if err != nil {
+				t.Fatalf("fts=%v SearchPage(%+v) error: %v", fts, tc.q, err)
+			}
+			if len(page.Items) != tc.items || page.Total != tc.total ||
+				page.Approximate != tc.approximate || page.Capped != tc.approximate {
+				t.Errorf("fts=%v SearchPage(%+v) = %d items, total %d, approximate %v, capped %v; want %d, %d, %v",
+					fts, tc.q, len(page.Items), page.Total, page.Approximate, page.Capped, tc.items, tc.total, tc.approximate)
+			}
+		}
+	}
+
+	b.searchCountCap = 0
+	page, err := b.SearchPage(catalog.SearchQuery{Query: "dragon", Limit: 2})
+	if err != nil || page.Total != 5 || page.Approximate || page.Capped {
+		t.Errorf("without a cap: total %d, approximate %v, capped %v, err %v; want an exact 5", page.Total, page.Approximate, page.Capped, err)
+	}
+}
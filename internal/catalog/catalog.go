@@ -3,7 +3,10 @@
 package catalog
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -15,6 +18,10 @@ type Book struct {
 	// Title is the display title of the publication.
 	Title string
 
+	// TitleSort is the key to sort by title (e.g. "Hobbit, The"); empty
+	// means sort by Title.
+	TitleSort string
+
 	// Authors is the list of authors.
 	Authors []Author
 
@@ -24,6 +31,11 @@ type Book struct {
 	// Language is the BCP 47 language tag (e.g. "en", "fr").
 	Language string
 
+	// Languages lists every language tag a multilingual publication
+	// declares, in order, Language being the first. It is nil for books in
+	// a single language.
+	Languages []string
+
 	// Publisher is the publisher name.
 	Publisher string
 
@@ -59,20 +71,98 @@ type Book struct {
 	// not by narrative order (corresponds to EPUB3 belongs-to-collection with type="set").
 	Collection string
 
+	// Source is what the book was derived from (dc:source), e.g. the
+	// original format or file of a converted book ("MOBI").
+	Source string
+
 	// IsRead indicates the user has marked this book as read.
 	IsRead bool
 
+	// ReadAt is when the book was last marked as read (zero if unread).
+	ReadAt time.Time
+
 	// Rating is the user's star rating (0 = not rated, 1–5 stars).
 	Rating int
 
+	// Hidden marks a book staged as a draft, e.g. until its metadata is
+	// fixed: it is left out of every listing, count and search unless
+	// SearchQuery.IncludeHidden is set, but still found by BookByID.
+	Hidden bool
+
 	// AddedAt is when this book was first added to the catalog.
 	AddedAt time.Time
+
+	// Identifiers maps an upper-case scheme (e.g. "ISBN", "UUID", "DOI") to
+	// the identifier value from the publication metadata.
+	Identifiers map[string]string
+}
+
+// ISBN returns the book's ISBN, or "" if it has none.
+func (b Book) ISBN() string {
+	return b.Identifiers["ISBN"]
 }
 
 // Author represents a publication author.
 type Author struct {
 	Name string
 	URI  string
+
+	// SortName is the key to sort by author (e.g. "Tolkien, J. R. R.");
+	// empty means sort by Name.
+	SortName string
+}
+
+// AuthorKey returns the key under which spellings of an author name are
+// grouped: trimmed, with runs of whitespace collapsed, lower-cased and
+// without the space after an initial, so "J.R.R. Tolkien" and
+// "j. r. r.  Tolkien" are the same author.
+func AuthorKey(name string) string {
+	key := strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.ReplaceAll(key, ". ", ".")
+}
+
+// IndexLetters are the entries of an alphabetical index: the letters A to Z,
+// then "#" for names starting with anything else (digits, punctuation,
+// accented letters).
+var IndexLetters = strings.Split("ABCDEFGHIJKLMNOPQRSTUVWXYZ#", "")
+
+// IndexLetter returns the entry of IndexLetters under which name is listed:
+// its first character upper-cased when it is a letter A to Z, "#" otherwise.
+func IndexLetter(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "#"
+	}
+	c := name[0]
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c < 'A' || c > 'Z' {
+		return "#"
+	}
+	return string(c)
+}
+
+// UniqueTags returns tags trimmed, without empty values and without tags
+// that differ only in case, keeping the first spelling seen: ["SciFi",
+// "scifi"] becomes ["SciFi"]. A nil slice stays nil, so the result can be
+// used as a BookUpdate field.
+func UniqueTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		key := strings.ToLower(t)
+		if t == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, t)
+	}
+	return out
 }
 
 // File represents a downloadable file associated with a book.
@@ -87,6 +177,15 @@ type File struct {
 	Size int64
 }
 
+// TotalSize returns the combined size in bytes of all of the book's files.
+func (b Book) TotalSize() int64 {
+	var n int64
+	for _, f := range b.Files {
+		n += f.Size
+	}
+	return n
+}
+
 // SearchQuery carries parameters for catalog search.
 type SearchQuery struct {
 	// Query is the full-text search term.
@@ -104,17 +203,31 @@ type SearchQuery struct {
 	// Collection filters by exact editorial collection name.
 	Collection string
 
-	// Language filters by BCP 47 language tag.
+	// Language filters by BCP 47 language tag (case-insensitive exact match).
 	Language string
 
 	// UnreadOnly restricts results to books not yet marked as read.
 	UnreadOnly bool
 
+	// ReadOnly restricts results to books marked as read.
+	ReadOnly bool
+
 	// Series filters by exact series name (empty = no filter).
 	Series string
 
+	// MinRating restricts results to books rated at least this many stars
+	// (0 = no filter).
+	MinRating int
+
+	// IncludeHidden also returns books marked Hidden.
+	IncludeHidden bool
+
 	// SortBy is the sort field: "" or "added" for added date, "title" for alphabetical,
-	// "series_index" for numeric series position.
+	// "series_index" for numeric series position, "size" for total file size,
+	// "read_at" for the date the book was marked as read, "updated" for the
+	// last modification date, "rating" for star rating (ties newest first),
+	// "relevance" for best match first when Query is set (backends without
+	// ranking sort by added date instead).
 	SortBy string
 
 	// SortOrder is the sort direction: "" or "desc" for descending, "asc" for ascending.
@@ -175,10 +288,48 @@ type NavEntry struct {
 type Uploader interface {
 	// StoreBook saves src as filename inside the catalog's root directory,
 	// indexes it immediately, and returns the resulting Book entry.
-	// src is consumed and closed by the implementation.
+	// src is consumed and closed by the implementation. A src longer than
+	// the backend's upload limit fails with an error wrapping ErrTooLarge
+	// and leaves nothing behind in the catalog.
 	StoreBook(filename string, src io.ReadCloser) (*Book, error)
 }
 
+// ErrTooLarge is wrapped by StoreBook errors for files over the upload
+// limit.
+var ErrTooLarge = errors.New("file too large")
+
+// DefaultMaxUploadSize is the upload limit, in bytes, used when none is
+// configured (100 MiB).
+const DefaultMaxUploadSize = 100 << 20
+
+// UploadLimit resolves a configured upload limit, as found in the
+// MaxUploadSize options: 0 means DefaultMaxUploadSize and a negative value
+// means no limit, returned as -1.
+func UploadLimit(size int64) int64 {
+	switch {
+	case size == 0:
+		return DefaultMaxUploadSize
+	case size < 0:
+		return -1
+	}
+	return size
+}
+
+// CopyLimited copies src to dst like io.Copy but stops once more than
+// UploadLimit(limit) bytes have been read, returning an error wrapping
+// ErrTooLarge.
+func CopyLimited(dst io.Writer, src io.Reader, limit int64) (int64, error) {
+	limit = UploadLimit(limit)
+	if limit < 0 {
+		return io.Copy(dst, src)
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, limit+1))
+	if err == nil && n > limit {
+		return n, fmt.Errorf("%w (limit %d bytes)", ErrTooLarge, limit)
+	}
+	return n, err
+}
+
 // CoverProvider is an optional interface that catalog backends may implement
 // to serve cached cover images by book ID.
 type CoverProvider interface {
@@ -187,6 +338,15 @@ type CoverProvider interface {
 	CoverPath(id string) (string, error)
 }
 
+// ThumbnailProvider is an optional interface that catalog backends may
+// implement to serve downscaled cover thumbnails by book ID.
+type ThumbnailProvider interface {
+	// ThumbnailPath returns the filesystem path to the cached JPEG thumbnail
+	// of the given book's cover, generating it if needed. Returns an error if
+	// the book has no cover or no thumbnail can be produced.
+	ThumbnailPath(id string) (string, error)
+}
+
 // BookUpdate carries the editable fields for a book metadata update.
 // Nil pointer fields are left unchanged; non-nil fields replace the current value.
 // Nil slice fields are left unchanged; non-nil (including empty) slices replace the current value.
@@ -201,8 +361,10 @@ type BookUpdate struct {
 	SeriesIndex *string
 	SeriesTotal *string
 	Collection  *string
+	Source      *string
 	IsRead      *bool
 	Rating      *int
+	Hidden      *bool
 }
 
 // Updater is an optional interface for catalog backends that support book metadata editing.
@@ -220,6 +382,35 @@ type Refresher interface {
 	Refresh() error
 }
 
+// LastModifier is an optional interface for catalog backends that can tell
+// when the catalog last changed. The server uses it for the Atom <updated>
+// of feeds and for Last-Modified / If-Modified-Since.
+type LastModifier interface {
+	// LastModified returns the time of the most recent change to the
+	// catalog: a book added, updated or removed. It returns the zero time
+	// when nothing is known, e.g. for an empty catalog.
+	LastModified() (time.Time, error)
+}
+
+// RefreshStats reports how a refresh changed the catalog.
+type RefreshStats struct {
+	Added   int // books indexed from newly found files
+	Updated int // books parsed again because their files changed on disk
+	Removed int // books dropped because their files are gone
+}
+
+// Changed reports whether the refresh added, updated or removed any book.
+func (s RefreshStats) Changed() bool {
+	return s.Added > 0 || s.Updated > 0 || s.Removed > 0
+}
+
+// StatsRefresher is an optional extension of Refresher for backends that
+// can report what a refresh changed.
+type StatsRefresher interface {
+	// RefreshWithStats is Refresh, returning the books added and removed.
+	RefreshWithStats() (RefreshStats, error)
+}
+
 // SeriesEntry holds a series name and the number of books in it.
 type SeriesEntry struct {
 	Name  string
@@ -234,6 +425,86 @@ type SeriesLister interface {
 	Series() ([]SeriesEntry, error)
 }
 
+// TagEntry holds a tag and the number of books carrying it.
+type TagEntry struct {
+	Name  string
+	Count int
+}
+
+// TagCounter is an optional interface for catalog backends that can list
+// tags with their book counts, e.g. for the OPDS genre navigation feed.
+type TagCounter interface {
+	// TagsWithCounts is Tags, each tag paired with its number of books.
+	TagsWithCounts(offset, limit int) ([]TagEntry, int, error)
+}
+
+// TagRelater is an optional interface for catalog backends that can find
+// the tags that co-occur with a tag, e.g. for "also tagged" recommendations.
+type TagRelater interface {
+	// RelatedTags returns up to limit other tags carried by books that also
+	// carry tag, each paired with the number of such books, the most
+	// shared first and ties sorted alphabetically.
+	RelatedTags(tag string, limit int) ([]TagEntry, error)
+}
+
+// AuthorEntry holds an author name and the number of books by the author.
+type AuthorEntry struct {
+	Name  string
+	Count int
+}
+
+// AuthorCounter is an optional interface for catalog backends that can
+// list authors with their book counts, e.g. for the OPDS author
+// navigation feed.
+type AuthorCounter interface {
+	// AuthorsWithCounts is Authors, each author paired with the number of
+	// books BooksByAuthor returns for it.
+	AuthorsWithCounts(offset, limit int) ([]AuthorEntry, int, error)
+}
+
+// AuthorIndexer is an optional interface for catalog backends that can list
+// authors by initial, for an alphabetical index of long author lists.
+type AuthorIndexer interface {
+	// AuthorsByLetter is AuthorsWithCounts restricted to the authors whose
+	// sort name is listed under letter, an entry of IndexLetters (see
+	// IndexLetter).
+	AuthorsByLetter(letter string, offset, limit int) ([]AuthorEntry, int, error)
+}
+
+// LanguageEntry holds a language tag and the number of books in it.
+type LanguageEntry struct {
+	// Language is the lower-cased BCP 47 tag (e.g. "fr", "en-gb").
+	Language string
+	Count    int
+}
+
+// LanguageLister is an optional interface for catalog backends that support
+// listing the languages of their books, e.g. for OPDS language facets.
+type LanguageLister interface {
+	// Languages returns every distinct non-empty language tag, compared
+	// case-insensitively and sorted, each paired with its book count.
+	Languages() ([]LanguageEntry, error)
+}
+
+// DecadeEntry holds a publication decade and the number of books in it.
+type DecadeEntry struct {
+	// Decade is the first year of the decade (e.g. 1990 for 1990–1999).
+	Decade int
+	Count  int
+}
+
+// YearBrowser is an optional interface for catalog backends that support
+// browsing books by publication year.
+type YearBrowser interface {
+	// Decades returns every decade containing at least one book with a known
+	// publication date, oldest first, each paired with its book count.
+	Decades() ([]DecadeEntry, error)
+
+	// BooksByYearRange returns books published between the years from and to
+	// (both inclusive), ordered by publication date, with pagination.
+	BooksByYearRange(from, to, offset, limit int) ([]Book, int, error)
+}
+
 // Deleter is an optional interface for catalog backends that support deleting
 // a book and its associated files from the catalog.
 type Deleter interface {
@@ -255,9 +526,178 @@ type CoverUpdater interface {
 // creating a consistent point-in-time backup of their persistent store.
 type Backupper interface {
 	// Backup writes a self-contained backup file named
-	// "catalog-YYYYMMDD-HHMMSS.db" into destDir and then prunes the
+	// "catalog-YYYYMMDD-HHMMSS<zone>.db" (zone is "Z" for UTC or an offset
+	// such as "+0200") into destDir and then prunes the
 	// oldest files in destDir so that at most keep backups are retained
 	// (keep ≤ 0 means unlimited).
 	// Returns the path of the newly created backup file.
 	Backup(destDir string, keep int) (string, error)
 }
+
+// Merger is an optional interface for catalog backends that support
+// combining two entries for the same book into one entry with several files.
+type Merger interface {
+	// MergeBooks attaches the source book's files to the target book,
+	// carries over metadata the target lacks, unions their tags, and removes
+	// the source entry. Returns the updated target.
+	MergeBooks(targetID, sourceID string) (*Book, error)
+}
+
+// List is a user-curated reading list (shelf) of books.
+type List struct {
+	ID        string
+	Name      string
+	BookCount int
+	CreatedAt time.Time
+}
+
+// ListManager is an optional interface for catalog backends that support
+// user-curated reading lists.
+type ListManager interface {
+	// CreateList creates an empty list with the given name.
+	CreateList(name string) (*List, error)
+
+	// Lists returns all lists sorted case-insensitively by name.
+	Lists() ([]List, error)
+
+	// ListByID returns the list with the given ID, or an error if unknown.
+	ListByID(id string) (*List, error)
+
+	// AddToList appends a book to a list. Adding a book that is already on
+	// the list is a no-op. Both the list and the book must exist.
+	AddToList(listID, bookID string) error
+
+	// ListBooks returns a paginated slice of the list's books in the order
+	// they were added, plus the total count. A limit <= 0 returns every book.
+	ListBooks(listID string, offset, limit int) ([]Book, int, error)
+}
+
+// ProgressTracker is an optional interface for catalog backends that record
+// how far each book has been read, so readers can resume on another device.
+type ProgressTracker interface {
+	// GetProgress returns the reading position of the book as a fraction
+	// from 0.0 (start) to 1.0 (end). A book without recorded progress
+	// reports 0. Returns an error if the book does not exist.
+	GetProgress(bookID string) (float64, error)
+
+	// SetProgress records the reading position of the book, a fraction
+	// from 0.0 to 1.0. Values outside that range are rejected.
+	SetProgress(bookID string, position float64) error
+}
+
+// PersonalDataResetter is an optional interface for catalog backends that
+// can strip per-reader data from the whole catalog, e.g. before sharing it.
+type PersonalDataResetter interface {
+	// ResetPersonalData clears the read state, read date, rating and
+	// reading progress of every book, leaving bibliographic metadata
+	// untouched. It returns the number of books that changed.
+	ResetPersonalData() (int, error)
+}
+
+// CoverPurger is an optional interface for catalog backends that can clean
+// their cover cache of images left behind by books no longer in the catalog.
+type CoverPurger interface {
+	// PruneCovers removes cached covers and thumbnails whose book ID is
+	// not in the catalog and returns how many files were removed. With
+	// dryRun set it only counts them. Backends also prune after every
+	// Refresh.
+	PruneCovers(dryRun bool) (int, error)
+}
+
+// AuthorAliaser is an optional interface for catalog backends that group an
+// author's pseudonyms under one canonical author, e.g. "Robert Galbraith"
+// under "J.K. Rowling".
+type AuthorAliaser interface {
+	// SetAuthorAlias makes alias a pseudonym of author: Authors lists the
+	// books of both under author's name only, and BooksByAuthor with
+	// either name returns all of them. Books keep their printed author.
+	// Names are compared by AuthorKey. If author is itself an alias, its
+	// canonical author is used; aliases of alias move to author. An empty
+	// author removes the alias.
+	SetAuthorAlias(alias, author string) error
+}
+
+// RandomPicker is an optional interface for catalog backends that can pick
+// a random book, for "surprise me" discovery.
+type RandomPicker interface {
+	// RandomBook returns a book chosen uniformly at random, or nil if the
+	// catalog is empty.
+	RandomBook() (*Book, error)
+
+	// RandomBooks returns up to n distinct books chosen at random, fewer
+	// when the catalog holds less than n books.
+	RandomBooks(n int) ([]Book, error)
+}
+
+// DownloadCounter is an optional interface for catalog backends that count
+// how often each book is downloaded, for a "popular" feed.
+type DownloadCounter interface {
+	// RecordDownload adds one to the download count of the book.
+	// Returns an error if the book does not exist.
+	RecordDownload(id string) error
+
+	// DownloadCount returns how many times the book has been downloaded.
+	// Returns an error if the book does not exist.
+	DownloadCount(id string) (int, error)
+
+	// PopularBooks returns a paginated slice of the books downloaded at
+	// least once, most downloaded first, and the total number of such books.
+	PopularBooks(offset, limit int) ([]Book, int, error)
+}
+
+// Page is one page of search results with its pagination metadata.
+type Page struct {
+	// Items are the books on the page.
+	Items []Book
+
+	// Total is the number of matching books, or a lower bound of it when
+	// Approximate is set.
+	Total int
+
+	// Approximate reports that Total is not an exact count.
+	Approximate bool
+
+	// Capped reports that the backend stopped counting at its count cap;
+	// Total then holds at least the cap and Approximate is set.
+	Capped bool
+}
+
+// PageSearcher is an optional interface for catalog backends that report
+// whether the totals of their search results are exact.
+type PageSearcher interface {
+	// SearchPage is Search, returning the results as a Page.
+	SearchPage(q SearchQuery) (Page, error)
+}
+
+// FullCatalog is the union of Catalog and every optional capability
+// interface. Backends that implement all of them can assert conformance at
+// compile time with var _ catalog.FullCatalog = (*Backend)(nil).
+type FullCatalog interface {
+	Catalog
+	Uploader
+	CoverProvider
+	ThumbnailProvider
+	CoverUpdater
+	Updater
+	Refresher
+	StatsRefresher
+	LastModifier
+	Deleter
+	SeriesLister
+	TagCounter
+	TagRelater
+	AuthorCounter
+	AuthorIndexer
+	LanguageLister
+	YearBrowser
+	Backupper
+	Merger
+	ListManager
+	ProgressTracker
+	PersonalDataResetter
+	CoverPurger
+	AuthorAliaser
+	RandomPicker
+	DownloadCounter
+	PageSearcher
+}
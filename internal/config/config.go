@@ -12,7 +12,19 @@
 // Configuration sources, in increasing priority order:
 //  1. Built-in defaults
 //  2. YAML config file (located by FindConfigFile or explicit path)
-//  3. Environment variables (LISTEN_ADDR, BOOKS_DIR, AUTH_PASSWORD, BACKEND, REFRESH_INTERVAL)
+//  3. Environment variables (LISTEN_ADDR, BOOKS_DIR, COVERS_DIR, EPUB_STRICT,
+//     CLEAN_FILENAME_TITLES, IGNORE_FILE_AS, ADDED_AT_SOURCE, PARSE_CACHE_DIR,
+//     ORGANIZE_UPLOADS, MAX_UPLOAD_SIZE, SCAN_RETRIES, SCAN_RETRY_DELAY,
+//     PENDING_RETRY_DELAY, SCAN_CONCURRENCY, TRUST_DIR_LISTING, SCAN_HIDDEN_FILES, DEDUPE_BY_HASH,
+//     SEARCH_COUNT_CAP, REMOVE_EMPTY_DIRS,
+//     AUTH_PASSWORD,
+//     BACKEND, REFRESH_INTERVAL, WATCH, TIMEZONE, TAG_SEPARATOR, PRIVATE,
+//     ROBOTS_TXT, READ_TIMEOUT, WRITE_TIMEOUT, IDLE_TIMEOUT, MAX_HEADER_BYTES,
+//     MAX_CONNECTIONS, MAX_FEED_BYTES, MAX_NAV_PAGE_SIZE, AUTHOR_INDEX_THRESHOLD,
+//     DOWNLOAD_BLOCKED_FORMATS, SHARED_DEVICE_TIMEOUT, SESSION_DURATION,
+//     SECURE_COOKIES, SESSION_SWEEP_INTERVAL,
+//     TRAILING_SLASH, LENDING, LOAN_PERIOD, DOWNLOAD_LINK_TITLE, RATING_SCALE,
+//     EMPTY_CATALOG_NOTICE, KEPUB_CACHE_DIR, PLACEHOLDER_COVERS, …)
 package config
 
 import (
@@ -20,9 +32,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/banux/nxt-opds/internal/catalog"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v3"
 )
 
@@ -35,9 +51,105 @@ type Config struct {
 	BooksDir string `yaml:"books_dir"`
 
 	// Password is the shared password for form-based authentication.
-	// Leave empty to disable authentication (development/trusted-network use only).
+	// Leave it and Users empty to disable authentication
+	// (development/trusted-network use only).
 	Password string `yaml:"auth_password"`
 
+	// Users are named accounts, each signing in with its own password, for
+	// a family or small-group server. Sessions then belong to a user. A
+	// shared Password, if also set, keeps working. Only configurable in the
+	// YAML file.
+	Users []UserConfig `yaml:"users"`
+
+	// CoversDir is the directory where cover images are cached.
+	// Defaults to "" which is resolved to {books_dir}/.covers by the backend.
+	// Set it to keep covers out of the books directory (e.g. when that
+	// directory is synced or backed up elsewhere).
+	CoversDir string `yaml:"covers_dir"`
+
+	// EPUBStrict rejects malformed EPUBs instead of trying to recover them
+	// (e.g. locating the OPF package when META-INF/container.xml is missing).
+	// Default: false.
+	EPUBStrict bool `yaml:"epub_strict"`
+
+	// CleanFilenameTitles tidies titles taken from file names (PDFs, EPUBs
+	// without a title): "the_great_gatsby_v2" becomes "The Great Gatsby".
+	// Default: false (raw file names are kept).
+	CleanFilenameTitles bool `yaml:"clean_filename_titles"`
+
+	// IgnoreFileAs disables sorting titles and authors by their EPUB
+	// "file-as" sort names ("Tolkien, J. R. R."), using the display names
+	// instead. Default: false.
+	IgnoreFileAs bool `yaml:"ignore_file_as"`
+
+	// AddedAtSource selects what dates newly discovered books ("recently
+	// added" feeds and sorting): "indexing" (default) the time they enter
+	// the catalog, "mtime" their file's modification time, which restored
+	// backups preserve, or "ctime" their file's status change time.
+	AddedAtSource string `yaml:"added_at_source"`
+
+	// ParseCacheDir enables a cache of parsed EPUB metadata keyed by file
+	// content, so moved or re-indexed books (e.g. after switching backends)
+	// are not parsed again. Empty disables the cache (default).
+	ParseCacheDir string `yaml:"parse_cache_dir"`
+
+	// OrganizeUploads files uploaded books under Author/Series/Title.ext
+	// inside BooksDir instead of flat in its root. Default: false.
+	OrganizeUploads bool `yaml:"organize_uploads"`
+
+	// MaxUploadSize is the largest book file, in bytes, accepted for
+	// upload; larger ones are rejected. 0 means the default of 104857600
+	// (100 MiB), to which Load resolves it; negative means no limit.
+	MaxUploadSize int64 `yaml:"max_upload_size"`
+
+	// RemoveEmptyDirs removes the folders of BooksDir that deleting a book
+	// leaves empty (e.g. Fiction/Asimov/ after its last book), never
+	// BooksDir itself. Default: false.
+	RemoveEmptyDirs bool `yaml:"remove_empty_dirs"`
+
+	// ScanRetries is how many extra times a scan retries opening a file that
+	// failed to open (e.g. still being copied), waiting ScanRetryDelay
+	// between attempts. Default: 2.
+	ScanRetries int `yaml:"scan_retries"`
+
+	// ScanConcurrency is how many files a scan parses at once. Raising it
+	// hides the latency of books directories on NFS or SMB mounts.
+	// Default: 1.
+	ScanConcurrency int `yaml:"scan_concurrency"`
+
+	// TrustDirListing takes file sizes and modification times from the
+	// directory listing a scan walks rather than stat-ing every file again.
+	// Default: false.
+	TrustDirListing bool `yaml:"trust_dir_listing"`
+
+	// ScanHiddenFiles indexes dotfiles and files inside dot-directories,
+	// which scans skip by default (e.g. ".staging/book.epub" or sync
+	// clients' temporary files). Default: false.
+	ScanHiddenFiles bool `yaml:"scan_hidden_files"`
+
+	// DedupeByHash lists files with identical content as one book with
+	// several files instead of one book per file. Every file is hashed
+	// (SHA-256) once. Default: false.
+	DedupeByHash bool `yaml:"dedupe_by_hash"`
+
+	// SearchCountCap bounds how many matches the sqlite backend counts for
+	// a search total; larger result sets are reported as "N+ results".
+	// Default: 0 (count every match).
+	SearchCountCap int `yaml:"search_count_cap"`
+
+	// ScanRetryDelayStr and PendingRetryDelayStr are duration strings.
+	// ScanRetryDelay (default "250ms") is the pause between open attempts;
+	// PendingRetryDelay (default "30s") is how long after a scan files that
+	// still could not be opened are retried automatically ("0" disables it).
+	// Parsed into the corresponding Duration fields by Load().
+	ScanRetryDelayStr    string `yaml:"scan_retry_delay"`
+	PendingRetryDelayStr string `yaml:"pending_retry_delay"`
+
+	// ScanRetryDelay and PendingRetryDelay are the parsed forms of the
+	// *Str fields above. Not marshalled to/from YAML directly.
+	ScanRetryDelay    time.Duration `yaml:"-"`
+	PendingRetryDelay time.Duration `yaml:"-"`
+
 	// Backend selects the catalog backend implementation.
 	// "fs"     – in-memory index, metadata stored in .metadata.json (default)
 	// "sqlite" – SQLite-indexed backend, metadata stored in .catalog.db
@@ -53,6 +165,12 @@ type Config struct {
 	// Not marshalled to/from YAML directly.
 	RefreshInterval time.Duration `yaml:"-"`
 
+	// WatchEnabled watches the books directory for added, removed or
+	// changed files and refreshes the catalog about two seconds after they
+	// settle, instead of waiting for the next RefreshInterval tick. The
+	// periodic refresh keeps running as a fallback. Default: false.
+	WatchEnabled bool `yaml:"watch"`
+
 	// BackupDir is the directory where nightly database backups are stored.
 	// Defaults to "" which is resolved to {books_dir}/.backups at runtime.
 	// Only used when backend is "sqlite".
@@ -63,22 +181,232 @@ type Config struct {
 	// Default: 7.
 	BackupKeep int `yaml:"backup_keep"`
 
+	// Timezone is the IANA time zone (e.g. "Europe/Paris") used for backup
+	// file names and the nightly backup schedule.
+	// Default: "UTC", which keeps backup names unambiguous across DST.
+	Timezone string `yaml:"timezone"`
+
+	// Location is the parsed form of Timezone. Not marshalled to/from YAML.
+	Location *time.Location `yaml:"-"`
+
 	// OPDSToken is the bearer token used to authenticate OPDS feed requests.
 	// OPDS readers can authenticate by appending ?token=<value> to the feed URL.
 	// If empty and Password is set, a stable token is derived from the password.
 	// Set explicitly via OPDS_TOKEN env var or opds_token config key.
 	OPDSToken string `yaml:"opds_token"`
+
+	// TagSeparator splits tags into a genre hierarchy for the OPDS tag
+	// feeds, e.g. ">" for "Fiction > Science Fiction". Empty (default)
+	// keeps tags flat.
+	TagSeparator string `yaml:"tag_separator"`
+
+	// DownloadBlockedFormats lists file extensions (e.g. "pdf") that stay
+	// indexed but cannot be downloaded; their acquisition links are left out
+	// of feeds. The env var takes a comma-separated list.
+	DownloadBlockedFormats []string `yaml:"download_blocked_formats"`
+
+	// KepubCacheDir keeps EPUBs converted for Kobo readers (downloads with
+	// ?format=kepub) in this directory. Empty (default) converts on every
+	// download.
+	KepubCacheDir string `yaml:"kepub_cache_dir"`
+
+	// PlaceholderCovers maps a book format to the image served as the cover
+	// of books of that format that have none, picked by the MIME type or
+	// extension of the book's primary file ("application/pdf" or "pdf");
+	// "default" covers every other format. Empty (default) answers 404 for
+	// missing covers. The env var takes "pdf=/img/pdf.png,default=/img/book.png".
+	PlaceholderCovers map[string]string `yaml:"placeholder_covers"`
+
+	// Private marks the catalog as private: responses carry an
+	// "X-Robots-Tag: noindex" header so search engines skip it.
+	Private bool `yaml:"private"`
+
+	// RobotsTxt overrides the body served at /robots.txt.
+	// Empty means the built-in "Disallow: /" for all user agents.
+	RobotsTxt string `yaml:"robots_txt"`
+
+	// ReadTimeoutStr, WriteTimeoutStr and IdleTimeoutStr bound how long the
+	// HTTP server waits on a client (duration strings, "0" = no timeout).
+	// WriteTimeout defaults to disabled so large downloads on slow links are
+	// not cut off. Parsed into the corresponding Duration fields by Load().
+	ReadTimeoutStr  string `yaml:"read_timeout"`
+	WriteTimeoutStr string `yaml:"write_timeout"`
+	IdleTimeoutStr  string `yaml:"idle_timeout"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are the parsed forms of the
+	// *Str fields above. Not marshalled to/from YAML directly.
+	ReadTimeout  time.Duration `yaml:"-"`
+	WriteTimeout time.Duration `yaml:"-"`
+	IdleTimeout  time.Duration `yaml:"-"`
+
+	// MaxHeaderBytes caps the size of request headers. Default: 1 MiB.
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+
+	// MaxConnections caps the number of simultaneously open client
+	// connections; further connections wait until one closes.
+	// 0 or negative means unlimited (default).
+	MaxConnections int `yaml:"max_connections"`
+
+	// MaxFeedBytes caps the serialized size of paginated OPDS 1.x feeds for
+	// memory-limited readers: oversize pages are shrunk and continued via
+	// "next" links. 0 or negative means unlimited (default).
+	MaxFeedBytes int `yaml:"max_feed_bytes"`
+
+	// MaxNavPageSize caps the page size of the author and genre navigation
+	// feeds. 0 or negative keeps the general cap of 200 (default).
+	MaxNavPageSize int `yaml:"max_nav_page_size"`
+
+	// AuthorIndexThreshold is the number of authors above which the OPDS
+	// author feed becomes an A–Z index leading to the authors of each
+	// initial. 0 or negative always lists the authors. Default: 200.
+	AuthorIndexThreshold int `yaml:"author_index_threshold"`
+
+	// SharedDeviceTimeoutStr is the inactivity timeout for sessions started
+	// with the login form's "shared device" option (duration string,
+	// default "15m"; "0" hides the option). Parsed into SharedDeviceTimeout.
+	SharedDeviceTimeoutStr string        `yaml:"shared_device_timeout"`
+	SharedDeviceTimeout    time.Duration `yaml:"-"`
+
+	// SessionDurationStr is how long a regular login session lasts
+	// (duration string, default "720h", i.e. 30 days). Parsed into
+	// SessionDuration.
+	SessionDurationStr string        `yaml:"session_duration"`
+	SessionDuration    time.Duration `yaml:"-"`
+
+	// SecureCookies marks the session cookie Secure and SameSite=Strict,
+	// for servers reached over HTTPS (directly or through a TLS-terminating
+	// reverse proxy). Default: false.
+	SecureCookies bool `yaml:"secure_cookies"`
+
+	// SessionSweepIntervalStr is how often expired login sessions are purged
+	// from memory (duration string, default "10m"; "0" disables the sweep).
+	// Parsed into SessionSweepInterval.
+	SessionSweepIntervalStr string        `yaml:"session_sweep_interval"`
+	SessionSweepInterval    time.Duration `yaml:"-"`
+
+	// TrailingSlash selects how paths with a trailing slash are handled
+	// ("/opds/books/"): "redirect" (default) sends a permanent redirect to
+	// the canonical path, "serve" answers it directly and "off" leaves them
+	// unmatched (404).
+	TrailingSlash string `yaml:"trailing_slash"`
+
+	// Lending runs the catalog as a lending library: feeds offer borrow
+	// links and files are only downloadable through the time-limited URLs
+	// issued when borrowing. Default: false.
+	Lending bool `yaml:"lending"`
+
+	// LoanPeriodStr is how long a borrow URL stays valid (duration string,
+	// default "24h"). Parsed into LoanPeriod.
+	LoanPeriodStr string        `yaml:"loan_period"`
+	LoanPeriod    time.Duration `yaml:"-"`
+
+	// DownloadLinkTitle is the title of acquisition links shown by OPDS
+	// clients, with "{format}" standing for the file format (e.g.
+	// "Télécharger {format}"). Default: "" ("Download {format}").
+	DownloadLinkTitle string `yaml:"download_link_title"`
+
+	// EmptyCatalogNotice is the title of an informational entry shown at
+	// the top of the OPDS root feed while the catalog is empty, e.g.
+	// "Library is empty — upload books". Default: "" (no entry).
+	EmptyCatalogNotice string `yaml:"empty_catalog_notice"`
+
+	// RatingScale selects how book ratings appear in OPDS feeds: "5"
+	// (default) as 0–5 stars, "1" as a 0–1 fraction, "off" to hide them.
+	RatingScale string `yaml:"rating_scale"`
+
+	// ClientQuirks adds or overrides feed workarounds for OPDS readers
+	// matched by User-Agent, on top of the built-in ones. Only
+	// configurable in the YAML file.
+	ClientQuirks []ClientQuirkConfig `yaml:"client_quirks"`
+
+	// Libraries hosts several catalogs on one server, each under its own
+	// URL prefix with its own books directory, backend and credentials.
+	// When set, BooksDir, Password, OPDSToken and Users are not used; the
+	// other settings apply to every library. Only configurable in the YAML file.
+	Libraries []LibraryConfig `yaml:"libraries"`
+}
+
+// UserConfig is one named account.
+type UserConfig struct {
+	Name string `yaml:"name"`
+
+	// PasswordHash is the bcrypt hash of the user's password, e.g. the part
+	// after "name:" in the output of `htpasswd -nbB name password`.
+	PasswordHash string `yaml:"password_hash"`
+}
+
+// ClientQuirkConfig is a feed workaround for the OPDS readers whose
+// User-Agent contains UserAgent (case-insensitively).
+type ClientQuirkConfig struct {
+	UserAgent string `yaml:"user_agent"`
+
+	// AbsoluteURLs makes feed links absolute instead of relative.
+	AbsoluteURLs bool `yaml:"absolute_urls"`
+
+	// PlainAtomType serves feeds as plain application/atom+xml, without
+	// the OPDS profile and kind parameters.
+	PlainAtomType bool `yaml:"plain_atom_type"`
+}
+
+// LibraryConfig is one catalog of a multi-library server.
+type LibraryConfig struct {
+	// Prefix is the URL path the library is served under, e.g. "/lib1".
+	Prefix string `yaml:"prefix"`
+
+	// BooksDir is the directory holding the library's books.
+	BooksDir string `yaml:"books_dir"`
+
+	// Backend is "fs" or "sqlite". Empty means Config.Backend.
+	Backend string `yaml:"backend"`
+
+	// Password and OPDSToken protect this library only, like the top-level
+	// auth_password and opds_token: a token is derived from the password
+	// when unset, and an empty password leaves the library open.
+	Password  string `yaml:"auth_password"`
+	OPDSToken string `yaml:"opds_token"`
+
+	// Users are the named accounts of this library only, like the
+	// top-level users.
+	Users []UserConfig `yaml:"users"`
 }
 
 // Default returns a Config populated with sensible defaults.
 func Default() Config {
 	return Config{
-		ListenAddr:         ":8080",
-		BooksDir:           "./books",
-		Backend:            "fs",
-		RefreshIntervalStr: "5m",
-		RefreshInterval:    5 * time.Minute,
-		BackupKeep:         7,
+		ListenAddr:           ":8080",
+		BooksDir:             "./books",
+		Backend:              "fs",
+		RefreshIntervalStr:   "5m",
+		RefreshInterval:      5 * time.Minute,
+		BackupKeep:           7,
+		Timezone:             "UTC",
+		Location:             time.UTC,
+		ScanRetries:          2,
+		ScanConcurrency:      1,
+		ScanRetryDelayStr:    "250ms",
+		ScanRetryDelay:       250 * time.Millisecond,
+		PendingRetryDelayStr: "30s",
+		PendingRetryDelay:    30 * time.Second,
+		ReadTimeoutStr:       "5m",
+		ReadTimeout:          5 * time.Minute,
+		WriteTimeoutStr:      "0",
+		IdleTimeoutStr:       "2m",
+		IdleTimeout:          2 * time.Minute,
+		MaxHeaderBytes:       1 << 20,
+		AuthorIndexThreshold: 200,
+		MaxUploadSize:        catalog.DefaultMaxUploadSize,
+
+		SharedDeviceTimeoutStr:  "15m",
+		SharedDeviceTimeout:     15 * time.Minute,
+		SessionDurationStr:      "720h",
+		SessionDuration:         30 * 24 * time.Hour,
+		SessionSweepIntervalStr: "10m",
+		SessionSweepInterval:    10 * time.Minute,
+		TrailingSlash:           "redirect",
+		RatingScale:             "5",
+		AddedAtSource:           "indexing",
+		LoanPeriodStr:           "24h",
+		LoanPeriod:              24 * time.Hour,
 	}
 }
 
@@ -109,12 +437,92 @@ func Load(path string) (Config, error) {
 	if v := os.Getenv("AUTH_PASSWORD"); v != "" {
 		cfg.Password = v
 	}
+	if v := os.Getenv("COVERS_DIR"); v != "" {
+		cfg.CoversDir = v
+	}
+	if v := os.Getenv("EPUB_STRICT"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.EPUBStrict = b
+		}
+	}
+	if v := os.Getenv("CLEAN_FILENAME_TITLES"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.CleanFilenameTitles = b
+		}
+	}
+	if v := os.Getenv("IGNORE_FILE_AS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.IgnoreFileAs = b
+		}
+	}
+	if v := os.Getenv("ADDED_AT_SOURCE"); v != "" {
+		cfg.AddedAtSource = v
+	}
+	if v := os.Getenv("PARSE_CACHE_DIR"); v != "" {
+		cfg.ParseCacheDir = v
+	}
+	if v := os.Getenv("ORGANIZE_UPLOADS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.OrganizeUploads = b
+		}
+	}
+	if v := os.Getenv("MAX_UPLOAD_SIZE"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.MaxUploadSize = n
+		}
+	}
+	if v := os.Getenv("REMOVE_EMPTY_DIRS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.RemoveEmptyDirs = b
+		}
+	}
+	if v := os.Getenv("SCAN_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ScanRetries = n
+		}
+	}
+	if v := os.Getenv("SCAN_CONCURRENCY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ScanConcurrency = n
+		}
+	}
+	if v := os.Getenv("TRUST_DIR_LISTING"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.TrustDirListing = b
+		}
+	}
+	if v := os.Getenv("SCAN_HIDDEN_FILES"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ScanHiddenFiles = b
+		}
+	}
+	if v := os.Getenv("DEDUPE_BY_HASH"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.DedupeByHash = b
+		}
+	}
+	if v := os.Getenv("SEARCH_COUNT_CAP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.SearchCountCap = n
+		}
+	}
+	if v := os.Getenv("SCAN_RETRY_DELAY"); v != "" {
+		cfg.ScanRetryDelayStr = v
+	}
+	if v := os.Getenv("PENDING_RETRY_DELAY"); v != "" {
+		cfg.PendingRetryDelayStr = v
+	}
 	if v := os.Getenv("BACKEND"); v != "" {
 		cfg.Backend = v
 	}
 	if v := os.Getenv("REFRESH_INTERVAL"); v != "" {
 		cfg.RefreshIntervalStr = v
 	}
+	if v := os.Getenv("WATCH"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.WatchEnabled = b
+		}
+	}
 	if v := os.Getenv("BACKUP_DIR"); v != "" {
 		cfg.BackupDir = v
 	}
@@ -123,9 +531,112 @@ func Load(path string) (Config, error) {
 			cfg.BackupKeep = n
 		}
 	}
+	if v := os.Getenv("TIMEZONE"); v != "" {
+		cfg.Timezone = v
+	}
 	if v := os.Getenv("OPDS_TOKEN"); v != "" {
 		cfg.OPDSToken = v
 	}
+	if v := os.Getenv("TAG_SEPARATOR"); v != "" {
+		cfg.TagSeparator = v
+	}
+	if v := os.Getenv("DOWNLOAD_BLOCKED_FORMATS"); v != "" {
+		cfg.DownloadBlockedFormats = nil
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				cfg.DownloadBlockedFormats = append(cfg.DownloadBlockedFormats, f)
+			}
+		}
+	}
+	if v := os.Getenv("KEPUB_CACHE_DIR"); v != "" {
+		cfg.KepubCacheDir = v
+	}
+	if v := os.Getenv("PLACEHOLDER_COVERS"); v != "" {
+		cfg.PlaceholderCovers = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			format, path, ok := strings.Cut(pair, "=")
+			if format, path = strings.TrimSpace(format), strings.TrimSpace(path); ok && format != "" && path != "" {
+				cfg.PlaceholderCovers[format] = path
+			}
+		}
+	}
+	if v := os.Getenv("PRIVATE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Private = b
+		}
+	}
+	if v := os.Getenv("ROBOTS_TXT"); v != "" {
+		cfg.RobotsTxt = v
+	}
+	if v := os.Getenv("READ_TIMEOUT"); v != "" {
+		cfg.ReadTimeoutStr = v
+	}
+	if v := os.Getenv("WRITE_TIMEOUT"); v != "" {
+		cfg.WriteTimeoutStr = v
+	}
+	if v := os.Getenv("IDLE_TIMEOUT"); v != "" {
+		cfg.IdleTimeoutStr = v
+	}
+	if v := os.Getenv("MAX_HEADER_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxHeaderBytes = n
+		}
+	}
+	if v := os.Getenv("MAX_CONNECTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxConnections = n
+		}
+	}
+	if v := os.Getenv("MAX_FEED_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxFeedBytes = n
+		}
+	}
+	if v := os.Getenv("MAX_NAV_PAGE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxNavPageSize = n
+		}
+	}
+	if v := os.Getenv("AUTHOR_INDEX_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AuthorIndexThreshold = n
+		}
+	}
+
+	if v := os.Getenv("SHARED_DEVICE_TIMEOUT"); v != "" {
+		cfg.SharedDeviceTimeoutStr = v
+	}
+	if v := os.Getenv("SESSION_DURATION"); v != "" {
+		cfg.SessionDurationStr = v
+	}
+	if v := os.Getenv("SECURE_COOKIES"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.SecureCookies = b
+		}
+	}
+	if v := os.Getenv("SESSION_SWEEP_INTERVAL"); v != "" {
+		cfg.SessionSweepIntervalStr = v
+	}
+	if v := os.Getenv("TRAILING_SLASH"); v != "" {
+		cfg.TrailingSlash = v
+	}
+	if v := os.Getenv("LENDING"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Lending = b
+		}
+	}
+	if v := os.Getenv("LOAN_PERIOD"); v != "" {
+		cfg.LoanPeriodStr = v
+	}
+	if v := os.Getenv("DOWNLOAD_LINK_TITLE"); v != "" {
+		cfg.DownloadLinkTitle = v
+	}
+	if v := os.Getenv("EMPTY_CATALOG_NOTICE"); v != "" {
+		cfg.EmptyCatalogNotice = v
+	}
+	if v := os.Getenv("RATING_SCALE"); v != "" {
+		cfg.RatingScale = v
+	}
 
 	// If no explicit OPDS token but a password is set, derive a stable token
 	// from the password so OPDS reader URLs remain valid across restarts.
@@ -145,9 +656,200 @@ func Load(path string) (Config, error) {
 		cfg.RefreshInterval = 0
 	}
 
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid timezone %q: %w", cfg.Timezone, err)
+	}
+	cfg.Location = loc
+
+	switch cfg.TrailingSlash {
+	case "redirect", "serve", "off":
+	default:
+		return cfg, fmt.Errorf("invalid trailing_slash %q: want redirect, serve or off", cfg.TrailingSlash)
+	}
+	switch cfg.RatingScale {
+	case "5", "1", "off":
+	default:
+		return cfg, fmt.Errorf("invalid rating_scale %q: want 5, 1 or off", cfg.RatingScale)
+	}
+	switch cfg.AddedAtSource {
+	case "indexing", "mtime", "ctime":
+	default:
+		return cfg, fmt.Errorf("invalid added_at_source %q: want indexing, mtime or ctime", cfg.AddedAtSource)
+	}
+
+	if cfg.MaxUploadSize == 0 {
+		cfg.MaxUploadSize = catalog.DefaultMaxUploadSize
+	}
+
+	cfg.ScanRetryDelay = parseDuration(cfg.ScanRetryDelayStr, cfg.ScanRetryDelay)
+	cfg.PendingRetryDelay = parseDuration(cfg.PendingRetryDelayStr, cfg.PendingRetryDelay)
+	cfg.ReadTimeout = parseDuration(cfg.ReadTimeoutStr, cfg.ReadTimeout)
+	cfg.WriteTimeout = parseDuration(cfg.WriteTimeoutStr, cfg.WriteTimeout)
+	cfg.IdleTimeout = parseDuration(cfg.IdleTimeoutStr, cfg.IdleTimeout)
+	cfg.SharedDeviceTimeout = parseDuration(cfg.SharedDeviceTimeoutStr, cfg.SharedDeviceTimeout)
+	cfg.SessionDuration = parseDuration(cfg.SessionDurationStr, cfg.SessionDuration)
+	cfg.SessionSweepInterval = parseDuration(cfg.SessionSweepIntervalStr, cfg.SessionSweepInterval)
+	cfg.LoanPeriod = parseDuration(cfg.LoanPeriodStr, cfg.LoanPeriod)
+
+	if err := cfg.loadUsers(); err != nil {
+		return cfg, err
+	}
+	for i, q := range cfg.ClientQuirks {
+		if strings.TrimSpace(q.UserAgent) == "" {
+			return cfg, fmt.Errorf("client quirk %d: user_agent is required", i+1)
+		}
+	}
+	if err := cfg.loadLibraries(); err != nil {
+		return cfg, err
+	}
+
 	return cfg, nil
 }
 
+// loadLibraries validates Libraries, normalizing prefixes, defaulting
+// backends and deriving OPDS tokens.
+func (c *Config) loadLibraries() error {
+	seen := make(map[string]bool, len(c.Libraries))
+	for i := range c.Libraries {
+		lib := &c.Libraries[i]
+		lib.Prefix = "/" + strings.Trim(lib.Prefix, "/")
+		if lib.Prefix == "/" {
+			return fmt.Errorf("library %d: prefix is required", i+1)
+		}
+		if seen[lib.Prefix] {
+			return fmt.Errorf("library %s: prefix is used twice", lib.Prefix)
+		}
+		seen[lib.Prefix] = true
+		if lib.BooksDir == "" {
+			return fmt.Errorf("library %s: books_dir is required", lib.Prefix)
+		}
+		if lib.Backend == "" {
+			lib.Backend = c.Backend
+		}
+		if lib.OPDSToken == "" && lib.Password != "" {
+			lib.OPDSToken = deriveOPDSToken(lib.Password)
+		}
+		if err := checkUsers(lib.Users); err != nil {
+			return fmt.Errorf("library %s: %w", lib.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// loadUsers checks the top-level Users.
+func (c *Config) loadUsers() error {
+	return checkUsers(c.Users)
+}
+
+// checkUsers checks that every user has a unique name and a bcrypt hash.
+func checkUsers(users []UserConfig) error {
+	seen := make(map[string]bool, len(users))
+	for i, u := range users {
+		if strings.TrimSpace(u.Name) == "" {
+			return fmt.Errorf("user %d: name is required", i+1)
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("user %q is defined twice", u.Name)
+		}
+		seen[u.Name] = true
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return fmt.Errorf("user %q: password_hash is not a bcrypt hash: %w", u.Name, err)
+		}
+	}
+	return nil
+}
+
+// redacted replaces secret values in Sanitized output.
+const redacted = "[redacted]"
+
+// EffectiveBackupDir returns BackupDir, or {books_dir}/.backups when unset.
+func (c Config) EffectiveBackupDir() string {
+	if c.BackupDir != "" {
+		return c.BackupDir
+	}
+	return filepath.Join(c.BooksDir, ".backups")
+}
+
+// EffectiveCoversDir returns CoversDir, or {books_dir}/.covers when unset
+// (the directory the catalog backends fall back to).
+func (c Config) EffectiveCoversDir() string {
+	if c.CoversDir != "" {
+		return c.CoversDir
+	}
+	return filepath.Join(c.BooksDir, ".covers")
+}
+
+// Sanitized returns the effective configuration keyed by config file names,
+// safe to show in diagnostics: secrets (the password and OPDS token) are
+// redacted, defaulted directories are resolved and durations report the
+// values actually in use.
+func (c Config) Sanitized() map[string]any {
+	if c.Password != "" {
+		c.Password = redacted
+	}
+	if c.OPDSToken != "" {
+		c.OPDSToken = redacted
+	}
+	c.BackupDir = c.EffectiveBackupDir()
+	c.CoversDir = c.EffectiveCoversDir()
+	c.Users = slices.Clone(c.Users)
+	for i := range c.Users {
+		c.Users[i].PasswordHash = redacted
+	}
+	c.Libraries = slices.Clone(c.Libraries)
+	for i := range c.Libraries {
+		if c.Libraries[i].Password != "" {
+			c.Libraries[i].Password = redacted
+		}
+		if c.Libraries[i].OPDSToken != "" {
+			c.Libraries[i].OPDSToken = redacted
+		}
+		c.Libraries[i].Users = slices.Clone(c.Libraries[i].Users)
+		for j := range c.Libraries[i].Users {
+			c.Libraries[i].Users[j].PasswordHash = redacted
+		}
+	}
+	c.RefreshIntervalStr = formatDuration(c.RefreshInterval)
+	c.ScanRetryDelayStr = formatDuration(c.ScanRetryDelay)
+	c.PendingRetryDelayStr = formatDuration(c.PendingRetryDelay)
+	c.ReadTimeoutStr = formatDuration(c.ReadTimeout)
+	c.WriteTimeoutStr = formatDuration(c.WriteTimeout)
+	c.IdleTimeoutStr = formatDuration(c.IdleTimeout)
+	c.SharedDeviceTimeoutStr = formatDuration(c.SharedDeviceTimeout)
+	c.SessionDurationStr = formatDuration(c.SessionDuration)
+	c.SessionSweepIntervalStr = formatDuration(c.SessionSweepInterval)
+	c.LoanPeriodStr = formatDuration(c.LoanPeriod)
+
+	// Round-trip through YAML so keys match the config file format.
+	out := map[string]any{}
+	if data, err := yaml.Marshal(c); err == nil {
+		_ = yaml.Unmarshal(data, &out)
+	}
+	return out
+}
+
+// formatDuration renders d the way it is written in the config: "0" for a
+// disabled duration, time.Duration's String form otherwise.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0"
+	}
+	return d.String()
+}
+
+// parseDuration parses a duration string from the config. An empty string or
+// "0" yields 0 (disabled); an invalid string leaves fallback unchanged.
+func parseDuration(s string, fallback time.Duration) time.Duration {
+	if s == "" || s == "0" {
+		return 0
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d
+	}
+	return fallback
+}
+
 // deriveOPDSToken returns a stable 32-character hex token derived from the
 // given password. It is deterministic: the same password always produces the
 // same token. This allows OPDS reader URLs to remain valid across restarts
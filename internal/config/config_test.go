@@ -1,8 +1,10 @@
 package config_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -311,3 +313,366 @@ func TestLoad_OPDSToken_ExplicitEnvOverrides(t *testing.T) {
 		t.Errorf("expected explicit token, got %q", cfg.OPDSToken)
 	}
 }
+
+// ---- HTTP server limits ----
+
+func TestLoad_ServerTimeouts(t *testing.T) {
+	yaml := `
+read_timeout: "30s"
+write_timeout: "1m"
+idle_timeout: "0"
+max_connections: 16
+`
+	path := writeTemp(t, "timeouts.yaml", yaml)
+	t.Setenv("READ_TIMEOUT", "")
+	t.Setenv("WRITE_TIMEOUT", "")
+	t.Setenv("IDLE_TIMEOUT", "")
+	t.Setenv("MAX_CONNECTIONS", "")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ReadTimeout != 30*time.Second {
+		t.Errorf("ReadTimeout: got %v, want 30s", cfg.ReadTimeout)
+	}
+	if cfg.WriteTimeout != time.Minute {
+		t.Errorf("WriteTimeout: got %v, want 1m", cfg.WriteTimeout)
+	}
+	if cfg.IdleTimeout != 0 {
+		t.Errorf("IdleTimeout: got %v, want 0 (disabled)", cfg.IdleTimeout)
+	}
+	if cfg.MaxConnections != 16 {
+		t.Errorf("MaxConnections: got %d, want 16", cfg.MaxConnections)
+	}
+}
+
+func TestLoad_PlaceholderCovers(t *testing.T) {
+	path := writeTemp(t, "placeholders.yaml", "placeholder_covers:\n  pdf: /img/pdf.png\n")
+	t.Setenv("PLACEHOLDER_COVERS", "")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(cfg.PlaceholderCovers) != 1 || cfg.PlaceholderCovers["pdf"] != "/img/pdf.png" {
+		t.Errorf("PlaceholderCovers from YAML: got %v", cfg.PlaceholderCovers)
+	}
+
+	t.Setenv("PLACEHOLDER_COVERS", "application/pdf = /img/pdf.png, default=/img/book.png,broken")
+	cfg, err = config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	want := map[string]string{"application/pdf": "/img/pdf.png", "default": "/img/book.png"}
+	if len(cfg.PlaceholderCovers) != len(want) {
+		t.Errorf("PlaceholderCovers from env: got %v, want %v", cfg.PlaceholderCovers, want)
+	}
+	for k, v := range want {
+		if cfg.PlaceholderCovers[k] != v {
+			t.Errorf("PlaceholderCovers[%q] from env: got %q, want %q", k, cfg.PlaceholderCovers[k], v)
+		}
+	}
+}
+
+func TestLoad_DownloadBlockedFormats(t *testing.T) {
+	path := writeTemp(t, "blocked.yaml", "download_blocked_formats: [pdf]\n")
+	t.Setenv("DOWNLOAD_BLOCKED_FORMATS", "")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(cfg.DownloadBlockedFormats) != 1 || cfg.DownloadBlockedFormats[0] != "pdf" {
+		t.Errorf("DownloadBlockedFormats from YAML: got %v, want [pdf]", cfg.DownloadBlockedFormats)
+	}
+
+	t.Setenv("DOWNLOAD_BLOCKED_FORMATS", "pdf, cbz ,")
+	cfg, err = config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if got := strings.Join(cfg.DownloadBlockedFormats, ","); got != "pdf,cbz" {
+		t.Errorf("DownloadBlockedFormats from env: got %q, want %q", got, "pdf,cbz")
+	}
+}
+
+func TestSanitized_RedactsSecretsAndResolvesDefaults(t *testing.T) {
+	t.Setenv("AUTH_PASSWORD", "supersecret")
+	t.Setenv("OPDS_TOKEN", "")
+	t.Setenv("BOOKS_DIR", "/srv/books")
+	t.Setenv("BACKEND", "sqlite")
+	t.Setenv("SHARED_DEVICE_TIMEOUT", "not-a-duration")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	got := cfg.Sanitized()
+	if got["auth_password"] != "[redacted]" || got["opds_token"] != "[redacted]" {
+		t.Errorf("secrets not redacted: password=%v token=%v", got["auth_password"], got["opds_token"])
+	}
+	if got["backend"] != "sqlite" || got["listen_addr"] != ":8080" {
+		t.Errorf("backend=%v listen_addr=%v", got["backend"], got["listen_addr"])
+	}
+	if got["backup_dir"] != filepath.Join("/srv/books", ".backups") || got["covers_dir"] != filepath.Join("/srv/books", ".covers") {
+		t.Errorf("dirs not resolved: backup_dir=%v covers_dir=%v", got["backup_dir"], got["covers_dir"])
+	}
+	// Invalid durations fall back to the default, which is what is reported.
+	if got["shared_device_timeout"] != "15m0s" || got["write_timeout"] != "0" {
+		t.Errorf("durations: shared_device_timeout=%v write_timeout=%v", got["shared_device_timeout"], got["write_timeout"])
+	}
+	if cfg.Password != "supersecret" {
+		t.Error("Sanitized must not modify the receiver")
+	}
+}
+
+func TestLoad_TrailingSlash(t *testing.T) {
+	t.Setenv("TRAILING_SLASH", "")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.TrailingSlash != "redirect" {
+		t.Errorf("default TrailingSlash: got %q, want redirect", cfg.TrailingSlash)
+	}
+
+	t.Setenv("TRAILING_SLASH", "serve")
+	if cfg, err := config.Load(""); err != nil || cfg.TrailingSlash != "serve" {
+		t.Errorf("TRAILING_SLASH=serve: got %q, %v", cfg.TrailingSlash, err)
+	}
+
+	t.Setenv("TRAILING_SLASH", "sometimes")
+	if _, err := config.Load(""); err == nil {
+		t.Error("expected an error for an unknown trailing_slash mode")
+	}
+}
+
+func TestLoad_RatingScale(t *testing.T) {
+	t.Setenv("RATING_SCALE", "")
+	if cfg, err := config.Load(""); err != nil || cfg.RatingScale != "5" {
+		t.Errorf("default RatingScale: got %q, %v; want 5", cfg.RatingScale, err)
+	}
+
+	t.Setenv("RATING_SCALE", "off")
+	if cfg, err := config.Load(""); err != nil || cfg.RatingScale != "off" {
+		t.Errorf("RATING_SCALE=off: got %q, %v", cfg.RatingScale, err)
+	}
+
+	t.Setenv("RATING_SCALE", "10")
+	if _, err := config.Load(""); err == nil {
+		t.Error("expected an error for an unknown rating_scale")
+	}
+}
+
+func TestLoad_AddedAtSource(t *testing.T) {
+	t.Setenv("ADDED_AT_SOURCE", "")
+	if cfg, err := config.Load(""); err != nil || cfg.AddedAtSource != "indexing" {
+		t.Errorf("default AddedAtSource: got %q, %v; want indexing", cfg.AddedAtSource, err)
+	}
+
+	t.Setenv("ADDED_AT_SOURCE", "mtime")
+	if cfg, err := config.Load(""); err != nil || cfg.AddedAtSource != "mtime" {
+		t.Errorf("ADDED_AT_SOURCE=mtime: got %q, %v", cfg.AddedAtSource, err)
+	}
+
+	t.Setenv("ADDED_AT_SOURCE", "atime")
+	if _, err := config.Load(""); err == nil {
+		t.Error("expected an error for an unknown added_at_source")
+	}
+}
+
+func TestLoad_MaxUploadSize(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int64
+	}{
+		{"", 100 << 20},
+		{"0", 100 << 20},
+		{"1048576", 1 << 20},
+		{"-1", -1},
+	} {
+		t.Setenv("MAX_UPLOAD_SIZE", tc.env)
+		if cfg, err := config.Load(""); err != nil || cfg.MaxUploadSize != tc.want {
+			t.Errorf("MAX_UPLOAD_SIZE=%q: got %d, %v; want %d", tc.env, cfg.MaxUploadSize, err, tc.want)
+		}
+	}
+}
+
+func TestLoad_Lending(t *testing.T) {
+	t.Setenv("LENDING", "")
+	t.Setenv("LOAN_PERIOD", "")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.Lending || cfg.LoanPeriod != 24*time.Hour {
+		t.Errorf("defaults: got Lending=%v LoanPeriod=%v, want false and 24h", cfg.Lending, cfg.LoanPeriod)
+	}
+
+	t.Setenv("LENDING", "true")
+	t.Setenv("LOAN_PERIOD", "2h")
+	cfg, err = config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !cfg.Lending || cfg.LoanPeriod != 2*time.Hour {
+		t.Errorf("env: got Lending=%v LoanPeriod=%v, want true and 2h", cfg.Lending, cfg.LoanPeriod)
+	}
+}
+
+func TestLoad_SessionCookies(t *testing.T) {
+	t.Setenv("SESSION_DURATION", "")
+	t.Setenv("SECURE_COOKIES", "")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.SecureCookies || cfg.SessionDuration != 30*24*time.Hour {
+		t.Errorf("defaults: got SecureCookies=%v SessionDuration=%v, want false and 720h", cfg.SecureCookies, cfg.SessionDuration)
+	}
+
+	t.Setenv("SESSION_DURATION", "12h")
+	t.Setenv("SECURE_COOKIES", "true")
+	cfg, err = config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !cfg.SecureCookies || cfg.SessionDuration != 12*time.Hour {
+		t.Errorf("env: got SecureCookies=%v SessionDuration=%v, want true and 12h", cfg.SecureCookies, cfg.SessionDuration)
+	}
+}
+
+func TestLoad_DownloadLinkTitle(t *testing.T) {
+	t.Setenv("DOWNLOAD_LINK_TITLE", "Télécharger {format}")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.DownloadLinkTitle != "Télécharger {format}" {
+		t.Errorf("DownloadLinkTitle = %q, want %q", cfg.DownloadLinkTitle, "Télécharger {format}")
+	}
+}
+
+func TestLoad_Libraries(t *testing.T) {
+	path := writeTemp(t, "nxt-opds.yaml", `
+backend: sqlite
+libraries:
+  - prefix: lib1/
+    books_dir: /srv/books1
+  - prefix: /lib2
+    books_dir: /srv/books2
+    backend: fs
+    auth_password: secret
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(cfg.Libraries) != 2 {
+		t.Fatalf("Libraries: got %+v", cfg.Libraries)
+	}
+	lib1, lib2 := cfg.Libraries[0], cfg.Libraries[1]
+	if lib1.Prefix != "/lib1" || lib1.Backend != "sqlite" || lib1.OPDSToken != "" {
+		t.Errorf("lib1: got %+v, want prefix /lib1, the default backend and no token", lib1)
+	}
+	if lib2.Backend != "fs" || len(lib2.OPDSToken) != 32 {
+		t.Errorf("lib2: got %+v, want the fs backend and a derived token", lib2)
+	}
+	if s := fmt.Sprint(cfg.Sanitized()["libraries"]); strings.Contains(s, "secret") {
+		t.Errorf("Sanitized leaks a library password: %s", s)
+	}
+	if cfg.Libraries[1].Password != "secret" {
+		t.Error("Sanitized modified the config's libraries")
+	}
+
+	const hash = "$2a$04$WcWyjQfIOoYPf9yOiPi.mevMqJuWFKlVswr30Jnk3KB0DhCAYDZa2"
+	cfg, err = config.Load(writeTemp(t, "users.yaml", fmt.Sprintf(`
+libraries:
+  - prefix: /lib1
+    books_dir: /srv/books1
+    users:
+      - name: alice
+        password_hash: %q
+  - prefix: /lib2
+    books_dir: /srv/books2
+`, hash)))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if u := cfg.Libraries[0].Users; len(u) != 1 || u[0].Name != "alice" || len(cfg.Libraries[1].Users) != 0 {
+		t.Errorf("library users: got %+v and %+v", cfg.Libraries[0].Users, cfg.Libraries[1].Users)
+	}
+	if s := fmt.Sprint(cfg.Sanitized()["libraries"]); strings.Contains(s, hash) {
+		t.Errorf("Sanitized leaks a library user's hash: %s", s)
+	}
+	if cfg.Libraries[0].Users[0].PasswordHash != hash {
+		t.Error("Sanitized modified the config's library users")
+	}
+
+	for _, bad := range []string{
+		"libraries:\n  - prefix: /\n    books_dir: /srv/books",
+		"libraries:\n  - prefix: /lib\n",
+		"libraries:\n  - {prefix: /lib, books_dir: /a}\n  - {prefix: /lib/, books_dir: /b}",
+		"libraries:\n  - {prefix: /lib, books_dir: /a, users: [{name: bob, password_hash: plain}]}",
+	} {
+		if _, err := config.Load(writeTemp(t, "bad.yaml", bad)); err == nil {
+			t.Errorf("Load(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestLoad_Users(t *testing.T) {
+	const hash = "$2a$04$WcWyjQfIOoYPf9yOiPi.mevMqJuWFKlVswr30Jnk3KB0DhCAYDZa2"
+	path := writeTemp(t, "users.yaml", fmt.Sprintf(`
+users:
+  - name: alice
+    password_hash: %q
+  - name: bob
+    password_hash: %q
+`, hash, hash))
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if len(cfg.Users) != 2 || cfg.Users[0].Name != "alice" || cfg.Users[1].PasswordHash != hash {
+		t.Fatalf("Users: got %+v", cfg.Users)
+	}
+	if s := fmt.Sprint(cfg.Sanitized()["users"]); strings.Contains(s, hash) || !strings.Contains(s, "alice") {
+		t.Errorf("Sanitized users: %s", s)
+	}
+	if cfg.Users[0].PasswordHash != hash {
+		t.Error("Sanitized modified the config's users")
+	}
+
+	for _, bad := range []string{
+		"users:\n  - password_hash: \"" + hash + "\"",
+		"users:\n  - {name: alice, password_hash: plaintext}",
+		"users:\n  - {name: alice, password_hash: \"" + hash + "\"}\n  - {name: alice, password_hash: \"" + hash + "\"}",
+	} {
+		if _, err := config.Load(writeTemp(t, "bad.yaml", bad)); err == nil {
+			t.Errorf("Load(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestLoad_ClientQuirks(t *testing.T) {
+	path := writeTemp(t, "quirks.yaml", `
+client_quirks:
+  - user_agent: "OldReader"
+    absolute_urls: true
+    plain_atom_type: true
+`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	want := config.ClientQuirkConfig{UserAgent: "OldReader", AbsoluteURLs: true, PlainAtomType: true}
+	if len(cfg.ClientQuirks) != 1 || cfg.ClientQuirks[0] != want {
+		t.Errorf("ClientQuirks: got %+v, want [%+v]", cfg.ClientQuirks, want)
+	}
+
+	if _, err := config.Load(writeTemp(t, "bad.yaml", "client_quirks:\n  - absolute_urls: true\n")); err == nil {
+		t.Error("expected an error for a quirk without user_agent")
+	}
+}
@@ -0,0 +1,175 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// parseCacheVersion is part of every cache key. Bump it whenever a parser
+// change alters the extracted metadata, so stale entries are ignored.
+const parseCacheVersion = 4
+
+// ParseCache stores the metadata extracted from EPUB files on disk, keyed by
+// a hash of the file content and its size. A file that was parsed before —
+// under another path, or by another catalog backend — is then indexed
+// without opening the archive again. Changing a file's content changes its
+// key, so edited files are always parsed afresh.
+//
+// A ParseCache is safe for concurrent use.
+type ParseCache struct {
+	dir    string
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewParseCache returns a ParseCache storing its entries in dir, which is
+// created if needed.
+func NewParseCache(dir string) (*ParseCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create parse cache dir: %w", err)
+	}
+	return &ParseCache{dir: dir}, nil
+}
+
+// Stats returns how many lookups were served from the cache and how many
+// required a full parse.
+func (c *ParseCache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// cacheEntry is the JSON document stored per key.
+type cacheEntry struct {
+	Book catalog.Book `json:"book"`
+	// TitleFromFile marks titles taken from the file name, which are
+	// derived again from the path the entry is reused for.
+	TitleFromFile bool `json:"titleFromFile,omitempty"`
+	// CoverExt is the extension of the cover image stored next to the
+	// entry, or "" if the book has no cover.
+	CoverExt string `json:"coverExt,omitempty"`
+}
+
+// key hashes the file at path and also returns the number of bytes hashed.
+// Options that change the parse result are part of the key so that toggling
+// them does not serve stale metadata.
+func (c *ParseCache) key(path string, opts Options) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	var flags string
+	if opts.Strict {
+		flags += "s"
+	}
+	if opts.IgnoreFileAs {
+		flags += "f"
+	}
+	return fmt.Sprintf("%x-%d-v%d%s", h.Sum(nil), size, parseCacheVersion, flags), size, nil
+}
+
+// lookup returns the cached Book for key, adapted to path: ID, file entry,
+// added date and file-name titles follow the new location, UpdatedAt keeps
+// the package's dcterms:modified date if any, and the cached cover
+// is copied into coversDir under the new ID (unless opts.InMemoryCovers).
+func (c *ParseCache) lookup(key, path, coversDir string, opts Options) (catalog.Book, bool) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+	if err != nil {
+		return catalog.Book{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return catalog.Book{}, false
+	}
+
+	book := entry.Book
+	book.ID = PathToID(path)
+	if book.UpdatedAt.IsZero() {
+		// No dcterms:modified date in the package.
+		book.UpdatedAt = time.Now()
+	}
+	size := int64(0)
+	info, err := opts.stat(path)
+	if err == nil {
+		size = info.Size()
+	} else {
+		info = nil
+	}
+	book.AddedAt = opts.addedAt(info)
+	book.Files = []catalog.File{{MIMEType: epubMIMEType(path), Path: path, Size: size}}
+	if entry.TitleFromFile {
+		book.Title = firstOrFilename(nil, path, opts.CleanFilenameTitles)
+	}
+
+	if opts.InMemoryCovers {
+		// Covers are read from the archive on demand.
+		if book.CoverURL != "" {
+			book.CoverURL = "/covers/" + book.ID
+			book.ThumbnailURL = "/covers/" + book.ID + "/thumb"
+		}
+		return book, true
+	}
+	book.CoverURL, book.ThumbnailURL = "", ""
+	if entry.CoverExt != "" {
+		dst := filepath.Join(coversDir, book.ID+entry.CoverExt)
+		if copyFile(filepath.Join(c.dir, key+entry.CoverExt), dst) == nil {
+			book.CoverURL = "/covers/" + book.ID
+			book.ThumbnailURL = "/covers/" + book.ID + "/thumb"
+		}
+	}
+	return book, true
+}
+
+// store records book under key, along with its cover from coversDir.
+// Failures are ignored: the cache is only an optimization.
+func (c *ParseCache) store(key string, book catalog.Book, titleFromFile bool, coversDir string) {
+	entry := cacheEntry{TitleFromFile: titleFromFile}
+	if book.CoverURL != "" {
+		if cover, err := CoverPath(coversDir, book.ID); err == nil {
+			ext := filepath.Ext(cover)
+			if copyFile(cover, filepath.Join(c.dir, key+ext)) == nil {
+				entry.CoverExt = ext
+			}
+		}
+	}
+	entry.Book = book
+	entry.Book.Files = nil
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, ".entry-*.tmp")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(data)
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key+".json")); err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// copyFile copies src to dst, replacing dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFileAtomic(dst, in)
+}
@@ -0,0 +1,206 @@
+package epub
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// MIME types for comic book archives, matching opds.MIMECBZ and
+// opds.MIMECBR.
+const (
+	mimeCBZ = "application/x-cbz"
+	mimeCBR = "application/x-cbr"
+)
+
+var errNoComicCover = errors.New("no image in comic archive")
+
+// comicImageExts lists the page image extensions looked for in a comic
+// archive.
+var comicImageExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+}
+
+// IsComic reports whether path is a comic book archive (CBZ or CBR) by its
+// extension.
+func IsComic(path string) bool {
+	switch FileExt(path) {
+	case ".cbz", ".cbr":
+		return true
+	}
+	return false
+}
+
+// ParseComicWithOptions creates a Book for a CBZ or CBR comic archive.
+// Comics carry no metadata the catalog reads, so the title comes from the
+// file name, as in ParsePathWithOptions; the first page image, in name
+// order, is cached in coversDir as the cover. An error wrapping ErrOpen is
+// returned if the archive cannot be opened.
+func ParseComicWithOptions(path, coversDir string, opts Options) (catalog.Book, error) {
+	book := ParsePathWithOptions(path, opts)
+	data, ext, err := readComicCover(path)
+	if errors.Is(err, ErrOpen) {
+		return catalog.Book{}, err
+	}
+	if err != nil {
+		return book, nil
+	}
+	if opts.InMemoryCovers || writeCoverData(data, ext, book.ID, coversDir) {
+		book.CoverURL = "/covers/" + book.ID
+		book.ThumbnailURL = "/covers/" + book.ID + "/thumb"
+	}
+	return book, nil
+}
+
+// readComicCover reads the first page image of the comic archive at p. It
+// backs ReadCover for comics.
+func readComicCover(p string) ([]byte, string, error) {
+	var (
+		name string
+		data []byte
+		err  error
+	)
+	if FileExt(p) == ".cbr" {
+		name, data, err = readCBRCover(p)
+	} else {
+		name, data, err = readCBZCover(p)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	return data, ext, nil
+}
+
+// readCBZCover returns the name and content of the first page image of the
+// CBZ (zip) archive at p.
+func readCBZCover(p string) (string, []byte, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("open cbz %q: %w: %w", p, ErrOpen, err)
+	}
+	defer zr.Close()
+
+	var names []string
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			names = append(names, f.Name)
+			files[f.Name] = f
+		}
+	}
+	name := firstComicPage(names)
+	if name == "" {
+		return "", nil, fmt.Errorf("%w %q", errNoComicCover, p)
+	}
+	rc, err := files[name].Open()
+	if err != nil {
+		return "", nil, fmt.Errorf("open cover in %q: %w", p, err)
+	}
+	defer rc.Close()
+	data, err := readComicPage(rc, p)
+	return name, data, err
+}
+
+// readCBRCover returns the name and content of the first page image of the
+// CBR (RAR) archive at p. The archive is read sequentially, as the pages
+// of solid archives cannot be opened on their own.
+func readCBRCover(p string) (string, []byte, error) {
+	if err := checkRarSignature(p); err != nil {
+		return "", nil, fmt.Errorf("open cbr %q: %w: %w", p, ErrOpen, err)
+	}
+	files, err := rardecode.List(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("open cbr %q: %w: %w", p, ErrOpen, err)
+	}
+	var names []string
+	for _, f := range files {
+		if !f.IsDir {
+			names = append(names, f.Name)
+		}
+	}
+	name := firstComicPage(names)
+	if name == "" {
+		return "", nil, fmt.Errorf("%w %q", errNoComicCover, p)
+	}
+
+	rc, err := rardecode.OpenReader(p)
+	if err != nil {
+		return "", nil, fmt.Errorf("open cbr %q: %w: %w", p, ErrOpen, err)
+	}
+	defer rc.Close()
+	for {
+		h, err := rc.Next()
+		if err != nil {
+			return "", nil, fmt.Errorf("read cover in %q: %w", p, err)
+		}
+		if h.Name == name {
+			data, err := readComicPage(rc, p)
+			return name, data, err
+		}
+	}
+}
+
+// rarSignature starts every RAR archive, of format 1.5 or 5.0.
+const rarSignature = "Rar!\x1a\x07"
+
+// checkRarSignature fails unless the file at p starts with rarSignature.
+// rardecode would otherwise search the file for an embedded archive, and
+// loops forever on some files that have none.
+func checkRarSignature(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig := make([]byte, len(rarSignature))
+	if _, err := io.ReadFull(f, sig); err != nil || string(sig) != rarSignature {
+		return errors.New("not a RAR archive")
+	}
+	return nil
+}
+
+// readComicPage reads a page image of the archive at p from r, failing
+// beyond maxCoverBytes.
+func readComicPage(r io.Reader, p string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxCoverBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read cover in %q: %w", p, err)
+	}
+	if len(data) > maxCoverBytes {
+		return nil, fmt.Errorf("cover in %q exceeds %d bytes", p, maxCoverBytes)
+	}
+	return data, nil
+}
+
+// firstComicPage returns the image file name that sorts first, which is the
+// first page for the usual "001.jpg" naming, skipping hidden files and
+// directories and macOS resource forks. It returns "" if there is no image.
+func firstComicPage(names []string) string {
+	var pages []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "__MACOSX/") ||
+			strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+			continue
+		}
+		if comicImageExts[strings.ToLower(path.Ext(name))] {
+			pages = append(pages, name)
+		}
+	}
+	if len(pages) == 0 {
+		return ""
+	}
+	sort.Strings(pages)
+	return pages[0]
+}
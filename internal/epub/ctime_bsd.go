@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || netbsd
+
+package epub
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the status change time (ctime) of the file info
+// describes.
+func changeTime(info fs.FileInfo) (time.Time, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(st.Ctimespec.Unix()), true
+}
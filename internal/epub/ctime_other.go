@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package epub
+
+import (
+	"io/fs"
+	"time"
+)
+
+// changeTime reports that the status change time is not available on this
+// system.
+func changeTime(info fs.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
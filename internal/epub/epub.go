@@ -1,76 +1,210 @@
-// Package epub provides EPUB and PDF metadata extraction utilities shared
-// across catalog backend implementations.
+// Package epub provides EPUB, PDF and MOBI/AZW3 metadata extraction
+// utilities shared across catalog backend implementations.
 package epub
 
 import (
 	"archive/zip"
+	"bytes"
 	"crypto/sha256"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/banux/nxt-opds/internal/catalog"
 )
 
+// Options controls how tolerant EPUB parsing is of malformed files.
+type Options struct {
+	// Strict disables recovery heuristics for malformed EPUBs, such as
+	// locating the OPF package when META-INF/container.xml is missing.
+	Strict bool
+
+	// OpenRetries is how many more times to try opening the archive after a
+	// failure, waiting OpenRetryDelay between attempts. This rides out files
+	// that are still being copied into the books directory.
+	OpenRetries    int
+	OpenRetryDelay time.Duration
+
+	// CleanFilenameTitles tidies titles that fall back to the file name
+	// (PDFs, EPUBs without dc:title): separators become spaces, version
+	// suffixes such as "_v2" or "_final" are dropped and words are
+	// title-cased, so "the_great_gatsby" reads "The Great Gatsby".
+	CleanFilenameTitles bool
+
+	// IgnoreFileAs skips "file-as" sort names for titles and authors, so
+	// books sort by their display title and author name.
+	IgnoreFileAs bool
+
+	// Cache, if non-nil, is consulted by ParseBookWithOptions before
+	// parsing an EPUB and filled afterwards.
+	Cache *ParseCache
+
+	// InMemoryCovers records whether a book has a cover without writing it
+	// to coversDir, for read-only covers directories. Such covers are read
+	// from the archive on demand with ReadCover.
+	InMemoryCovers bool
+
+	// Stat, if non-nil, replaces os.Stat for reading the size and
+	// modification time of a book file, e.g. to reuse the information of
+	// the directory listing a scan already made.
+	Stat func(path string) (fs.FileInfo, error)
+
+	// AddedAt selects what dates a parsed book's AddedAt. The zero value
+	// is AddedAtModTime.
+	AddedAt AddedAtSource
+}
+
+// AddedAtSource is the source of the AddedAt date of parsed books.
+type AddedAtSource string
+
+const (
+	// AddedAtModTime dates a book by its file's modification time, which
+	// copies that preserve times (e.g. restored backups) carry over.
+	AddedAtModTime AddedAtSource = "mtime"
+	// AddedAtChangeTime dates a book by its file's status change time,
+	// which copying, moving or renaming the file updates. Where the
+	// system does not expose it, the modification time is used.
+	AddedAtChangeTime AddedAtSource = "ctime"
+	// AddedAtIndexing dates a book by the time its file is parsed, i.e.
+	// when it enters the catalog.
+	AddedAtIndexing AddedAtSource = "indexing"
+)
+
+// addedAt returns the AddedAt date of a book whose file is described by
+// info (nil if it could not be stat'ed), according to o.AddedAt.
+func (o Options) addedAt(info fs.FileInfo) time.Time {
+	if info == nil || o.AddedAt == AddedAtIndexing {
+		return time.Now()
+	}
+	if o.AddedAt == AddedAtChangeTime {
+		if t, ok := changeTime(info); ok {
+			return t
+		}
+	}
+	return info.ModTime()
+}
+
+// stat returns the file information of path through o.Stat or os.Stat.
+func (o Options) stat(path string) (fs.FileInfo, error) {
+	if o.Stat != nil {
+		return o.Stat(path)
+	}
+	return os.Stat(path)
+}
+
+// ErrOpen is wrapped by ParseBook errors caused by the archive itself being
+// unreadable (missing, locked or truncated), as opposed to bad metadata.
+// Such failures are often transient.
+var ErrOpen = errors.New("cannot open archive")
+
 // ParseBook opens an EPUB file, extracts OPF metadata and cover image, and
 // returns a populated Book. coversDir is the directory where extracted cover
 // images are cached. An error is returned only for fatal parsing failures;
 // cover extraction failures are silently ignored.
 func ParseBook(path, coversDir string) (catalog.Book, error) {
-	zr, err := zip.OpenReader(path)
-	if err != nil {
-		return catalog.Book{}, fmt.Errorf("open epub %q: %w", path, err)
-	}
-	defer zr.Close()
+	return ParseBookWithOptions(path, coversDir, Options{})
+}
 
-	opfPath, err := readContainerXML(&zr.Reader)
-	if err != nil {
-		return catalog.Book{}, fmt.Errorf("epub container %q: %w", path, err)
+// ParseBookWithOptions is like ParseBook but applies the given Options,
+// serving the metadata from opts.Cache when the same content was parsed
+// before.
+func ParseBookWithOptions(path, coversDir string, opts Options) (catalog.Book, error) {
+	if opts.Cache == nil {
+		book, _, err := parseBook(path, coversDir, opts)
+		if book.UpdatedAt.IsZero() {
+			book.UpdatedAt = time.Now()
+		}
+		return book, err
+	}
+	key, size, err := opts.Cache.key(path, opts)
+	if err == nil {
+		if book, ok := opts.Cache.lookup(key, path, coversDir, opts); ok {
+			opts.Cache.hits.Add(1)
+			return book, nil
+		}
 	}
+	opts.Cache.misses.Add(1)
+	book, titleFromFile, perr := parseBook(path, coversDir, opts)
+	// A file still being written may have grown since it was hashed.
+	if perr == nil && err == nil && book.Files[0].Size == size {
+		opts.Cache.store(key, book, titleFromFile, coversDir)
+	}
+	if book.UpdatedAt.IsZero() {
+		book.UpdatedAt = time.Now()
+	}
+	return book, perr
+}
 
-	pkg, err := readOPFPackage(&zr.Reader, opfPath)
+// parseBook does the work of ParseBookWithOptions without the cache. It also
+// reports whether the title was taken from the file name. UpdatedAt is left
+// zero unless the package records a dcterms:modified date.
+func parseBook(path, coversDir string, opts Options) (catalog.Book, bool, error) {
+	zr, opfPath, pkg, err := openPackage(path, opts)
 	if err != nil {
-		return catalog.Book{}, fmt.Errorf("epub opf %q: %w", path, err)
+		return catalog.Book{}, false, err
 	}
+	defer zr.Close()
 	meta := pkg.Metadata
 
-	info, _ := os.Stat(path)
+	info, _ := opts.stat(path)
 	size := int64(0)
-	addedAt := time.Now()
 	if info != nil {
 		size = info.Size()
-		addedAt = info.ModTime()
 	}
+	addedAt := opts.addedAt(info)
 
 	id := PathToID(path)
+	titles := meta.titleValues()
 	book := catalog.Book{
 		ID:        id,
-		Title:     firstOrFilename(meta.Titles, path),
+		Title:     firstOrFilename(titles, path, opts.CleanFilenameTitles),
 		Summary:   meta.Description,
-		Language:  meta.Language,
 		Publisher: meta.Publisher,
-		UpdatedAt: time.Now(),
+		Source:    strings.TrimSpace(meta.Source),
 		AddedAt:   addedAt,
-		Tags:      meta.Subjects,
+		Tags:      catalog.UniqueTags(meta.Subjects),
 		Files: []catalog.File{
-			{MIMEType: "application/epub+zip", Path: path, Size: size},
+			{MIMEType: epubMIMEType(path), Path: path, Size: size},
 		},
 	}
 
+	var fileAs map[string]string
+	if !opts.IgnoreFileAs {
+		fileAs = extractFileAsFromMetas(meta.Metas)
+		book.TitleSort = titleSort(meta, fileAs)
+	}
 	for _, c := range meta.Creators {
-		book.Authors = append(book.Authors, catalog.Author{Name: c.Name})
+		a := catalog.Author{Name: c.Name}
+		if !opts.IgnoreFileAs {
+			a.SortName = sortName(c.FileAs, c.ID, fileAs)
+		}
+		book.Authors = append(book.Authors, a)
 	}
 
-	if meta.Date != "" {
-		if t, err := time.Parse("2006-01-02", meta.Date[:min(10, len(meta.Date))]); err == nil {
-			book.PublishedAt = t
+	if langs := meta.languageValues(); len(langs) > 0 {
+		book.Language = langs[0]
+		if len(langs) > 1 {
+			book.Languages = langs
 		}
 	}
 
+	if t, ok := parseDate(meta.Date); ok {
+		book.PublishedAt = t
+	}
+	if t, ok := extractModifiedFromMetas(meta.Metas); ok {
+		book.UpdatedAt = t
+	}
+
 	if series, seriesIdx := extractSeriesFromMetas(meta.Metas); series != "" {
 		book.Series = series
 		book.SeriesIndex = seriesIdx
@@ -80,27 +214,151 @@ func ParseBook(path, coversDir string) (catalog.Book, error) {
 		book.Collection = col
 	}
 
-	if coverPath := extractCoverFromPkg(&zr.Reader, opfPath, pkg, id, coversDir); coverPath != "" {
+	book.Identifiers = extractIdentifiers(meta.Identifiers)
+
+	hasCover := false
+	if opts.InMemoryCovers {
+		f, _ := locateCover(&zr.Reader, opfPath, pkg)
+		hasCover = f != nil
+	} else {
+		hasCover = extractCoverFromPkg(&zr.Reader, opfPath, pkg, id, coversDir) != ""
+	}
+	if hasCover {
 		book.CoverURL = "/covers/" + id
-		book.ThumbnailURL = "/covers/" + id
+		book.ThumbnailURL = "/covers/" + id + "/thumb"
+	}
+
+	return book, len(titles) == 0 || titles[0] == "", nil
+}
+
+// openPackage opens the archive at path, retrying as configured in opts,
+// and reads its OPF package. The caller must close the returned reader.
+func openPackage(path string, opts Options) (*zip.ReadCloser, string, opfPackage, error) {
+	zr, err := zip.OpenReader(path)
+	for i := 0; err != nil && i < opts.OpenRetries; i++ {
+		time.Sleep(opts.OpenRetryDelay)
+		zr, err = zip.OpenReader(path)
+	}
+	if err != nil {
+		return nil, "", opfPackage{}, fmt.Errorf("open epub %q: %w: %w", path, ErrOpen, err)
+	}
+
+	opfPath, err := readContainerXML(&zr.Reader)
+	if errors.Is(err, errNoContainer) && !opts.Strict {
+		opfPath, err = findLoneOPF(&zr.Reader)
+	}
+	if err != nil {
+		zr.Close()
+		return nil, "", opfPackage{}, fmt.Errorf("epub container %q: %w", path, err)
 	}
 
-	return book, nil
+	pkg, err := readOPFPackage(&zr.Reader, opfPath)
+	if err != nil {
+		zr.Close()
+		return nil, "", opfPackage{}, fmt.Errorf("epub opf %q: %w", path, err)
+	}
+	return zr, opfPath, pkg, nil
 }
 
-// ParsePath creates a minimal Book entry for a non-EPUB file (e.g. PDF).
+// maxCoverBytes caps the size of a cover read into memory.
+const maxCoverBytes = 20 << 20
+
+// ReadCover reads the cover image of the EPUB, FB2 or CBZ file at path into
+// memory, without caching it on disk. It returns the image data, upright
+// per its EXIF orientation, and its file extension (e.g. ".jpg"), or an
+// error if the book has no cover.
+func ReadCover(path string) ([]byte, string, error) {
+	data, ext, err := readCover(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return normalizeOrientation(data), ext, nil
+}
+
+// readCover reads the cover image of the book at path as stored in it.
+func readCover(path string) ([]byte, string, error) {
+	if IsComic(path) {
+		return readComicCover(path)
+	}
+	switch FileExt(path) {
+	case ".fb2", fb2ZipExt:
+		return readFB2Cover(path)
+	}
+	zr, opfPath, pkg, err := openPackage(path, Options{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer zr.Close()
+
+	f, ext := locateCover(&zr.Reader, opfPath, pkg)
+	if f == nil {
+		return nil, "", fmt.Errorf("no cover in %q", path)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, "", fmt.Errorf("open cover in %q: %w", path, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxCoverBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("read cover in %q: %w", path, err)
+	}
+	if len(data) > maxCoverBytes {
+		return nil, "", fmt.Errorf("cover in %q exceeds %d bytes", path, maxCoverBytes)
+	}
+	return data, ext, nil
+}
+
+// DirWritable reports whether files can be created in dir, creating the
+// directory first if needed. Backends call it once at startup to decide
+// whether covers can be cached on disk.
+func DirWritable(dir string) bool {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return false
+	}
+	name := f.Name()
+	f.Close()
+	_ = os.Remove(name)
+	return true
+}
+
+// HashFile returns the hex-encoded SHA-256 of the content of the file at
+// path. The file is streamed through the hash, not read into memory.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %q: %w", path, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ParsePath creates a minimal Book entry for a non-EPUB file (e.g. PDF),
+// titled after the file name.
 func ParsePath(path string) catalog.Book {
-	info, _ := os.Stat(path)
+	return ParsePathWithOptions(path, Options{})
+}
+
+// ParsePathWithOptions is like ParsePath but applies the given Options.
+func ParsePathWithOptions(path string, opts Options) catalog.Book {
+	info, _ := opts.stat(path)
 	size := int64(0)
-	addedAt := time.Now()
 	if info != nil {
 		size = info.Size()
-		addedAt = info.ModTime()
 	}
+	addedAt := opts.addedAt(info)
 
-	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-	mime := "application/pdf"
-	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+	name := firstOrFilename(nil, path, opts.CleanFilenameTitles)
+	mime, ok := fileMIMETypes[FileExt(path)]
+	if !ok {
 		mime = "application/octet-stream"
 	}
 
@@ -115,16 +373,66 @@ func ParsePath(path string) catalog.Book {
 	}
 }
 
+// fileMIMETypes maps the non-EPUB extensions the catalog indexes to the
+// MIME type recorded for their files.
+var fileMIMETypes = map[string]string{
+	".pdf":    "application/pdf",
+	".mobi":   mimeMOBI,
+	".azw3":   mimeAZW3,
+	".fb2":    mimeFB2,
+	fb2ZipExt: mimeFB2Zip,
+	".cbz":    mimeCBZ,
+	".cbr":    mimeCBR,
+}
+
+// MIME types recorded for EPUB files. Kobo EPUBs (".kepub.epub") are parsed
+// like any EPUB but advertised as kepub so Kobo readers pick them.
+const (
+	mimeEPUB  = "application/epub+zip"
+	mimeKEPUB = "application/kepub+zip"
+)
+
+// kepubExt is the double extension of Kobo EPUB files.
+const kepubExt = ".kepub.epub"
+
+// FileExt returns the lower-case extension of path, keeping the Kobo
+// ".kepub.epub" and zipped FictionBook ".fb2.zip" double extensions whole.
+func FileExt(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{kepubExt, fb2ZipExt} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return strings.ToLower(filepath.Ext(path))
+}
+
+func epubMIMEType(path string) string {
+	if FileExt(path) == kepubExt {
+		return mimeKEPUB
+	}
+	return mimeEPUB
+}
+
 // PathToID generates a stable string ID from a file path using a short SHA-256 hash.
 func PathToID(path string) string {
 	sum := sha256.Sum256([]byte(path))
 	return fmt.Sprintf("%x", sum[:8])
 }
 
+// coverExts are the extensions cached cover images may have.
+var coverExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// IsCoverExt reports whether ext (with the dot, lower-case) is an extension
+// a cached cover image may have.
+func IsCoverExt(ext string) bool {
+	return slices.Contains(coverExts, ext)
+}
+
 // CoverPath returns the filesystem path to the cached cover image for a book
 // ID, searching for common image extensions. Returns an error if no cover exists.
 func CoverPath(coversDir, id string) (string, error) {
-	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
+	for _, ext := range coverExts {
 		p := filepath.Join(coversDir, id+ext)
 		if _, err := os.Stat(p); err == nil {
 			return p, nil
@@ -133,6 +441,55 @@ func CoverPath(coversDir, id string) (string, error) {
 	return "", fmt.Errorf("no cover for book %q", id)
 }
 
+// RemoveCovers deletes the cached cover of a book, whatever its image
+// type, and its thumbnail. Missing files are ignored.
+func RemoveCovers(coversDir, id string) {
+	for _, ext := range coverExts {
+		_ = os.Remove(filepath.Join(coversDir, id+ext))
+	}
+	_ = os.Remove(ThumbnailPath(coversDir, id))
+}
+
+// PurgeCovers removes the cached covers and thumbnails in coversDir whose
+// book ID is not accepted by keep, e.g. those of books deleted while the
+// server was down. Files that are not named like a cover are left alone.
+// When dryRun is true nothing is removed. It returns the number of files
+// removed (or that would be).
+func PurgeCovers(coversDir string, keep func(id string) bool, dryRun bool) (int, error) {
+	entries, err := os.ReadDir(coversDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		id, ok := strings.CutSuffix(name, ".thumb.jpg")
+		if !ok {
+			ext := filepath.Ext(name)
+			if !IsCoverExt(ext) {
+				continue
+			}
+			id = strings.TrimSuffix(name, ext)
+		}
+		if id == "" || strings.HasPrefix(id, ".") || keep(id) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(filepath.Join(coversDir, name)); err != nil && !os.IsNotExist(err) {
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
 // --- internal XML struct types for OPF/container parsing ---
 
 type opfPackage struct {
@@ -150,19 +507,107 @@ type opfItemRef struct {
 }
 
 type opfMetadata struct {
-	Titles      []string    `xml:"title"`
+	Titles      []opfTitle  `xml:"title"`
 	Creators    []opfAuthor `xml:"creator"`
 	Subjects    []string    `xml:"subject"`
 	Description string      `xml:"description"`
-	Language    string      `xml:"language"`
+	Languages   []string    `xml:"language"`
 	Publisher   string      `xml:"publisher"`
+	Source      string      `xml:"source"`
 	Date        string      `xml:"date"`
+	Identifiers []opfIdent  `xml:"identifier"`
 	Metas       []opfMeta   `xml:"meta"`
 }
 
+// opfIdent is a <dc:identifier>. EPUB2 names the scheme in opf:scheme;
+// EPUB3 usually embeds it in the value as a URN ("urn:isbn:...").
+type opfIdent struct {
+	Value  string `xml:",chardata"`
+	Scheme string `xml:"scheme,attr"`
+}
+
+// titleValues returns the text of every <dc:title>.
+// languageValues returns the distinct non-empty dc:language values in
+// document order.
+func (m opfMetadata) languageValues() []string {
+	var langs []string
+	for _, l := range m.Languages {
+		l = strings.TrimSpace(l)
+		if l != "" && !slices.ContainsFunc(langs, func(s string) bool { return strings.EqualFold(s, l) }) {
+			langs = append(langs, l)
+		}
+	}
+	return langs
+}
+
+func (m opfMetadata) titleValues() []string {
+	titles := make([]string, 0, len(m.Titles))
+	for _, t := range m.Titles {
+		titles = append(titles, t.Value)
+	}
+	return titles
+}
+
+type opfTitle struct {
+	Value  string
+	ID     string
+	FileAs string // EPUB2 opf:file-as
+}
+
+// UnmarshalXML keeps the text of markup nested in the title, so
+// "R&amp;D <i>Notes</i>" reads as "R&D Notes" rather than "R&D ".
+func (t *opfTitle) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	t.ID, t.FileAs = xmlAttr(start, "id"), xmlAttr(start, "file-as")
+	v, err := elementText(d)
+	t.Value = v
+	return err
+}
+
 type opfAuthor struct {
-	Name string `xml:",chardata"`
-	Role string `xml:"role,attr"`
+	Name   string
+	Role   string
+	ID     string
+	FileAs string // EPUB2 opf:file-as
+}
+
+// UnmarshalXML reads a <dc:creator> like opfTitle.UnmarshalXML.
+func (a *opfAuthor) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	a.Role, a.ID, a.FileAs = xmlAttr(start, "role"), xmlAttr(start, "id"), xmlAttr(start, "file-as")
+	v, err := elementText(d)
+	a.Name = v
+	return err
+}
+
+// xmlAttr returns the value of the attribute with the given local name,
+// whatever its namespace (opf:file-as and file-as alike).
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// elementText consumes the decoder up to the end of the current element
+// and returns all its character data, including that of nested elements.
+func elementText(d *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	for depth := 1; depth > 0; {
+		tok, err := d.Token()
+		if err != nil {
+			return sb.String(), err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			sb.Write(tok)
+		}
+	}
+	return sb.String(), nil
 }
 
 type opfMeta struct {
@@ -212,7 +657,31 @@ func readContainerXML(zr *zip.Reader) (string, error) {
 			return c.Rootfile.FullPath, nil
 		}
 	}
-	return "", fmt.Errorf("META-INF/container.xml not found")
+	return "", errNoContainer
+}
+
+// errNoContainer is returned by readContainerXML when the EPUB has no
+// META-INF/container.xml entry.
+var errNoContainer = errors.New("META-INF/container.xml not found")
+
+// findLoneOPF returns the path of the only .opf file in the archive. It is
+// used as a fallback for EPUBs missing container.xml; an archive with zero
+// or several .opf files is rejected since the package cannot be identified.
+func findLoneOPF(zr *zip.Reader) (string, error) {
+	var found string
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".opf") {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("container.xml missing and multiple .opf files found")
+		}
+		found = f.Name
+	}
+	if found == "" {
+		return "", fmt.Errorf("container.xml missing and no .opf file found")
+	}
+	return found, nil
 }
 
 func readOPFPackage(zr *zip.Reader, opfPath string) (opfPackage, error) {
@@ -224,13 +693,13 @@ func readOPFPackage(zr *zip.Reader, opfPath string) (opfPackage, error) {
 			}
 			defer rc.Close()
 
-			data, err := io.ReadAll(rc)
-			if err != nil {
-				return opfPackage{}, err
-			}
-
+			// HTML named entities (&eacute;, &nbsp;) are common in OPFs
+			// written by hand or by HTML-minded tools; resolve them rather
+			// than rejecting the whole package.
+			dec := xml.NewDecoder(rc)
+			dec.Entity = xml.HTMLEntity
 			var pkg opfPackage
-			if err := xml.Unmarshal(data, &pkg); err != nil {
+			if err := dec.Decode(&pkg); err != nil {
 				return opfPackage{}, err
 			}
 			return pkg, nil
@@ -239,7 +708,73 @@ func readOPFPackage(zr *zip.Reader, opfPath string) (opfPackage, error) {
 	return opfPackage{}, fmt.Errorf("OPF file %q not found in epub", opfPath)
 }
 
-func extractCoverFromPkg(zr *zip.Reader, opfPath string, pkg opfPackage, bookID, coversDir string) string {
+// extractCoverFromPkg saves the cover image declared in the OPF (or, failing
+// that, the first image of the spine) to coversDir, upright per its EXIF
+// orientation, and generates its thumbnail. Returns the saved cover path or "" if the book has no cover.
+func extractCoverFromPkg(zr *zip.Reader, opfPath string, pkg opfPackage, bookID, coversDir string) (coverPath string) {
+	defer func() {
+		if coverPath != "" {
+			// A cover that cannot be decoded is still served as-is; the
+			// thumbnail endpoint falls back to it.
+			_ = ensureThumbnail(coverPath, ThumbnailPath(coversDir, bookID))
+		}
+	}()
+
+	coverFile, ext := locateCover(zr, opfPath, pkg)
+	if coverFile == nil {
+		return ""
+	}
+
+	destPath := filepath.Join(coversDir, bookID+ext)
+	if _, err := os.Stat(destPath); err == nil {
+		return destPath
+	}
+
+	rc, err := coverFile.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxCoverBytes+1))
+	if err != nil || len(data) > maxCoverBytes {
+		return ""
+	}
+
+	if err := writeFileAtomic(destPath, bytes.NewReader(normalizeOrientation(data))); err != nil {
+		return ""
+	}
+	return destPath
+}
+
+// writeFileAtomic writes src to a temporary file next to dst and renames it
+// into place, so that concurrent writers of the same cover (two scans of one
+// book, or a scan racing an upload) never interleave their bytes and readers
+// never see a partially written image: the last rename wins whole.
+func writeFileAtomic(dst string, src io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".cover-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := io.Copy(tmp, src)
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.Join(werr, cerr)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// locateCover returns the archive entry holding the cover image declared in
+// the OPF, or failing that the first image of the spine, together with the
+// file extension to store it under. Returns nil if the book has no cover.
+func locateCover(zr *zip.Reader, opfPath string, pkg opfPackage) (*zip.File, string) {
 	opfDir := filepath.ToSlash(filepath.Dir(opfPath))
 	if opfDir == "." {
 		opfDir = ""
@@ -268,7 +803,7 @@ func extractCoverFromPkg(zr *zip.Reader, opfPath string, pkg opfPackage, bookID,
 
 	if coverHref == "" {
 		// Fallback: scan the first HTML spine item for the first <img> tag.
-		return findCoverInSpine(zr, opfDir, pkg, bookID, coversDir)
+		return findCoverInSpine(zr, opfDir, pkg)
 	}
 
 	var fullHref string
@@ -286,7 +821,7 @@ func extractCoverFromPkg(zr *zip.Reader, opfPath string, pkg opfPackage, bookID,
 		}
 	}
 	if coverFile == nil {
-		return ""
+		return nil, ""
 	}
 
 	ext := mimeToExt(coverMIME)
@@ -296,35 +831,13 @@ func extractCoverFromPkg(zr *zip.Reader, opfPath string, pkg opfPackage, bookID,
 	if ext == "" {
 		ext = ".jpg"
 	}
-
-	destPath := filepath.Join(coversDir, bookID+ext)
-	if _, err := os.Stat(destPath); err == nil {
-		return destPath
-	}
-
-	rc, err := coverFile.Open()
-	if err != nil {
-		return ""
-	}
-	defer rc.Close()
-
-	out, err := os.Create(destPath)
-	if err != nil {
-		return ""
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, rc); err != nil {
-		_ = os.Remove(destPath)
-		return ""
-	}
-	return destPath
+	return coverFile, ext
 }
 
 // findCoverInSpine walks the OPF spine in order, opens the first HTML/XHTML
-// item, and saves the first <img src="…"> it finds as the book cover.
-// Returns the saved cover path or "" if nothing is found.
-func findCoverInSpine(zr *zip.Reader, opfDir string, pkg opfPackage, bookID, coversDir string) string {
+// item, and returns the archive entry of the first <img src="…"> it finds
+// along with its file extension. Returns nil if nothing is found.
+func findCoverInSpine(zr *zip.Reader, opfDir string, pkg opfPackage) (*zip.File, string) {
 	// Build manifest map: id → item.
 	byID := make(map[string]opfItem, len(pkg.Manifest.Items))
 	for _, item := range pkg.Manifest.Items {
@@ -409,30 +922,9 @@ func findCoverInSpine(zr *zip.Reader, opfDir string, pkg opfPackage, bookID, cov
 			ext = ".jpg"
 		}
 
-		destPath := filepath.Join(coversDir, bookID+ext)
-		if _, statErr := os.Stat(destPath); statErr == nil {
-			return destPath // already extracted
-		}
-
-		imgRC, err := imgFile.Open()
-		if err != nil {
-			continue
-		}
-		out, err := os.Create(destPath)
-		if err != nil {
-			imgRC.Close()
-			continue
-		}
-		_, copyErr := io.Copy(out, imgRC)
-		imgRC.Close()
-		out.Close()
-		if copyErr != nil {
-			_ = os.Remove(destPath)
-			continue
-		}
-		return destPath
+		return imgFile, ext
 	}
-	return ""
+	return nil, ""
 }
 
 // findFirstImgSrc does a simple scan for the first <img … src="…"> in an
@@ -487,6 +979,52 @@ func findFirstImgSrc(html string) string {
 	return strings.TrimSpace(src)
 }
 
+// extractFileAsFromMetas returns the EPUB3 file-as refinements keyed by the
+// id of the element they refine (without the leading "#"):
+//
+//	<dc:creator id="author">J. R. R. Tolkien</dc:creator>
+//	<meta refines="#author" property="file-as">Tolkien, J. R. R.</meta>
+func extractFileAsFromMetas(metas []opfMeta) map[string]string {
+	fileAs := make(map[string]string)
+	for _, m := range metas {
+		if m.Refines == "" || !strings.EqualFold(m.Property, "file-as") {
+			continue
+		}
+		if v := strings.TrimSpace(m.Value); v != "" {
+			fileAs[strings.TrimPrefix(m.Refines, "#")] = v
+		}
+	}
+	return fileAs
+}
+
+// sortName picks the sort key for an element: its EPUB2 opf:file-as
+// attribute, else an EPUB3 refinement of its id, else "".
+func sortName(attr, id string, fileAs map[string]string) string {
+	if v := strings.TrimSpace(attr); v != "" {
+		return v
+	}
+	if id != "" {
+		return fileAs[id]
+	}
+	return ""
+}
+
+// titleSort returns the sort key for the book's main title, falling back
+// to Calibre's <meta name="calibre:title_sort">.
+func titleSort(meta opfMetadata, fileAs map[string]string) string {
+	if len(meta.Titles) > 0 {
+		if v := sortName(meta.Titles[0].FileAs, meta.Titles[0].ID, fileAs); v != "" {
+			return v
+		}
+	}
+	for _, m := range meta.Metas {
+		if strings.EqualFold(m.Name, "calibre:title_sort") {
+			return strings.TrimSpace(m.Content)
+		}
+	}
+	return ""
+}
+
 // extractSeriesFromMetas looks for series/collection metadata in OPF meta elements.
 // It supports:
 //   - Calibre EPUB2 style: <meta name="calibre:series" content="..."/>
@@ -605,6 +1143,49 @@ func extractCollectionFromMetas(metas []opfMeta) string {
 	return ""
 }
 
+// extractModifiedFromMetas returns the date of the EPUB3
+// <meta property="dcterms:modified"> element, when the package has one.
+func extractModifiedFromMetas(metas []opfMeta) (time.Time, bool) {
+	for _, m := range metas {
+		if m.Refines == "" && strings.EqualFold(m.Property, "dcterms:modified") {
+			return parseDate(m.Value)
+		}
+	}
+	return time.Time{}, false
+}
+
+// dateLayouts are the dc:date forms parseDate accepts, most precise first.
+// OPF dates follow W3CDTF, so partial dates (year, year-month) are common.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// parseDate parses a dc:date value with the most precise layout that
+// matches, missing parts defaulting to the start of the period (so "1998"
+// is 1 January 1998). As a last resort it reads a leading YYYY-MM-DD, which
+// covers timestamps with an unusual time or zone suffix.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	if len(s) > 10 {
+		if t, err := time.Parse("2006-01-02", s[:10]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func mimeToExt(mimeType string) string {
 	switch strings.ToLower(mimeType) {
 	case "image/jpeg", "image/jpg":
@@ -622,9 +1203,100 @@ func mimeToExt(mimeType string) string {
 	}
 }
 
-func firstOrFilename(vals []string, path string) string {
+func firstOrFilename(vals []string, path string, clean bool) string {
 	if len(vals) > 0 && vals[0] != "" {
 		return vals[0]
 	}
-	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	base := filepath.Base(path)
+	name := base[:len(base)-len(FileExt(base))]
+	if clean {
+		return cleanFilenameTitle(name)
+	}
+	return name
+}
+
+// versionSuffix matches trailing file-name noise such as "_v2", ".v1.3",
+// " - v2", " (1)", "_final", "-draft" or " (copy)" left by editors and
+// download managers. "final", "draft" and "copy" only count in brackets or
+// after a separator other than a space, so "The Final Copy" is left alone.
+var versionSuffix = regexp.MustCompile(`(?i)(?:[\s_.+-]+(?:v\d+(?:\.\d+)*|\(\d+\))|\s*[(\[](?:final|draft|copy)[)\]]|(?:\s*-\s*|[_.+])(?:final|draft|copy))$`)
+
+// minorTitleWords stay lower-case inside a title-cased file name.
+var minorTitleWords = map[string]bool{
+	"a": true, "an": true, "and": true, "of": true, "the": true, "in": true,
+	"on": true, "to": true, "for": true, "or": true,
+	"de": true, "du": true, "des": true, "la": true, "le": true, "les": true, "et": true,
+}
+
+// cleanFilenameTitle turns a file name stem into a readable title. Words
+// that already contain capitals are kept as is; the raw name is returned
+// if nothing would be left.
+func cleanFilenameTitle(name string) string {
+	stem := name
+	for {
+		trimmed := versionSuffix.ReplaceAllString(stem, "")
+		if trimmed == stem || trimmed == "" {
+			break
+		}
+		stem = trimmed
+	}
+	words := strings.FieldsFunc(stem, func(r rune) bool {
+		return r == '_' || r == '.' || r == '+' || unicode.IsSpace(r)
+	})
+	if len(words) == 0 {
+		return name
+	}
+	for i, w := range words {
+		if w != strings.ToLower(w) {
+			continue
+		}
+		if i > 0 && minorTitleWords[w] {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(r)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+// identifierPrefixes maps value prefixes that carry their own scheme to
+// that scheme, for identifiers without an opf:scheme attribute.
+var identifierPrefixes = []struct{ prefix, scheme string }{
+	{"urn:isbn:", "ISBN"},
+	{"isbn:", "ISBN"},
+	{"urn:uuid:", "UUID"},
+	{"urn:doi:", "DOI"},
+	{"doi:", "DOI"},
+}
+
+// extractIdentifiers returns the <dc:identifier> values keyed by upper-case
+// scheme. Identifiers whose scheme cannot be determined are skipped, and
+// the first value wins when a scheme appears more than once.
+func extractIdentifiers(idents []opfIdent) map[string]string {
+	var ids map[string]string
+	for _, id := range idents {
+		value := strings.TrimSpace(id.Value)
+		scheme := strings.ToUpper(strings.TrimSpace(id.Scheme))
+		lower := strings.ToLower(value)
+		for _, p := range identifierPrefixes {
+			if strings.HasPrefix(lower, p.prefix) {
+				value = strings.TrimSpace(value[len(p.prefix):])
+				if scheme == "" {
+					scheme = p.scheme
+				}
+				break
+			}
+		}
+		if scheme == "" || value == "" {
+			continue
+		}
+		if _, ok := ids[scheme]; ok {
+			continue
+		}
+		if ids == nil {
+			ids = make(map[string]string)
+		}
+		ids[scheme] = value
+	}
+	return ids
 }
@@ -1,7 +1,27 @@
 package epub
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/catalog"
 )
 
 func TestFindFirstImgSrc(t *testing.T) {
@@ -211,3 +231,1187 @@ func TestExtractCollectionFromMetas(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractIdentifiers(t *testing.T) {
+	got := extractIdentifiers([]opfIdent{
+		{Scheme: "ISBN", Value: " 978-2-07-036822-8 "},
+		{Scheme: "uuid", Value: "urn:uuid:0b3c6f1e-2a4d-4e8f-9c1a-7d5e3b2f1a09"},
+		{Value: "urn:isbn:9999999999999"}, // ISBN already set by opf:scheme
+		{Value: "doi:10.1000/182"},
+		{Value: "no-scheme-at-all"},
+	})
+	want := map[string]string{
+		"ISBN": "978-2-07-036822-8",
+		"UUID": "0b3c6f1e-2a4d-4e8f-9c1a-7d5e3b2f1a09",
+		"DOI":  "10.1000/182",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+
+	if ids := extractIdentifiers(nil); ids != nil {
+		t.Errorf("no identifiers: got %v, want nil", ids)
+	}
+}
+
+// writeZip writes an archive containing the given name → content entries.
+func writeZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip create %q: %v", name, err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("zip write %q: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write %q: %v", path, err)
+	}
+}
+
+// writeRar writes a RAR 1.5 archive holding entries uncompressed ("store"
+// method), which is all the comic tests need from the format.
+func writeRar(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	// block appends a block whose header starts with its CRC: the low 16
+	// bits of the CRC-32 of the rest of the header.
+	var buf bytes.Buffer
+	block := func(header, data []byte) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(header)))
+		buf.Write(header)
+		buf.Write(data)
+	}
+	buf.WriteString("Rar!\x1a\x07\x00")
+	block([]byte{0x73, 0, 0, 13, 0, 0, 0, 0, 0, 0, 0}, nil)
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		data := []byte(entries[name])
+		var h bytes.Buffer
+		h.WriteByte(0x74) // file block
+		for _, v := range []any{
+			uint16(0x8000),         // data follows the header
+			uint16(32 + len(name)), // header size
+			uint32(len(data)),      // packed size
+			uint32(len(data)),      // unpacked size
+			uint8(3),               // Unix
+			crc32.ChecksumIEEE(data),
+			uint32(0),   // DOS time
+			uint8(20),   // version to extract
+			uint8(0x30), // store
+			uint16(len(name)),
+			uint32(0o100644),
+		} {
+			_ = binary.Write(&h, binary.LittleEndian, v)
+		}
+		h.WriteString(name)
+		block(h.Bytes(), data)
+	}
+	block([]byte{0x7b, 0, 0x40, 7, 0}, nil)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write %q: %v", path, err)
+	}
+}
+
+const containerlessOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>No Container</dc:title>
+    <dc:creator>Jane Doe</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>
+</package>`
+
+func TestParseBook_MissingContainerFallsBackToLoneOPF(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nocontainer.epub")
+	writeZip(t, path, map[string]string{
+		"mimetype":          "application/epub+zip",
+		"OEBPS/content.opf": containerlessOPF,
+	})
+
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if bk.Title != "No Container" {
+		t.Errorf("Title: got %q, want %q", bk.Title, "No Container")
+	}
+	if len(bk.Authors) != 1 || bk.Authors[0].Name != "Jane Doe" {
+		t.Errorf("Authors: got %+v, want Jane Doe", bk.Authors)
+	}
+
+	if _, err := ParseBookWithOptions(path, dir, Options{Strict: true}); err == nil {
+		t.Error("expected strict mode to reject EPUB without container.xml")
+	}
+}
+
+func TestParseBook_MissingContainerWithSeveralOPFs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ambiguous.epub")
+	writeZip(t, path, map[string]string{
+		"a.opf": containerlessOPF,
+		"b.opf": containerlessOPF,
+	})
+
+	if _, err := ParseBook(path, dir); err == nil {
+		t.Error("expected error when several .opf files exist and container.xml is missing")
+	}
+}
+
+func TestParseBook_KEPUB(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Kobo Story.KEPUB.epub")
+	writeZip(t, path, map[string]string{"OEBPS/content.opf": `<package><metadata/></package>`})
+
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if len(bk.Files) != 1 || bk.Files[0].MIMEType != mimeKEPUB {
+		t.Errorf("Files: got %+v, want kepub MIME type", bk.Files)
+	}
+	if bk.Title != "Kobo Story" {
+		t.Errorf("Title: got %q, want file name without .kepub.epub", bk.Title)
+	}
+
+	if got := FileExt(path); got != ".kepub.epub" {
+		t.Errorf("FileExt(%q) = %q", path, got)
+	}
+	if got := FileExt("plain.EPUB"); got != ".epub" {
+		t.Errorf("FileExt(plain.EPUB) = %q", got)
+	}
+	if got := UniquePath(path); got != filepath.Join(dir, "Kobo Story (2).KEPUB.epub") {
+		t.Errorf("UniquePath = %q", got)
+	}
+}
+
+func TestParseCache_HitForIdenticalContent(t *testing.T) {
+	dir := t.TempDir()
+	coversDir := filepath.Join(dir, "covers")
+	if err := os.MkdirAll(coversDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewParseCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("NewParseCache: %v", err)
+	}
+	opts := Options{Cache: cache}
+	entries := map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <dc:title>Cached Book</dc:title><dc:creator>Jane Doe</dc:creator>
+</metadata><manifest><item id="c" href="cover.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+		"cover.png": "\x89PNG fake image",
+	}
+	first := filepath.Join(dir, "first.epub")
+	writeZip(t, first, entries)
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "sub", "moved.kepub.epub")
+	if err := os.MkdirAll(filepath.Dir(moved), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(moved, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ParseBookWithOptions(first, coversDir, opts)
+	if err != nil {
+		t.Fatalf("first parse: %v", err)
+	}
+	b, err := ParseBookWithOptions(moved, coversDir, opts)
+	if err != nil {
+		t.Fatalf("second parse: %v", err)
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 1 {
+		t.Fatalf("after identical content: hits=%d misses=%d, want 1 and 1", hits, misses)
+	}
+	if b.Title != a.Title || len(b.Authors) != 1 || b.Authors[0].Name != "Jane Doe" {
+		t.Errorf("cached metadata: got %q by %+v", b.Title, b.Authors)
+	}
+	if b.ID == a.ID || b.ID != PathToID(moved) {
+		t.Errorf("cached book ID %q must follow the new path", b.ID)
+	}
+	if len(b.Files) != 1 || b.Files[0].Path != moved || b.Files[0].MIMEType != mimeKEPUB || b.Files[0].Size != int64(len(data)) {
+		t.Errorf("cached files: got %+v", b.Files)
+	}
+	if _, err := CoverPath(coversDir, b.ID); err != nil || b.CoverURL != "/covers/"+b.ID {
+		t.Errorf("cover not restored for cached book: %q, %v", b.CoverURL, err)
+	}
+	if a.ThumbnailURL != "/covers/"+a.ID+"/thumb" || b.ThumbnailURL != "/covers/"+b.ID+"/thumb" {
+		t.Errorf("ThumbnailURL: parsed %q, cached %q, want /covers/{id}/thumb", a.ThumbnailURL, b.ThumbnailURL)
+	}
+
+	// Changed content is parsed again.
+	entries["content.opf"] = strings.Replace(entries["content.opf"], "Cached Book", "Edited Book", 1)
+	writeZip(t, moved, entries)
+	c, err := ParseBookWithOptions(moved, coversDir, opts)
+	if err != nil {
+		t.Fatalf("third parse: %v", err)
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("after content change: hits=%d misses=%d, want 1 and 2", hits, misses)
+	}
+	if c.Title != "Edited Book" {
+		t.Errorf("title after content change: got %q", c.Title)
+	}
+}
+
+func TestParseCache_KeepsDCTermsModified(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewParseCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("NewParseCache: %v", err)
+	}
+	opts := Options{Cache: cache}
+	opf := `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>%s</dc:title>%s
+  </metadata>
+</package>`
+	modified := map[string]string{"content.opf": fmt.Sprintf(opf, "Modified", `
+    <meta property="dcterms:modified">2016-03-04T05:06:07Z</meta>`)}
+	undated := map[string]string{"content.opf": fmt.Sprintf(opf, "Undated", "")}
+
+	want := time.Date(2016, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, name := range []string{"a.epub", "b.epub"} { // b.epub is a cache hit
+		path := filepath.Join(dir, name)
+		writeZip(t, path, modified)
+		bk, err := ParseBookWithOptions(path, dir, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bk.UpdatedAt.Equal(want) {
+			t.Errorf("%s: UpdatedAt = %v, want %v", name, bk.UpdatedAt, want)
+		}
+	}
+	for _, name := range []string{"c.epub", "d.epub"} {
+		path := filepath.Join(dir, name)
+		writeZip(t, path, undated)
+		before := time.Now()
+		bk, err := ParseBookWithOptions(path, dir, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if bk.UpdatedAt.Before(before) {
+			t.Errorf("%s without dcterms:modified: UpdatedAt = %v, want the parse time", name, bk.UpdatedAt)
+		}
+	}
+	if hits, misses := cache.Stats(); hits != 2 || misses != 2 {
+		t.Errorf("hits=%d misses=%d, want 2 and 2", hits, misses)
+	}
+}
+
+const fileAsOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title id="main">The Hobbit</dc:title>
+    <meta refines="#main" property="file-as">Hobbit, The</meta>
+    <dc:creator id="author">J. R. R. Tolkien</dc:creator>
+    <meta refines="#author" property="file-as">Tolkien, J. R. R.</meta>
+    <dc:creator opf:file-as="Lee, Alan">Alan Lee</dc:creator>
+    <dc:creator>Anonymous</dc:creator>
+  </metadata>
+</package>`
+
+func TestParseBook_FileAs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hobbit.epub")
+	writeZip(t, path, map[string]string{"content.opf": fileAsOPF})
+
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if bk.TitleSort != "Hobbit, The" {
+		t.Errorf("TitleSort: got %q, want %q", bk.TitleSort, "Hobbit, The")
+	}
+	want := []string{"Tolkien, J. R. R.", "Lee, Alan", ""}
+	if len(bk.Authors) != len(want) {
+		t.Fatalf("Authors: got %+v", bk.Authors)
+	}
+	for i, w := range want {
+		if bk.Authors[i].SortName != w {
+			t.Errorf("author %q: SortName got %q, want %q", bk.Authors[i].Name, bk.Authors[i].SortName, w)
+		}
+	}
+
+	bk, err = ParseBookWithOptions(path, dir, Options{IgnoreFileAs: true})
+	if err != nil {
+		t.Fatalf("ParseBookWithOptions() error: %v", err)
+	}
+	if bk.TitleSort != "" || bk.Authors[0].SortName != "" {
+		t.Errorf("IgnoreFileAs: expected no sort names, got %q / %q", bk.TitleSort, bk.Authors[0].SortName)
+	}
+}
+
+func TestParseBook_PublishedDateFormats(t *testing.T) {
+	plusTwo := time.FixedZone("", 2*60*60)
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{"2011-07-15", time.Date(2011, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"2011-07", time.Date(2011, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"1998", time.Date(1998, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2011-07-15T10:30:00Z", time.Date(2011, 7, 15, 10, 30, 0, 0, time.UTC)},
+		{"2011-07-15T10:30:00+02:00", time.Date(2011, 7, 15, 10, 30, 0, 0, plusTwo)},
+		{"2011-07-15T10:30:00", time.Date(2011, 7, 15, 10, 30, 0, 0, time.UTC)},
+		{" 2011-07-15 ", time.Date(2011, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"2011-07-15 10:30", time.Date(2011, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"July 2011", time.Time{}},
+		{"", time.Time{}},
+	}
+	dir := t.TempDir()
+	for i, tc := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("dated-%d.epub", i))
+		writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Dated</dc:title>
+    <dc:date>` + tc.date + `</dc:date>
+  </metadata>
+</package>`})
+		bk, err := ParseBook(path, dir)
+		if err != nil {
+			t.Fatalf("%q: ParseBook() error: %v", tc.date, err)
+		}
+		if !bk.PublishedAt.Equal(tc.want) {
+			t.Errorf("%q: PublishedAt = %v, want %v", tc.date, bk.PublishedAt, tc.want)
+		}
+	}
+}
+
+func TestParseBook_DCTermsModified(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "modified.epub")
+	writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Modified</dc:title>
+    <dc:date>1998</dc:date>
+    <meta refines="#title" property="dcterms:modified">2001-01-01T00:00:00Z</meta>
+    <meta property="dcterms:modified">2016-03-04T05:06:07Z</meta>
+  </metadata>
+</package>`})
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if want := time.Date(2016, 3, 4, 5, 6, 7, 0, time.UTC); !bk.UpdatedAt.Equal(want) {
+		t.Errorf("UpdatedAt = %v, want %v", bk.UpdatedAt, want)
+	}
+	if want := time.Date(1998, 1, 1, 0, 0, 0, 0, time.UTC); !bk.PublishedAt.Equal(want) {
+		t.Errorf("PublishedAt = %v, want %v", bk.PublishedAt, want)
+	}
+}
+
+func TestParseBook_MultipleLanguages(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bilingual.epub")
+	writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Bilingual</dc:title>
+    <dc:language> fr </dc:language>
+    <dc:language>en</dc:language>
+    <dc:language>FR</dc:language>
+  </metadata>
+</package>`})
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if bk.Language != "fr" {
+		t.Errorf("Language = %q, want the first declared, fr", bk.Language)
+	}
+	if strings.Join(bk.Languages, ",") != "fr,en" {
+		t.Errorf("Languages = %q, want [fr en]", bk.Languages)
+	}
+}
+
+func TestParseBook_DuplicateTagsDifferentCase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tagged.epub")
+	writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Tagged</dc:title>
+    <dc:subject>SciFi</dc:subject>
+    <dc:subject> scifi </dc:subject>
+    <dc:subject></dc:subject>
+    <dc:subject>Space Opera</dc:subject>
+  </metadata>
+</package>`})
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if strings.Join(bk.Tags, ",") != "SciFi,Space Opera" {
+		t.Errorf("Tags = %q, want [SciFi Space Opera]", bk.Tags)
+	}
+}
+
+func TestParseBook_Source(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "converted.epub")
+	writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Converted</dc:title>
+    <dc:source>
+      MOBI
+    </dc:source>
+  </metadata>
+</package>`})
+	bk, err := ParseBook(path, t.TempDir())
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if bk.Source != "MOBI" {
+		t.Errorf("Source = %q, want MOBI", bk.Source)
+	}
+}
+
+func TestParseBook_TitleEntitiesAndMarkup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "entities.epub")
+	writeZip(t, path, map[string]string{"content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>R&amp;D &lt;Notes&gt; <i>vol.</i> 2</dc:title>
+    <dc:creator>Ren&eacute; Fran&ccedil;ois</dc:creator>
+  </metadata>
+</package>`})
+
+	bk, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook() error: %v", err)
+	}
+	if want := "R&D <Notes> vol. 2"; bk.Title != want {
+		t.Errorf("Title: got %q, want %q", bk.Title, want)
+	}
+	if len(bk.Authors) != 1 || bk.Authors[0].Name != "René François" {
+		t.Errorf("Authors: got %+v, want René François", bk.Authors)
+	}
+}
+
+func TestCleanFilenameTitle(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"the_great_gatsby", "The Great Gatsby"},
+		{"book_final_v2", "Book"},
+		{"the.lord.of.the.rings.v1.2", "The Lord of the Rings"},
+		{"NASA_handbook (1)", "NASA Handbook"},
+		{"Jean-Paul_sartre", "Jean-Paul Sartre"},
+		{"v2", "V2"},
+		{"___", "___"},
+		{"Dune (copy)", "Dune"},
+		{"Dune [Final]", "Dune"},
+		{"Dune - v2", "Dune"},
+		{"Dune - draft", "Dune"},
+		{"dune-draft", "Dune"},
+		// Words that are part of the title stay.
+		{"The Final Copy", "The Final Copy"},
+		{"First Draft", "First Draft"},
+		{"The Final Draft (1)", "The Final Draft"},
+	}
+	for _, tc := range cases {
+		if got := cleanFilenameTitle(tc.in); got != tc.want {
+			t.Errorf("cleanFilenameTitle(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParsePathWithOptions_CleanFilenameTitles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "the_great_gatsby.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ParsePath(path).Title; got != "the_great_gatsby" {
+		t.Errorf("disabled: title = %q, want raw file name", got)
+	}
+	if got := ParsePathWithOptions(path, Options{CleanFilenameTitles: true}).Title; got != "The Great Gatsby" {
+		t.Errorf("enabled: title = %q, want %q", got, "The Great Gatsby")
+	}
+}
+
+// fakeInfo overrides the size and modification time of a real FileInfo.
+type fakeInfo struct {
+	os.FileInfo
+	size int64
+	mod  time.Time
+}
+
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) ModTime() time.Time { return f.mod }
+
+func TestOptionsStat_ReplacesOSStat(t *testing.T) {
+	dir := t.TempDir()
+	pdf := filepath.Join(dir, "doc.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	book := filepath.Join(dir, "book.epub")
+	writeZip(t, book, map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container"><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf":            `<?xml version="1.0"?><package xmlns="http://www.idpf.org/2007/opf"><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>T</dc:title></metadata></package>`,
+	})
+
+	mod := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	var calls []string
+	opts := Options{Stat: func(path string) (os.FileInfo, error) {
+		calls = append(calls, path)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return fakeInfo{info, 12345, mod}, nil
+	}}
+
+	got := ParsePathWithOptions(pdf, opts)
+	if got.Files[0].Size != 12345 || !got.AddedAt.Equal(mod) {
+		t.Errorf("PDF: size %d, added %v; want the Stat values", got.Files[0].Size, got.AddedAt)
+	}
+	bk, err := ParseBookWithOptions(book, dir, opts)
+	if err != nil {
+		t.Fatalf("ParseBookWithOptions: %v", err)
+	}
+	if bk.Files[0].Size != 12345 || !bk.AddedAt.Equal(mod) {
+		t.Errorf("EPUB: size %d, added %v; want the Stat values", bk.Files[0].Size, bk.AddedAt)
+	}
+	if !slices.Equal(calls, []string{pdf, book}) {
+		t.Errorf("Stat calls: got %v, want one per book", calls)
+	}
+}
+
+func TestOptionsAddedAt(t *testing.T) {
+	pdf := filepath.Join(t.TempDir(), "doc.pdf")
+	if err := os.WriteFile(pdf, []byte("%PDF-1.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(pdf, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []AddedAtSource{"", AddedAtModTime} {
+		if got := ParsePathWithOptions(pdf, Options{AddedAt: source}).AddedAt; !got.Equal(old) {
+			t.Errorf("AddedAt %q: got %v, want the modification time %v", source, got, old)
+		}
+	}
+	before := time.Now()
+	if got := ParsePathWithOptions(pdf, Options{AddedAt: AddedAtIndexing}).AddedAt; got.Before(before) {
+		t.Errorf("AddedAt indexing: got %v, want the parse time", got)
+	}
+	// Writing the file and setting its times both changed its ctime.
+	if got := ParsePathWithOptions(pdf, Options{AddedAt: AddedAtChangeTime}).AddedAt; got.Equal(old) {
+		t.Errorf("AddedAt ctime: got the modification time %v", got)
+	}
+}
+
+func TestOrganizedPath(t *testing.T) {
+	cases := []struct {
+		name string
+		book catalog.Book
+		want string
+	}{
+		{
+			name: "author and series",
+			book: catalog.Book{Title: "Dune", Series: "Dune Chronicles", Authors: []catalog.Author{{Name: "Frank Herbert"}}},
+			want: filepath.Join("root", "Frank Herbert", "Dune Chronicles", "Dune.epub"),
+		},
+		{
+			name: "no series",
+			book: catalog.Book{Title: "Emma", Authors: []catalog.Author{{Name: "Jane Austen"}}},
+			want: filepath.Join("root", "Jane Austen", "Emma.epub"),
+		},
+		{
+			name: "unsafe characters and missing author",
+			book: catalog.Book{Title: "../What? A/B: Story."},
+			want: filepath.Join("root", "Unknown Author", "_What_ A_B_ Story.epub"),
+		},
+	}
+	for _, tc := range cases {
+		if got := OrganizedPath("root", tc.book, ".epub"); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// buildMOBI returns a minimal MOBI file: a Palm database header with one
+// record holding PalmDOC and MOBI headers, the full name and an EXTH block
+// with the given records.
+func buildMOBI(fullName string, exth map[uint32][]string) []byte {
+	be := binary.BigEndian
+	const rec0 = 86 // 78-byte PDB header + one 8-byte record entry
+	const mobiLen = 0xe8
+
+	var ext bytes.Buffer
+	var count uint32
+	for typ, values := range exth {
+		for _, v := range values {
+			ext.Write(be.AppendUint32(nil, typ))
+			ext.Write(be.AppendUint32(nil, uint32(8+len(v))))
+			ext.WriteString(v)
+			count++
+		}
+	}
+	exthBlock := append([]byte("EXTH"), be.AppendUint32(nil, uint32(12+ext.Len()))...)
+	exthBlock = be.AppendUint32(exthBlock, count)
+	exthBlock = append(exthBlock, ext.Bytes()...)
+
+	mobi := make([]byte, mobiLen)
+	copy(mobi, "MOBI")
+	be.PutUint32(mobi[4:], mobiLen)
+	be.PutUint32(mobi[12:], 65001) // UTF-8
+	be.PutUint32(mobi[0x44:], uint32(16+mobiLen+len(exthBlock)))
+	be.PutUint32(mobi[0x48:], uint32(len(fullName)))
+	be.PutUint32(mobi[0x70:], 0x40) // EXTH present
+
+	pdb := make([]byte, rec0)
+	copy(pdb, "palm_db_name")
+	copy(pdb[60:], "BOOKMOBI")
+	be.PutUint16(pdb[76:], 1)
+	be.PutUint32(pdb[78:], rec0)
+
+	out := append(pdb, make([]byte, 16)...) // PalmDOC header
+	out = append(out, mobi...)
+	out = append(out, exthBlock...)
+	return append(out, fullName...)
+}
+
+func TestParseMOBIWithOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "dune.azw3")
+	data := buildMOBI("Dune (Full Name)", map[uint32][]string{
+		exthTitle:     {"Dune"},
+		exthAuthor:    {"Frank Herbert"},
+		exthPublisher: {"Chilton"},
+		exthISBN:      {"978-0-441-17271-9"},
+		exthSubject:   {"Science Fiction"},
+	})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	bk := ParseMOBIWithOptions(path, Options{})
+	if bk.Title != "Dune" {
+		t.Errorf("Title: got %q, want %q", bk.Title, "Dune")
+	}
+	if len(bk.Authors) != 1 || bk.Authors[0].Name != "Frank Herbert" {
+		t.Errorf("Authors: got %+v", bk.Authors)
+	}
+	if bk.Publisher != "Chilton" || bk.ISBN() != "978-0-441-17271-9" {
+		t.Errorf("Publisher/ISBN: got %q / %q", bk.Publisher, bk.ISBN())
+	}
+	if len(bk.Tags) != 1 || bk.Tags[0] != "Science Fiction" {
+		t.Errorf("Tags: got %v", bk.Tags)
+	}
+	if len(bk.Files) != 1 || bk.Files[0].MIMEType != "application/x-mobi8-ebook" {
+		t.Errorf("Files: got %+v", bk.Files)
+	}
+
+	// Without an EXTH title the MOBI full name is used.
+	path = filepath.Join(dir, "untitled.mobi")
+	if err := os.WriteFile(path, buildMOBI("Full Name Title", nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bk := ParseMOBIWithOptions(path, Options{}); bk.Title != "Full Name Title" || bk.Files[0].MIMEType != "application/x-mobipocket-ebook" {
+		t.Errorf("full name fallback: got %q (%s)", bk.Title, bk.Files[0].MIMEType)
+	}
+
+	// Unreadable headers fall back to the file name.
+	path = filepath.Join(dir, "broken_file.mobi")
+	if err := os.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bk := ParseMOBIWithOptions(path, Options{}); bk.Title != "broken_file" {
+		t.Errorf("file name fallback: got %q", bk.Title)
+	}
+}
+
+func TestParseBook_GeneratesCoverThumbnail(t *testing.T) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 600, 900))); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "thumb.epub")
+	writeZip(t, path, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Thumb</dc:title></metadata>
+<manifest><item id="c" href="cover.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+		"cover.png": cover.String(),
+	})
+
+	book, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook: %v", err)
+	}
+	if book.ThumbnailURL != "/covers/"+book.ID+"/thumb" {
+		t.Errorf("ThumbnailURL = %q", book.ThumbnailURL)
+	}
+	f, err := os.Open(ThumbnailPath(dir, book.ID))
+	if err != nil {
+		t.Fatalf("thumbnail not written: %v", err)
+	}
+	defer f.Close()
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if cfg.Width != ThumbnailWidth || cfg.Height != 450 {
+		t.Errorf("thumbnail size = %dx%d, want %dx450", cfg.Width, cfg.Height, ThumbnailWidth)
+	}
+}
+
+func TestParseBook_ConcurrentCoverWrites(t *testing.T) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 400, 600))); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "race.epub")
+	writeZip(t, path, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Race</dc:title></metadata>
+<manifest><item id="c" href="cover.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+		"cover.png": cover.String(),
+	})
+
+	// Every goroutine parses the same file, so all of them extract the
+	// cover of the same book ID.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ParseBook(path, dir); err != nil {
+				t.Errorf("ParseBook: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	coverPath, err := CoverPath(dir, PathToID(path))
+	if err != nil {
+		t.Fatalf("cover not written: %v", err)
+	}
+	data, err := os.ReadFile(coverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, cover.Bytes()) {
+		t.Errorf("cover is %d bytes, want the %d-byte original", len(data), cover.Len())
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("cover is not a valid PNG: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".cover-*.tmp")); len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestWriteFileAtomic_ConcurrentWritersLeaveOneWholeImage(t *testing.T) {
+	images := make([][]byte, 4)
+	for i := range images {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100*(i+1), 100))); err != nil {
+			t.Fatal(err)
+		}
+		images[i] = buf.Bytes()
+	}
+	dst := filepath.Join(t.TempDir(), "book.png")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(img []byte) {
+			defer wg.Done()
+			if err := writeFileAtomic(dst, bytes.NewReader(img)); err != nil {
+				t.Errorf("writeFileAtomic: %v", err)
+			}
+		}(images[i%len(images)])
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, img := range images {
+		found = found || bytes.Equal(data, img)
+	}
+	if !found {
+		t.Errorf("final cover (%d bytes) matches none of the written images", len(data))
+	}
+}
+
+// buildFB2 returns a minimal FictionBook document with the given
+// <title-info> body and, when cover is non-nil, a PNG coverpage binary.
+func buildFB2(titleInfo string, cover []byte) string {
+	coverpage, binary := "", ""
+	if cover != nil {
+		coverpage = `<coverpage><image l:href="#cover.png"/></coverpage>`
+		binary = `<binary id="cover.png" content-type="image/png">` + base64.StdEncoding.EncodeToString(cover) + `</binary>`
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info>` + titleInfo + coverpage + `</title-info></description>
+<body><section><p>Text</p></section></body>
+` + binary + `
+</FictionBook>`
+}
+
+func TestParseFB2WithOptions(t *testing.T) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 10, 15))); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	info := `<genre>sf</genre><genre>adventure</genre>
+<author><first-name>Arkady</first-name><last-name>Strugatsky</last-name></author>
+<author><nickname>anon</nickname></author>
+<book-title>Roadside Picnic</book-title><lang>ru</lang>`
+
+	path := filepath.Join(dir, "picnic.fb2")
+	if err := os.WriteFile(path, []byte(buildFB2(info, cover.Bytes())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bk, err := ParseFB2WithOptions(path, dir, Options{})
+	if err != nil {
+		t.Fatalf("ParseFB2WithOptions: %v", err)
+	}
+	if bk.Title != "Roadside Picnic" || bk.Language != "ru" {
+		t.Errorf("Title/Language: got %q / %q", bk.Title, bk.Language)
+	}
+	if len(bk.Authors) != 2 || bk.Authors[0].Name != "Arkady Strugatsky" ||
+		bk.Authors[0].SortName != "Strugatsky, Arkady" || bk.Authors[1].Name != "anon" {
+		t.Errorf("Authors: got %+v", bk.Authors)
+	}
+	if len(bk.Tags) != 2 || bk.Tags[0] != "sf" || bk.Tags[1] != "adventure" {
+		t.Errorf("Tags: got %v", bk.Tags)
+	}
+	if bk.Files[0].MIMEType != mimeFB2 {
+		t.Errorf("MIME type: got %q", bk.Files[0].MIMEType)
+	}
+	if bk.CoverURL != "/covers/"+bk.ID {
+		t.Errorf("CoverURL: got %q", bk.CoverURL)
+	}
+	if got, err := CoverPath(dir, bk.ID); err != nil || filepath.Ext(got) != ".png" {
+		t.Errorf("cached cover: got %q, %v", got, err)
+	}
+
+	// Zipped FB2, with a windows-1251 encoded title.
+	cp1251 := strings.Replace(buildFB2(`<book-title>`+"\xcf\xe8\xea\xed\xe8\xea"+`</book-title>`, cover.Bytes()),
+		`encoding="UTF-8"`, `encoding="windows-1251"`, 1)
+	path = filepath.Join(dir, "picnic.fb2.zip")
+	writeZip(t, path, map[string]string{"picnic.fb2": cp1251})
+	bk, err = ParseFB2WithOptions(path, dir, Options{InMemoryCovers: true})
+	if err != nil {
+		t.Fatalf("ParseFB2WithOptions zip: %v", err)
+	}
+	if bk.Title != "Пикник" || bk.Files[0].MIMEType != mimeFB2Zip {
+		t.Errorf("zip: got %q (%s)", bk.Title, bk.Files[0].MIMEType)
+	}
+	if bk.CoverURL == "" {
+		t.Error("zip: in-memory cover not recorded")
+	}
+	if _, err := CoverPath(dir, bk.ID); err == nil {
+		t.Error("zip: cover written despite InMemoryCovers")
+	}
+	if data, ext, err := ReadCover(path); err != nil || ext != ".png" || !bytes.Equal(data, cover.Bytes()) {
+		t.Errorf("ReadCover: got %d bytes %q, %v", len(data), ext, err)
+	}
+
+	// Malformed documents fall back to the file name.
+	path = filepath.Join(dir, "broken_book.fb2")
+	if err := os.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bk, err := ParseFB2WithOptions(path, dir, Options{}); err != nil || bk.Title != "broken_book" {
+		t.Errorf("file name fallback: got %q, %v", bk.Title, err)
+	}
+}
+
+func TestReadContents_ResolvesSpineAndResources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contents.epub")
+	writeZip(t, path, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OPS/package.opf"/></rootfiles></container>`,
+		"OPS/package.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Contents</dc:title></metadata>
+<manifest>
+  <item id="b" href="Text/chapter%202.xhtml" media-type="application/xhtml+xml"/>
+  <item id="a" href="Text/chapter1.xhtml" media-type="application/xhtml+xml"/>
+  <item id="img" href="../Images/map.jpg" media-type="image/jpeg"/>
+  <item id="evil" href="../../etc/passwd" media-type="text/plain"/>
+  <item id="font" href="Fonts/serif.otf"/>
+</manifest>
+<spine><itemref idref="a"/><itemref idref="missing"/><itemref idref="b"/><itemref idref="a"/></spine></package>`,
+	})
+
+	c, err := ReadContents(path)
+	if err != nil {
+		t.Fatalf("ReadContents: %v", err)
+	}
+	var order []string
+	for _, it := range c.ReadingOrder {
+		order = append(order, it.Path)
+	}
+	if want := []string{"OPS/Text/chapter1.xhtml", "OPS/Text/chapter 2.xhtml"}; !slices.Equal(order, want) {
+		t.Errorf("reading order = %q, want %q", order, want)
+	}
+	var resources []string
+	for _, it := range c.Resources {
+		resources = append(resources, it.Path+" "+it.MediaType)
+	}
+	if want := []string{"Images/map.jpg image/jpeg", "OPS/Fonts/serif.otf font/otf"}; !slices.Equal(resources, want) {
+		t.Errorf("resources = %q, want %q", resources, want)
+	}
+}
+
+func TestKepubify(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>T. Q.</title><style>p { x: "a>b" }</style></head>` +
+		`<body><h1>Title</h1><p>First one. Second <em>bold!</em> Third?</p><!-- a. b --><p title="x>y">Last</p></body></html>`
+	want := `<?xml version="1.0"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>T. Q.</title><style>p { x: "a>b" }</style></head>` +
+		`<body><div id="book-columns"><div id="book-inner"><h1><span class="koboSpan" id="kobo.1.1">Title</span></h1>` +
+		`<p><span class="koboSpan" id="kobo.2.1">First one. </span><span class="koboSpan" id="kobo.2.2">Second </span>` +
+		`<em><span class="koboSpan" id="kobo.2.3">bold!</span></em><span class="koboSpan" id="kobo.2.4"> Third?</span></p>` +
+		`<!-- a. b --><p title="x>y"><span class="koboSpan" id="kobo.3.1">Last</span></p></div></div></body></html>`
+	if got := string(kepubify([]byte(doc))); got != want {
+		t.Errorf("kepubify:\n got %s\nwant %s", got, want)
+	}
+	if got := string(kepubify([]byte(want))); got != want {
+		t.Errorf("kepubify of a converted document changed it:\n%s", got)
+	}
+}
+
+func TestRemoveEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	mkdir := func(parts ...string) string {
+		t.Helper()
+		dir := filepath.Join(append([]string{root}, parts...)...)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	// Fiction/Asimov empties up to Fiction, which still holds Clarke's book.
+	asimov := mkdir("Fiction", "Asimov", "Foundation")
+	mkdir("Fiction", "Clarke")
+	if err := os.WriteFile(filepath.Join(root, "Fiction", "Clarke", "a.epub"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	RemoveEmptyDirs(root, asimov)
+	if exists(filepath.Join(root, "Fiction", "Asimov")) {
+		t.Error("empty Fiction/Asimov was kept")
+	}
+	if !exists(filepath.Join(root, "Fiction", "Clarke", "a.epub")) {
+		t.Error("non-empty Fiction/Clarke was removed")
+	}
+
+	// The root is never removed, even once empty.
+	lone := mkdir("Lone")
+	if err := os.RemoveAll(filepath.Join(root, "Fiction")); err != nil {
+		t.Fatal(err)
+	}
+	RemoveEmptyDirs(root, lone)
+	if exists(lone) || !exists(root) {
+		t.Errorf("Lone removed: %v, root kept: %v; want true, true", !exists(lone), exists(root))
+	}
+
+	// Folders outside root are left alone.
+	outside := t.TempDir()
+	RemoveEmptyDirs(root, outside)
+	if !exists(outside) {
+		t.Error("folder outside root was removed")
+	}
+}
+
+func TestReadCover_CBZFirstPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comic.cbz")
+	writeZip(t, path, map[string]string{
+		"010.png":         "tenth",
+		"002.JPEG":        "second",
+		"001.txt":         "notes",
+		".hidden/000.jpg": "",
+		"._000.jpg":       "resource fork",
+	})
+	data, ext, err := ReadCover(path)
+	if err != nil {
+		t.Fatalf("ReadCover() error: %v", err)
+	}
+	if string(data) != "second" || ext != ".jpg" {
+		t.Errorf("ReadCover() = %q, %q; want the first page image as .jpg", data, ext)
+	}
+
+	bk, err := ParseComicWithOptions(path, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("ParseComicWithOptions() error: %v", err)
+	}
+	if bk.Title != "comic" || bk.Files[0].MIMEType != mimeCBZ || bk.CoverURL == "" {
+		t.Errorf("ParseComicWithOptions() = title %q, MIME %q, cover %q", bk.Title, bk.Files[0].MIMEType, bk.CoverURL)
+	}
+}
+
+func TestReadCover_CBRFirstPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comic.cbr")
+	writeRar(t, path, map[string]string{
+		"010.png":   "tenth",
+		"002.JPEG":  "second",
+		"001.txt":   "notes",
+		"._000.jpg": "resource fork",
+	})
+	data, ext, err := ReadCover(path)
+	if err != nil {
+		t.Fatalf("ReadCover() error: %v", err)
+	}
+	if string(data) != "second" || ext != ".jpg" {
+		t.Errorf("ReadCover() = %q, %q; want the first page image as .jpg", data, ext)
+	}
+
+	bk, err := ParseComicWithOptions(path, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("ParseComicWithOptions() error: %v", err)
+	}
+	if bk.Title != "comic" || bk.Files[0].MIMEType != mimeCBR || bk.CoverURL == "" {
+		t.Errorf("ParseComicWithOptions() = title %q, MIME %q, cover %q", bk.Title, bk.Files[0].MIMEType, bk.CoverURL)
+	}
+
+	// A file that is not a RAR archive cannot be opened.
+	bad := filepath.Join(t.TempDir(), "bad.cbr")
+	if err := os.WriteFile(bad, []byte("not a rar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseComicWithOptions(bad, t.TempDir(), Options{}); !errors.Is(err, ErrOpen) {
+		t.Errorf("ParseComicWithOptions(bad) error = %v, want ErrOpen", err)
+	}
+}
+
+// orientedJPEG encodes a w×h JPEG whose left half is red and right half
+// blue, with an EXIF APP1 segment recording orientation o in the given
+// byte order.
+func orientedJPEG(t *testing.T, w, h, o int, order binary.ByteOrder) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tiff bytes.Buffer
+	if order == binary.BigEndian {
+		tiff.WriteString("MM")
+	} else {
+		tiff.WriteString("II")
+	}
+	_ = binary.Write(&tiff, order, uint16(42))
+	_ = binary.Write(&tiff, order, uint32(8))
+	_ = binary.Write(&tiff, order, uint16(1))
+	_ = binary.Write(&tiff, order, uint16(exifOrientationTag))
+	_ = binary.Write(&tiff, order, uint16(3)) // SHORT
+	_ = binary.Write(&tiff, order, uint32(1))
+	_ = binary.Write(&tiff, order, uint16(o))
+	_ = binary.Write(&tiff, order, uint16(0))
+	_ = binary.Write(&tiff, order, uint32(0)) // no next IFD
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(enc.Bytes()[:2]) // SOI
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(app1)+2))
+	out.Write(app1)
+	out.Write(enc.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if got := exifOrientation(orientedJPEG(t, 4, 2, 6, order)); got != 6 {
+			t.Errorf("%v: orientation = %d, want 6", order, got)
+		}
+	}
+	var plain bytes.Buffer
+	if err := png.Encode(&plain, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if got := exifOrientation(plain.Bytes()); got != 0 {
+		t.Errorf("PNG orientation = %d, want 0", got)
+	}
+}
+
+func TestParseBook_CoverExifOrientation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sideways.epub")
+	writeZip(t, path, map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Sideways</dc:title></metadata>
+<manifest><item id="c" href="cover.jpg" media-type="image/jpeg" properties="cover-image"/></manifest></package>`,
+		"cover.jpg": string(orientedJPEG(t, 80, 40, 6, binary.LittleEndian)),
+	})
+
+	book, err := ParseBook(path, dir)
+	if err != nil {
+		t.Fatalf("ParseBook: %v", err)
+	}
+	stored, err := os.ReadFile(filepath.Join(dir, book.ID+".jpg"))
+	if err != nil {
+		t.Fatalf("cover not written: %v", err)
+	}
+	read, _, err := ReadCover(path)
+	if err != nil {
+		t.Fatalf("ReadCover: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"stored": stored, "ReadCover": read} {
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 80 {
+			t.Errorf("%s: size = %dx%d, want 40x80", name, b.Dx(), b.Dy())
+		}
+		if o := exifOrientation(data); o != 0 {
+			t.Errorf("%s: orientation %d left in the cover", name, o)
+		}
+		// Turned 90° clockwise, the red left half becomes the top half.
+		if r, _, b, _ := img.At(20, 10).RGBA(); r < b {
+			t.Errorf("%s: top half is not red (r=%d b=%d)", name, r, b)
+		}
+		if r, _, b, _ := img.At(20, 70).RGBA(); b < r {
+			t.Errorf("%s: bottom half is not blue (r=%d b=%d)", name, r, b)
+		}
+	}
+}
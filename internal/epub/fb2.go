@@ -0,0 +1,290 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// MIME types for FictionBook files, matching opds.MIMEFB2 and
+// opds.MIMEFB2Zip.
+const (
+	mimeFB2    = "application/x-fictionbook+xml"
+	mimeFB2Zip = "application/x-zip-compressed-fb2"
+)
+
+// fb2ZipExt is the double extension of zipped FictionBook files.
+const fb2ZipExt = ".fb2.zip"
+
+// maxFB2Bytes caps how much of an FB2 document is read; the cover binary
+// is the only large part the parser keeps.
+const maxFB2Bytes = 64 << 20
+
+var errNoFB2Cover = errors.New("no FB2 cover")
+
+// fb2TitleInfo is the <description><title-info> block of a FictionBook.
+type fb2TitleInfo struct {
+	Genres  []string    `xml:"genre"`
+	Authors []fb2Author `xml:"author"`
+	Title   string      `xml:"book-title"`
+	Lang    string      `xml:"lang"`
+	Cover   struct {
+		Images []struct {
+			Href string `xml:"href,attr"`
+		} `xml:"image"`
+	} `xml:"coverpage"`
+}
+
+type fb2Author struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+// name joins the author's name parts, falling back to the nickname.
+func (a fb2Author) name() string {
+	parts := strings.Fields(strings.Join([]string{a.FirstName, a.MiddleName, a.LastName}, " "))
+	if len(parts) == 0 {
+		return strings.TrimSpace(a.Nickname)
+	}
+	return strings.Join(parts, " ")
+}
+
+// sortName returns "Last, First Middle" when the last name is known.
+func (a fb2Author) sortName() string {
+	last := strings.TrimSpace(a.LastName)
+	first := strings.Join(strings.Fields(a.FirstName+" "+a.MiddleName), " ")
+	if last == "" || first == "" {
+		return ""
+	}
+	return last + ", " + first
+}
+
+// fb2Document holds what the parser keeps of a FictionBook: its title-info
+// and the cover image, if any.
+type fb2Document struct {
+	info      fb2TitleInfo
+	cover     []byte
+	coverMIME string
+}
+
+// ParseFB2WithOptions creates a Book for a FictionBook file, either plain
+// (.fb2) or zipped (.fb2.zip), reading the title, authors, genres and
+// language from its <title-info>. The coverpage image is cached in
+// coversDir like EPUB covers. An error wrapping ErrOpen is returned if the
+// file cannot be read; a malformed document falls back to a file-name
+// title, as ParsePathWithOptions does.
+func ParseFB2WithOptions(path, coversDir string, opts Options) (catalog.Book, error) {
+	book := ParsePathWithOptions(path, opts)
+	doc, err := readFB2(path)
+	if errors.Is(err, ErrOpen) {
+		return catalog.Book{}, err
+	}
+	if err != nil {
+		return book, nil
+	}
+
+	info := doc.info
+	if t := strings.TrimSpace(info.Title); t != "" {
+		book.Title = t
+	}
+	for _, a := range info.Authors {
+		if name := a.name(); name != "" {
+			author := catalog.Author{Name: name}
+			if !opts.IgnoreFileAs {
+				author.SortName = a.sortName()
+			}
+			book.Authors = append(book.Authors, author)
+		}
+	}
+	book.Tags = catalog.UniqueTags(info.Genres)
+	if len(book.Tags) == 0 {
+		book.Tags = nil
+	}
+	book.Language = strings.TrimSpace(info.Lang)
+
+	if doc.cover != nil && (opts.InMemoryCovers || writeCoverData(doc.cover, fb2CoverExt(doc.coverMIME), book.ID, coversDir)) {
+		book.CoverURL = "/covers/" + book.ID
+		book.ThumbnailURL = "/covers/" + book.ID + "/thumb"
+	}
+	return book, nil
+}
+
+// writeCoverData caches a cover image read from a book file (an FB2
+// binary, a comic page) in coversDir under the book ID with extension ext,
+// upright per its EXIF orientation, and generates its thumbnail. It reports whether the cover is available.
+func writeCoverData(data []byte, ext, bookID, coversDir string) bool {
+	destPath := filepath.Join(coversDir, bookID+ext)
+	if _, err := os.Stat(destPath); err != nil {
+		if err := writeFileAtomic(destPath, bytes.NewReader(normalizeOrientation(data))); err != nil {
+			return false
+		}
+	}
+	// A cover that cannot be decoded is still served as-is; the thumbnail
+	// endpoint falls back to it.
+	_ = ensureThumbnail(destPath, ThumbnailPath(coversDir, bookID))
+	return true
+}
+
+// readFB2Cover reads the coverpage image of the FictionBook at path. It
+// backs ReadCover for FB2 files.
+func readFB2Cover(path string) ([]byte, string, error) {
+	doc, err := readFB2(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if doc.cover == nil {
+		return nil, "", fmt.Errorf("%w in %q", errNoFB2Cover, path)
+	}
+	return doc.cover, fb2CoverExt(doc.coverMIME), nil
+}
+
+func fb2CoverExt(mimeType string) string {
+	if ext := mimeToExt(mimeType); ext != "" {
+		return ext
+	}
+	return ".jpg"
+}
+
+// readFB2 opens the FictionBook at path, unwrapping the first .fb2 entry of
+// a .fb2.zip archive, and decodes it.
+func readFB2(path string) (fb2Document, error) {
+	if FileExt(path) != fb2ZipExt {
+		f, err := os.Open(path)
+		if err != nil {
+			return fb2Document{}, fmt.Errorf("open fb2 %q: %w: %w", path, ErrOpen, err)
+		}
+		defer f.Close()
+		return decodeFB2(f)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fb2Document{}, fmt.Errorf("open fb2 %q: %w: %w", path, ErrOpen, err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if !strings.EqualFold(filepath.Ext(f.Name), ".fb2") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fb2Document{}, fmt.Errorf("open fb2 %q: %w: %w", path, ErrOpen, err)
+		}
+		defer rc.Close()
+		return decodeFB2(rc)
+	}
+	return fb2Document{}, fmt.Errorf("open fb2 %q: %w: no .fb2 entry in archive", path, ErrOpen)
+}
+
+// decodeFB2 streams a FictionBook document, decoding its <title-info> and
+// then only the <binary> referenced by the coverpage.
+func decodeFB2(r io.Reader) (fb2Document, error) {
+	var doc fb2Document
+	dec := xml.NewDecoder(io.LimitReader(r, maxFB2Bytes))
+	dec.CharsetReader = fb2CharsetReader
+	coverID := ""
+	seenInfo := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if seenInfo {
+				// Keep the metadata of a truncated or malformed body.
+				return doc, nil
+			}
+			return doc, fmt.Errorf("decode fb2: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "title-info":
+			if seenInfo {
+				continue
+			}
+			if err := dec.DecodeElement(&doc.info, &start); err != nil {
+				return doc, fmt.Errorf("decode fb2 title-info: %w", err)
+			}
+			seenInfo = true
+			if imgs := doc.info.Cover.Images; len(imgs) > 0 {
+				coverID = strings.TrimPrefix(imgs[0].Href, "#")
+			}
+		case "body":
+			if err := dec.Skip(); err != nil {
+				return doc, nil
+			}
+		case "binary":
+			if coverID == "" || xmlAttr(start, "id") != coverID {
+				if err := dec.Skip(); err != nil {
+					return doc, nil
+				}
+				continue
+			}
+			var data string
+			if err := dec.DecodeElement(&data, &start); err != nil {
+				return doc, nil
+			}
+			cover, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+			if err != nil {
+				return doc, nil
+			}
+			doc.cover = cover
+			doc.coverMIME = xmlAttr(start, "content-type")
+			return doc, nil
+		}
+	}
+	if !seenInfo {
+		return doc, fmt.Errorf("decode fb2: no title-info")
+	}
+	return doc, nil
+}
+
+// fb2CharsetReader decodes the windows-1251 encoding most Russian FB2
+// files declare; UTF-8 needs no conversion and other charsets are refused.
+func fb2CharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return input, nil
+	case "windows-1251", "cp1251":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		sb.Grow(len(data) * 2)
+		for _, c := range data {
+			switch {
+			case c < 0x80:
+				sb.WriteByte(c)
+			case c >= 0xC0:
+				sb.WriteRune(0x0410 + rune(c-0xC0)) // А..я
+			default:
+				sb.WriteRune(cp1251High[c-0x80])
+			}
+		}
+		return strings.NewReader(sb.String()), nil
+	}
+	return nil, fmt.Errorf("unsupported fb2 charset %q", charset)
+}
+
+// cp1251High maps windows-1251 bytes 0x80–0xBF to Unicode.
+var cp1251High = [64]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', '\ufffd', '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	'\u00a0', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '\u00ad', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+}
@@ -0,0 +1,232 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WriteKepub writes a Kobo "kepub" version of the EPUB at epubPath to w:
+// the text of every XHTML document of the manifest is wrapped, sentence by
+// sentence, in koboSpan elements, which Kobo readers need for highlights,
+// reading statistics and page turns. Other entries are copied unchanged
+// and the file at epubPath is left untouched.
+//
+// The archive and its package are read before anything is written, so an
+// error returned without output means the book could not be converted.
+func WriteKepub(w io.Writer, epubPath string) error {
+	zr, opfPath, pkg, err := openPackage(epubPath, Options{})
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	opfDir := path.Dir(opfPath)
+	docs := make(map[string]bool)
+	for _, it := range pkg.Manifest.Items {
+		if it.MediaType != "application/xhtml+xml" && it.MediaType != "text/html" {
+			continue
+		}
+		href, err := url.PathUnescape(it.Href)
+		if err != nil {
+			href = it.Href
+		}
+		if name, err := cleanResourcePath(path.Join(opfDir, href)); err == nil {
+			docs[name] = true
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range zr.File {
+		if !docs[f.Name] {
+			if err := zw.Copy(f); err != nil {
+				return fmt.Errorf("kepub copy %q: %w", f.Name, err)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("kepub open %q: %w", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("kepub read %q: %w", f.Name, err)
+		}
+		dw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.Name,
+			Method:   zip.Deflate,
+			Modified: f.Modified,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := dw.Write(kepubify(data)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// kepubBlocks are the elements that start a new koboSpan paragraph.
+var kepubBlocks = map[string]bool{
+	"p": true, "div": true, "li": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"td": true, "th": true, "dt": true, "dd": true, "caption": true, "figcaption": true,
+}
+
+// kepubSkip are the elements whose content is not text to wrap.
+var kepubSkip = map[string]bool{
+	"script": true, "style": true, "svg": true, "math": true,
+}
+
+// kepubify wraps the sentences of an XHTML document's body in
+// <span class="koboSpan" id="kobo.P.S"> elements, P counting paragraphs and
+// S sentences within one, and wraps the body content in the book-columns
+// and book-inner divs Kobo's renderer expects. Documents already converted
+// are returned unchanged. Markup is copied byte for byte, so documents the
+// XML parser would reject are not made any worse.
+func kepubify(doc []byte) []byte {
+	if bytes.Contains(doc, []byte("koboSpan")) {
+		return doc
+	}
+	var out bytes.Buffer
+	out.Grow(len(doc) + len(doc)/4)
+
+	inBody := false
+	skip := 0
+	para, sent := 0, 0
+	newPara := true
+
+	s := string(doc)
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			lt = len(s)
+		}
+		if text := s[:lt]; inBody && skip == 0 && strings.TrimSpace(text) != "" {
+			if newPara {
+				para, sent, newPara = para+1, 0, false
+			}
+			for _, sentence := range splitSentences(text) {
+				sent++
+				out.WriteString(`<span class="koboSpan" id="kobo.` + strconv.Itoa(para) + "." + strconv.Itoa(sent) + `">`)
+				out.WriteString(sentence)
+				out.WriteString("</span>")
+			}
+		} else {
+			out.WriteString(text)
+		}
+		s = s[lt:]
+		if s == "" {
+			break
+		}
+
+		n := markupLen(s)
+		tag := s[:n]
+		s = s[n:]
+		name, closing, selfClosing := tagName(tag)
+		switch {
+		case name == "body" && !closing:
+			out.WriteString(tag)
+			out.WriteString(`<div id="book-columns"><div id="book-inner">`)
+			inBody = !selfClosing
+			continue
+		case name == "body":
+			out.WriteString(`</div></div>`)
+			out.WriteString(tag)
+			inBody = false
+			continue
+		case kepubSkip[name] && !selfClosing:
+			if closing {
+				skip = max(skip-1, 0)
+			} else {
+				skip++
+			}
+		case kepubBlocks[name], name == "br":
+			newPara = true
+		}
+		out.WriteString(tag)
+	}
+	return out.Bytes()
+}
+
+// markupLen returns the length of the tag, comment, CDATA section or
+// processing instruction s starts with; s begins with '<'. Unterminated
+// markup runs to the end of s.
+func markupLen(s string) int {
+	for _, m := range []struct{ open, close string }{
+		{"<!--", "-->"}, {"<![CDATA[", "]]>"}, {"<?", "?>"},
+	} {
+		if strings.HasPrefix(s, m.open) {
+			if i := strings.Index(s[len(m.open):], m.close); i >= 0 {
+				return len(m.open) + i + len(m.close)
+			}
+			return len(s)
+		}
+	}
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// tagName returns the lower-cased local name of an element tag and whether
+// it is an end tag or self-closing. Comments and other markup have no name.
+func tagName(tag string) (name string, closing, selfClosing bool) {
+	t := strings.TrimPrefix(tag, "<")
+	if strings.HasPrefix(t, "/") {
+		closing = true
+		t = t[1:]
+	}
+	selfClosing = strings.HasSuffix(tag, "/>")
+	end := strings.IndexAny(t, " \t\r\n/>")
+	if end < 0 {
+		end = len(t)
+	}
+	name = strings.ToLower(t[:end])
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" || strings.ContainsAny(name[:1], "!?") {
+		return "", false, false
+	}
+	return name, closing, selfClosing
+}
+
+// splitSentences cuts text after each '.', '!' or '?' followed by
+// whitespace, keeping that whitespace with the preceding sentence.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c != '.' && c != '!' && c != '?' {
+			continue
+		}
+		j := i + 1
+		for j < len(text) && strings.IndexByte(" \t\r\n", text[j]) >= 0 {
+			j++
+		}
+		if j > i+1 && j < len(text) {
+			out = append(out, text[start:j])
+			start = j
+			i = j - 1
+		}
+	}
+	return append(out, text[start:])
+}
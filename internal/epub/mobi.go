@@ -0,0 +1,162 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// MIME types for the Kindle formats, matching opds.MIMEMobiPocket and
+// opds.MIMEAZWThree.
+const (
+	mimeMOBI = "application/x-mobipocket-ebook"
+	mimeAZW3 = "application/x-mobi8-ebook"
+)
+
+// EXTH record types read from MOBI/AZW3 headers.
+const (
+	exthAuthor      = 100
+	exthPublisher   = 101
+	exthDescription = 103
+	exthISBN        = 104
+	exthSubject     = 105
+	exthTitle       = 503
+)
+
+// maxMOBIHeader bounds how much of a file is read to find its metadata;
+// record 0 (PalmDOC + MOBI + EXTH headers) is always near the start.
+const maxMOBIHeader = 1 << 20
+
+var errNotMOBI = errors.New("not a MOBI file")
+
+// ParseMOBIWithOptions creates a Book for a .mobi or .azw3 file, reading the
+// title, authors, publisher, description, subjects and ISBN from its MOBI
+// and EXTH headers. Files whose headers cannot be read fall back to a
+// file-name title, as ParsePathWithOptions does.
+func ParseMOBIWithOptions(path string, opts Options) catalog.Book {
+	book := ParsePathWithOptions(path, opts)
+	meta, err := readMOBIMetadata(path)
+	if err != nil {
+		return book
+	}
+	if meta.title != "" {
+		book.Title = meta.title
+	}
+	for _, a := range meta.authors {
+		book.Authors = append(book.Authors, catalog.Author{Name: a})
+	}
+	book.Publisher = meta.publisher
+	book.Summary = meta.description
+	book.Tags = catalog.UniqueTags(meta.subjects)
+	if meta.isbn != "" {
+		book.Identifiers = map[string]string{"ISBN": meta.isbn}
+	}
+	return book
+}
+
+type mobiMetadata struct {
+	title       string
+	authors     []string
+	publisher   string
+	description string
+	subjects    []string
+	isbn        string
+}
+
+// readMOBIMetadata parses the Palm database header, the MOBI header in
+// record 0 and, when present, the EXTH block that follows it.
+func readMOBIMetadata(path string) (mobiMetadata, error) {
+	var meta mobiMetadata
+	f, err := os.Open(path)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMOBIHeader))
+	if err != nil {
+		return meta, err
+	}
+
+	// Palm database header: 78 bytes, then 8 bytes per record entry.
+	if len(data) < 86 {
+		return meta, errNotMOBI
+	}
+	if typ := string(data[60:68]); typ != "BOOKMOBI" {
+		return meta, errNotMOBI
+	}
+	rec0 := int(binary.BigEndian.Uint32(data[78:82]))
+
+	// Record 0: 16-byte PalmDOC header, then the MOBI header.
+	mobi := rec0 + 16
+	if mobi+0x74 > len(data) || string(data[mobi:mobi+4]) != "MOBI" {
+		return meta, errNotMOBI
+	}
+	headerLen := int(binary.BigEndian.Uint32(data[mobi+4 : mobi+8]))
+	utf8Text := binary.BigEndian.Uint32(data[mobi+12:mobi+16]) == 65001
+	decode := func(b []byte) string {
+		if utf8Text || utf8.Valid(b) {
+			return strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+		}
+		return strings.TrimSpace(latin1(bytes.TrimRight(b, "\x00")))
+	}
+
+	nameOff := rec0 + int(binary.BigEndian.Uint32(data[mobi+0x44:mobi+0x48]))
+	nameLen := int(binary.BigEndian.Uint32(data[mobi+0x48 : mobi+0x4c]))
+	if nameOff >= 0 && nameLen > 0 && nameOff+nameLen <= len(data) {
+		meta.title = decode(data[nameOff : nameOff+nameLen])
+	}
+	if meta.title == "" {
+		meta.title = decode(data[:32])
+	}
+
+	exthFlags := binary.BigEndian.Uint32(data[mobi+0x70 : mobi+0x74])
+	exth := mobi + headerLen
+	if exthFlags&0x40 == 0 || exth+12 > len(data) || string(data[exth:exth+4]) != "EXTH" {
+		return meta, nil
+	}
+	count := int(binary.BigEndian.Uint32(data[exth+8 : exth+12]))
+	pos := exth + 12
+	for i := 0; i < count && pos+8 <= len(data); i++ {
+		typ := binary.BigEndian.Uint32(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		if size < 8 || pos+size > len(data) {
+			break
+		}
+		value := decode(data[pos+8 : pos+size])
+		pos += size
+		if value == "" {
+			continue
+		}
+		switch typ {
+		case exthTitle:
+			meta.title = value
+		case exthAuthor:
+			meta.authors = append(meta.authors, value)
+		case exthPublisher:
+			meta.publisher = value
+		case exthDescription:
+			meta.description = value
+		case exthSubject:
+			meta.subjects = append(meta.subjects, value)
+		case exthISBN:
+			meta.isbn = value
+		}
+	}
+	return meta, nil
+}
+
+// latin1 decodes b as ISO-8859-1, a close enough stand-in for the CP1252
+// text encoding used by older MOBI files.
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,110 @@
+package epub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// maxPathComponent caps the length (in runes) of each generated folder or
+// file name so deep Author/Series/Title paths stay within filesystem limits.
+const maxPathComponent = 100
+
+// OrganizedPath returns where an uploaded book belongs in an organized
+// library: root/Author/Series/Title.ext, or root/Author/Title.ext when the
+// book has no series. Missing authors and titles fall back to
+// "Unknown Author" and "Untitled".
+func OrganizedPath(root string, bk catalog.Book, ext string) string {
+	author := ""
+	if len(bk.Authors) > 0 {
+		author = sanitizePathComponent(bk.Authors[0].Name)
+	}
+	if author == "" {
+		author = "Unknown Author"
+	}
+	parts := []string{root, author}
+	if series := sanitizePathComponent(bk.Series); series != "" {
+		parts = append(parts, series)
+	}
+	title := sanitizePathComponent(bk.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	return filepath.Join(append(parts, title+ext)...)
+}
+
+// UniquePath returns path if nothing exists there, otherwise the first free
+// variant with " (2)", " (3)", … inserted before the extension.
+func UniquePath(path string) string {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := path[len(path)-len(FileExt(path)):]
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// MoveInto renames src to dest, creating the folders dest needs. The move is
+// retried once should a concurrent RemoveEmptyDirs delete a freshly created
+// folder before the rename.
+func MoveInto(src, dest string) error {
+	var err error
+	for range 2 {
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("create folder: %w", err)
+		}
+		if err = os.Rename(src, dest); !os.IsNotExist(err) {
+			break
+		}
+		if _, serr := os.Lstat(src); serr != nil {
+			break // the source itself is gone
+		}
+	}
+	return err
+}
+
+// RemoveEmptyDirs removes dir if it is empty, then each parent left empty,
+// stopping below root, which is never removed, and at the first folder
+// still holding anything (hidden files included). Folders outside root are
+// left alone. Only empty folders can be removed, so a folder a concurrent
+// upload has just put a book in is kept.
+func RemoveEmptyDirs(root, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// sanitizePathComponent makes s safe as a single folder or file name:
+// path separators, characters reserved on Windows and control characters
+// become "_", and leading dots are dropped so the result is neither hidden
+// nor "." or "..".
+func sanitizePathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r), unicode.IsControl(r):
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(s), "."))
+	if r := []rune(s); len(r) > maxPathComponent {
+		s = strings.TrimSpace(string(r[:maxPathComponent]))
+	}
+	return strings.TrimRight(s, ". ")
+}
@@ -0,0 +1,134 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+)
+
+// coverQuality is the JPEG quality used when a cover is re-encoded to apply
+// its EXIF orientation.
+const coverQuality = 90
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation.
+const exifOrientationTag = 0x0112
+
+// normalizeOrientation returns data with the EXIF orientation of a JPEG
+// cover applied: the image is rotated or flipped upright and re-encoded
+// without EXIF. Covers that are not JPEG, carry no orientation or are
+// already upright are returned unchanged, as are images that fail to decode.
+func normalizeOrientation(data []byte) []byte {
+	o := exifOrientation(data)
+	if o < 2 || o > 8 {
+		return data
+	}
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, orient(src, o), &jpeg.Options{Quality: coverQuality}); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// orient returns src transformed as EXIF orientation o prescribes for
+// display: 2 to 4 flip or turn it half-way, 5 to 8 swap its width and
+// height.
+func orient(src image.Image, o int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // turned 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // turned 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the orientation (1 to 8) recorded in the EXIF
+// APP1 segment of a JPEG, or 0 if data is not a JPEG or has none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return 0
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return 0
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + n
+	}
+	return 0
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF
+// structure embedded in an EXIF segment.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < count; k++ {
+		e := ifd + 2 + 12*k
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:]) == exifOrientationTag {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,196 @@
+package epub
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrResourceNotFound is returned by OpenResource when the archive has no
+// entry with the requested name.
+var ErrResourceNotFound = errors.New("resource not found in epub")
+
+// ErrInvalidResourcePath is returned by OpenResource for names that are
+// absolute or escape the archive root (e.g. "../secret").
+var ErrInvalidResourcePath = errors.New("invalid resource path")
+
+// Resource is an open entry inside an EPUB archive. Closing it also closes
+// the underlying archive.
+type Resource struct {
+	io.Reader
+	// Size is the uncompressed size in bytes.
+	Size int64
+	// ContentType is derived from the entry's extension.
+	ContentType string
+
+	rc io.Closer
+	zr *zip.ReadCloser
+}
+
+// Close releases the entry and the archive.
+func (r *Resource) Close() error {
+	err := r.rc.Close()
+	if zerr := r.zr.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
+
+// OpenResource opens the entry called name inside the EPUB at epubPath,
+// e.g. "OEBPS/chapter1.xhtml". The name is matched exactly after cleaning;
+// names that would resolve outside the archive root are rejected.
+func OpenResource(epubPath, name string) (*Resource, error) {
+	clean, err := cleanResourcePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("open epub %q: %w: %w", epubPath, ErrOpen, err)
+	}
+	for _, f := range zr.File {
+		if f.Name != clean {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("open %q in epub: %w", clean, err)
+		}
+		return &Resource{
+			Reader:      rc,
+			Size:        int64(f.UncompressedSize64),
+			ContentType: resourceContentType(clean),
+			rc:          rc,
+			zr:          zr,
+		}, nil
+	}
+	zr.Close()
+	return nil, ErrResourceNotFound
+}
+
+// ContentItem is an entry of an EPUB's OPF manifest.
+type ContentItem struct {
+	// Path is the entry name inside the archive, as accepted by
+	// OpenResource (e.g. "OEBPS/chapter1.xhtml").
+	Path string
+	// MediaType is the manifest media-type, or one derived from the
+	// extension when the manifest omits it.
+	MediaType string
+	// Properties are the EPUB 3 manifest properties, e.g. "cover-image nav".
+	Properties []string
+}
+
+// Contents is the structure of an EPUB: the documents of its spine in
+// reading order and every other manifest entry (styles, images, fonts, …).
+type Contents struct {
+	ReadingOrder []ContentItem
+	Resources    []ContentItem
+}
+
+// ReadContents reads the spine and manifest of the EPUB at epubPath. Spine
+// references to unknown manifest ids and entries whose href escapes the
+// archive root are skipped.
+func ReadContents(epubPath string) (Contents, error) {
+	zr, opfPath, pkg, err := openPackage(epubPath, Options{})
+	if err != nil {
+		return Contents{}, err
+	}
+	defer zr.Close()
+
+	opfDir := path.Dir(opfPath)
+	items := make(map[string]ContentItem, len(pkg.Manifest.Items))
+	for _, it := range pkg.Manifest.Items {
+		href, err := url.PathUnescape(it.Href)
+		if err != nil {
+			href = it.Href
+		}
+		name, err := cleanResourcePath(path.Join(opfDir, href))
+		if err != nil {
+			continue
+		}
+		mediaType := it.MediaType
+		if mediaType == "" {
+			mediaType = resourceContentType(name)
+		}
+		items[it.ID] = ContentItem{Path: name, MediaType: mediaType, Properties: strings.Fields(it.Properties)}
+	}
+
+	var c Contents
+	inSpine := make(map[string]bool, len(pkg.Spine.ItemRefs))
+	for _, ref := range pkg.Spine.ItemRefs {
+		it, ok := items[ref.IDRef]
+		if !ok || inSpine[ref.IDRef] {
+			continue
+		}
+		inSpine[ref.IDRef] = true
+		c.ReadingOrder = append(c.ReadingOrder, it)
+	}
+	// Manifest order, not map order, for the remaining resources.
+	for _, it := range pkg.Manifest.Items {
+		if item, ok := items[it.ID]; ok && !inSpine[it.ID] {
+			c.Resources = append(c.Resources, item)
+		}
+	}
+	return c, nil
+}
+
+// cleanResourcePath normalises a zip entry name and rejects traversal.
+func cleanResourcePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", ErrInvalidResourcePath
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", ErrInvalidResourcePath
+	}
+	return clean, nil
+}
+
+// resourceContentType returns the media type for an EPUB entry name.
+// Common EPUB types are handled explicitly since the system MIME table
+// may lack them.
+func resourceContentType(name string) string {
+	switch ext := strings.ToLower(path.Ext(name)); ext {
+	case ".xhtml", ".xht":
+		return "application/xhtml+xml"
+	case ".html", ".htm":
+		return "text/html; charset=utf-8"
+	case ".css":
+		return "text/css; charset=utf-8"
+	case ".opf":
+		return "application/oebps-package+xml"
+	case ".ncx":
+		return "application/x-dtbncx+xml"
+	case ".svg":
+		return "image/svg+xml"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".otf":
+		return "font/otf"
+	case ".ttf":
+		return "font/ttf"
+	case ".woff":
+		return "font/woff"
+	case ".woff2":
+		return "font/woff2"
+	default:
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+}
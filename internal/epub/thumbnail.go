@@ -0,0 +1,100 @@
+package epub
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	_ "image/png" // register PNG decoder
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// ThumbnailWidth is the maximum width in pixels of generated cover
+// thumbnails. Narrower covers keep their size.
+const ThumbnailWidth = 300
+
+// thumbnailQuality is the JPEG quality used for thumbnails.
+const thumbnailQuality = 80
+
+// ThumbnailPath returns the filesystem path of the cached thumbnail for a
+// book ID. The file may not exist.
+func ThumbnailPath(coversDir, id string) string {
+	return filepath.Join(coversDir, id+".thumb.jpg")
+}
+
+// Thumbnail returns the path to the thumbnail of the book's cover, generating
+// it first when it is missing or older than the cover. Returns an error if
+// the book has no cover or the cover cannot be decoded.
+func Thumbnail(coversDir, id string) (string, error) {
+	cover, err := CoverPath(coversDir, id)
+	if err != nil {
+		return "", err
+	}
+	thumb := ThumbnailPath(coversDir, id)
+	if err := ensureThumbnail(cover, thumb); err != nil {
+		return "", err
+	}
+	return thumb, nil
+}
+
+// ensureThumbnail writes the thumbnail of cover to thumb unless thumb already
+// exists and is at least as recent as cover.
+func ensureThumbnail(cover, thumb string) error {
+	coverInfo, err := os.Stat(cover)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(thumb); err == nil && !info.ModTime().Before(coverInfo.ModTime()) {
+		return nil
+	}
+	return writeThumbnail(cover, thumb)
+}
+
+// writeThumbnail decodes the image at cover, scales it down to at most
+// ThumbnailWidth pixels wide and writes it to thumb as JPEG. Transparent
+// areas are flattened onto white.
+func writeThumbnail(cover, thumb string) error {
+	in, err := os.Open(cover)
+	if err != nil {
+		return err
+	}
+	src, _, err := image.Decode(in)
+	in.Close()
+	if err != nil {
+		return fmt.Errorf("decode cover: %w", err)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return fmt.Errorf("decode cover: empty image")
+	}
+	if w > ThumbnailWidth {
+		h = max(1, h*ThumbnailWidth/w)
+		w = ThumbnailWidth
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	tmp, err := os.CreateTemp(filepath.Dir(thumb), ".thumb-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create thumbnail: %w", err)
+	}
+	werr := jpeg.Encode(tmp, dst, &jpeg.Options{Quality: thumbnailQuality})
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write thumbnail: %w", errors.Join(werr, cerr))
+	}
+	if err := os.Rename(tmp.Name(), thumb); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write thumbnail: %w", err)
+	}
+	return nil
+}
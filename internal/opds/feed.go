@@ -17,6 +17,7 @@ const (
 	NSDC         = "http://purl.org/dc/terms/"
 	NSDCElements = "http://purl.org/dc/elements/1.1/"
 	NSCalibre    = "http://calibre.kovidgoyal.net/2009/metadata"
+	NSThread     = "http://purl.org/syndication/thread/1.0"
 
 	// OPDS relation types
 	RelAcquisition         = "http://opds-spec.org/acquisition"
@@ -36,6 +37,7 @@ const (
 	RelLast                = "last"
 	RelNext                = "next"
 	RelPrevious            = "previous"
+	RelFacet               = "http://opds-spec.org/facet"
 
 	// MIME types
 	MIMEAtomFeed         = "application/atom+xml"
@@ -44,11 +46,14 @@ const (
 	MIMEAcquisitionFeed  = "application/atom+xml;profile=opds-catalog;kind=acquisition"
 	MIMEOpenSearchDesc   = "application/opensearchdescription+xml"
 	MIMEEPub             = "application/epub+zip"
+	MIMEKEPub            = "application/kepub+zip"
 	MIMEPdf              = "application/pdf"
 	MIMEMobiPocket       = "application/x-mobipocket-ebook"
 	MIMEAZWThree         = "application/x-mobi8-ebook"
 	MIMECBZ              = "application/x-cbz"
 	MIMECBR              = "application/x-cbr"
+	MIMEFB2              = "application/x-fictionbook+xml"
+	MIMEFB2Zip           = "application/x-zip-compressed-fb2"
 )
 
 // Feed represents an OPDS Atom feed (navigation or acquisition).
@@ -57,6 +62,8 @@ type Feed struct {
 	Xmlns        string   `xml:"xmlns,attr"`
 	XmlnsOS      string   `xml:"xmlns:os,attr,omitempty"`
 	XmlnsCalibre string   `xml:"xmlns:calibre,attr,omitempty"`
+	XmlnsOPDS    string   `xml:"xmlns:opds,attr,omitempty"`
+	XmlnsThr     string   `xml:"xmlns:thr,attr,omitempty"`
 
 	ID      string  `xml:"id"`
 	Title   Text    `xml:"title"`
@@ -132,6 +139,12 @@ type Link struct {
 	Type     string `xml:"type,attr,omitempty"`
 	Title    string `xml:"title,attr,omitempty"`
 	Count    int    `xml:"count,attr,omitempty"`
+	Length   int64  `xml:"length,attr,omitempty"` // size in bytes of the linked resource
+
+	// Facet attributes, declared by AddFacet (opds: and thr: prefixes).
+	FacetGroup  string `xml:"opds:facetGroup,attr,omitempty"`
+	ActiveFacet bool   `xml:"opds:activeFacet,attr,omitempty"`
+	ThrCount    int    `xml:"thr:count,attr,omitempty"`
 }
 
 // Entry represents a single entry in an OPDS feed.
@@ -153,6 +166,7 @@ type Entry struct {
 	// Calibre series extensions (widely supported by OPDS clients)
 	CalSeries      string `xml:"http://calibre.kovidgoyal.net/2009/metadata series,omitempty"`
 	CalSeriesIndex string `xml:"http://calibre.kovidgoyal.net/2009/metadata series_index,omitempty"`
+	CalRating      string `xml:"http://calibre.kovidgoyal.net/2009/metadata rating,omitempty"`
 
 	Links []Link `xml:"link"`
 }
@@ -168,6 +182,25 @@ func (f *Feed) AddLink(rel, href, mimeType string) {
 	f.Links = append(f.Links, Link{Rel: rel, Href: href, Type: mimeType})
 }
 
+// AddFacet appends an OPDS facet link belonging to group, e.g. "Language".
+// count is the number of entries behind the facet (0 omits it); active marks
+// the facet currently applied to the feed.
+func (f *Feed) AddFacet(group, title, href, mimeType string, count int, active bool) {
+	f.XmlnsOPDS = NSOPDS
+	if count > 0 {
+		f.XmlnsThr = NSThread
+	}
+	f.Links = append(f.Links, Link{
+		Rel:         RelFacet,
+		Href:        href,
+		Type:        mimeType,
+		Title:       title,
+		FacetGroup:  group,
+		ActiveFacet: active,
+		ThrCount:    count,
+	})
+}
+
 // AddEntry appends an entry to the feed.
 func (f *Feed) AddEntry(e Entry) {
 	f.Entries = append(f.Entries, e)
@@ -0,0 +1,146 @@
+package opds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// Issue is a problem found by Validate in an OPDS feed document.
+type Issue struct {
+	// Path locates the offending element, e.g. "feed/entry[2]/link[0]".
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return i.Path + ": " + i.Message
+}
+
+// navigationRels are the link relations that point to another OPDS feed and
+// so must carry an Atom MIME type.
+var navigationRels = map[string]bool{
+	RelSelf:              true,
+	RelStart:             true,
+	RelFirst:             true,
+	RelLast:              true,
+	RelNext:              true,
+	RelPrevious:          true,
+	"up":                 true,
+	RelCatalogNavigation: true,
+	RelCatalogNew:        true,
+	RelCatalogPopular:    true,
+}
+
+// Validate parses an OPDS 1.x feed document and checks its basic structure:
+// the Atom elements required on the feed and on every entry, the self and
+// start links, and the MIME types expected for navigation, search,
+// acquisition and image links. It is a sanity check, not a full schema
+// validation. It returns nil when no issue is found.
+func Validate(data []byte) []Issue {
+	var feed Feed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return []Issue{{Path: "feed", Message: fmt.Sprintf("not a well-formed feed: %v", err)}}
+	}
+
+	var issues []Issue
+	report := func(path, format string, args ...any) {
+		issues = append(issues, Issue{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if feed.XMLName.Space != NSAtom {
+		report("feed", "root element is not in the Atom namespace %s", NSAtom)
+	}
+	if feed.ID == "" {
+		report("feed", "missing required <id>")
+	}
+	if strings.TrimSpace(feed.Title.Value) == "" {
+		report("feed", "missing required <title>")
+	}
+	if feed.Updated.Time.IsZero() {
+		report("feed", "missing required <updated>")
+	}
+	for _, rel := range []string{RelSelf, RelStart} {
+		if !hasRel(feed.Links, rel) {
+			report("feed", "missing link rel=%q", rel)
+		}
+	}
+	for i, l := range feed.Links {
+		validateLink(fmt.Sprintf("feed/link[%d]", i), l, report)
+	}
+
+	ids := make(map[string]int, len(feed.Entries))
+	for i, e := range feed.Entries {
+		path := fmt.Sprintf("feed/entry[%d]", i)
+		if e.ID == "" {
+			report(path, "missing required <id>")
+		} else if j, dup := ids[e.ID]; dup {
+			report(path, "duplicate id %q (also on entry[%d])", e.ID, j)
+		} else {
+			ids[e.ID] = i
+		}
+		if strings.TrimSpace(e.Title.Value) == "" {
+			report(path, "missing required <title>")
+		}
+		if e.Updated.Time.IsZero() {
+			report(path, "missing required <updated>")
+		}
+		if len(e.Links) == 0 {
+			report(path, "entry has no link to a feed or publication")
+		}
+		for j, l := range e.Links {
+			validateLink(fmt.Sprintf("%s/link[%d]", path, j), l, report)
+		}
+	}
+	return issues
+}
+
+// validateLink checks the href and MIME type of a single link.
+func validateLink(path string, l Link, report func(path, format string, args ...any)) {
+	if l.Href == "" {
+		report(path, "link rel=%q has no href", l.Rel)
+	}
+	mediaType := ""
+	if l.Type != "" {
+		mt, _, err := mime.ParseMediaType(l.Type)
+		if err != nil {
+			report(path, "invalid MIME type %q", l.Type)
+			return
+		}
+		mediaType = mt
+	}
+
+	switch {
+	case navigationRels[l.Rel]:
+		if mediaType != MIMEAtomFeed {
+			report(path, "link rel=%q must have an Atom feed type, got %q", l.Rel, l.Type)
+		}
+	case l.Rel == RelSearch:
+		if mediaType != MIMEOpenSearchDesc && mediaType != MIMEAtomFeed {
+			report(path, "search link must have an OpenSearch or Atom type, got %q", l.Type)
+		}
+	case strings.HasPrefix(l.Rel, RelAcquisition):
+		if mediaType == "" {
+			report(path, "acquisition link has no type")
+		}
+	case l.Rel == RelCover || l.Rel == RelThumbnail:
+		if !strings.HasPrefix(mediaType, "image/") {
+			report(path, "image link must have an image type, got %q", l.Type)
+		}
+	case l.Rel == RelFacet:
+		if l.Title == "" {
+			report(path, "facet link has no title")
+		}
+	}
+}
+
+// hasRel reports whether links contain one with the given relation.
+func hasRel(links []Link, rel string) bool {
+	for _, l := range links {
+		if l.Rel == rel {
+			return true
+		}
+	}
+	return false
+}
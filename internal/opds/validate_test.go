@@ -0,0 +1,80 @@
+package opds_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/opds"
+)
+
+func validAcquisitionFeed() *opds.Feed {
+	feed := opds.NewAcquisitionFeed("urn:test:books", "All Books")
+	feed.AddLink(opds.RelSelf, "/opds/books", opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, "/opds", opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelSearch, "/opds/opensearch.xml", opds.MIMEOpenSearchDesc)
+	feed.AddFacet("Language", "English", "/opds/books?language=en", opds.MIMEAcquisitionFeed, 3, false)
+	feed.AddEntry(opds.Entry{
+		ID:      "urn:test:book:1",
+		Title:   opds.Text{Value: "A Book"},
+		Updated: opds.AtomDate{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Links: []opds.Link{
+			{Rel: opds.RelAcquisition, Href: "/opds/books/1/download", Type: opds.MIMEEPub},
+			{Rel: opds.RelCover, Href: "/covers/1", Type: "image/jpeg"},
+		},
+	})
+	return feed
+}
+
+func validate(t *testing.T, feed *opds.Feed) []opds.Issue {
+	t.Helper()
+	data, err := feed.MarshalToXML()
+	if err != nil {
+		t.Fatalf("MarshalToXML failed: %v", err)
+	}
+	return opds.Validate(data)
+}
+
+func TestValidate_WellFormedFeedPasses(t *testing.T) {
+	if issues := validate(t, validAcquisitionFeed()); len(issues) != 0 {
+		t.Errorf("expected no issues, got %v", issues)
+	}
+}
+
+func TestValidate_ReportsIssues(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(f *opds.Feed)
+		want   string
+	}{
+		{"feed id", func(f *opds.Feed) { f.ID = "" }, "feed: missing required <id>"},
+		{"entry title", func(f *opds.Feed) { f.Entries[0].Title.Value = "" }, "feed/entry[0]: missing required <title>"},
+		{"self link", func(f *opds.Feed) { f.Links = f.Links[1:] }, `feed: missing link rel="self"`},
+		{"start type", func(f *opds.Feed) { f.Links[1].Type = "text/html" }, `feed/link[1]: link rel="start" must have an Atom feed type`},
+		{"acquisition type", func(f *opds.Feed) { f.Entries[0].Links[0].Type = "" }, "feed/entry[0]/link[0]: acquisition link has no type"},
+		{"cover type", func(f *opds.Feed) { f.Entries[0].Links[1].Type = "application/pdf" }, "feed/entry[0]/link[1]: image link must have an image type"},
+		{"duplicate id", func(f *opds.Feed) { f.AddEntry(f.Entries[0]) }, `feed/entry[1]: duplicate id "urn:test:book:1"`},
+	}
+	for _, tc := range tests {
+		feed := validAcquisitionFeed()
+		tc.mutate(feed)
+		issues := validate(t, feed)
+		found := false
+		for _, is := range issues {
+			found = found || strings.HasPrefix(is.String(), tc.want)
+		}
+		if !found {
+			t.Errorf("%s: issues %v do not include %q", tc.name, issues, tc.want)
+		}
+	}
+}
+
+func TestValidate_NotAFeed(t *testing.T) {
+	issues := opds.Validate([]byte("<html><body>oops</body></html>"))
+	if len(issues) == 0 {
+		t.Fatal("expected issues for a non-feed document")
+	}
+	if issues := opds.Validate([]byte("<feed")); len(issues) != 1 || !strings.Contains(issues[0].Message, "well-formed") {
+		t.Errorf("truncated document: got %v", issues)
+	}
+}
@@ -18,12 +18,52 @@ type Feed struct {
 	Links        []Link        `json:"links"`
 	Navigation   []NavItem     `json:"navigation,omitempty"`
 	Publications []Publication `json:"publications,omitempty"`
+	Facets       []Facet       `json:"facets,omitempty"`
+	Groups       []Group       `json:"groups,omitempty"`
+}
+
+// Facet is a named set of links that filter or reorder the feed, e.g. by
+// language. The link of the active choice has rel "self".
+type Facet struct {
+	Metadata FeedMetadata `json:"metadata"`
+	Links    []Link       `json:"links"`
+}
+
+// Group is a titled section of a feed with its own navigation or
+// publications, typically a preview of a sub-feed its "self" link points to.
+type Group struct {
+	Metadata     FeedMetadata  `json:"metadata"`
+	Links        []Link        `json:"links,omitempty"`
+	Navigation   []NavItem     `json:"navigation,omitempty"`
+	Publications []Publication `json:"publications,omitempty"`
+}
+
+// AddFacet appends a link to the facet titled group, creating the facet on
+// first use. count, if positive, is the number of publications the link
+// leads to; active marks the choice the feed currently shows.
+func (f *Feed) AddFacet(group, title, href string, count int, active bool) {
+	l := Link{Href: href, Type: MIMEFeed, Title: title}
+	if active {
+		l.Rel = "self"
+	}
+	if count > 0 {
+		l.Properties = &LinkProperties{NumberOfItems: count}
+	}
+	for i := range f.Facets {
+		if f.Facets[i].Metadata.Title == group {
+			f.Facets[i].Links = append(f.Facets[i].Links, l)
+			return
+		}
+	}
+	f.Facets = append(f.Facets, Facet{Metadata: FeedMetadata{Title: group}, Links: []Link{l}})
 }
 
 // FeedMetadata holds top-level metadata for a feed.
 type FeedMetadata struct {
 	Title         string `json:"title"`
 	NumberOfItems int    `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
 }
 
 // Link represents a link in the feed or in a publication.
@@ -33,6 +73,15 @@ type Link struct {
 	Type      string      `json:"type,omitempty"`
 	Title     string      `json:"title,omitempty"`
 	Templated bool        `json:"templated,omitempty"`
+	Length    int64       `json:"length,omitempty"` // size in bytes
+
+	Properties *LinkProperties `json:"properties,omitempty"`
+}
+
+// LinkProperties holds the properties of a link, such as the number of
+// publications a facet link leads to.
+type LinkProperties struct {
+	NumberOfItems int `json:"numberOfItems,omitempty"`
 }
 
 // NavItem is a navigation entry in a navigation feed.
@@ -63,6 +112,7 @@ type PubMetadata struct {
 	Modified    string        `json:"modified,omitempty"`
 	Published   string        `json:"published,omitempty"`
 	BelongsTo   *BelongsTo    `json:"belongsTo,omitempty"`
+	Rating      float64       `json:"rating,omitempty"`
 }
 
 // Contributor represents an author or other contributor.
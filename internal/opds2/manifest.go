@@ -0,0 +1,19 @@
+package opds2
+
+// Readium Web Publication Manifest
+// (https://readium.org/webpub-manifest/), the per-book document OPDS 2.0
+// publications are modelled on.
+const (
+	MIMEManifest    = "application/webpub+json"
+	ManifestContext = "https://readium.org/webpub-manifest/context.jsonld"
+)
+
+// Manifest describes a single publication for Readium-based readers: its
+// metadata, the documents to read in order and the resources they use.
+type Manifest struct {
+	Context      string      `json:"@context"`
+	Metadata     PubMetadata `json:"metadata"`
+	Links        []Link      `json:"links"`
+	ReadingOrder []Link      `json:"readingOrder"`
+	Resources    []Link      `json:"resources,omitempty"`
+}
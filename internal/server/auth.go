@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
@@ -8,54 +9,211 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	sessionCookieName = "nxt_session"
-	sessionDuration   = 30 * 24 * time.Hour // 30 days
+	sessionCookieName      = "nxt_session"
+	defaultSessionDuration = 30 * 24 * time.Hour // 30 days
 )
 
+// User is a named account of a multi-user server.
+type User struct {
+	Name string
+	// PasswordHash is the bcrypt hash of the user's password.
+	PasswordHash string
+}
+
+// authenticator checks login credentials against the shared password and
+// the named users. Either may be empty; with both empty auth is disabled.
+type authenticator struct {
+	password string
+	users    map[string][]byte // user name -> bcrypt hash
+	dummy    []byte            // bcrypt hash checked for unknown names; nil without users
+}
+
+func newAuthenticator(password string, users []User) *authenticator {
+	a := &authenticator{password: password, users: make(map[string][]byte, len(users))}
+	cost := bcrypt.MinCost
+	for _, u := range users {
+		a.users[u.Name] = []byte(u.PasswordHash)
+		if c, err := bcrypt.Cost([]byte(u.PasswordHash)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	if len(users) > 0 {
+		// Same cost as the slowest user, so an unknown name takes as long
+		// to reject as a wrong password.
+		a.dummy, _ = bcrypt.GenerateFromPassword([]byte("nxt-opds"), cost)
+	}
+	return a
+}
+
+// enabled reports whether any credential is configured.
+func (a *authenticator) enabled() bool {
+	return a.password != "" || len(a.users) > 0
+}
+
+// check validates a user name and password. A known user name must come
+// with that user's password; otherwise the shared password is accepted
+// whatever the name. It returns the authenticated user name, "" for the
+// shared password. Unknown names are checked against a dummy hash so the
+// response time does not reveal which user names exist.
+func (a *authenticator) check(name, password string) (string, bool) {
+	if hash, ok := a.users[name]; ok {
+		if bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return name, true
+		}
+		return "", false
+	}
+	if a.dummy != nil {
+		_ = bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+	}
+	if a.password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1 {
+		return "", true
+	}
+	return "", false
+}
+
+// userKey is the request context key of the authenticated user name.
+type userKey struct{}
+
+// requestUser returns the name of the user who made the request, or ""
+// for the shared password, token access or a server without auth.
+func requestUser(r *http.Request) string {
+	name, _ := r.Context().Value(userKey{}).(string)
+	return name
+}
+
 // sessionStore holds active session tokens in memory.
-// For a personal single-user server this is perfectly sufficient.
+// For a personal or family server this is perfectly sufficient.
 type sessionStore struct {
-	mu      sync.RWMutex
-	tokens  map[string]time.Time // token -> expiry
+	mu       sync.RWMutex
+	tokens   map[string]session
+	duration time.Duration    // lifetime of regular sessions
+	now      func() time.Time // replaceable in tests
+}
+
+// session is the state kept for one token. Short-lived sessions (idle > 0)
+// expire after idle of inactivity: every authenticated request pushes the
+// expiry back by idle. Regular sessions last a fixed sessionStore.duration.
+type session struct {
+	user   string // owner of the token; "" for the shared password
+	expiry time.Time
+	idle   time.Duration
+}
+
+// newSessionStore returns an empty store whose regular sessions last
+// duration, or defaultSessionDuration when it is not positive.
+func newSessionStore(duration time.Duration) *sessionStore {
+	if duration <= 0 {
+		duration = defaultSessionDuration
+	}
+	return &sessionStore{tokens: make(map[string]session), duration: duration, now: time.Now}
+}
+
+// create generates a new random session token for user, stores it, and
+// returns it.
+func (s *sessionStore) create(user string) (string, error) {
+	return s.add(session{user: user, expiry: s.now().Add(s.duration)})
 }
 
-func newSessionStore() *sessionStore {
-	return &sessionStore{tokens: make(map[string]time.Time)}
+// createShortLived creates a session for user that expires after idle of
+// inactivity, for shared devices such as library kiosks.
+func (s *sessionStore) createShortLived(user string, idle time.Duration) (string, error) {
+	return s.add(session{user: user, expiry: s.now().Add(idle), idle: idle})
 }
 
-// create generates a new random session token, stores it, and returns it.
-func (s *sessionStore) create() (string, error) {
+func (s *sessionStore) add(sess session) (string, error) {
 	buf := make([]byte, 32)
 	if _, err := rand.Read(buf); err != nil {
 		return "", err
 	}
 	token := hex.EncodeToString(buf)
-	expiry := time.Now().Add(sessionDuration)
 
 	s.mu.Lock()
-	s.tokens[token] = expiry
+	s.tokens[token] = sess
 	s.mu.Unlock()
 	return token, nil
 }
 
-// valid returns true if token exists and has not expired.
+// valid returns true if token exists and has not expired. A valid
+// short-lived session is kept alive for another idle period.
 func (s *sessionStore) valid(token string) bool {
-	s.mu.RLock()
-	exp, ok := s.tokens[token]
-	s.mu.RUnlock()
+	_, ok := s.lookup(token)
+	return ok
+}
+
+// lookup is valid that also returns the user who owns the token.
+func (s *sessionStore) lookup(token string) (user string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.tokens[token]
+	if !ok {
+		return "", false
+	}
+	now := s.now()
+	if now.After(sess.expiry) {
+		delete(s.tokens, token)
+		return "", false
+	}
+	if sess.idle > 0 {
+		sess.expiry = now.Add(sess.idle)
+		s.tokens[token] = sess
+	}
+	return sess.user, true
+}
+
+// remaining reports the user owning token, how long it stays valid without
+// further activity, and its idle timeout (0 for regular sessions). Unlike valid it does not
+// extend the session, so polling it cannot keep an idle session alive.
+func (s *sessionStore) remaining(token string) (user string, left, idle time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.tokens[token]
 	if !ok {
-		return false
+		return "", 0, 0, false
 	}
-	if time.Now().After(exp) {
-		s.mu.Lock()
+	left = sess.expiry.Sub(s.now())
+	if left <= 0 {
 		delete(s.tokens, token)
-		s.mu.Unlock()
-		return false
+		return "", 0, 0, false
+	}
+	return sess.user, left, sess.idle, true
+}
+
+// sweep removes every expired token. Expired tokens are otherwise only
+// dropped when presented again.
+func (s *sessionStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for token, sess := range s.tokens {
+		if now.After(sess.expiry) {
+			delete(s.tokens, token)
+		}
 	}
-	return true
+}
+
+// startJanitor sweeps expired tokens every interval in a new goroutine
+// until the returned stop function is called.
+func (s *sessionStore) startJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
 }
 
 // delete removes a session token (logout).
@@ -70,28 +228,39 @@ func (s *sessionStore) delete(token string) {
 // Authentication methods (in order of precedence):
 //  1. Session cookie (browser users after login).
 //  2. OPDS token via ?token= query parameter (for OPDS reader clients on OPDS routes).
-//  3. HTTP Basic Auth fallback (kept for API clients; only when no opdsToken is set).
+//  3. OPDS token via "Authorization: Bearer <token>" header (for API integrations; any route).
+//  4. HTTP Basic Auth fallback (kept for API clients; only when no opdsToken is set).
 //
-// If password is empty, auth is disabled (development mode).
+// Session cookies and Basic Auth identify a user, available to handlers
+// through requestUser.
+// If auth has no credentials configured, auth is disabled (development mode).
 // opdsToken is the shared token for OPDS feed access; empty means token auth disabled.
-func authMiddleware(password, opdsToken string, sessions *sessionStore) func(http.Handler) http.Handler {
+// loginURL is where unauthenticated browsers are sent.
+func authMiddleware(auth *authenticator, opdsToken string, sessions *sessionStore, loginURL string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		if password == "" {
+		if !auth.enabled() {
 			return next
 		}
+		asUser := func(r *http.Request, user string) *http.Request {
+			if user == "" {
+				return r
+			}
+			return r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+		}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// 1. Check session cookie
 			if c, err := r.Cookie(sessionCookieName); err == nil {
-				if sessions.valid(c.Value) {
-					next.ServeHTTP(w, r)
+				if user, ok := sessions.lookup(c.Value); ok {
+					next.ServeHTTP(w, asUser(r, user))
 					return
 				}
 			}
 
-			// 2. Token auth: accepted on OPDS routes via ?token= query param.
+			// 2. Token auth: accepted on OPDS routes (and the borrow links
+			//    of lending feeds) via ?token= query param.
 			isOPDS := strings.HasPrefix(r.URL.Path, "/opds/") ||
 				r.URL.Path == "/opds" || r.URL.Path == "/opds/"
-			if isOPDS && opdsToken != "" {
+			if (isOPDS || isBorrowPath(r.URL.Path)) && opdsToken != "" {
 				if tok := r.URL.Query().Get("token"); tok != "" {
 					if subtle.ConstantTimeCompare([]byte(tok), []byte(opdsToken)) == 1 {
 						next.ServeHTTP(w, r)
@@ -100,32 +269,57 @@ func authMiddleware(password, opdsToken string, sessions *sessionStore) func(htt
 				}
 			}
 
-			// 3. Fallback: HTTP Basic Auth (for API clients and legacy OPDS readers
+			// 3. Bearer token: accepted on every protected route.
+			if opdsToken != "" {
+				if tok, ok := bearerToken(r); ok {
+					if subtle.ConstantTimeCompare([]byte(tok), []byte(opdsToken)) == 1 {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			// 4. Fallback: HTTP Basic Auth (for API clients and legacy OPDS readers
 			//    when no opdsToken is configured).
 			if opdsToken == "" {
-				if _, pass, ok := r.BasicAuth(); ok {
-					if subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1 {
-						next.ServeHTTP(w, r)
+				if name, pass, ok := r.BasicAuth(); ok {
+					if user, ok := auth.check(name, pass); ok {
+						next.ServeHTTP(w, asUser(r, user))
 						return
 					}
 				}
 			}
 
-			// 4. Not authenticated – redirect browser requests to /login,
+			// 5. Not authenticated – redirect browser requests to /login,
 			//    return 401 for API / OPDS requests.
 			accept := r.Header.Get("Accept")
 			isAPI := strings.HasPrefix(r.URL.Path, "/api/") || isOPDS
 			if !isAPI && (accept == "" || containsHTML(accept)) {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				http.Redirect(w, r, loginURL, http.StatusSeeOther)
 				return
 			}
 
 			w.Header().Set("WWW-Authenticate", `Bearer realm="nxt-opds"`)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 		})
 	}
 }
 
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header. The scheme is matched case-insensitively (RFC 7235).
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
 // containsHTML reports whether an Accept header value includes text/html.
 func containsHTML(accept string) bool {
 	for _, part := range splitAccept(accept) {
@@ -1,11 +1,16 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 
 	fsbackend "github.com/banux/nxt-opds/internal/backend/fs"
 )
@@ -171,12 +176,47 @@ func TestAuth_LoginPost_CorrectPassword(t *testing.T) {
 	}
 }
 
+func TestAuth_LoginPost_SessionDurationAndSecureCookies(t *testing.T) {
+	login := func(srv *Server) *http.Cookie {
+		t.Helper()
+		form := url.Values{"password": {"secret"}, "redirect": {"/"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == sessionCookieName {
+				return c
+			}
+		}
+		t.Fatal("expected session cookie to be set, got none")
+		return nil
+	}
+
+	c := login(newTestServer(t, Options{Password: "secret"}))
+	if c.MaxAge != int(defaultSessionDuration.Seconds()) || c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("default cookie: MaxAge=%d Secure=%v SameSite=%v, want 30 days, insecure, lax", c.MaxAge, c.Secure, c.SameSite)
+	}
+
+	srv := newTestServer(t, Options{Password: "secret", SessionDuration: 2 * time.Hour, SecureCookies: true})
+	now := time.Now()
+	srv.sessions.now = func() time.Time { return now }
+	c = login(srv)
+	if c.MaxAge != 7200 || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+		t.Errorf("configured cookie: MaxAge=%d Secure=%v SameSite=%v, want 7200, secure, strict", c.MaxAge, c.Secure, c.SameSite)
+	}
+	now = now.Add(2*time.Hour + time.Second)
+	if srv.sessions.valid(c.Value) {
+		t.Error("session should expire after SessionDuration")
+	}
+}
+
 func TestAuth_SessionCookie_GrantsAccess(t *testing.T) {
 	// A valid session cookie grants access to protected routes.
 	srv := newTestServer(t, Options{Password: "secret"})
 
 	// Login to get a session token.
-	token, err := srv.sessions.create()
+	token, err := srv.sessions.create("")
 	if err != nil {
 		t.Fatalf("create session: %v", err)
 	}
@@ -195,7 +235,7 @@ func TestAuth_Logout_ClearsSession(t *testing.T) {
 	// POST /logout must invalidate the session and redirect to /login.
 	srv := newTestServer(t, Options{Password: "secret"})
 
-	token, err := srv.sessions.create()
+	token, err := srv.sessions.create("")
 	if err != nil {
 		t.Fatalf("create session: %v", err)
 	}
@@ -223,6 +263,116 @@ func TestAuth_Logout_ClearsSession(t *testing.T) {
 	}
 }
 
+func TestAuth_SharedDeviceSession_Expires(t *testing.T) {
+	srv := newTestServer(t, Options{Password: "secret", SharedDeviceTimeout: 10 * time.Minute})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	srv.sessions.now = func() time.Time { return now }
+
+	form := url.Values{"password": {"secret"}, "shared": {"1"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var cookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("expected session cookie")
+	}
+	if cookie.MaxAge != 0 {
+		t.Errorf("shared-device cookie MaxAge = %d, want a browser-session cookie", cookie.MaxAge)
+	}
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie.Value})
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	remaining := func() int64 {
+		t.Helper()
+		rr := do(http.MethodGet, "/api/session")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET /api/session: %d", rr.Code)
+		}
+		var info sessionJSON
+		if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if !info.AuthRequired || info.IdleTimeoutSeconds != 600 {
+			t.Errorf("session info = %+v", info)
+		}
+		return info.RemainingSeconds
+	}
+
+	first := remaining()
+	if first != 600 {
+		t.Errorf("remaining right after login = %d, want 600", first)
+	}
+	now = now.Add(4 * time.Minute)
+	second := remaining()
+	if second >= first || second != 360 {
+		t.Errorf("remaining after 4 minutes = %d, want 360 (less than %d)", second, first)
+	}
+	// Polling the status is not activity; another request is.
+	if rr := do(http.MethodGet, "/api/books"); rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/books: %d", rr.Code)
+	}
+	if got := remaining(); got != 600 {
+		t.Errorf("remaining after activity = %d, want 600", got)
+	}
+
+	now = now.Add(9 * time.Minute)
+	if rr := do(http.MethodPost, "/api/session"); rr.Code != http.StatusOK {
+		t.Fatalf("POST /api/session: %d", rr.Code)
+	}
+	now = now.Add(10*time.Minute + time.Second)
+	if rr := do(http.MethodGet, "/api/session"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/session after expiry: %d, want 401", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/books"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/books after expiry: %d, want 401", rr.Code)
+	}
+}
+
+func TestAPISession_RegularAndDisabled(t *testing.T) {
+	srv := newTestServer(t, Options{Password: "secret"})
+	token, err := srv.sessions.create("")
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/session", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var info sessionJSON
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/session: %d %v", rr.Code, err)
+	}
+	if info.IdleTimeoutSeconds != 0 || info.RemainingSeconds <= int64((29*24*time.Hour).Seconds()) {
+		t.Errorf("regular session info = %+v", info)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/session", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("without cookie: %d, want 401", rr.Code)
+	}
+
+	// Without a password there is no session to expire.
+	open := newTestServer(t, Options{})
+	rr = httptest.NewRecorder()
+	open.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/session", nil))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), `"authRequired":true`) {
+		t.Errorf("auth disabled: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestAuth_UsernameIgnored_BasicAuth(t *testing.T) {
 	// Any username is accepted via Basic Auth as long as the password is correct.
 	srv := newTestServer(t, Options{Password: "mypass"})
@@ -237,3 +387,230 @@ func TestAuth_UsernameIgnored_BasicAuth(t *testing.T) {
 		}
 	}
 }
+
+// testUser returns a User whose password hash is cheap to verify.
+func testUser(t *testing.T, name, password string) User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	return User{Name: name, PasswordHash: string(hash)}
+}
+
+func TestAuth_MultiUserLogin(t *testing.T) {
+	srv := newTestServer(t, Options{
+		Password: "family",
+		Users:    []User{testUser(t, "alice", "alice-pw"), testUser(t, "bob", "bob-pw")},
+	})
+	login := func(username, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{"username": {username}, "password": {password}, "redirect": {"/"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	sessionUser := func(rr *httptest.ResponseRecorder) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/session", nil)
+		for _, c := range rr.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		srv.ServeHTTP(res, req)
+		var info sessionJSON
+		if err := json.NewDecoder(res.Body).Decode(&info); err != nil || res.Code != http.StatusOK {
+			t.Fatalf("GET /api/session: %d %v", res.Code, err)
+		}
+		return info.User
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if !strings.Contains(rr.Body.String(), `name="username"`) {
+		t.Error("login form should ask for a user name when users are configured")
+	}
+
+	for _, tc := range []struct{ username, password, wantUser string }{
+		{"alice", "alice-pw", "alice"},
+		{"bob", "bob-pw", "bob"},
+		{"", "family", ""}, // the shared password still works
+	} {
+		rr := login(tc.username, tc.password)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("login %q: expected 303, got %d", tc.username, rr.Code)
+			continue
+		}
+		if got := sessionUser(rr); got != tc.wantUser {
+			t.Errorf("login %q: session user %q, want %q", tc.username, got, tc.wantUser)
+		}
+	}
+	for _, tc := range []struct{ username, password string }{
+		{"alice", "bob-pw"},
+		{"alice", "family"}, // a known user must use their own password
+		{"carol", "alice-pw"},
+	} {
+		if rr := login(tc.username, tc.password); rr.Code != http.StatusUnauthorized {
+			t.Errorf("login %q/%q: expected 401, got %d", tc.username, tc.password, rr.Code)
+		}
+	}
+}
+
+func TestAuthenticator_UnknownUserChecksDummyHash(t *testing.T) {
+	alice := testUser(t, "alice", "alice-pw")
+	hash, err := bcrypt.GenerateFromPassword([]byte("bob-pw"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	auth := newAuthenticator("family", []User{alice, {Name: "bob", PasswordHash: string(hash)}})
+	if cost, err := bcrypt.Cost(auth.dummy); err != nil || cost != bcrypt.MinCost+1 {
+		t.Errorf("dummy hash cost: got %d (%v), want the slowest user's %d", cost, err, bcrypt.MinCost+1)
+	}
+	if _, ok := auth.check("mallory", "alice-pw"); ok {
+		t.Error("unknown user with another user's password: accepted")
+	}
+	if user, ok := auth.check("mallory", "family"); !ok || user != "" {
+		t.Errorf("unknown user with the shared password: got %q, %v", user, ok)
+	}
+	if newAuthenticator("family", nil).dummy != nil {
+		t.Error("dummy hash without users: expected none")
+	}
+}
+
+func TestAuthMiddleware_RecordsUser(t *testing.T) {
+	auth := newAuthenticator("", []User{testUser(t, "alice", "alice-pw")})
+	sessions := newSessionStore(0)
+	var got string
+	h := authMiddleware(auth, "", sessions, "/login")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestUser(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/opds", nil)
+	req.SetBasicAuth("alice", "alice-pw")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || got != "alice" {
+		t.Errorf("basic auth: status %d, user %q; want 200, alice", rr.Code, got)
+	}
+
+	token, err := sessions.create("alice")
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	got = ""
+	req = httptest.NewRequest(http.MethodGet, "/opds", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "alice" {
+		t.Errorf("session cookie: user %q, want alice", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/opds", nil)
+	req.SetBasicAuth("alice", "wrong")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAuth_BearerToken(t *testing.T) {
+	srv := newTestServer(t, Options{Password: "secret", OPDSToken: "tok123"})
+
+	cases := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"valid on API", "/api/books", "Bearer tok123", http.StatusOK},
+		{"valid on OPDS", "/opds", "Bearer tok123", http.StatusOK},
+		{"scheme is case-insensitive", "/api/books", "bearer tok123", http.StatusOK},
+		{"invalid token", "/api/books", "Bearer wrong", http.StatusUnauthorized},
+		{"password is not a token", "/api/books", "Bearer secret", http.StatusUnauthorized},
+		{"empty token", "/opds", "Bearer ", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", tc.header)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestAuth_QueryTokenOnBorrowRoute(t *testing.T) {
+	srv := newTestServer(t, Options{Password: "secret", OPDSToken: "tok123", Lending: true})
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		// Past auth: the book does not exist.
+		{"/api/books/missing/borrow?token=tok123", http.StatusNotFound},
+		{"/api/books/missing/borrow?token=wrong", http.StatusUnauthorized},
+		{"/api/books?token=tok123", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("GET %s: expected %d, got %d", tc.path, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestSessionJanitor_SweepsExpiredTokens(t *testing.T) {
+	store := newSessionStore(0)
+	var clock atomic.Int64
+	clock.Store(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Unix())
+	store.now = func() time.Time { return time.Unix(clock.Load(), 0) }
+
+	expiring, err := store.createShortLived("", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lasting, err := store.create("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := store.startJanitor(time.Millisecond)
+	defer stop()
+
+	clock.Add(int64(time.Hour / time.Second))
+	present := func(token string) bool {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		_, ok := store.tokens[token]
+		return ok
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for present(expiring) {
+		if time.Now().After(deadline) {
+			t.Fatal("expired token not swept by the janitor")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !present(lasting) {
+		t.Error("unexpired token was swept")
+	}
+}
+
+func TestServerClose_StopsSessionJanitor(t *testing.T) {
+	srv := newTestServer(t, Options{Password: "secret", SessionSweepInterval: time.Millisecond})
+	if srv.stopJanitor == nil {
+		t.Fatal("expected the session janitor to be started")
+	}
+	srv.Close()
+	srv.Close() // idempotent
+
+	if srv := newTestServer(t, Options{}); srv.stopJanitor != nil {
+		t.Error("janitor started with SessionSweepInterval 0")
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// exportColumns is the header row of GET /api/export.csv.
+var exportColumns = []string{
+	"id", "title", "authors", "series", "series_index", "tags",
+	"language", "publisher", "isRead", "rating",
+}
+
+// handleAPIExportCSV streams every book as CSV for spreadsheets, one page
+// of the catalog at a time so that large libraries are never held in
+// memory. Authors and tags are joined with semicolons.
+func (s *Server) handleAPIExportCSV(w http.ResponseWriter, r *http.Request) {
+	books, total, err := s.catalog.AllBooks(0, maxPageSize)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "catalog error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="catalog.csv"`)
+	w.Header().Set("Cache-Control", "no-store")
+	cw := csv.NewWriter(w)
+	_ = cw.Write(exportColumns)
+
+	for offset := 0; ; {
+		for _, bk := range books {
+			_ = cw.Write(exportRow(bk))
+		}
+		cw.Flush()
+		if cw.Error() != nil {
+			return // client went away
+		}
+		offset += len(books)
+		if len(books) == 0 || offset >= total {
+			return
+		}
+		// The response has started: an error now can only truncate it.
+		if books, _, err = s.catalog.AllBooks(offset, maxPageSize); err != nil {
+			return
+		}
+	}
+}
+
+// exportRow returns the CSV record of bk in exportColumns order.
+func exportRow(bk catalog.Book) []string {
+	authors := make([]string, len(bk.Authors))
+	for i, a := range bk.Authors {
+		authors[i] = a.Name
+	}
+	return []string{
+		bk.ID,
+		csvText(bk.Title),
+		csvText(strings.Join(authors, ";")),
+		csvText(bk.Series),
+		csvText(bk.SeriesIndex),
+		csvText(strings.Join(bk.Tags, ";")),
+		csvText(bk.Language),
+		csvText(bk.Publisher),
+		strconv.FormatBool(bk.IsRead),
+		strconv.Itoa(bk.Rating),
+	}
+}
+
+// csvText neutralises a metadata value that a spreadsheet would run as a
+// formula (one starting with =, +, -, @, a tab or a carriage return) by
+// prefixing it with a quote, which spreadsheets show as text.
+func csvText(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
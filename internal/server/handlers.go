@@ -1,9 +1,10 @@
 package server
 
 import (
-	"crypto/subtle"
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,23 +21,43 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/epub"
 	"github.com/banux/nxt-opds/internal/opds"
 	"github.com/banux/nxt-opds/internal/opds2"
 )
 
 const (
-	defaultPageSize = 50
-	maxPageSize     = 200
+	defaultPageSize   = 50
+	maxPageSize       = 200
+	defaultRecentSize = 20
+	// groupSize is how many publications an OPDS 2.0 group previews.
+	groupSize = 5
 )
 
-// writeOPDS writes an OPDS XML feed response.
-func writeOPDS(w http.ResponseWriter, status int, feed *opds.Feed) {
+// writeOPDS writes an OPDS XML feed response, adjusted for the client's
+// quirks and shrinking paginated feeds that exceed Options.MaxFeedBytes.
+func (s *Server) writeOPDS(w http.ResponseWriter, r *http.Request, status int, feed *opds.Feed) {
+	s.mountFeed(feed)
+	quirk := s.clientQuirk(r)
+	applyQuirk(feed, r, quirk)
+	base := s.opts.BasePath
+	if quirk.AbsoluteURLs {
+		base = strings.TrimSuffix(absoluteURL(r, "/"), "/") + base
+	}
 	data, err := feed.MarshalToXML()
+	if err == nil && s.opts.MaxFeedBytes > 0 && len(data) > s.opts.MaxFeedBytes {
+		data, err = shrinkFeed(feed, r, s.opts.MaxFeedBytes, data, base)
+	}
 	if err != nil {
 		http.Error(w, "feed serialization error", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", opds.MIMENavigationFeed+"; charset=utf-8")
+	contentType := opds.MIMENavigationFeed
+	if quirk.PlainAtomType {
+		contentType = opds.MIMEAtomFeed
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Add("Vary", "User-Agent")
 	w.WriteHeader(status)
 	_, _ = w.Write(data)
 }
@@ -54,6 +76,16 @@ func parsePagination(r *http.Request) (offset, limit int) {
 	return
 }
 
+// navPagination is parsePagination for the author and genre navigation
+// feeds, with the limit capped by Options.MaxNavPageSize.
+func (s *Server) navPagination(r *http.Request) (offset, limit int) {
+	offset, limit = parsePagination(r)
+	if n := s.opts.MaxNavPageSize; n > 0 && limit > n {
+		limit = n
+	}
+	return offset, limit
+}
+
 // paginationLink builds a URL for the given page by replacing the offset and
 // limit query parameters while preserving all other query parameters (e.g. q=).
 func paginationLink(r *http.Request, offset, limit int) string {
@@ -84,9 +116,227 @@ func addPaginationLinks(feed *opds.Feed, r *http.Request, offset, limit, total i
 	feed.AddLink(opds.RelLast, paginationLink(r, lastOffset, limit), mimeType)
 }
 
+// addPageLinks is addPaginationLinks for a page of search results. When the
+// page's total is approximate there is no "last" link, and "next" is
+// offered as long as pages come back full.
+func addPageLinks(feed *opds.Feed, r *http.Request, offset, limit int, page catalog.Page, mimeType string) {
+	if !page.Approximate {
+		addPaginationLinks(feed, r, offset, limit, page.Total, mimeType)
+		return
+	}
+	feed.AddLink(opds.RelFirst, paginationLink(r, 0, limit), mimeType)
+	if offset > 0 {
+		feed.AddLink(opds.RelPrevious, paginationLink(r, max(offset-limit, 0), limit), mimeType)
+	}
+	if len(page.Items) == limit {
+		feed.AddLink(opds.RelNext, paginationLink(r, offset+limit, limit), mimeType)
+	}
+}
+
+// searchPage runs a search, through catalog.PageSearcher when the backend
+// can tell whether its totals are exact.
+func (s *Server) searchPage(q catalog.SearchQuery) (catalog.Page, error) {
+	if s.pageSearcher != nil {
+		return s.pageSearcher.SearchPage(q)
+	}
+	books, total, err := s.catalog.Search(q)
+	return catalog.Page{Items: books, Total: total}, err
+}
+
+// resultCount formats the total of a page of search results, as "42", or
+// "1000+" when it is a lower bound.
+func resultCount(page catalog.Page) string {
+	if page.Approximate {
+		return fmt.Sprintf("%d+", page.Total)
+	}
+	return strconv.Itoa(page.Total)
+}
+
+// shrinkFeed returns the largest leading slice of feed's entries whose
+// serialization fits in maxBytes, with pagination links rewritten for the
+// reduced page size so the dropped entries stay reachable via "next".
+// The "last" link is dropped because the total is not known here.
+// Feeds without pagination links are returned unchanged (data), as is a
+// feed whose first entry alone exceeds the cap after trimming to one entry.
+// base is the prefix (Options.BasePath, possibly made absolute) the new
+// pagination links start with.
+func shrinkFeed(feed *opds.Feed, r *http.Request, maxBytes int, data []byte, base string) ([]byte, error) {
+	var mimeType string
+	for _, l := range feed.Links {
+		if l.Rel == opds.RelFirst {
+			mimeType = l.Type
+		}
+	}
+	if mimeType == "" || len(feed.Entries) <= 1 {
+		return data, nil
+	}
+	offset, _ := parsePagination(r)
+
+	build := func(n int) ([]byte, error) {
+		page := *feed
+		page.Entries = feed.Entries[:n]
+		page.Links = nil
+		for _, l := range feed.Links {
+			switch l.Rel {
+			case opds.RelFirst, opds.RelPrevious, opds.RelNext, opds.RelLast:
+			default:
+				page.Links = append(page.Links, l)
+			}
+		}
+		page.AddLink(opds.RelFirst, base+paginationLink(r, 0, n), mimeType)
+		if offset > 0 {
+			page.AddLink(opds.RelPrevious, base+paginationLink(r, max(offset-n, 0), n), mimeType)
+		}
+		page.AddLink(opds.RelNext, base+paginationLink(r, offset+n, n), mimeType)
+		return page.MarshalToXML()
+	}
+
+	// Binary search for the largest page that fits; keep at least one entry.
+	var best []byte
+	lo, hi := 1, len(feed.Entries)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		out, err := build(mid)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) <= maxBytes {
+			best, lo = out, mid+1
+		} else {
+			hi = mid - 1
+		}
+	}
+	if best == nil {
+		return build(1)
+	}
+	return best, nil
+}
+
+// catalogUpdated returns a stable timestamp for feeds: when the catalog
+// last changed, or the server start time for an empty catalog. Using it
+// instead of time.Now() keeps successive feeds identical while nothing
+// changes, so sync clients do not re-download. Backends implementing
+// catalog.LastModifier report changes exactly, removals included; for the
+// others it falls back to the most recent UpdatedAt or AddedAt.
+func (s *Server) catalogUpdated() time.Time {
+	latest := s.started
+	if s.lastModifier != nil {
+		if t, err := s.lastModifier.LastModified(); err == nil {
+			if t.After(latest) {
+				latest = t
+			}
+			return latest
+		}
+	}
+	if books, _, err := s.catalog.Search(catalog.SearchQuery{SortBy: "updated", SortOrder: "desc", Limit: 1}); err == nil && len(books) > 0 {
+		latest = books[0].UpdatedAt
+	}
+	if books, _, err := s.catalog.AllBooks(0, 1); err == nil && len(books) > 0 && books[0].AddedAt.After(latest) {
+		latest = books[0].AddedAt
+	}
+	return latest
+}
+
+// notModified sets the Last-Modified header of a feed response from
+// catalogUpdated and answers 304 Not Modified when the request's
+// If-Modified-Since is not older. It returns the timestamp to use for the
+// feed's <updated> and whether the response has already been written.
+func (s *Server) notModified(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	updated := s.catalogUpdated().UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !updated.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return updated, true
+	}
+	return updated, false
+}
+
+// downloadBlocked reports whether path has an extension listed in
+// Options.DownloadBlockedFormats.
+func (s *Server) downloadBlocked(path string) bool {
+	if len(s.blockedFormats) == 0 {
+		return false
+	}
+	return s.blockedFormats[strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))]
+}
+
+// downloadable returns bk with files of blocked formats removed, so feeds
+// do not advertise acquisition links that would answer 403.
+func (s *Server) downloadable(bk catalog.Book) catalog.Book {
+	if len(s.blockedFormats) == 0 {
+		return bk
+	}
+	files := make([]catalog.File, 0, len(bk.Files))
+	for _, f := range bk.Files {
+		if !s.downloadBlocked(f.Path) {
+			files = append(files, f)
+		}
+	}
+	bk.Files = files
+	return bk
+}
+
+// bookEntry converts a book to an acquisition feed entry without the files
+// of blocked formats, advertising borrow links instead of acquisition links
+// in lending mode.
+func (s *Server) bookEntry(bk catalog.Book, tok string) opds.Entry {
+	entry := bookToEntry(s.downloadable(bk), tok, s.linkTitle)
+	s.lendLinks(bk.ID, entry.Links)
+	if rating, ok := s.rating(bk); ok {
+		entry.CalRating = strconv.FormatFloat(rating, 'f', -1, 64)
+	}
+	return entry
+}
+
+// rating returns bk's rating on the scale selected by Options.RatingScale,
+// and false when the book is unrated or ratings are hidden.
+func (s *Server) rating(bk catalog.Book) (float64, bool) {
+	if bk.Rating <= 0 {
+		return 0, false
+	}
+	switch s.opts.RatingScale {
+	case RatingScaleOff:
+		return 0, false
+	case RatingScaleFraction:
+		return float64(bk.Rating) / 5, true
+	default:
+		return float64(bk.Rating), true
+	}
+}
+
+// defaultLinkTitle is the acquisition link title used when
+// Options.DownloadLinkTitle is empty.
+const defaultLinkTitle = "Download {format}"
+
+// formatNames maps file MIME types to the format names shown in
+// acquisition link titles.
+var formatNames = map[string]string{
+	opds.MIMEEPub:       "EPUB",
+	opds.MIMEKEPub:      "KEPUB",
+	opds.MIMEPdf:        "PDF",
+	opds.MIMEMobiPocket: "MOBI",
+	opds.MIMEAZWThree:   "AZW3",
+	opds.MIMECBZ:        "CBZ",
+	opds.MIMECBR:        "CBR",
+	opds.MIMEFB2:        "FB2",
+	opds.MIMEFB2Zip:     "FB2",
+}
+
+// acquisitionTitle returns the title of the acquisition link to f: layout
+// with "{format}" replaced by the format name, taken from the MIME type or,
+// for unknown types, the file extension.
+func acquisitionTitle(layout string, f catalog.File) string {
+	name, ok := formatNames[f.MIMEType]
+	if !ok {
+		name = strings.ToUpper(strings.TrimPrefix(epub.FileExt(f.Path), "."))
+	}
+	return strings.ReplaceAll(layout, "{format}", name)
+}
+
 // bookToEntry converts a catalog.Book to an opds.Entry for an acquisition feed.
 // tok is the OPDS authentication token to append to all URLs (may be empty).
-func bookToEntry(b catalog.Book, tok string) opds.Entry {
+// linkTitle is the acquisition link title layout (see acquisitionTitle).
+func bookToEntry(b catalog.Book, tok, linkTitle string) opds.Entry {
 	entry := opds.Entry{
 		ID:      "urn:nxt-opds:book:" + b.ID,
 		Title:   opds.Text{Value: b.Title},
@@ -113,9 +363,11 @@ func bookToEntry(b catalog.Book, tok string) opds.Entry {
 	// Acquisition links for each available file
 	for _, f := range b.Files {
 		entry.Links = append(entry.Links, opds.Link{
-			Rel:  opds.RelAcquisition,
-			Href: withToken("/opds/books/"+b.ID+"/download?path="+url.QueryEscape(f.Path), tok),
-			Type: f.MIMEType,
+			Rel:    opds.RelAcquisition,
+			Href:   withToken("/opds/books/"+b.ID+"/download?path="+url.QueryEscape(f.Path), tok),
+			Type:   f.MIMEType,
+			Title:  acquisitionTitle(linkTitle, f),
+			Length: f.Size,
 		})
 	}
 
@@ -154,7 +406,27 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	// Search link
 	feed.AddLink(opds.RelSearch, withToken("/opds/opensearch.xml", tok), opds.MIMEOpenSearchDesc)
 
-	now := time.Now()
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+
+	// Tell readers why every feed below is empty; the entry links to the
+	// web UI, where books can be uploaded.
+	if s.opts.EmptyCatalogNotice != "" {
+		if _, total, err := s.catalog.AllBooks(0, 1); err == nil && total == 0 {
+			feed.AddEntry(opds.Entry{
+				ID:      "urn:nxt-opds:empty",
+				Title:   opds.Text{Value: s.opts.EmptyCatalogNotice},
+				Updated: opds.AtomDate{Time: now},
+				Content: &opds.Content{Type: "text", Value: "The catalog has no books yet"},
+				Links: []opds.Link{
+					{Rel: "alternate", Href: withToken("/", tok), Type: "text/html"},
+				},
+			})
+		}
+	}
 
 	// Navigation entries
 	feed.AddEntry(opds.Entry{
@@ -167,6 +439,16 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 
+	feed.AddEntry(opds.Entry{
+		ID:      "urn:nxt-opds:recent",
+		Title:   opds.Text{Value: "Recently Added"},
+		Updated: opds.AtomDate{Time: now},
+		Content: &opds.Content{Type: "text", Value: "The newest books in the catalog"},
+		Links: []opds.Link{
+			{Rel: opds.RelCatalogNew, Href: withToken("/opds/recent", tok), Type: opds.MIMEAcquisitionFeed},
+		},
+	})
+
 	feed.AddEntry(opds.Entry{
 		ID:      "urn:nxt-opds:by-author",
 		Title:   opds.Text{Value: "By Author"},
@@ -197,6 +479,16 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 
+	feed.AddEntry(opds.Entry{
+		ID:      "urn:nxt-opds:recently-read",
+		Title:   opds.Text{Value: "Recently Read"},
+		Updated: opds.AtomDate{Time: now},
+		Content: &opds.Content{Type: "text", Value: "Books most recently marked as read"},
+		Links: []opds.Link{
+			{Rel: opds.RelCatalogNavigation, Href: withToken("/opds/recently-read", tok), Type: opds.MIMEAcquisitionFeed},
+		},
+	})
+
 	feed.AddEntry(opds.Entry{
 		ID:      "urn:nxt-opds:by-publisher",
 		Title:   opds.Text{Value: "By Publisher"},
@@ -207,7 +499,67 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 
-	writeOPDS(w, http.StatusOK, feed)
+	if s.seriesLister != nil {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:by-series",
+			Title:   opds.Text{Value: "By Series"},
+			Updated: opds.AtomDate{Time: now},
+			Content: &opds.Content{Type: "text", Value: "Browse books by series"},
+			Links: []opds.Link{
+				{Rel: opds.RelCatalogNavigation, Href: withToken("/opds/series", tok), Type: opds.MIMENavigationFeed},
+			},
+		})
+	}
+
+	if s.yearBrowser != nil {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:by-year",
+			Title:   opds.Text{Value: "By Year"},
+			Updated: opds.AtomDate{Time: now},
+			Content: &opds.Content{Type: "text", Value: "Browse books by publication decade"},
+			Links: []opds.Link{
+				{Rel: opds.RelCatalogNavigation, Href: withToken("/opds/years", tok), Type: opds.MIMENavigationFeed},
+			},
+		})
+	}
+
+	if s.downloadCounter != nil {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:popular",
+			Title:   opds.Text{Value: "Most Popular"},
+			Updated: opds.AtomDate{Time: now},
+			Content: &opds.Content{Type: "text", Value: "The most downloaded books"},
+			Links: []opds.Link{
+				{Rel: opds.RelCatalogPopular, Href: withToken("/opds/popular", tok), Type: opds.MIMEAcquisitionFeed},
+			},
+		})
+	}
+
+	if s.randomPicker != nil {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:random",
+			Title:   opds.Text{Value: "Surprise Me"},
+			Updated: opds.AtomDate{Time: now},
+			Content: &opds.Content{Type: "text", Value: "A random book from the catalog"},
+			Links: []opds.Link{
+				{Rel: opds.RelCatalogNavigation, Href: withToken("/opds/random", tok), Type: opds.MIMEAcquisitionFeed},
+			},
+		})
+	}
+
+	if s.listManager != nil {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:lists",
+			Title:   opds.Text{Value: "Reading Lists"},
+			Updated: opds.AtomDate{Time: now},
+			Content: &opds.Content{Type: "text", Value: "Browse your reading lists"},
+			Links: []opds.Link{
+				{Rel: opds.RelCatalogNavigation, Href: withToken("/opds/lists", tok), Type: opds.MIMENavigationFeed},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
 // handleUnreadBooks serves the OPDS 1.x acquisition feed filtered to unread books.
@@ -236,249 +588,703 @@ func (s *Server) handleUnreadBooks(w http.ResponseWriter, r *http.Request) {
 	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
 
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handleAllBooks serves the acquisition feed with all books.
-func (s *Server) handleAllBooks(w http.ResponseWriter, r *http.Request) {
+// handleRecentlyRead serves the OPDS 1.x acquisition feed of read books,
+// most recently read first.
+func (s *Server) handleRecentlyRead(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
 	offset, limit := parsePagination(r)
 
-	books, total, err := s.catalog.AllBooks(offset, limit)
+	books, total, err := s.catalog.Search(catalog.SearchQuery{
+		ReadOnly:  true,
+		Offset:    offset,
+		Limit:     limit,
+		SortBy:    "read_at",
+		SortOrder: "desc",
+	})
 	if err != nil {
 		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
 
 	feed := opds.NewAcquisitionFeed(
-		"urn:nxt-opds:all-books",
-		fmt.Sprintf("All Books (%d)", total),
+		"urn:nxt-opds:recently-read",
+		fmt.Sprintf("Recently Read (%d)", total),
 	)
-	feed.AddLink(opds.RelSelf, withToken("/opds/books", tok), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelSelf, withToken("/opds/recently-read", tok), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
 	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
 
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handleBook serves a single book entry.
-func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+// handleRecent serves the N most recently added books, newest first.
+// N is the ?limit= query parameter (default defaultRecentSize, capped at
+// maxPageSize); the feed is not paginated.
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	bk, err := s.catalog.BookByID(id)
-	if err != nil {
-		http.Error(w, "book not found", http.StatusNotFound)
-		return
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = defaultRecentSize
 	}
+	limit = min(limit, maxPageSize)
 
-	feed := opds.NewAcquisitionFeed(
-		"urn:nxt-opds:book:"+id,
-		bk.Title,
-	)
-	feed.AddLink(opds.RelSelf, withToken("/opds/books/"+id, tok), opds.MIMEAcquisitionFeed)
-	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
-	feed.AddEntry(bookToEntry(*bk, tok))
-
-	writeOPDS(w, http.StatusOK, feed)
-}
-
-// handleSearch performs a catalog search.
-func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	tok := r.URL.Query().Get("token")
-	q := r.URL.Query().Get("q")
-	if q == "" {
-		http.Error(w, "missing search query parameter 'q'", http.StatusBadRequest)
+	books, err := s.recentBooks(limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
 
-	offset, limit := parsePagination(r)
-
-	books, total, err := s.catalog.Search(catalog.SearchQuery{
-		Query:  q,
-		Offset: offset,
-		Limit:  limit,
-	})
-	if err != nil {
-		http.Error(w, "search error", http.StatusInternalServerError)
+	updated, done := s.notModified(w, r)
+	if done {
 		return
 	}
 
-	feed := opds.NewAcquisitionFeed(
-		"urn:nxt-opds:search",
-		fmt.Sprintf("Search: %s (%d results)", q, total),
-	)
+	feed := opds.NewAcquisitionFeed("urn:nxt-opds:recent", "Recently Added")
+	feed.Updated = opds.AtomDate{Time: updated}
 	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
-	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
 
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handleAuthors serves the author navigation feed.
-func (s *Server) handleAuthors(w http.ResponseWriter, r *http.Request) {
+// handlePopular serves the OPDS 1.x acquisition feed of downloaded books,
+// most downloaded first. Returns 501 if the backend does not count downloads.
+func (s *Server) handlePopular(w http.ResponseWriter, r *http.Request) {
+	if s.downloadCounter == nil {
+		http.Error(w, "download counts not supported by this backend", http.StatusNotImplemented)
+		return
+	}
 	tok := r.URL.Query().Get("token")
 	offset, limit := parsePagination(r)
 
-	authors, total, err := s.catalog.Authors(offset, limit)
+	books, total, err := s.downloadCounter.PopularBooks(offset, limit)
 	if err != nil {
 		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
 
-	feed := opds.NewNavigationFeed(
-		"urn:nxt-opds:authors",
-		fmt.Sprintf("Authors (%d)", total),
-	)
-	feed.AddLink(opds.RelSelf, withToken("/opds/authors", tok), opds.MIMENavigationFeed)
+	feed := opds.NewAcquisitionFeed("urn:nxt-opds:popular", "Most Popular")
+	feed.AddLink(opds.RelSelf, withToken("/opds/popular", tok), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
-	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
 
-	now := time.Now()
-	for _, name := range authors {
-		feed.AddEntry(opds.Entry{
-			ID:      "urn:nxt-opds:author:" + name,
-			Title:   opds.Text{Value: name},
-			Updated: opds.AtomDate{Time: now},
-			Links: []opds.Link{
-				{
-					Rel:  opds.RelCatalogNavigation,
-					Href: withToken("/opds/authors/"+url.PathEscape(name), tok),
-					Type: opds.MIMEAcquisitionFeed,
-				},
-			},
-		})
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handleAuthorBooks serves books filtered by a specific author.
-func (s *Server) handleAuthorBooks(w http.ResponseWriter, r *http.Request) {
+// handleRandom serves an acquisition feed holding n books picked at random
+// (?n=, default 1, at most maxPageSize). Returns 404 if the catalog is
+// empty.
+func (s *Server) handleRandom(w http.ResponseWriter, r *http.Request) {
+	if s.randomPicker == nil {
+		http.Error(w, "random books not supported by this backend", http.StatusNotImplemented)
+		return
+	}
 	tok := r.URL.Query().Get("token")
-	vars := mux.Vars(r)
-	author, _ := url.PathUnescape(vars["author"])
-	offset, limit := parsePagination(r)
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 1
+	}
+	n = min(n, maxPageSize)
 
-	books, total, err := s.catalog.BooksByAuthor(author, offset, limit)
+	books, err := s.randomPicker.RandomBooks(n)
 	if err != nil {
 		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
+	if len(books) == 0 {
+		http.Error(w, "the catalog is empty", http.StatusNotFound)
+		return
+	}
 
-	feed := opds.NewAcquisitionFeed(
-		"urn:nxt-opds:author:"+author,
-		fmt.Sprintf("Books by %s (%d)", author, total),
-	)
+	feed := opds.NewAcquisitionFeed("urn:nxt-opds:random", "Surprise Me")
 	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
-	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
-
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handleTags serves the tag/genre navigation feed.
-func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
-	tok := r.URL.Query().Get("token")
-	offset, limit := parsePagination(r)
-
-	tags, total, err := s.catalog.Tags(offset, limit)
+// randomBook picks a random book for handleAPIRandom, writing the error
+// response and reporting false when there is none.
+func (s *Server) randomBook(w http.ResponseWriter) (*catalog.Book, bool) {
+	if s.randomPicker == nil {
+		writeJSONError(w, http.StatusNotImplemented, "random books not supported by this backend")
+		return nil, false
+	}
+	bk, err := s.randomPicker.RandomBook()
 	if err != nil {
-		http.Error(w, "catalog error", http.StatusInternalServerError)
-		return
+		writeJSONError(w, http.StatusInternalServerError, "catalog error")
+		return nil, false
 	}
-
-	feed := opds.NewNavigationFeed(
-		"urn:nxt-opds:tags",
-		fmt.Sprintf("Genres (%d)", total),
-	)
-	feed.AddLink(opds.RelSelf, withToken("/opds/tags", tok), opds.MIMENavigationFeed)
-	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
-	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
-
-	now := time.Now()
-	for _, tag := range tags {
-		feed.AddEntry(opds.Entry{
-			ID:      "urn:nxt-opds:tag:" + tag,
-			Title:   opds.Text{Value: tag},
-			Updated: opds.AtomDate{Time: now},
-			Links: []opds.Link{
-				{
-					Rel:  opds.RelCatalogNavigation,
-					Href: withToken("/opds/tags/"+url.PathEscape(tag), tok),
-					Type: opds.MIMEAcquisitionFeed,
-				},
-			},
-		})
+	if bk == nil {
+		writeJSONError(w, http.StatusNotFound, "the catalog is empty")
+		return nil, false
 	}
-
-	writeOPDS(w, http.StatusOK, feed)
+	return bk, true
 }
 
-// handleTagBooks serves books filtered by a specific tag/genre.
-func (s *Server) handleTagBooks(w http.ResponseWriter, r *http.Request) {
+// handleAllBooks serves the acquisition feed with all books.
+func (s *Server) handleAllBooks(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
-	vars := mux.Vars(r)
-	tag, _ := url.PathUnescape(vars["tag"])
+	language := strings.ToLower(r.URL.Query().Get("language"))
 	offset, limit := parsePagination(r)
 
-	books, total, err := s.catalog.BooksByTag(tag, offset, limit)
+	var books []catalog.Book
+	var total int
+	var err error
+	if language != "" {
+		books, total, err = s.catalog.Search(catalog.SearchQuery{
+			Language: language,
+			Offset:   offset,
+			Limit:    limit,
+		})
+	} else {
+		books, total, err = s.catalog.AllBooks(offset, limit)
+	}
 	if err != nil {
 		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
 
-	feed := opds.NewAcquisitionFeed(
-		"urn:nxt-opds:tag:"+tag,
-		fmt.Sprintf("Genre: %s (%d)", tag, total),
-	)
-	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	id, title, self := "urn:nxt-opds:all-books", fmt.Sprintf("All Books (%d)", total), "/opds/books"
+	if language != "" {
+		id += ":" + language
+		title = fmt.Sprintf("All Books – %s (%d)", language, total)
+		self += "?language=" + url.QueryEscape(language)
+	}
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(id, title)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, withToken(self, tok), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
 	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+	s.addLanguageFacets(feed, language, tok)
 
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
-// handlePublishers serves the publisher navigation feed (OPDS 1.x).
-func (s *Server) handlePublishers(w http.ResponseWriter, r *http.Request) {
-	tok := r.URL.Query().Get("token")
-	offset, limit := parsePagination(r)
-
-	publishers, total, err := s.catalog.Publishers(offset, limit)
-	if err != nil {
-		http.Error(w, "catalog error", http.StatusInternalServerError)
+// addLanguageFacets adds a "Language" facet group to an all-books feed: one
+// link per language with its book count, plus one back to all languages.
+// active is the language currently filtered on ("" for none). Nothing is
+// added when the backend cannot list languages or no book has one.
+func (s *Server) addLanguageFacets(feed *opds.Feed, active, tok string) {
+	langs := s.languages()
+	if len(langs) == 0 {
 		return
 	}
+	const group = "Language"
+	feed.AddFacet(group, "All", withToken("/opds/books", tok), opds.MIMEAcquisitionFeed, 0, active == "")
+	for _, l := range langs {
+		href := "/opds/books?language=" + url.QueryEscape(l.Language)
+		feed.AddFacet(group, l.Language, withToken(href, tok), opds.MIMEAcquisitionFeed, l.Count, l.Language == active)
+	}
+}
 
-	feed := opds.NewNavigationFeed(
-		"urn:nxt-opds:publishers",
-		fmt.Sprintf("Publishers (%d)", total),
-	)
+// handleBook serves a single book entry. Hidden books answer 404.
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	bk, err := s.catalog.BookByID(id)
+	if err != nil || bk.Hidden {
+		http.Error(w, "book not found", http.StatusNotFound)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:book:"+id,
+		bk.Title,
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, withToken("/opds/books/"+id, tok), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	feed.AddEntry(s.bookEntry(*bk, tok))
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleSearch performs a catalog search, restricted to one language when
+// ?language= is given.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing search query parameter 'q'", http.StatusBadRequest)
+		return
+	}
+
+	offset, limit := parsePagination(r)
+
+	page, err := s.searchPage(catalog.SearchQuery{
+		Query:    q,
+		Language: strings.ToLower(r.URL.Query().Get("language")),
+		SortBy:   "relevance",
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		http.Error(w, "search error", http.StatusInternalServerError)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:search",
+		fmt.Sprintf("Search: %s (%s results)", q, resultCount(page)),
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPageLinks(feed, r, offset, limit, page, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range page.Items {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleAuthors serves the author navigation feed, or with ?letter= the
+// authors listed under one initial. Beyond Options.AuthorIndexThreshold
+// authors it serves the alphabetical index instead (see handleAuthorIndex).
+func (s *Server) handleAuthors(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	offset, limit := s.navPagination(r)
+
+	letter := strings.ToUpper(r.URL.Query().Get("letter"))
+	if letter != "" && (s.authorIndexer == nil || !slices.Contains(catalog.IndexLetters, letter)) {
+		http.Error(w, "unknown letter", http.StatusBadRequest)
+		return
+	}
+
+	var authors []catalog.AuthorEntry
+	var total int
+	var err error
+	if letter != "" {
+		authors, total, err = s.authorIndexer.AuthorsByLetter(letter, offset, limit)
+	} else {
+		authors, total, err = s.authorEntries(offset, limit)
+	}
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+	if letter == "" && s.authorIndexer != nil && s.opts.AuthorIndexThreshold > 0 && total > s.opts.AuthorIndexThreshold {
+		s.handleAuthorIndex(w, r, total)
+		return
+	}
+
+	id, title, self := "urn:nxt-opds:authors", fmt.Sprintf("Authors (%d)", total), "/opds/authors"
+	if letter != "" {
+		id += ":" + letter
+		title = fmt.Sprintf("Authors – %s (%d)", letter, total)
+		self += "?letter=" + url.QueryEscape(letter)
+	}
+	feed := opds.NewNavigationFeed(id, title)
+	feed.AddLink(opds.RelSelf, withToken(self, tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	if letter != "" {
+		feed.AddLink("up", withToken("/opds/authors", tok), opds.MIMENavigationFeed)
+	}
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
+
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+	for _, a := range authors {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:author:" + a.Name,
+			Title:   opds.Text{Value: countedTitle(a.Name, a.Count)},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/authors/"+nameSlug(a.Name), tok),
+					Type:  opds.MIMEAcquisitionFeed,
+					Count: a.Count,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleAuthorIndex serves the alphabetical index that replaces the author
+// feed beyond Options.AuthorIndexThreshold authors: one entry per initial
+// with authors, leading to the authors listed under it.
+func (s *Server) handleAuthorIndex(w http.ResponseWriter, r *http.Request, total int) {
+	tok := r.URL.Query().Get("token")
+	counts := make([]int, len(catalog.IndexLetters))
+	for i, letter := range catalog.IndexLetters {
+		_, n, err := s.authorIndexer.AuthorsByLetter(letter, 0, 0)
+		if err != nil {
+			http.Error(w, "catalog error", http.StatusInternalServerError)
+			return
+		}
+		counts[i] = n
+	}
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewNavigationFeed("urn:nxt-opds:authors", fmt.Sprintf("Authors (%d)", total))
+	feed.Updated = opds.AtomDate{Time: now}
+	feed.AddLink(opds.RelSelf, withToken("/opds/authors", tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	for i, letter := range catalog.IndexLetters {
+		n := counts[i]
+		if n == 0 {
+			continue
+		}
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:authors:" + letter,
+			Title:   opds.Text{Value: countedTitle(letter, n)},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/authors?letter="+url.QueryEscape(letter), tok),
+					Type:  opds.MIMENavigationFeed,
+					Count: n,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// authorEntries returns a page of authors with their book counts when the
+// backend implements catalog.AuthorCounter, and with zero counts otherwise.
+func (s *Server) authorEntries(offset, limit int) ([]catalog.AuthorEntry, int, error) {
+	if s.authorCounter != nil {
+		return s.authorCounter.AuthorsWithCounts(offset, limit)
+	}
+	names, total, err := s.catalog.Authors(offset, limit)
+	entries := make([]catalog.AuthorEntry, len(names))
+	for i, name := range names {
+		entries[i].Name = name
+	}
+	return entries, total, err
+}
+
+// tagEntries returns a page of tags with their book counts when the
+// backend implements catalog.TagCounter, and with zero counts otherwise.
+func (s *Server) tagEntries(offset, limit int) ([]catalog.TagEntry, int, error) {
+	if s.tagCounter != nil {
+		return s.tagCounter.TagsWithCounts(offset, limit)
+	}
+	names, total, err := s.catalog.Tags(offset, limit)
+	entries := make([]catalog.TagEntry, len(names))
+	for i, name := range names {
+		entries[i].Name = name
+	}
+	return entries, total, err
+}
+
+// countedTitle returns a navigation entry title with its book count, as in
+// "Fiction (42)", or name alone when the count is unknown.
+func countedTitle(name string, count int) string {
+	if count <= 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (%d)", name, count)
+}
+
+// handleAuthorBooks serves books filtered by a specific author.
+func (s *Server) handleAuthorBooks(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	vars := mux.Vars(r)
+	author, err := s.resolveAuthor(vars["author"])
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+	offset, limit := parsePagination(r)
+
+	books, total, err := s.catalog.BooksByAuthor(author, offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:author:"+author,
+		fmt.Sprintf("Books by %s (%d)", author, total),
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleTags serves the tag/genre navigation feed.
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	offset, limit := s.navPagination(r)
+
+	if s.opts.TagSeparator != "" {
+		tree, err := s.tagTree()
+		if err != nil {
+			http.Error(w, "catalog error", http.StatusInternalServerError)
+			return
+		}
+		s.writeTagTreeFeed(w, r, tree, "Genres")
+		return
+	}
+
+	tags, total, err := s.tagEntries(offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewNavigationFeed(
+		"urn:nxt-opds:tags",
+		fmt.Sprintf("Genres (%d)", total),
+	)
+	feed.AddLink(opds.RelSelf, withToken("/opds/tags", tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
+
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+	for _, tag := range tags {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:tag:" + tag.Name,
+			Title:   opds.Text{Value: countedTitle(tag.Name, tag.Count)},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/tags/"+nameSlug(tag.Name), tok),
+					Type:  opds.MIMEAcquisitionFeed,
+					Count: tag.Count,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleTagBooks serves books filtered by a specific tag/genre.
+func (s *Server) handleTagBooks(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	vars := mux.Vars(r)
+	tag, err := s.resolveTag(vars["tag"])
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+	offset, limit := parsePagination(r)
+
+	// With a tag hierarchy, a genre that has subgenres is a navigation feed
+	// unless ?books=1 asks for the books tagged with the genre itself.
+	if sep := s.opts.TagSeparator; sep != "" {
+		tree, err := s.tagTree()
+		if err != nil {
+			http.Error(w, "catalog error", http.StatusInternalServerError)
+			return
+		}
+		if node := tree.find(tag, sep); node != nil {
+			if len(node.children) > 0 && r.URL.Query().Get("books") != "1" {
+				s.writeTagTreeFeed(w, r, node, "Genre: "+node.path)
+				return
+			}
+			if node.tag != "" {
+				tag = node.tag
+			}
+		}
+	}
+
+	books, total, err := s.catalog.BooksByTag(tag, offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:tag:"+tag,
+		fmt.Sprintf("Genre: %s (%d)", tag, total),
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// tagTree builds the genre hierarchy from every tag in the catalog using
+// Options.TagSeparator.
+func (s *Server) tagTree() (*tagNode, error) {
+	_, total, err := s.catalog.Tags(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	tags, _, err := s.catalog.Tags(0, total)
+	if err != nil {
+		return nil, err
+	}
+	return buildTagTree(tags, s.opts.TagSeparator), nil
+}
+
+// writeTagTreeFeed writes a navigation feed listing the subgenres of node.
+// If node is itself a tag, the first entry links to its books.
+func (s *Server) writeTagTreeFeed(w http.ResponseWriter, r *http.Request, node *tagNode, title string) {
+	tok := r.URL.Query().Get("token")
+	offset, limit := s.navPagination(r)
+	children := node.sortedChildren()
+	total := len(children)
+
+	feedID := "urn:nxt-opds:tags"
+	if node.path != "" {
+		feedID = "urn:nxt-opds:tag:" + node.path
+	}
+	feed := opds.NewNavigationFeed(feedID, fmt.Sprintf("%s (%d)", title, total))
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
+
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+	if node.tag != "" && offset == 0 {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:tag-books:" + node.path,
+			Title:   opds.Text{Value: "All " + node.name},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:  opds.RelCatalogNavigation,
+					Href: withToken("/opds/tags/"+nameSlug(node.path)+"?books=1", tok),
+					Type: opds.MIMEAcquisitionFeed,
+				},
+			},
+		})
+	}
+
+	if offset < total {
+		end := offset + limit
+		if end > total {
+			end = total
+		}
+		for _, child := range children[offset:end] {
+			linkType := opds.MIMEAcquisitionFeed
+			if len(child.children) > 0 {
+				linkType = opds.MIMENavigationFeed
+			}
+			feed.AddEntry(opds.Entry{
+				ID:      "urn:nxt-opds:tag:" + child.path,
+				Title:   opds.Text{Value: child.name},
+				Updated: opds.AtomDate{Time: now},
+				Links: []opds.Link{
+					{
+						Rel:  opds.RelCatalogNavigation,
+						Href: withToken("/opds/tags/"+nameSlug(child.path), tok),
+						Type: linkType,
+					},
+				},
+			})
+		}
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handlePublishers serves the publisher navigation feed (OPDS 1.x).
+func (s *Server) handlePublishers(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	offset, limit := parsePagination(r)
+
+	publishers, total, err := s.catalog.Publishers(offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewNavigationFeed(
+		"urn:nxt-opds:publishers",
+		fmt.Sprintf("Publishers (%d)", total),
+	)
 	feed.AddLink(opds.RelSelf, withToken("/opds/publishers", tok), opds.MIMENavigationFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
 	addPaginationLinks(feed, r, offset, limit, total, opds.MIMENavigationFeed)
 
-	now := time.Now()
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
 	for _, pub := range publishers {
 		feed.AddEntry(opds.Entry{
 			ID:      "urn:nxt-opds:publisher:" + pub,
@@ -494,7 +1300,7 @@ func (s *Server) handlePublishers(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
 // handlePublisherBooks serves books filtered by a specific publisher (OPDS 1.x).
@@ -510,19 +1316,313 @@ func (s *Server) handlePublisherBooks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
 	feed := opds.NewAcquisitionFeed(
 		"urn:nxt-opds:publisher:"+publisher,
 		fmt.Sprintf("Publisher: %s (%d)", publisher, total),
 	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleSeries serves the series navigation feed (OPDS 1.x), each entry
+// carrying the number of books in the series.
+// Returns 501 if the backend does not support listing series.
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if s.seriesLister == nil {
+		http.Error(w, "browsing by series not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+
+	series, err := s.seriesLister.Series()
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewNavigationFeed(
+		"urn:nxt-opds:series",
+		fmt.Sprintf("Series (%d)", len(series)),
+	)
+	feed.AddLink(opds.RelSelf, withToken("/opds/series", tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+	for _, se := range series {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:series:" + se.Name,
+			Title:   opds.Text{Value: se.Name},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/series/"+nameSlug(se.Name), tok),
+					Type:  opds.MIMEAcquisitionFeed,
+					Count: se.Count,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleSeriesBooks serves the books of one series in reading order (OPDS 1.x).
+// Returns 404 for an unknown series and 501 if the backend does not support
+// listing series.
+func (s *Server) handleSeriesBooks(w http.ResponseWriter, r *http.Request) {
+	if s.seriesLister == nil {
+		http.Error(w, "browsing by series not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+	series, ok, err := s.resolveSeries(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "series not found", http.StatusNotFound)
+		return
+	}
+	offset, limit := parsePagination(r)
+
+	books, total, err := s.catalog.Search(catalog.SearchQuery{
+		Series:    series,
+		SortBy:    "series_index",
+		SortOrder: "asc",
+		Offset:    offset,
+		Limit:     limit,
+	})
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:series:"+series,
+		fmt.Sprintf("Series: %s (%d)", series, total),
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
 	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
 	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
 	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
 
 	for _, bk := range books {
-		feed.AddEntry(bookToEntry(bk, tok))
+		feed.AddEntry(s.bookEntry(bk, tok))
 	}
 
-	writeOPDS(w, http.StatusOK, feed)
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleYears serves the publication decade navigation feed (OPDS 1.x).
+// Returns 501 if the backend does not support browsing by year.
+func (s *Server) handleYears(w http.ResponseWriter, r *http.Request) {
+	if s.yearBrowser == nil {
+		http.Error(w, "browsing by year not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+
+	decades, err := s.yearBrowser.Decades()
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewNavigationFeed(
+		"urn:nxt-opds:years",
+		fmt.Sprintf("Decades (%d)", len(decades)),
+	)
+	feed.AddLink(opds.RelSelf, withToken("/opds/years", tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+
+	now, done := s.notModified(w, r)
+	if done {
+		return
+	}
+	feed.Updated = opds.AtomDate{Time: now}
+	for _, d := range decades {
+		yearRange := fmt.Sprintf("%d-%d", d.Decade, d.Decade+9)
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:years:" + yearRange,
+			Title:   opds.Text{Value: fmt.Sprintf("%ds", d.Decade)},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/years/"+yearRange, tok),
+					Type:  opds.MIMEAcquisitionFeed,
+					Count: d.Count,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleYearBooks serves books published within a year range (OPDS 1.x).
+// The {range} path segment is either a single year ("1994") or an inclusive
+// span ("1990-1999").
+func (s *Server) handleYearBooks(w http.ResponseWriter, r *http.Request) {
+	if s.yearBrowser == nil {
+		http.Error(w, "browsing by year not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+	yearRange := mux.Vars(r)["range"]
+	from, to, ok := parseYearRange(yearRange)
+	if !ok {
+		http.Error(w, "invalid year range (expected YYYY or YYYY-YYYY)", http.StatusBadRequest)
+		return
+	}
+	offset, limit := parsePagination(r)
+
+	books, total, err := s.yearBrowser.BooksByYearRange(from, to, offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	updated, done := s.notModified(w, r)
+	if done {
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:years:"+yearRange,
+		fmt.Sprintf("Published %s (%d)", yearRange, total),
+	)
+	feed.Updated = opds.AtomDate{Time: updated}
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// parseYearRange parses "YYYY" or "YYYY-YYYY" into an inclusive year span.
+func parseYearRange(s string) (from, to int, ok bool) {
+	fromStr, toStr, found := strings.Cut(s, "-")
+	from, err := strconv.Atoi(fromStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !found {
+		return from, from, true
+	}
+	to, err = strconv.Atoi(toStr)
+	if err != nil || to < from {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// handleLists serves the reading list navigation feed (OPDS 1.x).
+// Returns 501 if the backend does not support reading lists.
+func (s *Server) handleLists(w http.ResponseWriter, r *http.Request) {
+	if s.listManager == nil {
+		http.Error(w, "reading lists not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+
+	lists, err := s.listManager.Lists()
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewNavigationFeed(
+		"urn:nxt-opds:lists",
+		fmt.Sprintf("Lists (%d)", len(lists)),
+	)
+	feed.AddLink(opds.RelSelf, withToken("/opds/lists", tok), opds.MIMENavigationFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+
+	now := s.catalogUpdated()
+	feed.Updated = opds.AtomDate{Time: now}
+	for _, l := range lists {
+		feed.AddEntry(opds.Entry{
+			ID:      "urn:nxt-opds:lists:" + l.ID,
+			Title:   opds.Text{Value: l.Name},
+			Updated: opds.AtomDate{Time: now},
+			Links: []opds.Link{
+				{
+					Rel:   opds.RelCatalogNavigation,
+					Href:  withToken("/opds/lists/"+url.PathEscape(l.ID), tok),
+					Type:  opds.MIMEAcquisitionFeed,
+					Count: l.BookCount,
+				},
+			},
+		})
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleListBooks serves the books on a reading list, in the order they
+// were added (OPDS 1.x).
+func (s *Server) handleListBooks(w http.ResponseWriter, r *http.Request) {
+	if s.listManager == nil {
+		http.Error(w, "reading lists not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	tok := r.URL.Query().Get("token")
+	id := mux.Vars(r)["id"]
+	offset, limit := parsePagination(r)
+
+	l, err := s.listManager.ListByID(id)
+	if err != nil {
+		http.Error(w, "list not found", http.StatusNotFound)
+		return
+	}
+	books, total, err := s.listManager.ListBooks(id, offset, limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := opds.NewAcquisitionFeed(
+		"urn:nxt-opds:lists:"+l.ID,
+		fmt.Sprintf("%s (%d)", l.Name, total),
+	)
+	feed.AddLink(opds.RelSelf, r.URL.RequestURI(), opds.MIMEAcquisitionFeed)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	addPaginationLinks(feed, r, offset, limit, total, opds.MIMEAcquisitionFeed)
+
+	for _, bk := range books {
+		feed.AddEntry(s.bookEntry(bk, tok))
+	}
+
+	s.writeOPDS(w, r, http.StatusOK, feed)
 }
 
 // handleOpenSearch serves the OpenSearch description document.
@@ -544,7 +1644,7 @@ func (s *Server) handleOpenSearch(w http.ResponseWriter, r *http.Request) {
 		Description: "Search the nxt-opds catalog",
 	}
 	desc.URL.Type = opds.MIMEAcquisitionFeed
-	desc.URL.Template = "/opds/search?q={searchTerms}"
+	desc.URL.Template = s.url("/opds/search?q={searchTerms}")
 
 	data, err := xml.MarshalIndent(desc, "", "  ")
 	if err != nil {
@@ -557,6 +1657,17 @@ func (s *Server) handleOpenSearch(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(data)
 }
 
+// handleRobots serves /robots.txt from Options.RobotsTxt, defaulting to a
+// blanket Disallow.
+func (s *Server) handleRobots(w http.ResponseWriter, r *http.Request) {
+	body := s.opts.RobotsTxt
+	if body == "" {
+		body = defaultRobotsTxt
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, body)
+}
+
 // handleHealth serves a simple health-check endpoint.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -565,25 +1676,80 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // bookJSON is the JSON representation of a book for the frontend API.
 type bookJSON struct {
-	ID          string   `json:"id"`
-	Title       string   `json:"title"`
-	Authors     []string `json:"authors"`
-	CoverURL    string   `json:"coverUrl,omitempty"`
-	Tags        []string `json:"tags,omitempty"`
-	Language    string   `json:"language,omitempty"`
-	Publisher   string   `json:"publisher,omitempty"`
-	Summary     string   `json:"summary,omitempty"`
-	Series      string   `json:"series,omitempty"`
-	SeriesIndex string   `json:"seriesIndex,omitempty"`
-	SeriesTotal string   `json:"seriesTotal,omitempty"`
-	Collection  string   `json:"collection,omitempty"`
-	IsRead      bool     `json:"isRead"`
-	Rating      int      `json:"rating"`
-	DownloadURL string   `json:"downloadUrl"`
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	Authors       []string   `json:"authors"`
+	CoverURL      string     `json:"coverUrl,omitempty"`
+	ThumbnailURL  string     `json:"thumbnailUrl,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	Language      string     `json:"language,omitempty"`
+	Publisher     string     `json:"publisher,omitempty"`
+	Summary       string     `json:"summary,omitempty"`
+	Series        string     `json:"series,omitempty"`
+	SeriesIndex   string     `json:"seriesIndex,omitempty"`
+	SeriesTotal   string     `json:"seriesTotal,omitempty"`
+	Collection    string     `json:"collection,omitempty"`
+	Source        string     `json:"source,omitempty"`
+	ISBN          string     `json:"isbn,omitempty"`
+	IsRead        bool       `json:"isRead"`
+	ReadAt        *time.Time `json:"readAt,omitempty"`
+	Rating        int        `json:"rating"`
+	Hidden        bool       `json:"hidden"`
+	Size          int64      `json:"size"`
+	DownloadURL   string     `json:"downloadUrl"`
+	DownloadCount int        `json:"downloadCount"` // 0 when the backend doesn't count downloads
+}
+
+// toBookJSON converts a catalog book to its frontend JSON representation.
+func toBookJSON(bk catalog.Book) bookJSON {
+	j := bookJSON{
+		ID:           bk.ID,
+		Title:        bk.Title,
+		CoverURL:     bk.CoverURL,
+		ThumbnailURL: bk.ThumbnailURL,
+		Tags:         bk.Tags,
+		Language:     bk.Language,
+		Publisher:    bk.Publisher,
+		Summary:      bk.Summary,
+		Series:       bk.Series,
+		SeriesIndex:  bk.SeriesIndex,
+		SeriesTotal:  bk.SeriesTotal,
+		Collection:   bk.Collection,
+		Source:       bk.Source,
+		ISBN:         bk.ISBN(),
+		IsRead:       bk.IsRead,
+		Rating:       bk.Rating,
+		Hidden:       bk.Hidden,
+		Size:         bk.TotalSize(),
+		DownloadURL:  "/opds/books/" + bk.ID + "/download",
+	}
+	if !bk.ReadAt.IsZero() {
+		readAt := bk.ReadAt.UTC()
+		j.ReadAt = &readAt
+	}
+	for _, a := range bk.Authors {
+		j.Authors = append(j.Authors, a.Name)
+	}
+	return j
+}
+
+// bookJSON is toBookJSON plus the book's download count, when the backend
+// counts downloads, with its URLs under Options.BasePath.
+func (s *Server) bookJSON(bk catalog.Book) bookJSON {
+	j := toBookJSON(bk)
+	j.CoverURL, j.ThumbnailURL, j.DownloadURL = s.url(j.CoverURL), s.url(j.ThumbnailURL), s.url(j.DownloadURL)
+	if s.downloadCounter != nil {
+		if n, err := s.downloadCounter.DownloadCount(bk.ID); err == nil {
+			j.DownloadCount = n
+		}
+	}
+	return j
 }
 
 // parseSortParam maps the ?sort= query parameter to SortBy and SortOrder values.
-// Valid values: "added_desc" (default), "added_asc", "title_asc", "title_desc", "series_index".
+// Valid values: "added_desc" (default), "added_asc", "title_asc", "title_desc", "series_index",
+// "size_desc" (largest first), "size_asc", "read_desc" (most recently read first), "read_asc",
+// "relevance" (best search match first).
 func parseSortParam(r *http.Request) (sortBy, sortOrder string) {
 	switch r.URL.Query().Get("sort") {
 	case "title_asc":
@@ -594,15 +1760,42 @@ func parseSortParam(r *http.Request) (sortBy, sortOrder string) {
 		return "added", "asc"
 	case "series_index":
 		return "series_index", "asc"
+	case "size_desc":
+		return "size", "desc"
+	case "size_asc":
+		return "size", "asc"
+	case "read_desc":
+		return "read_at", "desc"
+	case "read_asc":
+		return "read_at", "asc"
+	case "rating_desc":
+		return "rating", "desc"
+	case "rating_asc":
+		return "rating", "asc"
+	case "relevance":
+		return "relevance", ""
 	default: // "added_desc" or empty → newest first
 		return "added", "desc"
 	}
 }
 
+// writeJSONError writes msg as a {"error": msg} JSON body with the given
+// status. Every /api route reports errors this way so that the frontend can
+// decode them; OPDS routes keep plain-text http.Error bodies.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
 // handleAPIBooks serves the full book list as JSON for the web frontend.
 // Supports optional ?q= search query, ?series= series filter, ?author= author filter,
 // ?tag= tag filter, ?publisher= publisher filter, ?collection= collection filter,
-// ?unread=1 filter, ?sort= sort order, and standard ?offset=&limit= pagination.
+// ?language= language filter, ?unread=1 filter, ?minRating= minimum star
+// rating, ?includeHidden=1 to list hidden books too, ?sort= sort order, and
+// standard ?offset=&limit= pagination.
 func (s *Server) handleAPIBooks(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	seriesFilter := r.URL.Query().Get("series")
@@ -610,49 +1803,37 @@ func (s *Server) handleAPIBooks(w http.ResponseWriter, r *http.Request) {
 	tagFilter := r.URL.Query().Get("tag")
 	publisherFilter := r.URL.Query().Get("publisher")
 	collectionFilter := r.URL.Query().Get("collection")
+	languageFilter := strings.ToLower(r.URL.Query().Get("language"))
 	unreadOnly := r.URL.Query().Get("unread") == "1"
+	minRating, _ := strconv.Atoi(r.URL.Query().Get("minRating"))
+	includeHidden := r.URL.Query().Get("includeHidden") == "1"
 	offset, limit := parsePagination(r)
 	sortBy, sortOrder := parseSortParam(r)
 
 	books, total, err := s.catalog.Search(catalog.SearchQuery{
-		Query:      q,
-		Series:     seriesFilter,
-		Author:     authorFilter,
-		Tag:        tagFilter,
-		Publisher:  publisherFilter,
-		Collection: collectionFilter,
-		Offset:     offset,
-		Limit:      limit,
-		UnreadOnly: unreadOnly,
-		SortBy:     sortBy,
-		SortOrder:  sortOrder,
+		Query:         q,
+		Series:        seriesFilter,
+		Author:        authorFilter,
+		Tag:           tagFilter,
+		Publisher:     publisherFilter,
+		Collection:    collectionFilter,
+		Language:      languageFilter,
+		Offset:        offset,
+		Limit:         limit,
+		UnreadOnly:    unreadOnly,
+		MinRating:     minRating,
+		IncludeHidden: includeHidden,
+		SortBy:        sortBy,
+		SortOrder:     sortOrder,
 	})
 	if err != nil {
-		http.Error(w, "catalog error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "catalog error")
 		return
 	}
 
 	result := make([]bookJSON, 0, len(books))
 	for _, bk := range books {
-		j := bookJSON{
-			ID:          bk.ID,
-			Title:       bk.Title,
-			CoverURL:    bk.CoverURL,
-			Tags:        bk.Tags,
-			Language:    bk.Language,
-			Publisher:   bk.Publisher,
-			Summary:     bk.Summary,
-			Series:      bk.Series,
-			SeriesIndex: bk.SeriesIndex,
-			SeriesTotal: bk.SeriesTotal,
-			Collection:  bk.Collection,
-			IsRead:      bk.IsRead,
-			Rating:      bk.Rating,
-			DownloadURL: "/opds/books/" + bk.ID + "/download",
-		}
-		for _, a := range bk.Authors {
-			j.Authors = append(j.Authors, a.Name)
-		}
+		j := s.bookJSON(bk)
 		result = append(result, j)
 	}
 
@@ -663,6 +1844,86 @@ func (s *Server) handleAPIBooks(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// searchGroupSize is the default number of results per group returned by
+// GET /api/search.
+const searchGroupSize = 10
+
+// handleAPISearch serves the universal search box (GET /api/search?q=):
+// books matching the query as for /api/books, plus the authors and series
+// whose names contain it (case-insensitive), each group capped at ?limit=
+// (default searchGroupSize). Series are empty when the backend cannot list
+// them. Returns 400 if q is missing.
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing search query parameter 'q'")
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = searchGroupSize
+	}
+	limit = min(limit, maxPageSize)
+	qLower := strings.ToLower(q)
+
+	books, _, err := s.catalog.Search(catalog.SearchQuery{
+		Query:  q,
+		SortBy: "relevance",
+		Limit:  limit,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "catalog error")
+		return
+	}
+	bookResults := make([]bookJSON, 0, len(books))
+	for _, bk := range books {
+		bookResults = append(bookResults, s.bookJSON(bk))
+	}
+
+	authors, err := allNames(s.catalog.Authors)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "authors query error")
+		return
+	}
+	authorResults := []string{}
+	for _, a := range authors {
+		if len(authorResults) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(a), qLower) {
+			authorResults = append(authorResults, a)
+		}
+	}
+
+	type seriesJSON struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	seriesResults := []seriesJSON{}
+	if s.seriesLister != nil {
+		entries, err := s.seriesLister.Series()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "series query error")
+			return
+		}
+		for _, e := range entries {
+			if len(seriesResults) == limit {
+				break
+			}
+			if strings.Contains(strings.ToLower(e.Name), qLower) {
+				seriesResults = append(seriesResults, seriesJSON{Name: e.Name, Count: e.Count})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"books":   bookResults,
+		"authors": authorResults,
+		"series":  seriesResults,
+	})
+}
+
 // bookUpdateRequest is the JSON body accepted by PATCH /api/books/{id}.
 // All fields are optional; only non-nil fields are applied.
 type bookUpdateRequest struct {
@@ -676,8 +1937,10 @@ type bookUpdateRequest struct {
 	SeriesIndex *string  `json:"seriesIndex"`
 	SeriesTotal *string  `json:"seriesTotal"`
 	Collection  *string  `json:"collection"`
+	Source      *string  `json:"source"`
 	IsRead      *bool    `json:"isRead"`
 	Rating      *int     `json:"rating"`
+	Hidden      *bool    `json:"hidden"`
 }
 
 // handleAPIBook handles GET /api/books/{id} to fetch a single book as JSON.
@@ -687,38 +1950,32 @@ func (s *Server) handleAPIBook(w http.ResponseWriter, r *http.Request) {
 
 	bk, err := s.catalog.BookByID(id)
 	if err != nil {
-		http.Error(w, "book not found", http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "book not found")
 		return
 	}
 
-	j := bookJSON{
-		ID:          bk.ID,
-		Title:       bk.Title,
-		CoverURL:    bk.CoverURL,
-		Tags:        bk.Tags,
-		Language:    bk.Language,
-		Publisher:   bk.Publisher,
-		Summary:     bk.Summary,
-		Series:      bk.Series,
-		SeriesIndex: bk.SeriesIndex,
-		SeriesTotal: bk.SeriesTotal,
-		Collection:  bk.Collection,
-		IsRead:      bk.IsRead,
-		Rating:      bk.Rating,
-		DownloadURL: "/opds/books/" + bk.ID + "/download",
-	}
-	for _, a := range bk.Authors {
-		j.Authors = append(j.Authors, a.Name)
-	}
+	j := s.bookJSON(*bk)
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(j)
 }
 
+// handleAPIRandom handles GET /api/random, returning one book picked at
+// random as JSON. Returns 404 if the catalog is empty.
+func (s *Server) handleAPIRandom(w http.ResponseWriter, r *http.Request) {
+	bk, ok := s.randomBook(w)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(s.bookJSON(*bk))
+}
+
 // handleAPIUpdateBook handles PATCH /api/books/{id} to update book metadata.
 func (s *Server) handleAPIUpdateBook(w http.ResponseWriter, r *http.Request) {
 	if s.updater == nil {
-		http.Error(w, "metadata editing not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "metadata editing not supported by this backend")
 		return
 	}
 
@@ -727,7 +1984,7 @@ func (s *Server) handleAPIUpdateBook(w http.ResponseWriter, r *http.Request) {
 
 	var req bookUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
 		return
 	}
 
@@ -742,35 +1999,21 @@ func (s *Server) handleAPIUpdateBook(w http.ResponseWriter, r *http.Request) {
 		SeriesIndex: req.SeriesIndex,
 		SeriesTotal: req.SeriesTotal,
 		Collection:  req.Collection,
+		Source:      req.Source,
 		IsRead:      req.IsRead,
 		Rating:      req.Rating,
+		Hidden:      req.Hidden,
 	}
 
+	old, _ := s.catalog.BookByID(id)
 	bk, err := s.updater.UpdateBook(id, update)
 	if err != nil {
-		http.Error(w, "update failed: "+err.Error(), http.StatusUnprocessableEntity)
+		writeJSONError(w, http.StatusUnprocessableEntity, "update failed: "+err.Error())
 		return
 	}
+	s.forgetKepubs(old)
 
-	j := bookJSON{
-		ID:          bk.ID,
-		Title:       bk.Title,
-		CoverURL:    bk.CoverURL,
-		Tags:        bk.Tags,
-		Language:    bk.Language,
-		Publisher:   bk.Publisher,
-		Summary:     bk.Summary,
-		Series:      bk.Series,
-		SeriesIndex: bk.SeriesIndex,
-		SeriesTotal: bk.SeriesTotal,
-		Collection:  bk.Collection,
-		IsRead:      bk.IsRead,
-		Rating:      bk.Rating,
-		DownloadURL: "/opds/books/" + bk.ID + "/download",
-	}
-	for _, a := range bk.Authors {
-		j.Authors = append(j.Authors, a.Name)
-	}
+	j := s.bookJSON(*bk)
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(j)
@@ -779,27 +2022,254 @@ func (s *Server) handleAPIUpdateBook(w http.ResponseWriter, r *http.Request) {
 // handleAPIDeleteBook handles DELETE /api/books/{id} to remove a book from the catalog.
 func (s *Server) handleAPIDeleteBook(w http.ResponseWriter, r *http.Request) {
 	if s.deleter == nil {
-		http.Error(w, "deletion not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "deletion not supported by this backend")
 		return
 	}
 
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	bk, _ := s.catalog.BookByID(id)
 	if err := s.deleter.DeleteBook(id); err != nil {
-		http.Error(w, "delete failed: "+err.Error(), http.StatusUnprocessableEntity)
+		writeJSONError(w, http.StatusUnprocessableEntity, "delete failed: "+err.Error())
 		return
 	}
+	s.forgetKepubs(bk)
 
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write([]byte(`{"ok":true}`))
 }
 
+// mergeRequest is the JSON body accepted by POST /api/books/{id}/duplicate-merge.
+type mergeRequest struct {
+	SourceID string `json:"sourceId"`
+}
+
+// handleAPIMergeBooks merges the book given in the request body into the
+// book identified by {id}, returning the merged book as JSON.
+// Returns 404 if either book is unknown, 422 if the merge itself is
+// rejected (e.g. a book merged into itself) and 501 if the backend does
+// not support merging.
+func (s *Server) handleAPIMergeBooks(w http.ResponseWriter, r *http.Request) {
+	if s.merger == nil {
+		writeJSONError(w, http.StatusNotImplemented, "merging not supported by this backend")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.SourceID == "" {
+		writeJSONError(w, http.StatusBadRequest, "sourceId is required")
+		return
+	}
+	for _, bookID := range []string{id, req.SourceID} {
+		if _, err := s.catalog.BookByID(bookID); err != nil {
+			writeJSONError(w, http.StatusNotFound, "book not found")
+			return
+		}
+	}
+
+	bk, err := s.merger.MergeBooks(id, req.SourceID)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "merge failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.bookJSON(*bk))
+}
+
+// listJSON is the JSON representation of a reading list.
+type listJSON struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	BookCount int       `json:"bookCount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func toListJSON(l catalog.List) listJSON {
+	return listJSON{ID: l.ID, Name: l.Name, BookCount: l.BookCount, CreatedAt: l.CreatedAt}
+}
+
+// handleAPILists returns all reading lists as a JSON array.
+// Returns 501 if the backend does not support reading lists.
+func (s *Server) handleAPILists(w http.ResponseWriter, r *http.Request) {
+	if s.listManager == nil {
+		writeJSONError(w, http.StatusNotImplemented, "reading lists not supported by this backend")
+		return
+	}
+	lists, err := s.listManager.Lists()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "lists query error")
+		return
+	}
+	result := make([]listJSON, 0, len(lists))
+	for _, l := range lists {
+		result = append(result, toListJSON(l))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// createListRequest is the JSON body accepted by POST /api/lists.
+type createListRequest struct {
+	Name string `json:"name"`
+}
+
+// handleAPICreateList handles POST /api/lists, creating an empty reading
+// list and returning it as JSON with status 201.
+func (s *Server) handleAPICreateList(w http.ResponseWriter, r *http.Request) {
+	if s.listManager == nil {
+		writeJSONError(w, http.StatusNotImplemented, "reading lists not supported by this backend")
+		return
+	}
+
+	var req createListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	l, err := s.listManager.CreateList(name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "create list failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(toListJSON(*l))
+}
+
+// addToListRequest is the JSON body accepted by POST /api/lists/{id}/books.
+type addToListRequest struct {
+	BookID string `json:"bookId"`
+}
+
+// handleAPIAddToList handles POST /api/lists/{id}/books, appending a book
+// to the reading list and returning the updated list as JSON.
+func (s *Server) handleAPIAddToList(w http.ResponseWriter, r *http.Request) {
+	if s.listManager == nil {
+		writeJSONError(w, http.StatusNotImplemented, "reading lists not supported by this backend")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req addToListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.BookID == "" {
+		writeJSONError(w, http.StatusBadRequest, "bookId is required")
+		return
+	}
+
+	if _, err := s.listManager.ListByID(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "list not found")
+		return
+	}
+	if _, err := s.catalog.BookByID(req.BookID); err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+	if err := s.listManager.AddToList(id, req.BookID); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "add to list failed: "+err.Error())
+		return
+	}
+	l, err := s.listManager.ListByID(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "catalog error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(toListJSON(*l))
+}
+
+// progressJSON is the JSON body of the reading progress endpoints.
+type progressJSON struct {
+	Position float64 `json:"position"`
+}
+
+// handleAPIGetProgress handles GET /api/books/{id}/progress, returning the
+// book's reading position as a 0.0–1.0 fraction.
+func (s *Server) handleAPIGetProgress(w http.ResponseWriter, r *http.Request) {
+	if s.progressTracker == nil {
+		writeJSONError(w, http.StatusNotImplemented, "reading progress not supported by this backend")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := s.catalog.BookByID(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+	position, err := s.progressTracker.GetProgress(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "get progress failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(progressJSON{Position: position})
+}
+
+// handleAPISetProgress handles PUT /api/books/{id}/progress with a JSON body
+// {"position":0.42}. Positions outside 0.0–1.0 are rejected with 400.
+func (s *Server) handleAPISetProgress(w http.ResponseWriter, r *http.Request) {
+	if s.progressTracker == nil {
+		writeJSONError(w, http.StatusNotImplemented, "reading progress not supported by this backend")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	var req struct {
+		Position *float64 `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Position == nil {
+		writeJSONError(w, http.StatusBadRequest, "position is required")
+		return
+	}
+	if *req.Position < 0 || *req.Position > 1 {
+		writeJSONError(w, http.StatusBadRequest, "position must be between 0 and 1")
+		return
+	}
+	if _, err := s.catalog.BookByID(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+	if err := s.progressTracker.SetProgress(id, *req.Position); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "set progress failed: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(progressJSON{Position: *req.Position})
+}
+
 // handleAPIAuthors returns all distinct author names as a JSON array of strings.
 func (s *Server) handleAPIAuthors(w http.ResponseWriter, r *http.Request) {
-	authors, _, err := s.catalog.Authors(0, 10000)
+	authors, err := allNames(s.catalog.Authors)
 	if err != nil {
-		http.Error(w, "authors query error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "authors query error")
 		return
 	}
 	if authors == nil {
@@ -809,11 +2279,47 @@ func (s *Server) handleAPIAuthors(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(authors)
 }
 
+// handleAPIAuthorAlias makes one author name a pseudonym of another, so
+// that both are browsed as one author. The body is
+// {"alias":"Robert Galbraith","author":"J.K. Rowling"}; an empty author
+// removes the alias.
+// Returns 501 if the backend does not support aliases, 400 for a missing
+// alias or an alias of itself, 200 {"ok":true} on success.
+func (s *Server) handleAPIAuthorAlias(w http.ResponseWriter, r *http.Request) {
+	if s.authorAliaser == nil {
+		writeJSONError(w, http.StatusNotImplemented, "author aliases not supported by this backend")
+		return
+	}
+	var req struct {
+		Alias  string `json:"alias"`
+		Author string `json:"author"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	aliasKey := catalog.AuthorKey(req.Alias)
+	if aliasKey == "" {
+		writeJSONError(w, http.StatusBadRequest, "alias is required")
+		return
+	}
+	if aliasKey == catalog.AuthorKey(req.Author) {
+		writeJSONError(w, http.StatusBadRequest, "an author cannot be an alias of itself")
+		return
+	}
+	if err := s.authorAliaser.SetAuthorAlias(req.Alias, req.Author); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "set alias failed: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
 // handleAPITags returns all distinct tag names as a JSON array of strings.
 func (s *Server) handleAPITags(w http.ResponseWriter, r *http.Request) {
-	tags, _, err := s.catalog.Tags(0, 10000)
+	tags, err := allNames(s.catalog.Tags)
 	if err != nil {
-		http.Error(w, "tags query error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "tags query error")
 		return
 	}
 	if tags == nil {
@@ -823,11 +2329,48 @@ func (s *Server) handleAPITags(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(tags)
 }
 
+// relatedTagsSize is the default number of tags returned by
+// GET /api/tags/{tag}/related.
+const relatedTagsSize = 20
+
+// handleAPIRelatedTags returns the tags most often found on the books of a
+// tag as a JSON array of {name, count} objects, count being the number of
+// books carrying both. ?limit= caps the list (default relatedTagsSize, at
+// most maxPageSize).
+// Returns 501 if the backend cannot relate tags.
+func (s *Server) handleAPIRelatedTags(w http.ResponseWriter, r *http.Request) {
+	if s.tagRelater == nil {
+		writeJSONError(w, http.StatusNotImplemented, "related tags not supported by this backend")
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = relatedTagsSize
+	}
+	limit = min(limit, maxPageSize)
+
+	entries, err := s.tagRelater.RelatedTags(mux.Vars(r)["tag"], limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "related tags query error")
+		return
+	}
+	type tagJSON struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	result := make([]tagJSON, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, tagJSON{Name: e.Name, Count: e.Count})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
 // handleAPIPublishers returns all distinct publisher names as a JSON array of strings.
 func (s *Server) handleAPIPublishers(w http.ResponseWriter, r *http.Request) {
-	publishers, _, err := s.catalog.Publishers(0, 10000)
+	publishers, err := allNames(s.catalog.Publishers)
 	if err != nil {
-		http.Error(w, "publishers query error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "publishers query error")
 		return
 	}
 	if publishers == nil {
@@ -841,12 +2384,12 @@ func (s *Server) handleAPIPublishers(w http.ResponseWriter, r *http.Request) {
 // Returns 501 if the backend does not support series listing.
 func (s *Server) handleAPISeries(w http.ResponseWriter, r *http.Request) {
 	if s.seriesLister == nil {
-		http.Error(w, "series listing not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "series listing not supported by this backend")
 		return
 	}
 	entries, err := s.seriesLister.Series()
 	if err != nil {
-		http.Error(w, "series query error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "series query error")
 		return
 	}
 
@@ -862,7 +2405,8 @@ func (s *Server) handleAPISeries(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(result)
 }
 
-// handleCover serves the cached cover image for a book by its ID.
+// handleCover serves the cached cover image for a book by its ID. Covers
+// missing from the cache are read from the book's EPUB instead.
 // Returns 501 if the backend does not support cover serving.
 // Returns 404 if no cover image exists for the given ID.
 func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
@@ -876,10 +2420,56 @@ func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 
 	coverPath, err := s.coverProvider.CoverPath(id)
 	if err != nil {
-		http.Error(w, "cover not found", http.StatusNotFound)
+		if !s.serveEmbeddedCover(w, r, id) && !s.servePlaceholderCover(w, r, id) {
+			http.Error(w, "cover not found", http.StatusNotFound)
+		}
 		return
 	}
+	serveCoverFile(w, r, coverPath)
+}
+
+// servePlaceholderCover serves the Options.PlaceholderCovers image for the
+// format of the primary file of a book without a cover. It reports false,
+// writing nothing, if the book does not exist or no placeholder applies.
+func (s *Server) servePlaceholderCover(w http.ResponseWriter, r *http.Request, id string) bool {
+	if len(s.placeholders) == 0 {
+		return false
+	}
+	bk, err := s.catalog.BookByID(id)
+	if err != nil {
+		return false
+	}
+	path := s.placeholders["default"]
+	if len(bk.Files) > 0 {
+		f := bk.Files[0]
+		if p, ok := s.placeholders[strings.ToLower(f.MIMEType)]; ok {
+			path = p
+		} else if p, ok := s.placeholders[strings.ToLower(strings.TrimPrefix(filepath.Ext(f.Path), "."))]; ok {
+			path = p
+		}
+	}
+	if path == "" {
+		return false
+	}
+	serveCoverFile(w, r, path)
+	return true
+}
+
+// handleThumbnail serves the downscaled cover thumbnail for a book by its ID.
+// When the backend cannot provide a thumbnail (no ThumbnailProvider, or the
+// cover could not be decoded) the full cover is served instead.
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if s.thumbnailProvider != nil {
+		if thumbPath, err := s.thumbnailProvider.ThumbnailPath(mux.Vars(r)["id"]); err == nil {
+			serveCoverFile(w, r, thumbPath)
+			return
+		}
+	}
+	s.handleCover(w, r)
+}
 
+// serveCoverFile writes the image at coverPath with caching headers.
+func serveCoverFile(w http.ResponseWriter, r *http.Request, coverPath string) {
 	f, err := os.Open(coverPath)
 	if err != nil {
 		http.Error(w, "cover unavailable", http.StatusInternalServerError)
@@ -887,51 +2477,115 @@ func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 	}
 	defer f.Close()
 
-	contentType := mime.TypeByExtension(filepath.Ext(coverPath))
-	if contentType == "" {
-		contentType = "image/jpeg"
-	}
-	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Cache-Control", "public, max-age=86400")
-
 	// Use the file's actual mod-time so browsers honour If-Modified-Since
 	// after the cover has been replaced by the user.
 	stat, _ := f.Stat()
 	var modTime time.Time
+	var size int64
 	if stat != nil {
-		modTime = stat.ModTime()
+		modTime, size = stat.ModTime(), stat.Size()
+	}
+	serveCoverContent(w, r, filepath.Base(coverPath), modTime, size, f)
+}
+
+// serveEmbeddedCover serves the cover of a book straight from its EPUB, FB2
+// or CBZ file, read into memory, for covers that were never cached on disk
+// (read-only covers directory). It reports false, writing nothing, if the
+// book has no embedded cover.
+func (s *Server) serveEmbeddedCover(w http.ResponseWriter, r *http.Request, id string) bool {
+	bk, err := s.catalog.BookByID(id)
+	if err != nil || bk.CoverURL == "" {
+		return false
+	}
+	for _, f := range bk.Files {
+		switch f.MIMEType {
+		case opds.MIMEEPub, opds.MIMEKEPub, opds.MIMEFB2, opds.MIMEFB2Zip, opds.MIMECBZ:
+		default:
+			continue
+		}
+		data, ext, err := epub.ReadCover(f.Path)
+		if err != nil {
+			continue
+		}
+		var modTime time.Time
+		if info, err := os.Stat(f.Path); err == nil {
+			modTime = info.ModTime()
+		}
+		serveCoverContent(w, r, id+ext, modTime, int64(len(data)), bytes.NewReader(data))
+		return true
+	}
+	return false
+}
+
+// serveCoverContent writes a cover image named name (its extension picks
+// the content type) of size bytes with caching headers.
+func serveCoverContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, size int64, content io.ReadSeeker) {
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "image/jpeg"
 	}
-	http.ServeContent(w, r, filepath.Base(coverPath), modTime, f)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+
+	// The ETag lets clients revalidate with If-None-Match once max-age has
+	// expired and pick up a replaced cover immediately; http.ServeContent
+	// answers 304 for us.
+	w.Header().Set("ETag", coverETag(size, modTime))
+	http.ServeContent(w, r, name, modTime, content)
+}
+
+// coverETag returns a weak ETag derived from a cover's size and
+// modification time, so revalidating does not read the image. Replacing a
+// cover rewrites its file, which changes the modification time.
+func coverETag(size int64, modTime time.Time) string {
+	return `W/"` + strconv.FormatInt(size, 16) + "-" + strconv.FormatInt(modTime.UnixNano(), 16) + `"`
 }
 
-// maxUploadSize is the maximum file size accepted for upload (100 MiB).
-const maxUploadSize = 100 << 20
+// uploadOverhead is allowed on top of the file size for the rest of an
+// upload's multipart body (boundaries and part headers).
+const uploadOverhead = 1 << 20
+
+// maxCoverSize is the maximum request size accepted for a cover image
+// upload (20 MiB).
+const maxCoverSize = 20 << 20
 
 // handleUpload accepts a multipart/form-data POST with a single file field named "file".
 // It stores the file in the catalog and returns the resulting Book as JSON.
 // Returns 501 if the backend does not support upload.
 func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	if s.uploader == nil {
-		http.Error(w, "upload not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "upload not supported by this backend")
 		return
 	}
 
 	// Limit request body to prevent memory exhaustion
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	limit := catalog.UploadLimit(s.opts.MaxUploadSize)
+	if limit >= 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit+uploadOverhead)
+	}
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "request too large or malformed: "+err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "request too large or malformed: "+err.Error())
 		return
 	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "missing 'file' field in form: "+err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "missing 'file' field in form: "+err.Error())
 		return
 	}
 	// file is an io.ReadCloser; StoreBook will close it
+	if limit >= 0 && header.Size > limit {
+		file.Close()
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file larger than %d bytes", limit))
+		return
+	}
 	book, err := s.uploader.StoreBook(header.Filename, file)
+	if errors.Is(err, catalog.ErrTooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "upload failed: "+err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, "upload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		writeJSONError(w, http.StatusUnprocessableEntity, "upload failed: "+err.Error())
 		return
 	}
 
@@ -940,73 +2594,255 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(book)
 }
 
-// handleAPIConfig returns public server configuration for the web frontend.
+// handleAPIConfig returns server configuration for the web frontend.
 // The response includes the OPDS token (if configured) so that the UI can
-// display the OPDS reader URL with the token for easy copy-paste.
-// Returns 200 with a JSON object.
+// display the OPDS reader URL with the token for easy copy-paste, and the
+// sanitized effective configuration (secrets redacted) under "effective".
+// It sits behind authentication. Returns 200 with a JSON object.
 func (s *Server) handleAPIConfig(w http.ResponseWriter, r *http.Request) {
 	type configJSON struct {
-		OPDSToken string `json:"opdsToken"`
+		OPDSToken string         `json:"opdsToken"`
+		Lending   bool           `json:"lending,omitempty"`
+		Effective map[string]any `json:"effective,omitempty"`
 	}
 	cfg := configJSON{
 		OPDSToken: s.opdsToken,
+		Lending:   s.lender != nil,
+		Effective: s.opts.EffectiveConfig,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(cfg)
 }
 
+// feedCapture is an http.ResponseWriter that keeps a rendered feed in
+// memory so that it can be validated instead of sent.
+type feedCapture struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *feedCapture) Header() http.Header         { return c.header }
+func (c *feedCapture) Write(b []byte) (int, error) { return c.body.Write(b) }
+func (c *feedCapture) WriteHeader(status int)      { c.status = status }
+
+// handleAPIValidateOPDS handles GET /api/validate/opds. It renders the root
+// navigation feed and the first page of /opds/books with their regular
+// handlers, runs each through opds.Validate and returns a JSON report:
+//
+//	{"valid": false, "feeds": [{"path": "/opds", "issues": [...]}, ...]}
+func (s *Server) handleAPIValidateOPDS(w http.ResponseWriter, r *http.Request) {
+	type feedReport struct {
+		Path   string       `json:"path"`
+		Status int          `json:"status"`
+		Issues []opds.Issue `json:"issues"`
+	}
+	report := struct {
+		Valid bool         `json:"valid"`
+		Feeds []feedReport `json:"feeds"`
+	}{Valid: true}
+
+	feeds := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/opds", s.handleRoot},
+		{"/opds/books", s.handleAllBooks},
+	}
+	for _, f := range feeds {
+		req := r.Clone(r.Context())
+		req.URL = &url.URL{Path: f.path}
+		// A browser revalidating the report must not turn the feeds
+		// into 304 answers with nothing to validate.
+		req.Header.Del("If-Modified-Since")
+		req.Header.Del("If-None-Match")
+		if tok := r.URL.Query().Get("token"); tok != "" {
+			req.URL.RawQuery = url.Values{"token": {tok}}.Encode()
+		}
+		rec := &feedCapture{header: make(http.Header), status: http.StatusOK}
+		f.handler(rec, req)
+
+		fr := feedReport{Path: f.path, Status: rec.status, Issues: []opds.Issue{}}
+		if rec.status != http.StatusOK {
+			fr.Issues = append(fr.Issues, opds.Issue{Path: "feed", Message: fmt.Sprintf("feed answered HTTP %d", rec.status)})
+		} else if issues := opds.Validate(rec.body.Bytes()); issues != nil {
+			fr.Issues = issues
+		}
+		if len(fr.Issues) > 0 {
+			report.Valid = false
+		}
+		report.Feeds = append(report.Feeds, fr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// capabilitiesJSON reports which optional catalog interfaces the active
+// backend implements.
+type capabilitiesJSON struct {
+	Upload        bool `json:"upload"`
+	Update        bool `json:"update"`
+	Delete        bool `json:"delete"`
+	Cover         bool `json:"cover"`
+	CoverUpdate   bool `json:"coverUpdate"`
+	Refresh       bool `json:"refresh"`
+	Series        bool `json:"series"`
+	Years         bool `json:"years"`
+	Backup        bool `json:"backup"`
+	Merge         bool `json:"merge"`
+	Lists         bool `json:"lists"`
+	Progress      bool `json:"progress"`
+	Random        bool `json:"random"`
+	Downloads     bool `json:"downloads"`
+	ResetPersonal bool `json:"resetPersonal"`
+	PurgeCovers   bool `json:"purgeCovers"`
+	AuthorAliases bool `json:"authorAliases"`
+	RelatedTags   bool `json:"relatedTags"`
+}
+
+// capabilities derives the capability set from the optional interfaces
+// detected in New.
+func (s *Server) capabilities() capabilitiesJSON {
+	return capabilitiesJSON{
+		Upload:        s.uploader != nil,
+		Update:        s.updater != nil,
+		Delete:        s.deleter != nil,
+		Cover:         s.coverProvider != nil,
+		CoverUpdate:   s.coverUpdater != nil,
+		Refresh:       s.refresher != nil,
+		Series:        s.seriesLister != nil,
+		Years:         s.yearBrowser != nil,
+		Backup:        s.backupper != nil,
+		Merge:         s.merger != nil,
+		Lists:         s.listManager != nil,
+		Progress:      s.progressTracker != nil,
+		Random:        s.randomPicker != nil,
+		Downloads:     s.downloadCounter != nil,
+		ResetPersonal: s.personalResetter != nil,
+		PurgeCovers:   s.coverPurger != nil,
+		AuthorAliases: s.authorAliaser != nil,
+		RelatedTags:   s.tagRelater != nil,
+	}
+}
+
+// handleAPICapabilities returns the optional features supported by the
+// catalog backend so that clients can hide unavailable actions.
+func (s *Server) handleAPICapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.capabilities())
+}
+
 // handleAPIRefresh triggers an on-demand catalog refresh.
 // Returns 501 if the backend does not support refresh.
 // Returns 200 {"ok":true} on success, 500 on backend error.
 func (s *Server) handleAPIRefresh(w http.ResponseWriter, r *http.Request) {
 	if s.refresher == nil {
-		http.Error(w, "refresh not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "refresh not supported by this backend")
 		return
 	}
 	if err := s.refresher.Refresh(); err != nil {
-		http.Error(w, "refresh failed: "+err.Error(), http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "refresh failed: "+err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write([]byte(`{"ok":true}`))
 }
 
+// handleAPIResetPersonal clears the read state, ratings and reading progress
+// of every book, e.g. before sharing the catalog. As this cannot be undone,
+// the body must be {"confirm":true}.
+// Returns 501 if the backend does not support it, 400 without confirmation,
+// 200 {"ok":true,"reset":N} with the number of books changed on success.
+func (s *Server) handleAPIResetPersonal(w http.ResponseWriter, r *http.Request) {
+	if s.personalResetter == nil {
+		writeJSONError(w, http.StatusNotImplemented, "resetting personal data not supported by this backend")
+		return
+	}
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Confirm {
+		writeJSONError(w, http.StatusBadRequest, `confirmation required: send {"confirm":true}`)
+		return
+	}
+	n, err := s.personalResetter.ResetPersonalData()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "reset failed: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "reset": n})
+}
+
+// handleAPIPurgeCovers removes cached covers and thumbnails whose book is no
+// longer in the catalog. With ?dryRun=1 the orphans are only counted.
+// Returns 501 if the backend does not support it, 200
+// {"ok":true,"removed":N} with the number of files removed on success.
+func (s *Server) handleAPIPurgeCovers(w http.ResponseWriter, r *http.Request) {
+	if s.coverPurger == nil {
+		writeJSONError(w, http.StatusNotImplemented, "purging covers not supported by this backend")
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "1"
+	n, err := s.coverPurger.PruneCovers(dryRun)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "purge failed: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "removed": n, "dryRun": dryRun})
+}
+
 // handleAPIUpdateCover replaces the cover image for a book with the uploaded file.
 // Accepts a multipart/form-data POST with a field named "cover".
 // Returns 501 if the backend does not support cover updates.
 // Returns 200 {"ok":true} on success.
 func (s *Server) handleAPIUpdateCover(w http.ResponseWriter, r *http.Request) {
 	if s.coverUpdater == nil {
-		http.Error(w, "cover update not supported by this backend", http.StatusNotImplemented)
+		writeJSONError(w, http.StatusNotImplemented, "cover update not supported by this backend")
 		return
 	}
 
 	id := mux.Vars(r)["id"]
 
-	// Limit to 20 MB for cover images.
-	if err := r.ParseMultipartForm(20 << 20); err != nil {
-		http.Error(w, "invalid form data", http.StatusBadRequest)
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoverSize)
+	if err := r.ParseMultipartForm(maxCoverSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "cover image too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid form data")
 		return
 	}
 
-	file, header, err := r.FormFile("cover")
+	file, _, err := r.FormFile("cover")
 	if err != nil {
-		http.Error(w, "missing cover field", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "missing cover field")
 		return
 	}
 	defer file.Close()
 
-	// Determine extension from Content-Type header, then fall back to filename.
-	ext := imageExtFromMIME(header.Header.Get("Content-Type"))
-	if ext == "" {
-		ext = strings.ToLower(filepath.Ext(header.Filename))
+	// Trust the bytes, not the client's Content-Type or file name: a PDF or
+	// an HTML page named cover.jpg must not become the cover.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "read cover: "+err.Error())
+		return
 	}
+	head = head[:n]
+	ext := imageExtFromMIME(http.DetectContentType(head))
 	if ext == "" {
-		ext = ".jpg"
+		writeJSONError(w, http.StatusUnsupportedMediaType, "cover must be a JPEG, PNG, GIF or WebP image")
+		return
 	}
 
-	if err := s.coverUpdater.UpdateCover(id, io.NopCloser(file), ext); err != nil {
-		http.Error(w, "update cover: "+err.Error(), http.StatusInternalServerError)
+	src := io.MultiReader(bytes.NewReader(head), file)
+	if err := s.coverUpdater.UpdateCover(id, io.NopCloser(src), ext); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "update cover: "+err.Error())
 		return
 	}
 
@@ -1046,6 +2882,7 @@ func imageExtFromMIME(mimeType string) string {
 // handleDownload serves the raw file for a book's acquisition link.
 // Query param "path" is the filesystem path stored in the catalog File entry.
 // Only files inside the catalog root are served (path traversal prevention).
+// In lending mode the URL must carry the signature issued by handleAPIBorrow.
 func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -1078,6 +2915,20 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "file not found for this book", http.StatusNotFound)
 		return
 	}
+	if s.downloadBlocked(matched.Path) {
+		http.Error(w, "downloads of this format are disabled", http.StatusForbidden)
+		return
+	}
+	if s.lender != nil && !s.lender.valid(bk.ID, matched.Path, r.URL.Query()) {
+		http.Error(w, "borrow this book first", http.StatusForbidden)
+		return
+	}
+	// A KEPUB already is what ?format=kepub asks for: serve it as is.
+	kepub := r.URL.Query().Get("format") == "kepub" && matched.MIMEType != opds.MIMEKEPub
+	if kepub && matched.MIMEType != opds.MIMEEPub {
+		http.Error(w, "only EPUB files can be converted to kepub", http.StatusBadRequest)
+		return
+	}
 
 	f, err := os.Open(matched.Path)
 	if err != nil {
@@ -1086,6 +2937,33 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer f.Close()
 
+	// The real modification time gives clients a Last-Modified validator,
+	// so If-Range lets a reader resume an interrupted download safely.
+	var modTime time.Time
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	// Count whole-file downloads only, once the response status is known:
+	// HEAD requests, 304 revalidations and the 206 answers of a reader
+	// resuming or streaming with Range requests are not new downloads. A
+	// failure to record the download does not fail it.
+	if s.downloadCounter != nil && r.Method == http.MethodGet {
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		defer func() {
+			if sw.status == http.StatusOK {
+				_ = s.downloadCounter.RecordDownload(bk.ID)
+			}
+		}()
+	}
+
+	if kepub {
+		s.serveKepub(w, r, matched.Path, modTime, size)
+		return
+	}
+
 	contentType := matched.MIMEType
 	if contentType == "" {
 		contentType = mime.TypeByExtension(filepath.Ext(matched.Path))
@@ -1096,11 +2974,205 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Disposition",
 		`attachment; filename="`+filepath.Base(matched.Path)+`"`)
 
-	http.ServeContent(w, r, filepath.Base(matched.Path), time.Time{}, f)
+	http.ServeContent(w, r, filepath.Base(matched.Path), modTime, f)
+}
+
+// statusWriter records the status code sent through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// handleAPIBorrow lends a book file (GET /api/books/{id}/borrow?path=…,
+// defaulting to the first file): it issues a download URL valid for the
+// loan period. OPDS readers following a borrow link get an acquisition feed
+// whose entry links to that URL; clients asking for JSON get
+// {"href": …, "expiresAt": …}. Returns 404 when lending mode is off or the
+// book or file does not exist.
+func (s *Server) handleAPIBorrow(w http.ResponseWriter, r *http.Request) {
+	if s.lender == nil {
+		writeJSONError(w, http.StatusNotFound, "lending is not enabled")
+		return
+	}
+	tok := r.URL.Query().Get("token")
+	bk, err := s.catalog.BookByID(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+	lendable := s.downloadable(*bk)
+	reqPath := r.URL.Query().Get("path")
+	if reqPath == "" && len(lendable.Files) > 0 {
+		reqPath = lendable.Files[0].Path
+	}
+	var matched *catalog.File
+	for i := range lendable.Files {
+		if lendable.Files[i].Path == reqPath {
+			matched = &lendable.Files[i]
+			break
+		}
+	}
+	if matched == nil {
+		writeJSONError(w, http.StatusNotFound, "file not found for this book")
+		return
+	}
+
+	href, expires := s.lender.downloadURL(bk.ID, matched.Path)
+	href = withToken(href, tok)
+	if acceptsJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Href      string    `json:"href"`
+			ExpiresAt time.Time `json:"expiresAt"`
+		}{s.url(href), expires.UTC()})
+		return
+	}
+
+	loan := *bk
+	loan.Files = []catalog.File{*matched}
+	entry := bookToEntry(loan, tok, s.linkTitle)
+	for i, l := range entry.Links {
+		if l.Rel == opds.RelAcquisition {
+			entry.Links[i].Href = href
+		}
+	}
+	feed := opds.NewAcquisitionFeed("urn:nxt-opds:borrow:"+bk.ID, bk.Title)
+	feed.AddLink(opds.RelStart, withToken("/opds", tok), opds.MIMENavigationFeed)
+	feed.AddEntry(entry)
+	s.writeOPDS(w, r, http.StatusOK, feed)
+}
+
+// handleAPIBookResource streams a single entry from inside a book's EPUB
+// (GET /api/books/{id}/resource?path=OEBPS/chapter1.xhtml) for in-browser
+// reading. Returns 400 for paths escaping the archive and 404 when the book,
+// its EPUB file or the entry does not exist.
+func (s *Server) handleAPIBookResource(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	bk, err := s.catalog.BookByID(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+
+	epubPath := epubFile(*bk)
+	if epubPath == "" {
+		writeJSONError(w, http.StatusNotFound, "book has no EPUB file")
+		return
+	}
+
+	res, err := epub.OpenResource(epubPath, r.URL.Query().Get("path"))
+	switch {
+	case errors.Is(err, epub.ErrInvalidResourcePath):
+		writeJSONError(w, http.StatusBadRequest, "invalid resource path")
+		return
+	case errors.Is(err, epub.ErrResourceNotFound):
+		writeJSONError(w, http.StatusNotFound, "resource not found")
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, "cannot read book file")
+		return
+	}
+	defer res.Close()
+
+	w.Header().Set("Content-Type", res.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(res.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Book content is served from our origin; never let it run scripts.
+	w.Header().Set("Content-Security-Policy", "script-src 'none'")
+	_, _ = io.Copy(w, res)
+}
+
+// epubFile returns the path of the book's EPUB (or KEPUB) file, or "" if
+// it has none.
+func epubFile(bk catalog.Book) string {
+	for _, f := range bk.Files {
+		if f.MIMEType == opds.MIMEEPub || f.MIMEType == opds.MIMEKEPub || strings.EqualFold(filepath.Ext(f.Path), ".epub") {
+			return f.Path
+		}
+	}
+	return ""
+}
+
+// handleAPIBookManifest serves a Readium Web Publication Manifest for a
+// book's EPUB (GET /api/books/{id}/manifest.json): the OPDS 2.0 metadata,
+// the spine as readingOrder and the other manifest entries as resources,
+// each linking to the resource endpoint. Returns 404 when the book or its
+// EPUB file does not exist.
+func (s *Server) handleAPIBookManifest(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	id := mux.Vars(r)["id"]
+
+	bk, err := s.catalog.BookByID(id)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+	epubPath := epubFile(*bk)
+	if epubPath == "" {
+		writeJSONError(w, http.StatusNotFound, "book has no EPUB file")
+		return
+	}
+	contents, err := epub.ReadContents(epubPath)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "cannot read book file")
+		return
+	}
+
+	link := func(it epub.ContentItem) opds2.Link {
+		q := url.Values{"path": {it.Path}}
+		if tok != "" {
+			q.Set("token", tok)
+		}
+		l := opds2.Link{
+			Href: s.url("/api/books/" + id + "/resource?" + q.Encode()),
+			Type: it.MediaType,
+		}
+		switch {
+		case slices.Contains(it.Properties, "cover-image"):
+			l.Rel = "cover"
+		case slices.Contains(it.Properties, "nav"):
+			l.Rel = "contents"
+		}
+		return l
+	}
+	m := opds2.Manifest{
+		Context:  opds2.ManifestContext,
+		Metadata: bookToPublication(*bk, tok, s.linkTitle).Metadata,
+		Links: []opds2.Link{
+			{Rel: "self", Href: s.url(withToken("/api/books/"+id+"/manifest.json", tok)), Type: opds2.MIMEManifest},
+		},
+		ReadingOrder: []opds2.Link{},
+	}
+	for _, it := range contents.ReadingOrder {
+		m.ReadingOrder = append(m.ReadingOrder, link(it))
+	}
+	for _, it := range contents.Resources {
+		m.Resources = append(m.Resources, link(it))
+	}
+
+	w.Header().Set("Content-Type", opds2.MIMEManifest)
+	_ = json.NewEncoder(w).Encode(m)
 }
 
 // writeOPDS2 serializes an OPDS 2.0 feed to JSON and writes it to the response.
-func writeOPDS2(w http.ResponseWriter, status int, feed *opds2.Feed) {
+func (s *Server) writeOPDS2(w http.ResponseWriter, status int, feed *opds2.Feed) {
+	s.mountFeed2(feed)
 	w.Header().Set("Content-Type", opds2.MIMEFeed+"; charset=utf-8")
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
@@ -1108,9 +3180,31 @@ func writeOPDS2(w http.ResponseWriter, status int, feed *opds2.Feed) {
 	_ = enc.Encode(feed)
 }
 
+// bookPublication is the OPDS 2.0 counterpart of bookEntry.
+func (s *Server) bookPublication(bk catalog.Book, tok string) opds2.Publication {
+	pub := bookToPublication(s.downloadable(bk), tok, s.linkTitle)
+	if rating, ok := s.rating(bk); ok {
+		pub.Metadata.Rating = rating
+	}
+	if s.lender != nil {
+		for i, l := range pub.Links {
+			if l.Rel == opds.RelAcquisition {
+				pub.Links[i] = opds2.Link{
+					Rel:   opds.RelAcquisitionBorrow,
+					Href:  borrowHref(bk.ID, l.Href),
+					Type:  opds.MIMEAcquisitionFeed,
+					Title: l.Title,
+				}
+			}
+		}
+	}
+	return pub
+}
+
 // bookToPublication converts a catalog.Book to an opds2.Publication.
 // tok is the OPDS authentication token to append to all URLs (may be empty).
-func bookToPublication(b catalog.Book, tok string) opds2.Publication {
+// linkTitle is the acquisition link title layout (see acquisitionTitle).
+func bookToPublication(b catalog.Book, tok, linkTitle string) opds2.Publication {
 	pub := opds2.Publication{
 		Metadata: opds2.PubMetadata{
 			Type:        "http://schema.org/Book",
@@ -1120,8 +3214,13 @@ func bookToPublication(b catalog.Book, tok string) opds2.Publication {
 			Description: b.Summary,
 		},
 	}
+	if isbn := b.ISBN(); isbn != "" {
+		pub.Metadata.Identifier = "urn:isbn:" + isbn
+	}
 
-	if b.Language != "" {
+	if len(b.Languages) > 1 {
+		pub.Metadata.Language = b.Languages
+	} else if b.Language != "" {
 		pub.Metadata.Language = b.Language
 	}
 
@@ -1167,9 +3266,11 @@ func bookToPublication(b catalog.Book, tok string) opds2.Publication {
 	// Acquisition links
 	for _, f := range b.Files {
 		pub.Links = append(pub.Links, opds2.Link{
-			Rel:  "http://opds-spec.org/acquisition",
-			Href: withToken("/opds/books/"+b.ID+"/download?path="+url.QueryEscape(f.Path), tok),
-			Type: f.MIMEType,
+			Rel:    "http://opds-spec.org/acquisition",
+			Href:   withToken("/opds/books/"+b.ID+"/download?path="+url.QueryEscape(f.Path), tok),
+			Type:   f.MIMEType,
+			Title:  acquisitionTitle(linkTitle, f),
+			Length: f.Size,
 		})
 	}
 
@@ -1192,9 +3293,16 @@ func bookToPublication(b catalog.Book, tok string) opds2.Publication {
 	return pub
 }
 
-// addPaginationLinks2 appends OPDS 2.0 pagination links to a feed.
+// addPaginationLinks2 appends OPDS 2.0 pagination links to a feed and fills
+// in the numberOfItems/itemsPerPage/currentPage (1-based) metadata.
 func addPaginationLinks2(feed *opds2.Feed, r *http.Request, offset, limit, total int) {
-	if total <= 0 || limit <= 0 {
+	if limit <= 0 {
+		return
+	}
+	feed.Metadata.NumberOfItems = total
+	feed.Metadata.ItemsPerPage = limit
+	feed.Metadata.CurrentPage = offset/limit + 1
+	if total <= 0 {
 		return
 	}
 	lastOffset := ((total - 1) / limit) * limit
@@ -1212,6 +3320,27 @@ func addPaginationLinks2(feed *opds2.Feed, r *http.Request, offset, limit, total
 	feed.Links = append(feed.Links, opds2.Link{Rel: "last", Href: paginationLink(r, lastOffset, limit), Type: opds2.MIMEFeed})
 }
 
+// addPageLinks2 is addPageLinks for OPDS 2.0: an approximate total is not
+// advertised as numberOfItems.
+func addPageLinks2(feed *opds2.Feed, r *http.Request, offset, limit int, page catalog.Page) {
+	if !page.Approximate {
+		addPaginationLinks2(feed, r, offset, limit, page.Total)
+		return
+	}
+	if limit <= 0 {
+		return
+	}
+	feed.Metadata.ItemsPerPage = limit
+	feed.Metadata.CurrentPage = offset/limit + 1
+	feed.Links = append(feed.Links, opds2.Link{Rel: "first", Href: paginationLink(r, 0, limit), Type: opds2.MIMEFeed})
+	if offset > 0 {
+		feed.Links = append(feed.Links, opds2.Link{Rel: "previous", Href: paginationLink(r, max(offset-limit, 0), limit), Type: opds2.MIMEFeed})
+	}
+	if len(page.Items) == limit {
+		feed.Links = append(feed.Links, opds2.Link{Rel: "next", Href: paginationLink(r, offset+limit, limit), Type: opds2.MIMEFeed})
+	}
+}
+
 // handleOPDS2Root serves the OPDS 2.0 root navigation feed.
 func (s *Server) handleOPDS2Root(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
@@ -1224,13 +3353,14 @@ func (s *Server) handleOPDS2Root(w http.ResponseWriter, r *http.Request) {
 		},
 		Navigation: []opds2.NavItem{
 			{Title: "Tous les livres", Href: withToken("/opds/v2/publications", tok), Type: opds2.MIMEFeed, Rel: "current"},
+			{Title: "Récemment ajoutés", Href: withToken("/opds/v2/recent", tok), Type: opds2.MIMEFeed, Rel: "current"},
 			{Title: "Par auteur", Href: withToken("/opds/v2/authors", tok), Type: opds2.MIMEFeed, Rel: "current"},
 			{Title: "Par genre", Href: withToken("/opds/v2/tags", tok), Type: opds2.MIMEFeed, Rel: "current"},
 			{Title: "Par éditeur", Href: withToken("/opds/v2/publishers", tok), Type: opds2.MIMEFeed, Rel: "current"},
 			{Title: "Non lus", Href: withToken("/opds/v2/unread", tok), Type: opds2.MIMEFeed, Rel: "current"},
 		},
 	}
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2Unread serves the OPDS 2.0 acquisition feed filtered to unread books.
@@ -1263,40 +3393,181 @@ func (s *Server) handleOPDS2Unread(w http.ResponseWriter, r *http.Request) {
 	addPaginationLinks2(feed, r, offset, limit, total)
 
 	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
+	}
+
+	s.writeOPDS2(w, http.StatusOK, feed)
+}
+
+// handleOPDS2Recent serves the OPDS 2.0 counterpart of handleRecent: the N
+// most recently added books, newest first, N being ?limit= (default
+// defaultRecentSize, capped at maxPageSize).
+func (s *Server) handleOPDS2Recent(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = defaultRecentSize
+	}
+	limit = min(limit, maxPageSize)
+
+	books, err := s.recentBooks(limit)
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
+
+	feed := &opds2.Feed{
+		Metadata: opds2.FeedMetadata{Title: "Récemment ajoutés"},
+		Links: []opds2.Link{
+			{Rel: "self", Href: r.URL.RequestURI(), Type: opds2.MIMEFeed},
+			{Rel: "start", Href: withToken("/opds/v2", tok), Type: opds2.MIMEFeed},
+		},
+	}
+	for _, bk := range books {
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
+}
+
+// recentBooks returns the limit most recently added books, newest first.
+func (s *Server) recentBooks(limit int) ([]catalog.Book, error) {
+	books, _, err := s.catalog.Search(catalog.SearchQuery{
+		Limit:     limit,
+		SortBy:    "added",
+		SortOrder: "desc",
+	})
+	return books, err
 }
 
-// handleOPDS2Publications serves the OPDS 2.0 acquisition feed with all books.
+// handleOPDS2Publications serves the OPDS 2.0 acquisition feed with all
+// books, or those in one language with ?language=. The feed carries a
+// language facet; its first unfiltered page also groups the recently added
+// books and the languages, previewing their sub-feeds.
 func (s *Server) handleOPDS2Publications(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
+	language := strings.ToLower(r.URL.Query().Get("language"))
 	offset, limit := parsePagination(r)
 
-	books, total, err := s.catalog.AllBooks(offset, limit)
+	var books []catalog.Book
+	var total int
+	var err error
+	if language != "" {
+		books, total, err = s.catalog.Search(catalog.SearchQuery{
+			Language: language,
+			Offset:   offset,
+			Limit:    limit,
+		})
+	} else {
+		books, total, err = s.catalog.AllBooks(offset, limit)
+	}
 	if err != nil {
 		http.Error(w, "catalog error", http.StatusInternalServerError)
 		return
 	}
 
+	title, self := fmt.Sprintf("Tous les livres (%d)", total), "/opds/v2/publications"
+	if language != "" {
+		title = fmt.Sprintf("Tous les livres – %s (%d)", language, total)
+		self += "?language=" + url.QueryEscape(language)
+	}
 	feed := &opds2.Feed{
 		Metadata: opds2.FeedMetadata{
-			Title:         fmt.Sprintf("Tous les livres (%d)", total),
+			Title:         title,
 			NumberOfItems: total,
 		},
 		Links: []opds2.Link{
-			{Rel: "self", Href: withToken("/opds/v2/publications", tok), Type: opds2.MIMEFeed},
+			{Rel: "self", Href: withToken(self, tok), Type: opds2.MIMEFeed},
 			{Rel: "start", Href: withToken("/opds/v2", tok), Type: opds2.MIMEFeed},
 		},
 	}
 	addPaginationLinks2(feed, r, offset, limit, total)
 
+	langs := s.languages()
+	if len(langs) > 0 {
+		feed.AddFacet("Langue", "Toutes", withToken("/opds/v2/publications", tok), 0, language == "")
+		for _, l := range langs {
+			href := "/opds/v2/publications?language=" + url.QueryEscape(l.Language)
+			feed.AddFacet("Langue", l.Language, withToken(href, tok), l.Count, l.Language == language)
+		}
+	}
+
+	if offset == 0 && language == "" {
+		recent, err := s.recentBooks(groupSize)
+		if err != nil {
+			http.Error(w, "catalog error", http.StatusInternalServerError)
+			return
+		}
+		if len(recent) > 0 {
+			group := opds2.Group{
+				Metadata: opds2.FeedMetadata{Title: "Récemment ajoutés"},
+				Links:    []opds2.Link{{Rel: "self", Href: withToken("/opds/v2/recent", tok), Type: opds2.MIMEFeed}},
+			}
+			for _, bk := range recent {
+				group.Publications = append(group.Publications, s.bookPublication(bk, tok))
+			}
+			feed.Groups = append(feed.Groups, group)
+		}
+		if len(langs) > 0 {
+			group := opds2.Group{Metadata: opds2.FeedMetadata{Title: "Langues", NumberOfItems: len(langs)}}
+			for _, l := range langs {
+				href := "/opds/v2/publications?language=" + url.QueryEscape(l.Language)
+				group.Navigation = append(group.Navigation, opds2.NavItem{
+					Title: fmt.Sprintf("%s (%d)", l.Language, l.Count),
+					Href:  withToken(href, tok),
+					Type:  opds2.MIMEFeed,
+				})
+			}
+			feed.Groups = append(feed.Groups, group)
+		}
+	}
+
 	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
+	}
+
+	s.writeOPDS2(w, http.StatusOK, feed)
+}
+
+// languages returns the languages of the catalog's books with their counts,
+// or none when the backend cannot list them.
+func (s *Server) languages() []catalog.LanguageEntry {
+	if s.languageLister == nil {
+		return nil
+	}
+	langs, err := s.languageLister.Languages()
+	if err != nil {
+		return nil
+	}
+	return langs
+}
+
+// handleOPDS2Publication serves an OPDS 2.0 feed holding a single book, the
+// counterpart of handleBook. An unknown id answers 404 with a JSON body, as
+// OPDS 2.0 clients expect JSON from every /opds/v2 route.
+func (s *Server) handleOPDS2Publication(w http.ResponseWriter, r *http.Request) {
+	tok := r.URL.Query().Get("token")
+	id := mux.Vars(r)["id"]
+
+	bk, err := s.catalog.BookByID(id)
+	if err != nil || bk.Hidden {
+		writeJSONError(w, http.StatusNotFound, "book not found")
+		return
+	}
+
+	feed := &opds2.Feed{
+		Metadata: opds2.FeedMetadata{
+			Title:         bk.Title,
+			NumberOfItems: 1,
+		},
+		Links: []opds2.Link{
+			{Rel: "self", Href: withToken("/opds/v2/publications/"+id, tok), Type: opds2.MIMEFeed},
+			{Rel: "start", Href: withToken("/opds/v2", tok), Type: opds2.MIMEFeed},
+		},
+		Publications: []opds2.Publication{s.bookPublication(*bk, tok)},
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2Search performs a catalog search and returns an OPDS 2.0 feed.
@@ -1310,7 +3581,7 @@ func (s *Server) handleOPDS2Search(w http.ResponseWriter, r *http.Request) {
 
 	offset, limit := parsePagination(r)
 
-	books, total, err := s.catalog.Search(catalog.SearchQuery{
+	page, err := s.searchPage(catalog.SearchQuery{
 		Query:  q,
 		Offset: offset,
 		Limit:  limit,
@@ -1322,27 +3593,26 @@ func (s *Server) handleOPDS2Search(w http.ResponseWriter, r *http.Request) {
 
 	feed := &opds2.Feed{
 		Metadata: opds2.FeedMetadata{
-			Title:         fmt.Sprintf("Recherche : %s (%d résultats)", q, total),
-			NumberOfItems: total,
+			Title: fmt.Sprintf("Recherche : %s (%s résultats)", q, resultCount(page)),
 		},
 		Links: []opds2.Link{
 			{Rel: "self", Href: r.URL.RequestURI(), Type: opds2.MIMEFeed},
 			{Rel: "start", Href: withToken("/opds/v2", tok), Type: opds2.MIMEFeed},
 		},
 	}
-	addPaginationLinks2(feed, r, offset, limit, total)
+	addPageLinks2(feed, r, offset, limit, page)
 
-	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+	for _, bk := range page.Items {
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2Authors serves the OPDS 2.0 author navigation feed.
 func (s *Server) handleOPDS2Authors(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
-	offset, limit := parsePagination(r)
+	offset, limit := s.navPagination(r)
 
 	authors, total, err := s.catalog.Authors(offset, limit)
 	if err != nil {
@@ -1365,20 +3635,24 @@ func (s *Server) handleOPDS2Authors(w http.ResponseWriter, r *http.Request) {
 	for _, name := range authors {
 		feed.Navigation = append(feed.Navigation, opds2.NavItem{
 			Title: name,
-			Href:  withToken("/opds/v2/authors/"+url.PathEscape(name), tok),
+			Href:  withToken("/opds/v2/authors/"+nameSlug(name), tok),
 			Type:  opds2.MIMEFeed,
 			Rel:   "subsection",
 		})
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2AuthorBooks serves an OPDS 2.0 acquisition feed for a specific author.
 func (s *Server) handleOPDS2AuthorBooks(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
 	vars := mux.Vars(r)
-	author, _ := url.PathUnescape(vars["author"])
+	author, err := s.resolveAuthor(vars["author"])
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
 	offset, limit := parsePagination(r)
 
 	books, total, err := s.catalog.BooksByAuthor(author, offset, limit)
@@ -1400,16 +3674,16 @@ func (s *Server) handleOPDS2AuthorBooks(w http.ResponseWriter, r *http.Request)
 	addPaginationLinks2(feed, r, offset, limit, total)
 
 	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2Tags serves the OPDS 2.0 tag/genre navigation feed.
 func (s *Server) handleOPDS2Tags(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
-	offset, limit := parsePagination(r)
+	offset, limit := s.navPagination(r)
 
 	tags, total, err := s.catalog.Tags(offset, limit)
 	if err != nil {
@@ -1432,20 +3706,24 @@ func (s *Server) handleOPDS2Tags(w http.ResponseWriter, r *http.Request) {
 	for _, tag := range tags {
 		feed.Navigation = append(feed.Navigation, opds2.NavItem{
 			Title: tag,
-			Href:  withToken("/opds/v2/tags/"+url.PathEscape(tag), tok),
+			Href:  withToken("/opds/v2/tags/"+nameSlug(tag), tok),
 			Type:  opds2.MIMEFeed,
 			Rel:   "subsection",
 		})
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2TagBooks serves an OPDS 2.0 acquisition feed for a specific tag/genre.
 func (s *Server) handleOPDS2TagBooks(w http.ResponseWriter, r *http.Request) {
 	tok := r.URL.Query().Get("token")
 	vars := mux.Vars(r)
-	tag, _ := url.PathUnescape(vars["tag"])
+	tag, err := s.resolveTag(vars["tag"])
+	if err != nil {
+		http.Error(w, "catalog error", http.StatusInternalServerError)
+		return
+	}
 	offset, limit := parsePagination(r)
 
 	books, total, err := s.catalog.BooksByTag(tag, offset, limit)
@@ -1467,10 +3745,10 @@ func (s *Server) handleOPDS2TagBooks(w http.ResponseWriter, r *http.Request) {
 	addPaginationLinks2(feed, r, offset, limit, total)
 
 	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2Publishers serves the OPDS 2.0 publisher navigation feed.
@@ -1505,7 +3783,7 @@ func (s *Server) handleOPDS2Publishers(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // handleOPDS2PublisherBooks serves an OPDS 2.0 acquisition feed for a specific publisher.
@@ -1534,10 +3812,10 @@ func (s *Server) handleOPDS2PublisherBooks(w http.ResponseWriter, r *http.Reques
 	addPaginationLinks2(feed, r, offset, limit, total)
 
 	for _, bk := range books {
-		feed.Publications = append(feed.Publications, bookToPublication(bk, tok))
+		feed.Publications = append(feed.Publications, s.bookPublication(bk, tok))
 	}
 
-	writeOPDS2(w, http.StatusOK, feed)
+	s.writeOPDS2(w, http.StatusOK, feed)
 }
 
 // loginPageHTML is the standalone login form served at GET /login.
@@ -1559,24 +3837,41 @@ const loginPageHTML = `<!DOCTYPE html>
           d="M12 6.253v13m0-13C10.832 5.477 9.246 5 7.5 5S4.168 5.477 3 6.253v13C4.168 18.477 5.754 18 7.5 18s3.332.477 4.5 1.253m0-13C13.168 5.477 14.754 5 16.5 5c1.746 0 3.332.477 4.5 1.253v13C19.832 18.477 18.246 18 16.5 18c-1.746 0-3.332.477-4.5 1.253"/>
       </svg>
       <h1 class="text-xl font-bold text-gray-900">nxt-opds Library</h1>
-      <p class="text-sm text-gray-500 mt-1">Enter your password to continue</p>
+      <p class="text-sm text-gray-500 mt-1">{{if .Users}}Sign in to continue{{else}}Enter your password to continue{{end}}</p>
     </div>
     {{if .Error}}
     <div class="mb-4 px-3 py-2 bg-red-50 border border-red-200 rounded-lg text-sm text-red-700">
       {{.Error}}
     </div>
     {{end}}
-    <form method="POST" action="/login">
+    <form method="POST" action="{{.Action}}">
       <input type="hidden" name="redirect" value="{{.Redirect}}"/>
+      {{if .Users}}
+      <div class="mb-4">
+        <label class="block text-sm font-medium text-gray-700 mb-1" for="username">User name</label>
+        <input
+          id="username" name="username" type="text" autocomplete="username"
+          autofocus value="{{.Username}}"
+          class="w-full px-3 py-2 border border-gray-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent text-sm"
+        />
+      </div>
+      {{end}}
       <div class="mb-4">
         <label class="block text-sm font-medium text-gray-700 mb-1" for="password">Password</label>
         <input
           id="password" name="password" type="password" autocomplete="current-password"
-          autofocus required
+          {{if not .Users}}autofocus{{end}} required
           class="w-full px-3 py-2 border border-gray-300 rounded-lg focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent text-sm"
           placeholder="••••••••"
         />
       </div>
+      {{if .SharedTimeout}}
+      <label class="flex items-center gap-2 mb-4 text-sm text-gray-700">
+        <input type="checkbox" name="shared" value="1" {{if .Shared}}checked{{end}}
+          class="rounded border-gray-300 text-blue-600 focus:ring-blue-500"/>
+        Shared device (sign out after {{.SharedTimeout}} of inactivity)
+      </label>
+      {{end}}
       <button type="submit"
         class="w-full py-2 px-4 bg-blue-600 hover:bg-blue-700 text-white font-medium rounded-lg text-sm transition-colors">
         Sign in
@@ -1589,20 +3884,22 @@ const loginPageHTML = `<!DOCTYPE html>
 // handleLoginPage serves the GET /login HTML form.
 func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
 	// If auth is disabled, redirect straight to home.
-	if s.opts.Password == "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	if !s.auth.enabled() {
+		http.Redirect(w, r, s.url("/"), http.StatusSeeOther)
 		return
 	}
 	// If already logged in, redirect to home.
 	if c, err := r.Cookie(sessionCookieName); err == nil && s.sessions.valid(c.Value) {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, s.url("/"), http.StatusSeeOther)
 		return
 	}
 	redirect := r.URL.Query().Get("redirect")
 	if redirect == "" {
 		redirect = "/"
 	}
-	s.renderLoginPage(w, redirect, "")
+	// ?shared=1 pre-ticks the shared-device box, e.g. for a kiosk homepage.
+	shared := r.URL.Query().Get("shared") != ""
+	s.renderLoginPage(w, redirect, "", shared, "")
 }
 
 // handleLoginPost processes the POST /login form submission.
@@ -1612,36 +3909,59 @@ func (s *Server) handleLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	username := strings.TrimSpace(r.FormValue("username"))
 	password := r.FormValue("password")
 	redirect := r.FormValue("redirect")
 	if redirect == "" || redirect[0] != '/' {
 		redirect = "/"
 	}
 
-	// Constant-time password comparison to prevent timing attacks.
-	passwordOK := s.opts.Password == "" ||
-		(subtle.ConstantTimeCompare([]byte(password), []byte(s.opts.Password)) == 1)
+	user, passwordOK := s.auth.check(username, password)
+	if !s.auth.enabled() {
+		user, passwordOK = "", true
+	}
+
+	shared := r.FormValue("shared") != "" && s.opts.SharedDeviceTimeout > 0
 
 	if passwordOK {
-		token, err := s.sessions.create()
+		var token string
+		var err error
+		if shared {
+			token, err = s.sessions.createShortLived(user, s.opts.SharedDeviceTimeout)
+		} else {
+			token, err = s.sessions.create(user)
+		}
 		if err != nil {
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
-		http.SetCookie(w, &http.Cookie{
+		cookie := &http.Cookie{
 			Name:     sessionCookieName,
 			Value:    token,
-			Path:     "/",
-			MaxAge:   int(sessionDuration.Seconds()),
+			Path:     s.cookiePath(),
+			MaxAge:   int(s.sessions.duration.Seconds()),
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
-		})
-		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		}
+		if s.opts.SecureCookies {
+			cookie.Secure = true
+			cookie.SameSite = http.SameSiteStrictMode
+		}
+		if shared {
+			// Browser-session cookie: gone when the kiosk browser closes.
+			cookie.MaxAge = 0
+		}
+		http.SetCookie(w, cookie)
+		http.Redirect(w, r, s.url(redirect), http.StatusSeeOther)
 		return
 	}
 
 	// Wrong password – re-render the form with an error.
-	s.renderLoginPage(w, redirect, "Incorrect password. Please try again.")
+	errMsg := "Incorrect password. Please try again."
+	if len(s.opts.Users) > 0 {
+		errMsg = "Incorrect user name or password. Please try again."
+	}
+	s.renderLoginPage(w, redirect, username, shared, errMsg)
 }
 
 // handleLogout clears the session cookie and redirects to /login.
@@ -1652,18 +3972,28 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:    sessionCookieName,
 		Value:   "",
-		Path:    "/",
+		Path:    s.cookiePath(),
 		MaxAge:  -1,
 		Expires: time.Unix(0, 0),
+		Secure:  s.opts.SecureCookies,
 	})
-	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	http.Redirect(w, r, s.url("/login"), http.StatusSeeOther)
 }
 
 // renderLoginPage writes the login HTML page with the given error message.
-func (s *Server) renderLoginPage(w http.ResponseWriter, redirect, errMsg string) {
+func (s *Server) renderLoginPage(w http.ResponseWriter, redirect, username string, shared bool, errMsg string) {
 	type data struct {
-		Error    string
-		Redirect string
+		Error         string
+		Action        string
+		Redirect      string
+		Users         bool
+		Username      string
+		Shared        bool
+		SharedTimeout string
+	}
+	var sharedTimeout string
+	if s.opts.SharedDeviceTimeout > 0 {
+		sharedTimeout = s.opts.SharedDeviceTimeout.String()
 	}
 	tmpl, err := template.New("login").Parse(loginPageHTML)
 	if err != nil {
@@ -1674,5 +4004,53 @@ func (s *Server) renderLoginPage(w http.ResponseWriter, redirect, errMsg string)
 	if errMsg != "" {
 		w.WriteHeader(http.StatusUnauthorized)
 	}
-	_ = tmpl.Execute(w, data{Error: errMsg, Redirect: redirect})
+	_ = tmpl.Execute(w, data{
+		Error:         errMsg,
+		Action:        s.url("/login"),
+		Redirect:      redirect,
+		Users:         len(s.opts.Users) > 0,
+		Username:      username,
+		Shared:        shared,
+		SharedTimeout: sharedTimeout,
+	})
+}
+
+// sessionJSON is the response of /api/session.
+type sessionJSON struct {
+	AuthRequired       bool   `json:"authRequired"`
+	User               string `json:"user,omitempty"`
+	RemainingSeconds   int64  `json:"remainingSeconds"`
+	IdleTimeoutSeconds int64  `json:"idleTimeoutSeconds"`
+	ExpiresAt          string `json:"expiresAt,omitempty"`
+}
+
+// handleAPISession reports how long the browser session stays valid so the
+// web UI can warn before an idle shared-device session ends and return to
+// the login page afterwards. GET only reads the state; POST goes through the
+// auth middleware, which extends an idle session, and is used by the UI's
+// "stay signed in" action.
+// Returns 401 when the session cookie is missing or expired.
+func (s *Server) handleAPISession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.auth.enabled() {
+		_ = json.NewEncoder(w).Encode(sessionJSON{})
+		return
+	}
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	user, left, idle, ok := s.sessions.remaining(c.Value)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(sessionJSON{
+		AuthRequired:       true,
+		User:               user,
+		RemainingSeconds:   int64(left / time.Second),
+		IdleTimeoutSeconds: int64(idle / time.Second),
+		ExpiresAt:          s.sessions.now().Add(left).UTC().Format(time.RFC3339),
+	})
 }
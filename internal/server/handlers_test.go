@@ -1,18 +1,34 @@
 package server
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	fsbackend "github.com/banux/nxt-opds/internal/backend/fs"
+	sqlitebackend "github.com/banux/nxt-opds/internal/backend/sqlite"
 	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/config"
 	"github.com/banux/nxt-opds/internal/opds"
+	"github.com/banux/nxt-opds/internal/opds2"
 )
 
 // ---- mock types for refresh tests ----
@@ -45,8 +61,13 @@ func (f *failRefreshBackend) Refresh() error {
 // uploadBook is a test helper that uploads a minimal EPUB and returns the resulting Book.
 func uploadBook(t *testing.T, srv *Server, filename, title, author string) catalog.Book {
 	t.Helper()
-	epubData := buildEPUBBytes(title, author)
-	body, ct := buildMultipartBody(t, "file", filename, epubData)
+	return uploadFile(t, srv, filename, buildEPUBBytes(title, author))
+}
+
+// uploadFile is a test helper that uploads raw file bytes and returns the resulting Book.
+func uploadFile(t *testing.T, srv *Server, filename string, data []byte) catalog.Book {
+	t.Helper()
+	body, ct := buildMultipartBody(t, "file", filename, data)
 	req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
 	req.Header.Set("Content-Type", ct)
 	rr := httptest.NewRecorder()
@@ -100,6 +121,31 @@ func TestHandleRoot_TrailingSlash(t *testing.T) {
 	}
 }
 
+func TestHandleRoot_EmptyCatalogNotice(t *testing.T) {
+	hasNotice := func(feed opds.Feed) bool {
+		for _, e := range feed.Entries {
+			if e.ID == "urn:nxt-opds:empty" {
+				return e.Title.Value == "Library is empty"
+			}
+		}
+		return false
+	}
+
+	srv := newTestServer(t, Options{EmptyCatalogNotice: "Library is empty"})
+	if feed := getFeed(t, srv, "/opds"); !hasNotice(feed) {
+		t.Errorf("expected the empty-catalog entry, got %+v", feed.Entries)
+	}
+	uploadBook(t, srv, "a.epub", "A Book", "Someone")
+	if feed := getFeed(t, srv, "/opds"); hasNotice(feed) {
+		t.Error("empty-catalog entry still present after an upload")
+	}
+
+	srv = newTestServer(t, Options{})
+	if feed := getFeed(t, srv, "/opds"); hasNotice(feed) {
+		t.Error("empty-catalog entry present without EmptyCatalogNotice")
+	}
+}
+
 // ---- OPDS all books ----
 
 func TestHandleAllBooks_EmptyCatalog(t *testing.T) {
@@ -299,6 +345,83 @@ func TestHandleSearch_WithResults(t *testing.T) {
 	}
 }
 
+func TestHandleSearch_ApproximateTotal(t *testing.T) {
+	backend, err := sqlitebackend.NewWithOptions(t.TempDir(), sqlitebackend.Options{SearchCountCap: 2})
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+	for i := 0; i < 5; i++ {
+		uploadBook(t, srv, fmt.Sprintf("go%d.epub", i), fmt.Sprintf("Learning Go %d", i), "Jon Bodner")
+	}
+
+	rels := func(links []opds.Link) map[string]bool {
+		m := map[string]bool{}
+		for _, l := range links {
+			m[l.Rel] = true
+		}
+		return m
+	}
+	feed := getFeed(t, srv, "/opds/search?q=learning&limit=2")
+	if !strings.Contains(feed.Title.Value, "(2+ results)") {
+		t.Errorf("title = %q, want an approximate count", feed.Title.Value)
+	}
+	if got := rels(feed.Links); !got[opds.RelNext] || got[opds.RelLast] {
+		t.Errorf("links %v: want next and no last for an approximate total", feed.Links)
+	}
+	// The last, partial page ends the "next" chain.
+	feed = getFeed(t, srv, "/opds/search?q=learning&limit=2&offset=4")
+	if got := rels(feed.Links); len(feed.Entries) != 1 || got[opds.RelNext] {
+		t.Errorf("last page: %d entries, links %v", len(feed.Entries), feed.Links)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/search?q=learning&limit=2", nil))
+	var v2 opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &v2); err != nil {
+		t.Fatalf("decode OPDS 2.0 search: %v", err)
+	}
+	if v2.Metadata.NumberOfItems != 0 || !strings.Contains(v2.Metadata.Title, "2+") {
+		t.Errorf("OPDS 2.0 metadata = %+v, want no numberOfItems and an approximate title", v2.Metadata)
+	}
+}
+
+func TestSearchCountCap_ListingsStayExact(t *testing.T) {
+	backend, err := sqlitebackend.NewWithOptions(t.TempDir(), sqlitebackend.Options{SearchCountCap: 2})
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+	for i := 0; i < 5; i++ {
+		uploadBook(t, srv, fmt.Sprintf("go%d.epub", i), fmt.Sprintf("Learning Go %d", i), "Jon Bodner")
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books?limit=2", nil))
+	var resp struct {
+		Total int `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode /api/books: %v", err)
+	}
+	if resp.Total != 5 {
+		t.Errorf("/api/books total = %d, want the exact 5", resp.Total)
+	}
+
+	feed := getFeed(t, srv, "/opds/books?limit=2")
+	var last string
+	for _, l := range feed.Links {
+		if l.Rel == opds.RelLast {
+			last = l.Href
+		}
+	}
+	if !strings.Contains(last, "offset=4") {
+		t.Errorf("all-books last link = %q, want offset=4", last)
+	}
+}
+
 // ---- OPDS authors ----
 
 func TestHandleAuthors_Empty(t *testing.T) {
@@ -338,6 +461,40 @@ func TestHandleAuthors_WithBooks(t *testing.T) {
 	}
 }
 
+func TestHandleAuthors_MaxNavPageSize(t *testing.T) {
+	srv := newTestServer(t, Options{MaxNavPageSize: 2})
+	uploadBook(t, srv, "a.epub", "Book A", "Alice Smith")
+	uploadBook(t, srv, "b.epub", "Book B", "Bob Jones")
+	uploadBook(t, srv, "c.epub", "Book C", "Carol King")
+
+	feed := getFeed(t, srv, "/opds/authors?limit=100")
+	if len(feed.Entries) != 2 {
+		t.Errorf("authors: got %d entries, want 2", len(feed.Entries))
+	}
+	var next string
+	for _, l := range feed.Links {
+		if l.Rel == opds.RelNext {
+			next = l.Href
+		}
+	}
+	if !strings.Contains(next, "limit=2") {
+		t.Errorf("authors: next link %q should use the capped page size", next)
+	}
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/authors?limit=100", nil))
+	var feed2 opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed2); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(feed2.Navigation) != 2 {
+		t.Errorf("OPDS 2.0 authors: got %d entries, want 2", len(feed2.Navigation))
+	}
+	// Acquisition feeds keep the general cap.
+	if feed := getFeed(t, srv, "/opds/books?limit=100"); len(feed.Entries) != 3 {
+		t.Errorf("/opds/books: got %d entries, want 3", len(feed.Entries))
+	}
+}
+
 func TestHandleAuthorBooks_NotFound(t *testing.T) {
 	srv := newTestServer(t, Options{})
 	req := httptest.NewRequest(http.MethodGet, "/opds/authors/"+url.PathEscape("Unknown Author"), nil)
@@ -575,6 +732,62 @@ func TestHandleAPIBooks_Pagination(t *testing.T) {
 	}
 }
 
+// ---- API error format ----
+
+func TestAPIErrors_JSON(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	book := uploadBook(t, srv, "errors.epub", "Errors", "Author")
+	authSrv := newTestServer(t, Options{Password: "secret"})
+
+	cases := []struct {
+		name   string
+		srv    *Server
+		method string
+		path   string
+		body   string
+		status int
+		msg    string
+	}{
+		{"unknown book", srv, http.MethodGet, "/api/books/nonexistent", "", http.StatusNotFound, "book not found"},
+		{"invalid JSON", srv, http.MethodPatch, "/api/books/" + book.ID, "not json", http.StatusBadRequest, ""},
+		{"unauthenticated", authSrv, http.MethodGet, "/api/books", "", http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			tc.srv.ServeHTTP(rr, req)
+			if rr.Code != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error body %q: %v", rr.Body.String(), err)
+			}
+			if body.Error == "" || (tc.msg != "" && body.Error != tc.msg) {
+				t.Errorf("error = %q, want %q", body.Error, tc.msg)
+			}
+		})
+	}
+
+	// OPDS routes keep plain-text errors.
+	req := httptest.NewRequest(http.MethodGet, "/opds/search", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for OPDS search without q, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("OPDS error Content-Type = %q, want text/plain", ct)
+	}
+}
+
 // ---- API update book ----
 
 func TestHandleAPIUpdateBook_NotFound(t *testing.T) {
@@ -628,6 +841,235 @@ func TestHandleAPIUpdateBook_UpdateTitle(t *testing.T) {
 	}
 }
 
+func TestTags_DedupedCaseInsensitively(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			book := uploadFile(t, srv, "messy.epub", buildEPUBBytesWithMetadata("Messy", "Author",
+				"<dc:subject>SciFi</dc:subject><dc:subject>scifi</dc:subject><dc:subject>Space</dc:subject>"))
+			if got := strings.Join(book.Tags, ","); got != "SciFi,Space" {
+				t.Errorf("uploaded tags: got %q, want %q", got, "SciFi,Space")
+			}
+			if got := strings.Join(entryTitles(getFeed(t, srv, "/opds/tags")), ","); got != "SciFi (1),Space (1)" {
+				t.Errorf("tags feed: got %q", got)
+			}
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/books/"+book.ID,
+				strings.NewReader(`{"tags":["Opera","opera "," OPERA","Space"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("update tags: got %d: %s", rr.Code, rr.Body.String())
+			}
+			var updated bookJSON
+			if err := json.NewDecoder(rr.Body).Decode(&updated); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got := strings.Join(updated.Tags, ","); got != "Opera,Space" {
+				t.Errorf("updated tags: got %q, want %q", got, "Opera,Space")
+			}
+		})
+	}
+}
+
+func TestHiddenBooks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			uploadBook(t, srv, "public.epub", "Public", "Shown Author")
+			draft := uploadBook(t, srv, "draft.epub", "Draft", "Staged Author")
+
+			setHidden := func(hidden bool) {
+				t.Helper()
+				body := fmt.Sprintf(`{"hidden":%t}`, hidden)
+				req := httptest.NewRequest(http.MethodPatch, "/api/books/"+draft.ID, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("PATCH hidden=%t: got %d: %s", hidden, rr.Code, rr.Body.String())
+				}
+				var updated bookJSON
+				if err := json.NewDecoder(rr.Body).Decode(&updated); err != nil {
+					t.Fatalf("decode response: %v", err)
+				}
+				if updated.Hidden != hidden {
+					t.Errorf("PATCH response hidden = %t, want %t", updated.Hidden, hidden)
+				}
+			}
+			apiBooks := func(path string) []bookJSON {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("GET %s: got %d", path, rr.Code)
+				}
+				var resp struct {
+					Books []bookJSON `json:"books"`
+					Total int        `json:"total"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatalf("decode %s: %v", path, err)
+				}
+				if resp.Total != len(resp.Books) {
+					t.Errorf("GET %s: total %d for %d books", path, resp.Total, len(resp.Books))
+				}
+				return resp.Books
+			}
+
+			setHidden(true)
+
+			if got := strings.Join(entryTitles(getFeed(t, srv, "/opds/books")), ","); got != "Public" {
+				t.Errorf("/opds/books: got %q, want only Public", got)
+			}
+			if got := entryTitles(getFeed(t, srv, "/opds/search?q=Draft")); len(got) != 0 {
+				t.Errorf("/opds/search: hidden book found: %v", got)
+			}
+			if got := strings.Join(entryTitles(getFeed(t, srv, "/opds/authors")), ","); got != "Shown Author (1)" {
+				t.Errorf("/opds/authors: got %q", got)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/opds/books/"+draft.ID, nil)
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("/opds/books/{id} of a hidden book: got %d, want 404", rr.Code)
+			}
+
+			if got := apiBooks("/api/books"); len(got) != 1 || got[0].Title != "Public" {
+				t.Errorf("/api/books: got %+v, want only Public", got)
+			}
+			all := apiBooks("/api/books?includeHidden=1")
+			if len(all) != 2 {
+				t.Fatalf("/api/books?includeHidden=1: got %d books, want 2", len(all))
+			}
+			for _, bk := range all {
+				if bk.Hidden != (bk.ID == draft.ID) {
+					t.Errorf("%s: hidden = %t", bk.Title, bk.Hidden)
+				}
+			}
+
+			setHidden(false)
+			if got := strings.Join(entryTitles(getFeed(t, srv, "/opds/books")), ","); got != "Draft,Public" {
+				t.Errorf("/opds/books after unhiding: got %q", got)
+			}
+		})
+	}
+}
+
+func TestBookRating_InFeeds(t *testing.T) {
+	tests := []struct {
+		scale    string
+		wantOPDS string
+		wantV2   float64
+	}{
+		{"", "4", 4},
+		{RatingScaleFraction, "0.8", 0.8},
+		{RatingScaleOff, "", 0},
+	}
+	for _, tc := range tests {
+		srv := newTestServer(t, Options{RatingScale: tc.scale})
+		book := uploadBook(t, srv, "rated.epub", "Rated", "Author")
+		req := httptest.NewRequest(http.MethodPatch, "/api/books/"+book.ID, strings.NewReader(`{"rating":4}`))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("scale %q: rate book: got %d: %s", tc.scale, rr.Code, rr.Body.String())
+		}
+
+		feed := getFeed(t, srv, "/opds/books/"+book.ID)
+		if got := feed.Entries[0].CalRating; got != tc.wantOPDS {
+			t.Errorf("scale %q: calibre:rating = %q, want %q", tc.scale, got, tc.wantOPDS)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/publications/"+book.ID, nil))
+		var v2 opds2.Feed
+		if err := json.Unmarshal(rr.Body.Bytes(), &v2); err != nil || len(v2.Publications) != 1 {
+			t.Fatalf("scale %q: OPDS 2 publication: %v: %s", tc.scale, err, rr.Body.String())
+		}
+		if got := v2.Publications[0].Metadata.Rating; got != tc.wantV2 {
+			t.Errorf("scale %q: metadata.rating = %v, want %v", tc.scale, got, tc.wantV2)
+		}
+	}
+}
+
+func TestOPDS2_MultipleLanguages(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			bilingual := uploadFile(t, srv, "bilingual.epub",
+				buildEPUBBytesWithMetadata("Bilingual", "Author", "\n    <dc:language>fr</dc:language>"))
+			single := uploadBook(t, srv, "single.epub", "Single", "Author")
+
+			language := func(id string) any {
+				t.Helper()
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/publications/"+id, nil))
+				var v2 struct {
+					Publications []struct {
+						Metadata struct {
+							Language any `json:"language"`
+						} `json:"metadata"`
+					} `json:"publications"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &v2); err != nil || len(v2.Publications) != 1 {
+					t.Fatalf("OPDS 2 publication: %v: %s", err, rr.Body.String())
+				}
+				return v2.Publications[0].Metadata.Language
+			}
+
+			if got, ok := language(bilingual.ID).([]any); !ok || len(got) != 2 || got[0] != "en" || got[1] != "fr" {
+				t.Errorf("bilingual book: language = %#v, want [en fr]", language(bilingual.ID))
+			}
+			if got := language(single.ID); got != "en" {
+				t.Errorf("single-language book: language = %#v, want \"en\"", got)
+			}
+		})
+	}
+}
+
 func TestHandleAPIUpdateBook_UpdateIsRead(t *testing.T) {
 	srv := newTestServer(t, Options{})
 	book := uploadBook(t, srv, "read.epub", "Read Test", "Read Author")
@@ -655,6 +1097,107 @@ func TestHandleAPIUpdateBook_UpdateIsRead(t *testing.T) {
 	}
 }
 
+func TestHandleRecentlyRead_OrderedByReadAt(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	first := uploadBook(t, srv, "first.epub", "First Read", "Author")
+	second := uploadBook(t, srv, "second.epub", "Second Read", "Author")
+	uploadBook(t, srv, "unread.epub", "Never Read", "Author")
+
+	for _, id := range []string{first.ID, second.ID} {
+		req := httptest.NewRequest(http.MethodPatch, "/api/books/"+id, strings.NewReader(`{"isRead":true}`))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("PATCH %s: expected 200, got %d: %s", id, rr.Code, rr.Body.String())
+		}
+		var updated bookJSON
+		if err := json.NewDecoder(rr.Body).Decode(&updated); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if updated.ReadAt == nil || updated.ReadAt.IsZero() {
+			t.Errorf("PATCH %s: expected readAt to be set", id)
+		}
+	}
+
+	feed := getFeed(t, srv, "/opds/recently-read")
+	if got := strings.Join(entryTitles(feed), ","); got != "Second Read,First Read" {
+		t.Errorf("recently read order: got %q, want %q", got, "Second Read,First Read")
+	}
+}
+
+func TestHandleRecent_NewestFirst(t *testing.T) {
+	// Files already in the library keep their mtimes as added dates, in an
+	// order unrelated to their names.
+	dir := t.TempDir()
+	now := time.Now()
+	for _, f := range []struct {
+		name string
+		age  time.Duration
+	}{
+		{"a.epub", 3 * time.Hour},
+		{"b.epub", time.Hour},
+		{"c.epub", 2 * time.Hour},
+	} {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, buildEPUBBytesWithMetadata("Book "+f.name[:1], "Author", ""), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(dir)
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(dir)
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			uploaded := uploadBook(t, srv, "new-"+tc.name+".epub", "Just Uploaded", "Author")
+			t.Cleanup(func() { _ = os.Remove(uploaded.Files[0].Path) })
+
+			feed := getFeed(t, srv, "/opds/recent")
+			if got := strings.Join(entryTitles(feed), ","); got != "Just Uploaded,Book b,Book c,Book a" {
+				t.Errorf("recent: got %q", got)
+			}
+			feed = getFeed(t, srv, "/opds/recent?limit=2")
+			if got := strings.Join(entryTitles(feed), ","); got != "Just Uploaded,Book b" {
+				t.Errorf("recent?limit=2: got %q", got)
+			}
+
+			root := getFeed(t, srv, "/opds")
+			found := false
+			for _, e := range root.Entries {
+				for _, l := range e.Links {
+					if strings.HasPrefix(l.Href, "/opds/recent") && l.Rel == opds.RelCatalogNew {
+						found = true
+					}
+				}
+			}
+			if !found {
+				t.Error("root feed: no sort/new link to /opds/recent")
+			}
+		})
+	}
+}
+
 func TestHandleAPIUpdateBook_UpdateSeries(t *testing.T) {
 	srv := newTestServer(t, Options{})
 	book := uploadBook(t, srv, "series.epub", "Series Book", "Series Author")
@@ -702,6 +1245,143 @@ func TestHandleAPIUpdateBook_UpdateTags(t *testing.T) {
 	}
 }
 
+func TestHandleAPIUpdateBook_UpdateSource(t *testing.T) {
+	backends := map[string]func(t *testing.T, dir string) catalog.Catalog{
+		"fs": func(t *testing.T, dir string) catalog.Catalog {
+			backend, err := fsbackend.New(dir)
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		},
+		"sqlite": func(t *testing.T, dir string) catalog.Catalog {
+			backend, err := sqlitebackend.New(dir)
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		},
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			srv := New(open(t, dir), Options{})
+			book := uploadBook(t, srv, "converted.epub", "Converted Book", "Author")
+
+			req := httptest.NewRequest(http.MethodPatch, "/api/books/"+book.ID, strings.NewReader(`{"source":"MOBI"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			// The edit survives reopening the catalog.
+			srv = New(open(t, dir), Options{})
+			rr = httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID, nil))
+			var got bookJSON
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.Source != "MOBI" {
+				t.Errorf("source: got %q, want MOBI", got.Source)
+			}
+		})
+	}
+}
+
+// pagedCatalog serves generated books and records the largest page asked for.
+type pagedCatalog struct {
+	noRefreshCatalog
+	books    []catalog.Book
+	maxLimit int
+}
+
+func (c *pagedCatalog) AllBooks(offset, limit int) ([]catalog.Book, int, error) {
+	c.maxLimit = max(c.maxLimit, limit)
+	if offset >= len(c.books) {
+		return nil, len(c.books), nil
+	}
+	return c.books[offset:min(offset+limit, len(c.books))], len(c.books), nil
+}
+
+func TestHandleAPIExportCSV(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	book := uploadBook(t, srv, "export.epub", "Guns, Germs, and Steel", "Jared Diamond")
+	req := httptest.NewRequest(http.MethodPatch, "/api/books/"+book.ID,
+		strings.NewReader(`{"authors":["Jared Diamond","Second \"Author\""],"tags":["history","science"],"series":"Big","seriesIndex":"1","isRead":true,"rating":4}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/export.csv", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="catalog.csv"` {
+		t.Errorf("Content-Disposition: got %q", cd)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"id", "title", "authors", "series", "series_index", "tags", "language", "publisher", "isRead", "rating"},
+		{book.ID, "Guns, Germs, and Steel", `Jared Diamond;Second "Author"`, "Big", "1", "history;science", "en", "", "true", "4"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV:\n got %q\nwant %q", records, want)
+	}
+
+	// Metadata that a spreadsheet would evaluate is exported as text.
+	formula := uploadBook(t, srv, "formula.epub", `=HYPERLINK("http://evil","x")`, "@SUM(1)")
+	req = httptest.NewRequest(http.MethodPatch, "/api/books/"+formula.ID,
+		strings.NewReader(`{"tags":["-2+3"],"series":"+cmd","publisher":"Safe = plain"}`))
+	req.Header.Set("Content-Type", "application/json")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/export.csv", nil))
+	records, err = csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	var found bool
+	for _, rec := range records[1:] {
+		if rec[0] != formula.ID {
+			continue
+		}
+		found = true
+		got := []string{rec[1], rec[2], rec[3], rec[5], rec[7]}
+		want := []string{`'=HYPERLINK("http://evil","x")`, "'@SUM(1)", "'+cmd", "'-2+3", "Safe = plain"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("formula cells: got %q, want %q", got, want)
+		}
+	}
+	if !found {
+		t.Errorf("no CSV record for book %s", formula.ID)
+	}
+
+	// Large catalogs are read page by page.
+	cat := &pagedCatalog{}
+	for i := 0; i < 2*maxPageSize+50; i++ {
+		cat.books = append(cat.books, catalog.Book{ID: fmt.Sprintf("id-%d", i), Title: fmt.Sprintf("Book %d", i)})
+	}
+	rr = httptest.NewRecorder()
+	New(cat, Options{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/export.csv", nil))
+	records, err = csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != len(cat.books)+1 || records[len(records)-1][0] != cat.books[len(cat.books)-1].ID {
+		t.Errorf("got %d records, want %d ending with the last book", len(records), len(cat.books)+1)
+	}
+	if cat.maxLimit > maxPageSize {
+		t.Errorf("export asked for %d books at once, want at most %d", cat.maxLimit, maxPageSize)
+	}
+}
+
 // ---- Pagination helper unit tests ----
 
 func TestPaginationLink_PreservesExistingQueryParams(t *testing.T) {
@@ -943,6 +1623,106 @@ func TestAPIConfig_ReturnsToken(t *testing.T) {
 	}
 }
 
+func TestAPIConfig_EffectiveConfigRedacted(t *testing.T) {
+	t.Setenv("AUTH_PASSWORD", "hunter2")
+	t.Setenv("LISTEN_ADDR", ":9090")
+	t.Setenv("BACKEND", "sqlite")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	srv := New(noRefreshCatalog{}, Options{
+		Password:        cfg.Password,
+		OPDSToken:       cfg.OPDSToken,
+		EffectiveConfig: cfg.Sanitized(),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated: expected 401, got %d", rr.Code)
+	}
+
+	token, err := srv.sessions.create("")
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "hunter2") {
+		t.Fatalf("response leaks the password: %s", rr.Body.String())
+	}
+	var resp struct {
+		Effective map[string]any `json:"effective"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Effective["backend"] != "sqlite" || resp.Effective["listen_addr"] != ":9090" {
+		t.Errorf("backend=%v listen_addr=%v", resp.Effective["backend"], resp.Effective["listen_addr"])
+	}
+	if resp.Effective["auth_password"] != "[redacted]" || resp.Effective["opds_token"] != "[redacted]" {
+		t.Errorf("secrets not redacted: %v / %v", resp.Effective["auth_password"], resp.Effective["opds_token"])
+	}
+}
+
+// ---- Trailing slashes ----
+
+func TestTrailingSlash_Modes(t *testing.T) {
+	get := func(srv *Server, method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Default: permanent redirect to the canonical path, query preserved.
+	srv := newTestServer(t, Options{})
+	uploadBook(t, srv, "slash.epub", "Slash", "Author")
+	rr := get(srv, http.MethodGet, "/opds/books/?offset=0&limit=5")
+	if rr.Code != http.StatusMovedPermanently {
+		t.Fatalf("GET /opds/books/: expected 301, got %d", rr.Code)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/opds/books?offset=0&limit=5" {
+		t.Errorf("Location = %q", loc)
+	}
+	if rr := get(srv, http.MethodGet, "/api/books//"); rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/api/books" {
+		t.Errorf("GET /api/books//: got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	// Routes registered with a slash are served as-is.
+	if rr := get(srv, http.MethodGet, "/opds/"); rr.Code != http.StatusOK {
+		t.Errorf("GET /opds/: expected 200, got %d", rr.Code)
+	}
+	// No route behind the slash, or none for this method: nothing to fix.
+	if rr := get(srv, http.MethodGet, "/opds/nowhere/"); rr.Code != http.StatusNotFound {
+		t.Errorf("GET /opds/nowhere/: expected 404, got %d", rr.Code)
+	}
+	if rr := get(srv, http.MethodPost, "/api/books/"); rr.Code != http.StatusNotFound {
+		t.Errorf("POST /api/books/: expected 404, got %d", rr.Code)
+	}
+	if rr := get(srv, http.MethodPost, "/api/refresh/"); rr.Code != http.StatusPermanentRedirect {
+		t.Errorf("POST /api/refresh/: expected 308, got %d", rr.Code)
+	}
+
+	serve := newTestServer(t, Options{TrailingSlash: TrailingSlashServe})
+	uploadBook(t, serve, "slash.epub", "Slash", "Author")
+	rr = get(serve, http.MethodGet, "/api/books/")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Slash") {
+		t.Errorf("serve mode GET /api/books/: got %d %s", rr.Code, rr.Body.String())
+	}
+
+	off := newTestServer(t, Options{TrailingSlash: TrailingSlashOff})
+	if rr := get(off, http.MethodGet, "/api/books/"); rr.Code != http.StatusNotFound {
+		t.Errorf("off mode GET /api/books/: expected 404, got %d", rr.Code)
+	}
+}
+
 // ---- OPDS token propagation through feed links ----
 
 // TestWithToken verifies the withToken helper appends the token correctly.
@@ -1061,3 +1841,3119 @@ func TestOPDSRootFeed_NoTokenWhenAbsent(t *testing.T) {
 		}
 	}
 }
+
+func TestClientQuirks_AbsoluteCoverURLs(t *testing.T) {
+	srv := newTestServer(t, Options{ClientQuirks: []ClientQuirk{
+		{UserAgent: "QuirkyReader", PlainAtomType: true},
+		{UserAgent: "Marvin"}, // switches the built-in quirk off
+	}})
+	bk := uploadBook(t, srv, "cover.epub", "Cover Book", "Author")
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\ncover"))
+
+	fetch := func(userAgent string) (*httptest.ResponseRecorder, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "http://books.example/opds/books", nil)
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", userAgent, rr.Code)
+		}
+		var feed opds.Feed
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: invalid XML: %v", userAgent, err)
+		}
+		for _, e := range feed.Entries {
+			for _, l := range e.Links {
+				if l.Rel == opds.RelCover {
+					return rr, l.Href
+				}
+			}
+		}
+		t.Fatalf("%s: no cover link in the feed", userAgent)
+		return nil, ""
+	}
+
+	for _, tc := range []struct {
+		userAgent, wantCover, wantType string
+	}{
+		{"Mozilla/5.0 KOReader/2024.01", "/covers/" + bk.ID, opds.MIMENavigationFeed},
+		{"Aldiko/3.0 (Android)", "http://books.example/covers/" + bk.ID, opds.MIMEAtomFeed},
+		{"Marvin/3.9", "/covers/" + bk.ID, opds.MIMENavigationFeed},
+		{"quirkyreader 1.0", "/covers/" + bk.ID, opds.MIMEAtomFeed},
+	} {
+		rr, cover := fetch(tc.userAgent)
+		if cover != tc.wantCover {
+			t.Errorf("%s: cover href %q, want %q", tc.userAgent, cover, tc.wantCover)
+		}
+		if got := rr.Header().Get("Content-Type"); got != tc.wantType+"; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q, want %s", tc.userAgent, got, tc.wantType)
+		}
+	}
+}
+
+// ---- Cover ETag ----
+
+// postCover uploads data as the cover image for the book with the given ID.
+func postCover(t *testing.T, srv *Server, id string, data []byte) {
+	t.Helper()
+	body, ct := buildMultipartBody(t, "cover", "cover.png", data)
+	req := httptest.NewRequest(http.MethodPost, "/api/books/"+id+"/cover", body)
+	req.Header.Set("Content-Type", ct)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update cover: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// getCover fetches /covers/{id}, optionally sending If-None-Match.
+func getCover(srv *Server, id, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/covers/"+id, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleCover_ETagChangesAfterUpdate(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "cover.epub", "Cover Book", "Author")
+
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\nfirst-cover"))
+	rr1 := getCover(srv, bk.ID, "")
+	if rr1.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr1.Code)
+	}
+	etag1 := rr1.Header().Get("ETag")
+	if etag1 == "" {
+		t.Fatal("expected ETag header on cover response")
+	}
+
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\nsecond-cover"))
+	rr2 := getCover(srv, bk.ID, etag1)
+	if rr2.Code != http.StatusOK {
+		t.Fatalf("stale ETag must yield the new image (200), got %d", rr2.Code)
+	}
+	etag2 := rr2.Header().Get("ETag")
+	if etag2 == etag1 {
+		t.Errorf("ETag did not change after cover update: %s", etag2)
+	}
+	if !strings.Contains(rr2.Body.String(), "second-cover") {
+		t.Error("expected the updated cover bytes in the response")
+	}
+}
+
+func TestHandleCover_MatchingETagReturns304(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "cover.epub", "Cover Book", "Author")
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\ncover"))
+
+	etag := getCover(srv, bk.ID, "").Header().Get("ETag")
+	rr := getCover(srv, bk.ID, etag)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", rr.Code)
+	}
+}
+
+func TestHandleCover_ETagFollowsFileModTime(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "cover.epub", "Cover Book", "Author")
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\ncover"))
+
+	etag1 := getCover(srv, bk.ID, "").Header().Get("ETag")
+	if !strings.HasPrefix(etag1, `W/"`) {
+		t.Fatalf("expected a weak ETag, got %q", etag1)
+	}
+	coverPath, err := srv.coverProvider.CoverPath(bk.ID)
+	if err != nil {
+		t.Fatalf("CoverPath: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(coverPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	rr := getCover(srv, bk.ID, etag1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ETag of a rewritten cover file must not match, got %d", rr.Code)
+	}
+	if etag2 := rr.Header().Get("ETag"); etag2 == etag1 {
+		t.Errorf("ETag did not change with the file's modification time: %s", etag2)
+	}
+}
+
+func TestHandleCover_PlaceholderByFormat(t *testing.T) {
+	dir := t.TempDir()
+	pdfCover := filepath.Join(dir, "pdf.png")
+	defaultCover := filepath.Join(dir, "book.jpg")
+	if err := os.WriteFile(pdfCover, []byte("\x89PNG\r\n\x1a\npdf-placeholder"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(defaultCover, []byte("\xff\xd8\xffdefault-placeholder"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, Options{PlaceholderCovers: map[string]string{".PDF": pdfCover, "default": defaultCover}})
+	pdf := uploadFile(t, srv, "scan.pdf", []byte("%PDF-1.4\n%%EOF\n"))
+	bk := uploadBook(t, srv, "plain.epub", "Plain Book", "Author")
+
+	for _, tc := range []struct {
+		id, body, contentType string
+	}{
+		{pdf.ID, "pdf-placeholder", "image/png"},
+		{bk.ID, "default-placeholder", "image/jpeg"},
+	} {
+		rr := getCover(srv, tc.id, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("cover of %s: expected 200, got %d", tc.id, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.body) {
+			t.Errorf("cover of %s: expected %s, got %q", tc.id, tc.body, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("cover of %s: Content-Type = %q, want %q", tc.id, ct, tc.contentType)
+		}
+	}
+
+	if rr := getCover(srv, "no-such-book", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown book: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleAPIUpdateCover_SniffsImageType(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "cover.epub", "Cover Book", "Author")
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\noriginal-cover"))
+
+	post := func(filename string, data []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		body, ct := buildMultipartBody(t, "cover", filename, data)
+		req := httptest.NewRequest(http.MethodPost, "/api/books/"+bk.ID+"/cover", body)
+		req.Header.Set("Content-Type", ct)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for name, data := range map[string][]byte{
+		"pdf":   []byte("%PDF-1.7\n1 0 obj\n"),
+		"html":  []byte("<!DOCTYPE html><html><body>hi</body></html>"),
+		"zip":   buildEPUBBytes("Not A Cover", "Author"),
+		"empty": nil,
+	} {
+		if rr := post("cover.jpg", data); rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s named cover.jpg: expected 415, got %d", name, rr.Code)
+		}
+	}
+	if rr := getCover(srv, bk.ID, ""); !strings.Contains(rr.Body.String(), "original-cover") {
+		t.Error("a rejected upload must leave the existing cover in place")
+	}
+
+	// The stored type follows the bytes, not the file name.
+	if rr := post("cover.png", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00jpeg-cover")); rr.Code != http.StatusOK {
+		t.Fatalf("JPEG upload: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := getCover(srv, bk.ID, ""); rr.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("cover Content-Type: got %q, want image/jpeg", rr.Header().Get("Content-Type"))
+	}
+
+	big := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, maxCoverSize)...)
+	if rr := post("big.png", big); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversize cover: expected 413, got %d", rr.Code)
+	}
+}
+
+func TestHandleThumbnail_ScaledAndFallback(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "thumb.epub", "Thumb Book", "Author")
+
+	// An undecodable cover has no thumbnail: the full cover is served.
+	postCover(t, srv, bk.ID, []byte("\x89PNG\r\n\x1a\nnot-an-image"))
+	req := httptest.NewRequest(http.MethodGet, "/covers/"+bk.ID+"/thumb", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "not-an-image") {
+		t.Fatalf("fallback: expected the full cover, got %d", rr.Code)
+	}
+
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 800, 1200))); err != nil {
+		t.Fatal(err)
+	}
+	postCover(t, srv, bk.ID, cover.Bytes())
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/covers/"+bk.ID+"/thumb", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", ct)
+	}
+	cfg, err := jpeg.DecodeConfig(rr.Body)
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if cfg.Width != 300 || cfg.Height != 450 {
+		t.Errorf("thumbnail size = %dx%d, want 300x450", cfg.Width, cfg.Height)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/covers/missing/thumb", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("missing book: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleAllBooks_LanguageFacets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			uploadBook(t, srv, "en1.epub", "English One", "Author")
+			uploadBook(t, srv, "en2.epub", "English Two", "Author")
+			uploadFile(t, srv, "fr.epub", buildEPUBBytesFromMetadata(`
+    <dc:title>Livre</dc:title><dc:creator>Auteur</dc:creator><dc:language>FR</dc:language>`))
+
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books", nil))
+			body := rr.Body.String()
+			for _, want := range []string{
+				`xmlns:opds="http://opds-spec.org/2010/catalog"`,
+				`rel="http://opds-spec.org/facet" href="/opds/books?language=en"`,
+				`title="en" opds:facetGroup="Language" thr:count="2"`,
+				`href="/opds/books?language=fr"`,
+				`title="All" opds:facetGroup="Language" opds:activeFacet="true"`,
+			} {
+				if !strings.Contains(body, want) {
+					t.Errorf("all books feed: missing %s in\n%s", want, body)
+				}
+			}
+
+			rr = httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books?language=fr", nil))
+			if !strings.Contains(rr.Body.String(), `title="fr" opds:facetGroup="Language" opds:activeFacet="true" thr:count="1"`) {
+				t.Errorf("fr facet not active:\n%s", rr.Body.String())
+			}
+			feed := getFeed(t, srv, "/opds/books?language=fr")
+			if got := strings.Join(entryTitles(feed), ","); got != "Livre" {
+				t.Errorf("language=fr: got %q", got)
+			}
+
+			// The JSON list and the OPDS search honour ?language= too, with
+			// and without a query.
+			uploadFile(t, srv, "fr2.epub", buildEPUBBytesFromMetadata(`
+    <dc:title>English Made Easy</dc:title><dc:creator>Auteur</dc:creator><dc:language>fr</dc:language>`))
+			rr = httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books?language=FR&sort=title_asc", nil))
+			var list struct {
+				Books []bookJSON `json:"books"`
+				Total int        `json:"total"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			var titles []string
+			for _, b := range list.Books {
+				titles = append(titles, b.Title)
+			}
+			if list.Total != 2 || !slices.Equal(titles, []string{"English Made Easy", "Livre"}) {
+				t.Errorf("/api/books?language=FR: got %v (total %d), want the two French books", titles, list.Total)
+			}
+			if got := entryTitles(getFeed(t, srv, "/opds/search?q=English&language=fr")); !slices.Equal(got, []string{"English Made Easy"}) {
+				t.Errorf("search q=English&language=fr: got %v", got)
+			}
+			if got := entryTitles(getFeed(t, srv, "/opds/search?q=English")); len(got) != 3 {
+				t.Errorf("search q=English: got %v, want all three matches", got)
+			}
+		})
+	}
+}
+
+func TestHandleCover_ReadOnlyCoversDir(t *testing.T) {
+	// A covers dir below a regular file can never be created or written,
+	// even by root, which makes it a portable stand-in for a read-only mount.
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	coversDir := filepath.Join(blocker, "covers")
+
+	cover := "\x89PNG\r\n\x1a\nembedded-cover"
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf"/></rootfiles></container>`,
+		"content.opf": `<package><metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Read Only</dc:title></metadata>
+<manifest><item id="c" href="cover.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+		"cover.png": cover,
+	} {
+		f, _ := zw.Create(name)
+		_, _ = f.Write([]byte(body))
+	}
+	_ = zw.Close()
+
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T, dir string) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T, dir string) catalog.Catalog {
+			backend, err := fsbackend.NewWithOptions(dir, fsbackend.Options{CoversDir: coversDir})
+			if err != nil {
+				t.Fatalf("fs.NewWithOptions: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T, dir string) catalog.Catalog {
+			backend, err := sqlitebackend.NewWithOptions(dir, sqlitebackend.Options{CoversDir: coversDir})
+			if err != nil {
+				t.Fatalf("sqlite.NewWithOptions: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "scanned.epub"), buf.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			srv := New(tc.backend(t, dir), Options{})
+			uploaded := uploadFile(t, srv, "uploaded.epub", buf.Bytes())
+
+			books, _, err := srv.catalog.AllBooks(0, 10)
+			if err != nil || len(books) != 2 {
+				t.Fatalf("AllBooks: %d books, %v", len(books), err)
+			}
+			for _, bk := range books {
+				if bk.CoverURL == "" {
+					t.Errorf("%s: no cover URL", bk.Files[0].Path)
+					continue
+				}
+				for _, path := range []string{bk.CoverURL, bk.ThumbnailURL} {
+					rr := httptest.NewRecorder()
+					srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+					if rr.Code != http.StatusOK || rr.Body.String() != cover {
+						t.Errorf("GET %s: got %d %q", path, rr.Code, rr.Body.String())
+					}
+					if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+						t.Errorf("GET %s: Content-Type %q", path, ct)
+					}
+				}
+			}
+			if etag := getCover(srv, uploaded.ID, "").Header().Get("ETag"); etag == "" || getCover(srv, uploaded.ID, etag).Code != http.StatusNotModified {
+				t.Errorf("in-memory cover: ETag %q not honoured", etag)
+			}
+		})
+	}
+}
+
+// ---- Browse by year ----
+
+func TestHandleYears_BucketsByDecade(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	uploadFile(t, srv, "a.epub", buildEPUBBytesWithMetadata("Neuromancer", "Gibson", "<dc:date>1984-07-01</dc:date>"))
+	uploadFile(t, srv, "b.epub", buildEPUBBytesWithMetadata("Snow Crash", "Stephenson", "<dc:date>1992-06-01</dc:date>"))
+	uploadFile(t, srv, "c.epub", buildEPUBBytesWithMetadata("Diamond Age", "Stephenson", "<dc:date>1995-02-01</dc:date>"))
+	uploadBook(t, srv, "undated.epub", "Undated", "Nobody")
+
+	req := httptest.NewRequest(http.MethodGet, "/opds/years", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var feed opds.Feed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	got := map[string]int{}
+	for _, e := range feed.Entries {
+		got[e.Title.Value] = e.Links[0].Count
+	}
+	want := map[string]int{"1980s": 1, "1990s": 2}
+	if len(got) != len(want) {
+		t.Fatalf("decades: got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("decade %s: got count %d, want %d", k, got[k], v)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/opds/years/1990-1999", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	feed = opds.Feed{}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatalf("expected 2 books in the 1990s, got %d", len(feed.Entries))
+	}
+	if feed.Entries[0].Title.Value != "Snow Crash" || feed.Entries[1].Title.Value != "Diamond Age" {
+		t.Errorf("expected books ordered by publication date, got %q, %q",
+			feed.Entries[0].Title.Value, feed.Entries[1].Title.Value)
+	}
+}
+
+func TestHandleYearBooks_InvalidRange(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	req := httptest.NewRequest(http.MethodGet, "/opds/years/nineties", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+}
+
+// ---- Capabilities ----
+
+func getCapabilities(t *testing.T, srv *Server) map[string]bool {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /api/capabilities: expected 200, got %d", rr.Code)
+	}
+	var caps map[string]bool
+	if err := json.Unmarshal(rr.Body.Bytes(), &caps); err != nil {
+		t.Fatalf("decode capabilities: %v", err)
+	}
+	return caps
+}
+
+func TestHandleAPICapabilities_SQLite(t *testing.T) {
+	backend, err := sqlitebackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	caps := getCapabilities(t, New(backend, Options{}))
+
+	for _, name := range []string{"upload", "update", "delete", "cover", "coverUpdate", "refresh", "series", "years", "backup", "merge", "lists", "progress", "random", "downloads", "resetPersonal", "purgeCovers", "authorAliases", "relatedTags"} {
+		if !caps[name] {
+			t.Errorf("sqlite backend: expected %q capability to be true", name)
+		}
+	}
+}
+
+func TestHandleAPICapabilities_FS(t *testing.T) {
+	caps := getCapabilities(t, newTestServer(t, Options{}))
+
+	for _, name := range []string{"upload", "update", "delete", "cover", "coverUpdate", "refresh", "series", "years", "lists", "random", "downloads", "resetPersonal", "purgeCovers", "authorAliases", "relatedTags"} {
+		if !caps[name] {
+			t.Errorf("fs backend: expected %q capability to be true", name)
+		}
+	}
+	for _, name := range []string{"backup", "merge", "progress"} {
+		if caps[name] {
+			t.Errorf("fs backend: expected %q capability to be false", name)
+		}
+	}
+}
+
+func TestHandleAuthors_LetterIndex(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{AuthorIndexThreshold: 3})
+			uploadBook(t, srv, "a1.epub", "One", "Alice Adams")
+			uploadBook(t, srv, "a2.epub", "Two", "anne able")
+			uploadBook(t, srv, "b.epub", "Three", "Bob Brown")
+			uploadBook(t, srv, "n.epub", "Four", "1984 Collective")
+
+			index := getFeed(t, srv, "/opds/authors")
+			if got, want := entryTitles(index), []string{"A (2)", "B (1)", "# (1)"}; !slices.Equal(got, want) {
+				t.Errorf("index: got %v, want %v", got, want)
+			}
+			if href := index.Entries[2].Links[0].Href; href != "/opds/authors?letter=%23" {
+				t.Errorf("# entry links to %q", href)
+			}
+
+			if got, want := entryTitles(getFeed(t, srv, "/opds/authors?letter=a")), []string{"Alice Adams (1)", "anne able (1)"}; !slices.Equal(got, want) {
+				t.Errorf("letter=a: got %v, want %v", got, want)
+			}
+			if got, want := entryTitles(getFeed(t, srv, "/opds/authors?letter=%23")), []string{"1984 Collective (1)"}; !slices.Equal(got, want) {
+				t.Errorf("letter=#: got %v, want %v", got, want)
+			}
+			if got := entryTitles(getFeed(t, srv, "/opds/authors?letter=Z")); len(got) != 0 {
+				t.Errorf("letter=Z: got %v, want none", got)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/authors?letter=ab", nil))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("letter=ab: expected 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestAPIRelatedTags(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			tagged := func(name string, tags ...string) {
+				t.Helper()
+				bk := uploadBook(t, srv, name+".epub", name, "Author")
+				body, _ := json.Marshal(map[string]any{"tags": tags})
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/api/books/"+bk.ID, bytes.NewReader(body)))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("PATCH %s: expected 200, got %d: %s", name, rr.Code, rr.Body.String())
+				}
+			}
+			tagged("One", "Fantasy", "Dragons", "Magic")
+			tagged("Two", "Fantasy", "Magic", "Epic")
+			tagged("Three", "Fantasy", "Magic")
+			tagged("Four", "Fantasy", "Dragons")
+			tagged("Five", "Science", "Space")
+
+			related := func(target string) []map[string]any {
+				t.Helper()
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("GET %s: expected 200, got %d: %s", target, rr.Code, rr.Body.String())
+				}
+				var got []map[string]any
+				if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+					t.Fatalf("GET %s: invalid JSON: %v", target, err)
+				}
+				return got
+			}
+
+			got := related("/api/tags/Fantasy/related")
+			want := []map[string]any{
+				{"name": "Magic", "count": float64(3)},
+				{"name": "Dragons", "count": float64(2)},
+				{"name": "Epic", "count": float64(1)},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("related to Fantasy: got %v, want %v", got, want)
+			}
+			if got := related("/api/tags/Fantasy/related?limit=1"); len(got) != 1 || got[0]["name"] != "Magic" {
+				t.Errorf("limit=1: got %v, want [Magic]", got)
+			}
+			if got := related("/api/tags/Unknown/related"); len(got) != 0 {
+				t.Errorf("unknown tag: got %v, want []", got)
+			}
+		})
+	}
+}
+
+func TestAPIProgress(t *testing.T) {
+	backend, err := sqlitebackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+	bk := uploadBook(t, srv, "progress.epub", "Progress Book", "Author")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	position := func(rr *httptest.ResponseRecorder) float64 {
+		t.Helper()
+		var resp progressJSON
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode progress: %v", err)
+		}
+		return resp.Position
+	}
+
+	path := "/api/books/" + bk.ID + "/progress"
+	if rr := do(http.MethodGet, path, ""); rr.Code != http.StatusOK || position(rr) != 0 {
+		t.Fatalf("initial GET: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPut, path, `{"position":0.42}`); rr.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, path, ""); position(rr) != 0.42 {
+		t.Errorf("GET after PUT: got %s", rr.Body.String())
+	}
+
+	for _, body := range []string{`{"position":1.5}`, `{"position":-0.1}`, `{}`, `not json`} {
+		if rr := do(http.MethodPut, path, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: expected 400, got %d", body, rr.Code)
+		}
+	}
+	if rr := do(http.MethodGet, path, ""); position(rr) != 0.42 {
+		t.Errorf("rejected PUT changed the position: %s", rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/books/missing/progress", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown book: expected 404, got %d", rr.Code)
+	}
+
+	fsSrv := newTestServer(t, Options{})
+	fsBook := uploadBook(t, fsSrv, "fs.epub", "FS Book", "Author")
+	req := httptest.NewRequest(http.MethodGet, "/api/books/"+fsBook.ID+"/progress", nil)
+	rr := httptest.NewRecorder()
+	fsSrv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("fs backend: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestAPIResetPersonal(t *testing.T) {
+	backends := map[string]func(t *testing.T) *Server{
+		"fs": func(t *testing.T) *Server { return newTestServer(t, Options{}) },
+		"sqlite": func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		},
+	}
+	for name, newServer := range backends {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t)
+			do := func(method, path, body string) *httptest.ResponseRecorder {
+				t.Helper()
+				req := httptest.NewRequest(method, path, strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				return rr
+			}
+
+			rated := uploadBook(t, srv, "rated.epub", "Rated Book", "Ann Author")
+			untouched := uploadBook(t, srv, "plain.epub", "Plain Book", "Bob Author")
+			if rr := do(http.MethodPatch, "/api/books/"+rated.ID, `{"isRead":true,"rating":5}`); rr.Code != http.StatusOK {
+				t.Fatalf("PATCH: got %d: %s", rr.Code, rr.Body.String())
+			}
+			hasProgress := srv.progressTracker != nil
+			if hasProgress {
+				if rr := do(http.MethodPut, "/api/books/"+untouched.ID+"/progress", `{"position":0.5}`); rr.Code != http.StatusOK {
+					t.Fatalf("PUT progress: got %d", rr.Code)
+				}
+			}
+
+			for _, body := range []string{``, `{}`, `{"confirm":false}`} {
+				if rr := do(http.MethodPost, "/api/maintenance/reset-personal", body); rr.Code != http.StatusBadRequest {
+					t.Errorf("body %q: expected 400, got %d", body, rr.Code)
+				}
+			}
+			rr := do(http.MethodPost, "/api/maintenance/reset-personal", `{"confirm":true}`)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("reset: expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var resp struct {
+				Reset int `json:"reset"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			wantReset := 1
+			if hasProgress {
+				wantReset = 2
+			}
+			if resp.Reset != wantReset {
+				t.Errorf("reset count: got %d, want %d", resp.Reset, wantReset)
+			}
+
+			var bk bookJSON
+			if err := json.Unmarshal(do(http.MethodGet, "/api/books/"+rated.ID, "").Body.Bytes(), &bk); err != nil {
+				t.Fatalf("decode book: %v", err)
+			}
+			if bk.IsRead || bk.ReadAt != nil || bk.Rating != 0 {
+				t.Errorf("personal fields not cleared: isRead=%v readAt=%v rating=%d", bk.IsRead, bk.ReadAt, bk.Rating)
+			}
+			if bk.Title != "Rated Book" || len(bk.Authors) != 1 || bk.Authors[0] != "Ann Author" {
+				t.Errorf("metadata changed: title %q, authors %v", bk.Title, bk.Authors)
+			}
+			if hasProgress {
+				if rr := do(http.MethodGet, "/api/books/"+untouched.ID+"/progress", ""); !strings.Contains(rr.Body.String(), `"position":0`) {
+					t.Errorf("progress not cleared: %s", rr.Body.String())
+				}
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	New(noRefreshCatalog{}, Options{}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/maintenance/reset-personal", strings.NewReader(`{"confirm":true}`)))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("unsupported backend: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestAPIPurgeCovers(t *testing.T) {
+	backends := map[string]func(t *testing.T, dir string) *Server{
+		"fs": func(t *testing.T, dir string) *Server {
+			backend, err := fsbackend.New(dir)
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return New(backend, Options{})
+		},
+		"sqlite": func(t *testing.T, dir string) *Server {
+			backend, err := sqlitebackend.New(dir)
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		},
+	}
+	for name, newServer := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			srv := newServer(t, dir)
+			bk := uploadBook(t, srv, "kept.epub", "Kept Book", "Ann Author")
+
+			coversDir := filepath.Join(dir, ".covers")
+			if err := os.MkdirAll(coversDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			files := []string{bk.ID + ".png", bk.ID + ".thumb.jpg", "0123456789abcdef.jpg", "0123456789abcdef.thumb.jpg", "README.txt"}
+			for _, name := range files {
+				if err := os.WriteFile(filepath.Join(coversDir, name), []byte("x"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			purge := func(path string) int {
+				t.Helper()
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("POST %s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+				}
+				var resp struct {
+					Removed int `json:"removed"`
+				}
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				return resp.Removed
+			}
+
+			if n := purge("/api/maintenance/purge-covers?dryRun=1"); n != 2 {
+				t.Errorf("dry run: got %d, want 2", n)
+			}
+			if _, err := os.Stat(filepath.Join(coversDir, "0123456789abcdef.jpg")); err != nil {
+				t.Errorf("dry run removed a file: %v", err)
+			}
+			if n := purge("/api/maintenance/purge-covers"); n != 2 {
+				t.Errorf("purge: got %d, want 2", n)
+			}
+			for i, name := range files {
+				_, err := os.Stat(filepath.Join(coversDir, name))
+				if orphan := i == 2 || i == 3; orphan != os.IsNotExist(err) {
+					t.Errorf("%s: orphan=%v, stat error %v", name, orphan, err)
+				}
+			}
+			if n := purge("/api/maintenance/purge-covers"); n != 0 {
+				t.Errorf("second purge: got %d, want 0", n)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	New(noRefreshCatalog{}, Options{}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/maintenance/purge-covers", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("unsupported backend: expected 501, got %d", rr.Code)
+	}
+}
+
+func TestHandleCover_ExternalCoversDir(t *testing.T) {
+	booksDir := t.TempDir()
+	coversDir := filepath.Join(t.TempDir(), "covers")
+	backend, err := fsbackend.NewWithOptions(booksDir, fsbackend.Options{CoversDir: coversDir})
+	if err != nil {
+		t.Fatalf("backend.NewWithOptions: %v", err)
+	}
+	srv := New(backend, Options{})
+	bk := uploadBook(t, srv, "external.epub", "External Covers", "Author")
+
+	cover := []byte("\x89PNG\r\n\x1a\nexternal-cover")
+	postCover(t, srv, bk.ID, cover)
+
+	if _, err := os.Stat(filepath.Join(coversDir, bk.ID+".png")); err != nil {
+		t.Errorf("expected cover in external covers dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(booksDir, ".covers")); !os.IsNotExist(err) {
+		t.Errorf("expected no .covers dir inside books dir, stat err = %v", err)
+	}
+
+	rr := getCover(srv, bk.ID, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if rr.Body.String() != string(cover) {
+		t.Errorf("served cover does not match uploaded cover")
+	}
+}
+
+// ---- robots.txt / private catalogs ----
+
+func TestHandleRobots_DefaultDisallowsAll(t *testing.T) {
+	// robots.txt must be reachable without credentials.
+	srv := newTestServer(t, Options{Password: "secret"})
+	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected text/plain, got %q", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "Disallow: /") {
+		t.Errorf("expected Disallow: / in body, got %q", rr.Body.String())
+	}
+}
+
+func TestHandleRobots_Custom(t *testing.T) {
+	custom := "User-agent: *\nAllow: /\n"
+	srv := newTestServer(t, Options{RobotsTxt: custom})
+	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if rr.Body.String() != custom {
+		t.Errorf("expected custom robots.txt %q, got %q", custom, rr.Body.String())
+	}
+}
+
+func TestPrivate_AddsNoindexHeader(t *testing.T) {
+	for _, path := range []string{"/opds", "/api/books", "/robots.txt"} {
+		srv := newTestServer(t, Options{Private: true})
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if got := rr.Header().Get("X-Robots-Tag"); !strings.Contains(got, "noindex") {
+			t.Errorf("%s: expected X-Robots-Tag noindex, got %q", path, got)
+		}
+	}
+
+	srv := newTestServer(t, Options{})
+	req := httptest.NewRequest(http.MethodGet, "/opds", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if got := rr.Header().Get("X-Robots-Tag"); got != "" {
+		t.Errorf("expected no X-Robots-Tag when not private, got %q", got)
+	}
+}
+
+// ---- Sort by size ----
+
+// paddedEPUB returns an EPUB whose size grows with n by embedding n bytes of
+// incompressible padding in an XML comment.
+func paddedEPUB(t *testing.T, title string, n int) []byte {
+	t.Helper()
+	pad := make([]byte, n)
+	if _, err := rand.Read(pad); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	return buildEPUBBytesWithMetadata(title, "Author", "<!--"+hex.EncodeToString(pad)+"-->")
+}
+
+func TestHandleAPIBooks_SortBySize(t *testing.T) {
+	backends := map[string]func(t *testing.T) *Server{
+		"fs": func(t *testing.T) *Server { return newTestServer(t, Options{}) },
+		"sqlite": func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		},
+	}
+	for name, newServer := range backends {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t)
+			uploadFile(t, srv, "medium.epub", paddedEPUB(t, "Medium", 4000))
+			uploadFile(t, srv, "small.epub", paddedEPUB(t, "Small", 10))
+			uploadFile(t, srv, "large.epub", paddedEPUB(t, "Large", 16000))
+
+			for sortParam, want := range map[string][]string{
+				"size_desc": {"Large", "Medium", "Small"},
+				"size_asc":  {"Small", "Medium", "Large"},
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/api/books?sort="+sortParam, nil)
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				var resp struct {
+					Books []bookJSON `json:"books"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				var got []string
+				for _, b := range resp.Books {
+					got = append(got, b.Title)
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("sort=%s: got %v, want %v", sortParam, got, want)
+				}
+				if len(resp.Books) > 0 && resp.Books[0].Size == 0 {
+					t.Errorf("sort=%s: expected non-zero size in response", sortParam)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleAPIBooks_RatingFilterAndSort(t *testing.T) {
+	backends := map[string]func(t *testing.T) *Server{
+		"fs": func(t *testing.T) *Server { return newTestServer(t, Options{}) },
+		"sqlite": func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		},
+	}
+	for name, newServer := range backends {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t)
+			for title, rating := range map[string]int{"Good": 4, "Best": 5, "Meh": 2, "Unrated": 0} {
+				bk := uploadBook(t, srv, strings.ToLower(title)+".epub", title, "Author")
+				if rating == 0 {
+					continue
+				}
+				req := httptest.NewRequest(http.MethodPatch, "/api/books/"+bk.ID, strings.NewReader(fmt.Sprintf(`{"rating":%d}`, rating)))
+				req.Header.Set("Content-Type", "application/json")
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("rate %s: got %d: %s", title, rr.Code, rr.Body.String())
+				}
+			}
+
+			for query, want := range map[string][]string{
+				"sort=rating_desc":             {"Best", "Good", "Meh", "Unrated"},
+				"sort=rating_asc":              {"Unrated", "Meh", "Good", "Best"},
+				"minRating=4&sort=rating_desc": {"Best", "Good"},
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/api/books?"+query, nil)
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				var resp struct {
+					Books []bookJSON `json:"books"`
+					Total int        `json:"total"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				var got []string
+				for _, b := range resp.Books {
+					got = append(got, b.Title)
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") || resp.Total != len(want) {
+					t.Errorf("%s: got %v (total %d), want %v", query, got, resp.Total, want)
+				}
+			}
+		})
+	}
+}
+
+// ---- Tag hierarchy ----
+
+// getFeed fetches path and decodes the OPDS 1.x feed, failing on non-200.
+func getFeed(t *testing.T, srv *Server, path string) opds.Feed {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected 200, got %d: %s", path, rr.Code, rr.Body.String())
+	}
+	var feed opds.Feed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("GET %s: invalid XML: %v", path, err)
+	}
+	return feed
+}
+
+func entryTitles(feed opds.Feed) []string {
+	var titles []string
+	for _, e := range feed.Entries {
+		titles = append(titles, e.Title.Value)
+	}
+	return titles
+}
+
+func TestHandleTags_Hierarchy(t *testing.T) {
+	srv := newTestServer(t, Options{TagSeparator: ">"})
+	uploadFile(t, srv, "neuro.epub", buildEPUBBytesWithMetadata("Neuromancer", "Gibson", "<dc:subject>Fiction > SciFi</dc:subject>"))
+	uploadFile(t, srv, "dune.epub", buildEPUBBytesWithMetadata("Dune", "Herbert", "<dc:subject>Fiction > SciFi</dc:subject>"))
+	uploadFile(t, srv, "cook.epub", buildEPUBBytesWithMetadata("Cookbook", "Chef", "<dc:subject>Cooking</dc:subject>"))
+
+	top := getFeed(t, srv, "/opds/tags")
+	if got := strings.Join(entryTitles(top), ","); got != "Cooking,Fiction" {
+		t.Fatalf("top-level genres: got %q, want %q", got, "Cooking,Fiction")
+	}
+
+	var fictionHref string
+	for _, e := range top.Entries {
+		if e.Title.Value == "Fiction" {
+			fictionHref = e.Links[0].Href
+			if e.Links[0].Type != opds.MIMENavigationFeed {
+				t.Errorf("Fiction link type: got %q, want navigation feed", e.Links[0].Type)
+			}
+		}
+	}
+	fiction := getFeed(t, srv, fictionHref)
+	if got := strings.Join(entryTitles(fiction), ","); got != "SciFi" {
+		t.Fatalf("Fiction subgenres: got %q, want %q", got, "SciFi")
+	}
+
+	scifi := getFeed(t, srv, fiction.Entries[0].Links[0].Href)
+	if len(scifi.Entries) != 2 {
+		t.Fatalf("SciFi books: expected 2 entries, got %v", entryTitles(scifi))
+	}
+
+	cooking := getFeed(t, srv, "/opds/tags/Cooking")
+	if got := strings.Join(entryTitles(cooking), ","); got != "Cookbook" {
+		t.Errorf("Cooking books: got %q, want %q", got, "Cookbook")
+	}
+}
+
+// ---- Browse slugs ----
+
+func TestBrowseFeeds_SlugsForAwkwardNames(t *testing.T) {
+	const author = "AC/DC 🎸 / Bon Scott"
+	const tag = "Rock/Metal 🤘"
+	srv := newTestServer(t, Options{})
+	uploadFile(t, srv, "hell.epub", buildEPUBBytesWithMetadata("Highway to Hell", author, "<dc:subject>"+tag+"</dc:subject>"))
+	uploadBook(t, srv, "other.epub", "Other", "Someone Else")
+
+	for _, tc := range []struct{ nav, name string }{
+		{"/opds/authors", author},
+		{"/opds/tags", tag},
+	} {
+		var href string
+		for _, e := range getFeed(t, srv, tc.nav).Entries {
+			if e.Title.Value == tc.name+" (1)" {
+				href = e.Links[0].Href
+			}
+		}
+		if href == "" {
+			t.Fatalf("%s: no entry titled %q", tc.nav, tc.name)
+		}
+		if strings.Count(href, "/") != 3 || strings.Contains(href, "%") {
+			t.Errorf("%s: expected a single plain slug segment, got %q", tc.nav, href)
+		}
+		feed := getFeed(t, srv, href)
+		if got := strings.Join(entryTitles(feed), ","); got != "Highway to Hell" {
+			t.Errorf("GET %s: got %q, want %q", href, got, "Highway to Hell")
+		}
+		if !strings.Contains(feed.Title.Value, tc.name) {
+			t.Errorf("GET %s: title %q should show the display name %q", href, feed.Title.Value, tc.name)
+		}
+	}
+}
+
+func TestNameSlug(t *testing.T) {
+	long := strings.Repeat("Very Long Author Name ", 20)
+	for _, tc := range []struct{ name, prefix string }{
+		{"Isaac Asimov", "isaac-asimov-"},
+		{"AC/DC 🎸", "ac-dc-"},
+		{"🎸🤘", ""},
+		{long, "very-long-author-name-very-long-author-n-"},
+	} {
+		slug := nameSlug(tc.name)
+		if !strings.HasPrefix(slug, tc.prefix) || len(slug) != len(tc.prefix)+12 {
+			t.Errorf("nameSlug(%q) = %q, want prefix %q plus a 12-character hash", tc.name, slug, tc.prefix)
+		}
+		if nameSlug(tc.name) != slug {
+			t.Errorf("nameSlug(%q) is not stable", tc.name)
+		}
+	}
+	if nameSlug("AC/DC") == nameSlug("AC DC") {
+		t.Error("names with the same readable prefix must get distinct slugs")
+	}
+}
+
+func TestSlugIndex_ReloadsOnlyAfterChanges(t *testing.T) {
+	var x slugIndex
+	version := time.Unix(1, 0)
+	names := []string{"Isaac Asimov"}
+	loads := 0
+	resolve := func(slug string) (string, bool) {
+		t.Helper()
+		name, ok, err := x.resolve(slug, func() time.Time { return version }, func() ([]string, error) {
+			loads++
+			return names, nil
+		})
+		if err != nil {
+			t.Fatalf("resolve(%q): %v", slug, err)
+		}
+		return name, ok
+	}
+
+	if name, ok := resolve(nameSlug("Isaac Asimov")); !ok || name != "Isaac Asimov" {
+		t.Fatalf("known slug: got %q, %v", name, ok)
+	}
+	for range 3 {
+		if _, ok := resolve("Isaac%20Asimov"); ok {
+			t.Error("raw name resolved as a slug")
+		}
+	}
+	if loads != 1 {
+		t.Errorf("misses on an unchanged catalog: %d loads, want 1", loads)
+	}
+
+	names = append(names, "Ursula K. Le Guin")
+	version = time.Unix(2, 0)
+	if name, ok := resolve(nameSlug("Ursula K. Le Guin")); !ok || name != "Ursula K. Le Guin" {
+		t.Errorf("name added since the last load: got %q, %v", name, ok)
+	}
+	if loads != 2 {
+		t.Errorf("after a catalog change: %d loads, want 2", loads)
+	}
+}
+
+// ---- Identifiers ----
+
+func TestISBN_SearchableAndExposed(t *testing.T) {
+	backend, err := sqlitebackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+
+	bk := uploadFile(t, srv, "etranger.epub", buildEPUBBytesWithMetadata("L'Étranger", "Camus",
+		`<dc:identifier xmlns:opf="http://www.idpf.org/2007/opf" opf:scheme="ISBN">978-2-07-036002-4</dc:identifier>`))
+	uploadBook(t, srv, "other.epub", "Other", "Someone")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/books/"+bk.ID, nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var j bookJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatalf("decode book: %v", err)
+	}
+	if j.ISBN != "978-2-07-036002-4" {
+		t.Errorf("isbn: got %q, want %q", j.ISBN, "978-2-07-036002-4")
+	}
+
+	for _, q := range []string{"978-2-07-036002-4", "9782070360024"} {
+		feed := getFeed(t, srv, "/opds/search?q="+q)
+		if got := strings.Join(entryTitles(feed), ","); got != "L'Étranger" {
+			t.Errorf("search %q: got %q, want %q", q, got, "L'Étranger")
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/opds/v2/publications", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var feed opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	var found bool
+	for _, p := range feed.Publications {
+		found = found || p.Metadata.Identifier == "urn:isbn:978-2-07-036002-4"
+	}
+	if !found {
+		t.Error("OPDS 2.0: expected a publication identified by its ISBN")
+	}
+}
+
+// ---- Sort names ----
+
+func TestAuthors_SortByFileAs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			// EPUB3 refinement: "Zadie Smith" files under S.
+			uploadFile(t, srv, "smith.epub", buildEPUBBytesFromMetadata(`
+    <dc:title id="t">The Apple Tree</dc:title>
+    <meta refines="#t" property="file-as">Apple Tree, The</meta>
+    <dc:creator id="a">Zadie Smith</dc:creator>
+    <meta refines="#a" property="file-as">Smith, Zadie</meta>`))
+			// EPUB2 attribute: "Anne Tyler" files under T.
+			uploadFile(t, srv, "tyler.epub", buildEPUBBytesFromMetadata(`
+    <dc:title>Breathing Lessons</dc:title>
+    <dc:creator opf:file-as="Tyler, Anne">Anne Tyler</dc:creator>`))
+			uploadBook(t, srv, "moore.epub", "Moon Tiger", "Moore Penelope")
+
+			authors := getFeed(t, srv, "/opds/authors")
+			want := "Moore Penelope (1),Zadie Smith (1),Anne Tyler (1)"
+			if got := strings.Join(entryTitles(authors), ","); got != want {
+				t.Errorf("authors: got %q, want %q", got, want)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/books?sort=title_asc", nil)
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			var resp struct {
+				Books []bookJSON `json:"books"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode books: %v", err)
+			}
+			var titles []string
+			for _, b := range resp.Books {
+				titles = append(titles, b.Title)
+			}
+			if got := strings.Join(titles, ","); got != "The Apple Tree,Breathing Lessons,Moon Tiger" {
+				t.Errorf("titles: got %q, want sorted by file-as", got)
+			}
+		})
+	}
+}
+
+func TestNavigationFeeds_BookCounts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			uploadFile(t, srv, "dune.epub", buildEPUBBytesWithMetadata("Dune", "Frank Herbert", "<dc:subject>Fiction</dc:subject>"))
+			uploadFile(t, srv, "messiah.epub", buildEPUBBytesWithMetadata("Dune Messiah", "Frank Herbert", "<dc:subject>Fiction</dc:subject>"))
+			uploadFile(t, srv, "cook.epub", buildEPUBBytesWithMetadata("Cookbook", "Julia Child", "<dc:subject>Cooking</dc:subject>"))
+
+			for _, nav := range []struct {
+				path string
+				want map[string]int
+			}{
+				{"/opds/authors", map[string]int{"Frank Herbert (2)": 2, "Julia Child (1)": 1}},
+				{"/opds/tags", map[string]int{"Fiction (2)": 2, "Cooking (1)": 1}},
+			} {
+				feed := getFeed(t, srv, nav.path)
+				if len(feed.Entries) != len(nav.want) {
+					t.Fatalf("%s: got %q, want %d entries", nav.path, entryTitles(feed), len(nav.want))
+				}
+				for _, e := range feed.Entries {
+					count, ok := nav.want[e.Title.Value]
+					if !ok {
+						t.Errorf("%s: unexpected entry title %q", nav.path, e.Title.Value)
+						continue
+					}
+					if e.Links[0].Count != count {
+						t.Errorf("%s: %q link count = %d, want %d", nav.path, e.Title.Value, e.Links[0].Count, count)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAuthors_MergeSpellingVariants(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			uploadBook(t, srv, "hobbit.epub", "The Hobbit", "J.R.R. Tolkien")
+			uploadBook(t, srv, "silmarillion.epub", "The Silmarillion", "j. r. r.  Tolkien ")
+
+			authors := getFeed(t, srv, "/opds/authors")
+			if len(authors.Entries) != 1 {
+				t.Fatalf("authors: got %q, want one entry", entryTitles(authors))
+			}
+			var href string
+			for _, l := range authors.Entries[0].Links {
+				if l.Rel == opds.RelCatalogNavigation {
+					href = l.Href
+				}
+			}
+			books := getFeed(t, srv, href)
+			if got := entryTitles(books); len(got) != 2 {
+				t.Errorf("books of %q: got %q, want both spellings", authors.Entries[0].Title.Value, got)
+			}
+		})
+	}
+}
+
+func TestAPIAuthorAlias(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			uploadBook(t, srv, "stone.epub", "Philosopher's Stone", "J.K. Rowling")
+			uploadBook(t, srv, "cuckoo.epub", "The Cuckoo's Calling", "Robert Galbraith")
+			uploadBook(t, srv, "hobbit.epub", "The Hobbit", "J.R.R. Tolkien")
+
+			post := func(body string) int {
+				t.Helper()
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/authors/alias", strings.NewReader(body)))
+				return rr.Code
+			}
+			if code := post(`{"alias":"","author":"J.K. Rowling"}`); code != http.StatusBadRequest {
+				t.Errorf("empty alias: expected 400, got %d", code)
+			}
+			if code := post(`{"alias":"j.k. rowling","author":"J.K. Rowling"}`); code != http.StatusBadRequest {
+				t.Errorf("alias of itself: expected 400, got %d", code)
+			}
+			if code := post(`{"alias":"Robert Galbraith","author":"J.K. Rowling"}`); code != http.StatusOK {
+				t.Fatalf("set alias: expected 200, got %d", code)
+			}
+
+			authors := getFeed(t, srv, "/opds/authors")
+			if got := entryTitles(authors); strings.Join(got, "|") != "J.K. Rowling (2)|J.R.R. Tolkien (1)" {
+				t.Fatalf("authors: got %q, want the alias grouped under J.K. Rowling", got)
+			}
+			for _, name := range []string{"J.K. Rowling", "Robert Galbraith"} {
+				books := getFeed(t, srv, "/opds/authors/"+url.PathEscape(name))
+				if got := entryTitles(books); len(got) != 2 {
+					t.Errorf("books of %q: got %q, want both names' books", name, got)
+				}
+				for _, e := range books.Entries {
+					if e.Title.Value == "The Cuckoo's Calling" && (len(e.Authors) != 1 || e.Authors[0].Name != "Robert Galbraith") {
+						t.Errorf("aliased book should keep its printed author, got %+v", e.Authors)
+					}
+				}
+			}
+
+			if code := post(`{"alias":"Robert Galbraith","author":""}`); code != http.StatusOK {
+				t.Fatalf("remove alias: expected 200, got %d", code)
+			}
+			if got := entryTitles(getFeed(t, srv, "/opds/authors")); len(got) != 3 {
+				t.Errorf("authors after removing the alias: got %q, want 3", got)
+			}
+		})
+	}
+}
+
+// ---- Duplicate merge ----
+
+func TestHandleAPIMergeBooks(t *testing.T) {
+	backend, err := sqlitebackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+
+	target := uploadFile(t, srv, "dup.epub", buildEPUBBytesWithMetadata("Duplicate", "Author", "<dc:subject>Fantasy</dc:subject>"))
+	source := uploadFile(t, srv, "dup-copy.epub", buildEPUBBytesWithMetadata("Duplicate", "Author",
+		"<dc:subject>Adventure</dc:subject><dc:publisher>Acme</dc:publisher>"))
+
+	body := strings.NewReader(`{"sourceId":"` + source.ID + `"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/books/"+target.ID+"/duplicate-merge", body)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merge: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	merged, err := backend.BookByID(target.ID)
+	if err != nil {
+		t.Fatalf("BookByID(target): %v", err)
+	}
+	if len(merged.Files) != 2 {
+		t.Errorf("expected 2 files after merge, got %d: %+v", len(merged.Files), merged.Files)
+	}
+	if merged.Publisher != "Acme" {
+		t.Errorf("expected publisher carried over from source, got %q", merged.Publisher)
+	}
+	if strings.Join(merged.Tags, ",") != "Adventure,Fantasy" && strings.Join(merged.Tags, ",") != "Fantasy,Adventure" {
+		t.Errorf("expected union of tags, got %v", merged.Tags)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/books/"+source.ID, nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("source book: expected 404 after merge, got %d", rr.Code)
+	}
+
+	// A rescan must not resurrect the source as a separate book.
+	if err := backend.Refresh(); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, total, _ := backend.AllBooks(0, 10); total != 1 {
+		t.Errorf("expected 1 book after refresh, got %d", total)
+	}
+
+	// Both formats remain downloadable.
+	for _, f := range merged.Files {
+		req = httptest.NewRequest(http.MethodGet, "/opds/books/"+target.ID+"/download?path="+url.QueryEscape(f.Path), nil)
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("download %s: expected 200, got %d", filepath.Base(f.Path), rr.Code)
+		}
+	}
+}
+
+func TestHandleAPIMergeBooks_Errors(t *testing.T) {
+	backend, err := sqlitebackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	srv := New(backend, Options{})
+	bk := uploadBook(t, srv, "dune.epub", "Dune", "Herbert")
+
+	for _, tc := range []struct {
+		target, source string
+		want           int
+	}{
+		{"missing", bk.ID, http.StatusNotFound},
+		{bk.ID, "missing", http.StatusNotFound},
+		{bk.ID, bk.ID, http.StatusUnprocessableEntity},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/books/"+tc.target+"/duplicate-merge", strings.NewReader(`{"sourceId":"`+tc.source+`"}`))
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("merge %s into %s: expected %d, got %d: %s", tc.source, tc.target, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestHandleAPIMergeBooks_NotSupported(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	req := httptest.NewRequest(http.MethodPost, "/api/books/a/duplicate-merge", strings.NewReader(`{"sourceId":"b"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("expected 501, got %d", rr.Code)
+	}
+}
+
+// ---- Reading lists ----
+
+func TestReadingLists(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srv  func(t *testing.T) *Server
+	}{
+		{"fs", func(t *testing.T) *Server { return newTestServer(t, Options{}) }},
+		{"sqlite", func(t *testing.T) *Server {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return New(backend, Options{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := tc.srv(t)
+			dune := uploadBook(t, srv, "dune.epub", "Dune", "Herbert")
+			uploadBook(t, srv, "other.epub", "Not Listed", "Nobody")
+			neuro := uploadBook(t, srv, "neuro.epub", "Neuromancer", "Gibson")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/lists", strings.NewReader(`{"name":"Summer reading"}`))
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("create list: expected 201, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var created listJSON
+			if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+				t.Fatalf("decode list: %v", err)
+			}
+			if created.ID == "" || created.Name != "Summer reading" {
+				t.Fatalf("unexpected list: %+v", created)
+			}
+
+			// Neuromancer is added first; re-adding it must not duplicate it.
+			for _, id := range []string{neuro.ID, dune.ID, neuro.ID} {
+				req = httptest.NewRequest(http.MethodPost, "/api/lists/"+created.ID+"/books", strings.NewReader(`{"bookId":"`+id+`"}`))
+				rr = httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("add %s: expected 200, got %d: %s", id, rr.Code, rr.Body.String())
+				}
+			}
+
+			feed := getFeed(t, srv, "/opds/lists/"+created.ID)
+			if got := strings.Join(entryTitles(feed), ","); got != "Neuromancer,Dune" {
+				t.Errorf("list books: got %q, want %q", got, "Neuromancer,Dune")
+			}
+
+			// A limit of 0 means no limit on both backends.
+			all, total, err := srv.listManager.ListBooks(created.ID, 0, 0)
+			if err != nil {
+				t.Fatalf("ListBooks: %v", err)
+			}
+			if len(all) != 2 || total != 2 {
+				t.Errorf("ListBooks with limit 0: got %d books (total %d), want 2", len(all), total)
+			}
+
+			lists := getFeed(t, srv, "/opds/lists")
+			if len(lists.Entries) != 1 || lists.Entries[0].Links[0].Count != 2 {
+				t.Errorf("lists feed: expected one list with 2 books, got %+v", lists.Entries)
+			}
+
+			var found bool
+			for _, e := range getFeed(t, srv, "/opds").Entries {
+				found = found || e.ID == "urn:nxt-opds:lists"
+			}
+			if !found {
+				t.Error("root feed: expected a reading lists entry")
+			}
+		})
+	}
+}
+
+func TestHandleAPIAddToList_Errors(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	req := httptest.NewRequest(http.MethodPost, "/api/lists", strings.NewReader(`{"name":"Favourites"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var created listJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/api/lists/missing/books", `{"bookId":"x"}`, http.StatusNotFound},
+		{"/api/lists/" + created.ID + "/books", `{"bookId":"missing"}`, http.StatusNotFound},
+		{"/api/lists/" + created.ID + "/books", `{}`, http.StatusBadRequest},
+	} {
+		req = httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("POST %s %s: expected %d, got %d", tc.path, tc.body, tc.want, rr.Code)
+		}
+	}
+}
+
+// ---- EPUB resources ----
+
+// buildEPUBWithResources returns an EPUB containing the given extra entries
+// alongside a minimal container.xml and OPF.
+func buildEPUBWithResources(t *testing.T, title string, resources map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	entries := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>` + title + `</dc:title></metadata>
+</package>`,
+	}
+	for name, body := range resources {
+		entries[name] = body
+	}
+	for name, body := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip create: %v", err)
+		}
+		_, _ = w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleAPIBookResource(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	chapter := `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Hello</p></body></html>`
+	image := "\x89PNG\r\n\x1a\nimage-bytes"
+	bk := uploadFile(t, srv, "reader.epub", buildEPUBWithResources(t, "Reader", map[string]string{
+		"OEBPS/chapter1.xhtml":  chapter,
+		"OEBPS/images/pic.png":  image,
+		"OEBPS/styles/book.css": "p { margin: 0 }",
+	}))
+
+	cases := []struct {
+		path       string
+		wantStatus int
+		wantType   string
+		wantBody   string
+	}{
+		{"OEBPS/chapter1.xhtml", http.StatusOK, "application/xhtml+xml", chapter},
+		{"OEBPS/images/pic.png", http.StatusOK, "image/png", image},
+		{"OEBPS/styles/book.css", http.StatusOK, "text/css", "p { margin: 0 }"},
+		{"OEBPS/text/../chapter1.xhtml", http.StatusOK, "application/xhtml+xml", chapter},
+		{"OEBPS/missing.xhtml", http.StatusNotFound, "", ""},
+		{"../../etc/passwd", http.StatusBadRequest, "", ""},
+		{"/etc/passwd", http.StatusBadRequest, "", ""},
+		{"", http.StatusBadRequest, "", ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/books/"+bk.ID+"/resource?path="+url.QueryEscape(tc.path), nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.wantStatus {
+			t.Errorf("path %q: expected %d, got %d", tc.path, tc.wantStatus, rr.Code)
+			continue
+		}
+		if tc.wantStatus != http.StatusOK {
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.wantType) {
+			t.Errorf("path %q: Content-Type %q, want %q", tc.path, ct, tc.wantType)
+		}
+		if rr.Body.String() != tc.wantBody {
+			t.Errorf("path %q: body mismatch", tc.path)
+		}
+	}
+}
+
+func TestHandleAPIBookManifest(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	chapter := func(n int) string {
+		return fmt.Sprintf(`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Chapter %d</p></body></html>`, n)
+	}
+	bk := uploadFile(t, srv, "manifest.epub", buildEPUBWithResources(t, "Manifest", map[string]string{
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Manifest Book</dc:title><dc:creator>Jane Doe</dc:creator></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="c1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c3" href="text/ch3.xhtml" media-type="application/xhtml+xml"/>
+    <item id="css" href="styles/book.css" media-type="text/css"/>
+    <item id="cover" href="images/cover.png" media-type="image/png" properties="cover-image"/>
+  </manifest>
+  <spine><itemref idref="c1"/><itemref idref="c2"/><itemref idref="c3"/></spine>
+</package>`,
+		"OEBPS/nav.xhtml":        `<html/>`,
+		"OEBPS/text/ch1.xhtml":   chapter(1),
+		"OEBPS/text/ch2.xhtml":   chapter(2),
+		"OEBPS/text/ch3.xhtml":   chapter(3),
+		"OEBPS/styles/book.css":  "p { margin: 0 }",
+		"OEBPS/images/cover.png": "\x89PNG\r\n\x1a\n",
+	}))
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books/"+bk.ID+"/manifest.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != opds2.MIMEManifest {
+		t.Errorf("Content-Type %q, want %q", ct, opds2.MIMEManifest)
+	}
+	var m opds2.Manifest
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if m.Context != opds2.ManifestContext || m.Metadata.Title != "Manifest Book" {
+		t.Errorf("context/title: got %q / %q", m.Context, m.Metadata.Title)
+	}
+	if len(m.ReadingOrder) != 3 {
+		t.Fatalf("readingOrder: got %d items, want 3: %+v", len(m.ReadingOrder), m.ReadingOrder)
+	}
+	// Every readingOrder href serves its chapter, in spine order.
+	for i, l := range m.ReadingOrder {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, l.Href, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != chapter(i+1) {
+			t.Errorf("readingOrder[%d] %s: got %d %q", i, l.Href, rr.Code, rr.Body.String())
+		}
+		if l.Type != "application/xhtml+xml" {
+			t.Errorf("readingOrder[%d] type: got %q", i, l.Type)
+		}
+	}
+	var cover, nav bool
+	for _, l := range m.Resources {
+		switch l.Rel {
+		case "cover":
+			cover = l.Type == "image/png"
+		case "contents":
+			nav = true
+		}
+	}
+	if len(m.Resources) != 3 || !cover || !nav {
+		t.Errorf("resources: got %+v, want the nav, stylesheet and cover", m.Resources)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books/missing/manifest.json", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown book: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleAllBooks_MaxFeedBytes(t *testing.T) {
+	const maxBytes = 6000
+	srv := newTestServer(t, Options{MaxFeedBytes: maxBytes})
+	summary := strings.Repeat("A very long summary. ", 60)
+	for i := 0; i < 10; i++ {
+		title := fmt.Sprintf("Book %02d", i)
+		uploadFile(t, srv, fmt.Sprintf("book%02d.epub", i),
+			buildEPUBBytesWithMetadata(title, "Author", "<dc:description>"+summary+"</dc:description>"))
+	}
+
+	seen := map[string]bool{}
+	path := "/opds/books"
+	for pages := 0; path != ""; pages++ {
+		if pages > 10 {
+			t.Fatal("too many pages; pagination does not advance")
+		}
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", path, rr.Code)
+		}
+		if rr.Body.Len() > maxBytes {
+			t.Errorf("GET %s: feed is %d bytes, cap is %d", path, rr.Body.Len(), maxBytes)
+		}
+		var feed opds.Feed
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("GET %s: invalid XML: %v", path, err)
+		}
+		if len(feed.Entries) == 0 {
+			t.Fatalf("GET %s: no entries", path)
+		}
+		for _, e := range feed.Entries {
+			seen[e.Title.Value] = true
+		}
+		path = ""
+		for _, l := range feed.Links {
+			if l.Rel == opds.RelNext {
+				path = l.Href
+			}
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("expected all 10 books across pages, saw %d", len(seen))
+	}
+}
+
+func TestHandleAllBooks_MaxFeedBytesUnderCapUnchanged(t *testing.T) {
+	srv := newTestServer(t, Options{MaxFeedBytes: 1 << 20})
+	uploadBook(t, srv, "a.epub", "Small A", "Author")
+	uploadBook(t, srv, "b.epub", "Small B", "Author")
+
+	feed := getFeed(t, srv, "/opds/books")
+	if len(feed.Entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(feed.Entries))
+	}
+	for _, l := range feed.Links {
+		if l.Rel == opds.RelNext {
+			t.Errorf("unexpected next link %q", l.Href)
+		}
+	}
+}
+
+func TestHandleDownload_RangeAndIfRange(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	content := []byte("%PDF-1.4\n" + strings.Repeat("0123456789", 10) + "\n%%EOF\n")
+	pdf := uploadFile(t, srv, "large.pdf", content)
+	get := func(headers ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/opds/books/"+pdf.ID+"/download", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("Range", "bytes=10-20")
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("Range: expected 206, got %d", rr.Code)
+	}
+	if got, want := rr.Header().Get("Content-Range"), fmt.Sprintf("bytes 10-20/%d", len(content)); got != want {
+		t.Errorf("Content-Range: got %q, want %q", got, want)
+	}
+	if got := rr.Body.String(); got != string(content[10:21]) {
+		t.Errorf("body: got %q, want %q", got, content[10:21])
+	}
+	lastModified := rr.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("expected a Last-Modified header")
+	}
+
+	// If-Range with the current date resumes; with an older one the client's
+	// partial copy is stale and the whole file is sent.
+	if rr := get("Range", "bytes=10-20", "If-Range", lastModified); rr.Code != http.StatusPartialContent {
+		t.Errorf("matching If-Range: expected 206, got %d", rr.Code)
+	}
+	stale := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	if rr := get("Range", "bytes=10-20", "If-Range", stale); rr.Code != http.StatusOK || rr.Body.Len() != len(content) {
+		t.Errorf("stale If-Range: expected 200 with the whole file, got %d (%d bytes)", rr.Code, rr.Body.Len())
+	}
+}
+
+func TestHandleDownload_Kepub(t *testing.T) {
+	chapter := `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>One. Two.</p></body></html>`
+	data := buildEPUBWithResources(t, "Kobo", map[string]string{
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Kobo Book</dc:title></metadata>
+  <manifest><item id="c1" href="ch1.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="c1"/></spine>
+</package>`,
+		"OEBPS/ch1.xhtml": chapter,
+	})
+
+	for _, cacheDir := range []string{"", t.TempDir()} {
+		srv := newTestServer(t, Options{KepubCacheDir: cacheDir})
+		bk := uploadFile(t, srv, "kobo.epub", data)
+		pdf := uploadFile(t, srv, "scan.pdf", []byte("%PDF-1.4\n%%EOF\n"))
+
+		for range 2 { // the second download is served from the cache, if any
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books/"+bk.ID+"/download?format=kepub", nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("cache %q: expected 200, got %d: %s", cacheDir, rr.Code, rr.Body.String())
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != opds.MIMEKEPub {
+				t.Errorf("cache %q: Content-Type %q, want %q", cacheDir, ct, opds.MIMEKEPub)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="kobo.kepub.epub"`) {
+				t.Errorf("cache %q: Content-Disposition %q", cacheDir, cd)
+			}
+			if bytes.Equal(rr.Body.Bytes(), data) {
+				t.Fatalf("cache %q: kepub download is identical to the EPUB", cacheDir)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+			if err != nil {
+				t.Fatalf("cache %q: download is not a zip: %v", cacheDir, err)
+			}
+			for _, f := range zr.File {
+				if f.Name != "OEBPS/ch1.xhtml" {
+					continue
+				}
+				rc, _ := f.Open()
+				var got bytes.Buffer
+				_, _ = got.ReadFrom(rc)
+				rc.Close()
+				if !strings.Contains(got.String(), `<span class="koboSpan" id="kobo.1.2">Two.</span>`) {
+					t.Errorf("cache %q: chapter not converted: %s", cacheDir, got.String())
+				}
+			}
+		}
+
+		// The stored file is untouched.
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books/"+bk.ID+"/download", nil))
+		if !bytes.Equal(rr.Body.Bytes(), data) {
+			t.Errorf("cache %q: plain download no longer returns the original EPUB", cacheDir)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books/"+pdf.ID+"/download?format=kepub", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("cache %q: kepub of a PDF: expected 400, got %d", cacheDir, rr.Code)
+		}
+	}
+}
+
+func TestKepubCache_Cleanup(t *testing.T) {
+	cacheDir := t.TempDir()
+	srv := newTestServer(t, Options{KepubCacheDir: cacheDir})
+	bk := uploadBook(t, srv, "kobo.epub", "Kobo Book", "Author")
+	download := func() {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books/"+bk.ID+"/download?format=kepub", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("kepub download: expected 200, got %d", rr.Code)
+		}
+	}
+	cached := func() int {
+		t.Helper()
+		entries, err := os.ReadDir(cacheDir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		return len(entries)
+	}
+
+	download()
+	if n := cached(); n != 1 {
+		t.Fatalf("after download: %d cached files, want 1", n)
+	}
+
+	// A modified source replaces the stale copy.
+	stored, err := srv.catalog.BookByID(bk.ID)
+	if err != nil {
+		t.Fatalf("BookByID: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(stored.Files[0].Path, later, later); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	download()
+	if n := cached(); n != 1 {
+		t.Errorf("after the source changed: %d cached files, want 1", n)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/books/"+bk.ID, strings.NewReader(`{"title":"Renamed"}`))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := cached(); n != 0 {
+		t.Errorf("after update: %d cached files, want 0", n)
+	}
+
+	download()
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/books/"+bk.ID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if n := cached(); n != 0 {
+		t.Errorf("after delete: %d cached files, want 0", n)
+	}
+}
+
+func TestHandleDownload_BlockedFormat(t *testing.T) {
+	srv := newTestServer(t, Options{DownloadBlockedFormats: []string{".PDF"}})
+	pdf := uploadFile(t, srv, "scan.pdf", []byte("%PDF-1.4\n%%EOF\n"))
+	book := uploadBook(t, srv, "novel.epub", "Novel", "Author")
+
+	for _, tc := range []struct {
+		id   string
+		want int
+	}{
+		{pdf.ID, http.StatusForbidden},
+		{book.ID, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/opds/books/"+tc.id+"/download", nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("download %s: expected %d, got %d", tc.id, tc.want, rr.Code)
+		}
+	}
+
+	feed := getFeed(t, srv, "/opds/books")
+	for _, e := range feed.Entries {
+		var acquisitions int
+		for _, l := range e.Links {
+			if l.Rel == opds.RelAcquisition {
+				acquisitions++
+			}
+		}
+		wantAcq := 1
+		if e.ID == "urn:nxt-opds:book:"+pdf.ID {
+			wantAcq = 0
+		}
+		if acquisitions != wantAcq {
+			t.Errorf("entry %q: expected %d acquisition links, got %d", e.Title.Value, wantAcq, acquisitions)
+		}
+	}
+}
+
+func TestNavigationFeeds_StableUpdated(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	srv.started = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	first := getFeed(t, srv, "/opds")
+	second := getFeed(t, srv, "/opds")
+	if !first.Updated.Time.Equal(second.Updated.Time) {
+		t.Errorf("feed updated changed between requests: %v vs %v", first.Updated.Time, second.Updated.Time)
+	}
+	if !first.Updated.Time.Equal(srv.started) {
+		t.Errorf("empty catalog: feed updated = %v, want server start %v", first.Updated.Time, srv.started)
+	}
+	for i, e := range first.Entries {
+		if !e.Updated.Time.Equal(second.Entries[i].Updated.Time) || !e.Updated.Time.Equal(srv.started) {
+			t.Errorf("entry %q: updated %v / %v, want %v", e.Title.Value, e.Updated.Time, second.Entries[i].Updated.Time, srv.started)
+		}
+	}
+
+	// Adding a book moves the timestamp forward, and it then stays put.
+	uploadBook(t, srv, "new.epub", "New Book", "Author")
+	afterUpload := getFeed(t, srv, "/opds/authors")
+	if !afterUpload.Updated.Time.After(srv.started) {
+		t.Errorf("updated should advance after an upload, got %v", afterUpload.Updated.Time)
+	}
+	again := getFeed(t, srv, "/opds/authors")
+	if !again.Updated.Time.Equal(afterUpload.Updated.Time) || !again.Entries[0].Updated.Time.Equal(afterUpload.Entries[0].Updated.Time) {
+		t.Errorf("authors feed updated changed between requests: %v vs %v", afterUpload.Updated.Time, again.Updated.Time)
+	}
+}
+
+func TestFeeds_ConditionalGet(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	srv.started = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	get := func(path, since string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if since != "" {
+			req.Header.Set("If-Modified-Since", since)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/opds/books", "")
+	lastMod := rr.Header().Get("Last-Modified")
+	if rr.Code != http.StatusOK || lastMod != srv.started.Format(http.TimeFormat) {
+		t.Fatalf("first request: status %d, Last-Modified %q", rr.Code, lastMod)
+	}
+	if rr := get("/opds/books", lastMod); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("unchanged catalog: got %d with %d bytes, want empty 304", rr.Code, rr.Body.Len())
+	}
+
+	// A new book invalidates every feed, navigation and acquisition alike.
+	uploadBook(t, srv, "new.epub", "New Book", "Author")
+	for _, path := range []string{"/opds", "/opds/books", "/opds/authors"} {
+		rr := get(path, lastMod)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s after upload: got %d, want 200", path, rr.Code)
+			continue
+		}
+		if rr := get(path, rr.Header().Get("Last-Modified")); rr.Code != http.StatusNotModified {
+			t.Errorf("%s repeated: got %d, want 304", path, rr.Code)
+		}
+	}
+
+	feed := getFeed(t, srv, "/opds/books")
+	if lm, _ := http.ParseTime(get("/opds/books", "").Header().Get("Last-Modified")); !feed.Updated.Time.Equal(lm) {
+		t.Errorf("feed updated %v does not match Last-Modified %v", feed.Updated.Time, lm)
+	}
+
+	// Random picks differ on every request and are never answered with 304.
+	if rr := get("/opds/random", time.Now().Add(time.Hour).Format(http.TimeFormat)); rr.Code == http.StatusNotModified {
+		t.Error("random feed must not be conditional")
+	}
+}
+
+func TestTitleEntities_EscapedOnce(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	const title = "R&D <Notes>"
+	data := buildEPUBBytes("R&amp;D &lt;Notes&gt;", "Smith &amp; Wesson")
+	body, ct := buildMultipartBody(t, "file", "notes.epub", data)
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
+	req.Header.Set("Content-Type", ct)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("upload: got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books", nil))
+	raw := rr.Body.String()
+	if !strings.Contains(raw, "R&amp;D &lt;Notes&gt;") || strings.Contains(raw, "&amp;amp;") || strings.Contains(raw, "&amp;lt;") {
+		t.Errorf("OPDS feed does not escape the title exactly once:\n%s", raw)
+	}
+	feed := getFeed(t, srv, "/opds/books")
+	if got := entryTitles(feed); !slices.Equal(got, []string{title}) {
+		t.Errorf("OPDS titles = %q, want [%q]", got, title)
+	}
+	if len(feed.Entries[0].Authors) != 1 || feed.Entries[0].Authors[0].Name != "Smith & Wesson" {
+		t.Errorf("OPDS authors = %+v, want Smith & Wesson", feed.Entries[0].Authors)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/books", nil))
+	if strings.Contains(rr.Body.String(), "&amp;") || strings.Contains(rr.Body.String(), "&lt;") {
+		t.Errorf("JSON carries XML-escaped text: %s", rr.Body.String())
+	}
+	var resp struct {
+		Books []bookJSON `json:"books"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Books) != 1 || resp.Books[0].Title != title || !slices.Equal(resp.Books[0].Authors, []string{"Smith & Wesson"}) {
+		t.Errorf("JSON books = %+v, want title %q by Smith & Wesson", resp.Books, title)
+	}
+}
+
+func TestAcquisitionLinkTitles(t *testing.T) {
+	for _, tc := range []struct {
+		layout string
+		want   string
+	}{
+		{"", "Download EPUB"},
+		{"Télécharger {format}", "Télécharger EPUB"},
+	} {
+		srv := newTestServer(t, Options{DownloadLinkTitle: tc.layout})
+		uploadBook(t, srv, "titled.epub", "Titled", "Author")
+
+		feed := getFeed(t, srv, "/opds/books")
+		var got []string
+		for _, l := range feed.Entries[0].Links {
+			if l.Rel == opds.RelAcquisition {
+				got = append(got, l.Title)
+			}
+		}
+		if !slices.Equal(got, []string{tc.want}) {
+			t.Errorf("layout %q: OPDS 1.x acquisition titles = %q, want [%q]", tc.layout, got, tc.want)
+		}
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/publications", nil))
+		var feed2 opds2.Feed
+		if err := json.Unmarshal(rr.Body.Bytes(), &feed2); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		got = nil
+		for _, l := range feed2.Publications[0].Links {
+			if l.Rel == opds.RelAcquisition {
+				got = append(got, l.Title)
+			}
+		}
+		if !slices.Equal(got, []string{tc.want}) {
+			t.Errorf("layout %q: OPDS 2.0 acquisition titles = %q, want [%q]", tc.layout, got, tc.want)
+		}
+	}
+}
+
+func TestAcquisitionLinkLength(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	data := buildEPUBBytes("Sized", "Author")
+	uploadFile(t, srv, "sized.epub", data)
+	want := int64(len(data))
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/books", nil))
+	if attr := fmt.Sprintf(`length="%d"`, want); !strings.Contains(rr.Body.String(), attr) {
+		t.Errorf("OPDS 1.x feed has no %s attribute:\n%s", attr, rr.Body.String())
+	}
+	var feed opds.Feed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	for _, l := range feed.Entries[0].Links {
+		if l.Rel == opds.RelAcquisition && l.Length != want {
+			t.Errorf("OPDS 1.x acquisition length = %d, want %d", l.Length, want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/publications", nil))
+	var feed2 opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed2); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, l := range feed2.Publications[0].Links {
+		if l.Rel == opds.RelAcquisition && l.Length != want {
+			t.Errorf("OPDS 2.0 acquisition length = %d, want %d", l.Length, want)
+		}
+	}
+}
+
+func TestHandleOPDS2Publications_PaginationMetadata(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	for i := 0; i < 5; i++ {
+		uploadBook(t, srv, fmt.Sprintf("v2-%d.epub", i), fmt.Sprintf("V2 Book %d", i), "Author")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/opds/v2/publications?offset=2&limit=2", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var feed opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if feed.Metadata.ItemsPerPage != 2 {
+		t.Errorf("itemsPerPage: got %d, want 2", feed.Metadata.ItemsPerPage)
+	}
+	if feed.Metadata.CurrentPage != 2 {
+		t.Errorf("currentPage: got %d, want 2", feed.Metadata.CurrentPage)
+	}
+	if feed.Metadata.NumberOfItems != 5 {
+		t.Errorf("numberOfItems: got %d, want 5", feed.Metadata.NumberOfItems)
+	}
+	if len(feed.Publications) != 2 {
+		t.Errorf("publications: got %d, want 2", len(feed.Publications))
+	}
+}
+
+func TestHandleOPDS2Publications_GroupsAndFacets(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	for i := 0; i < 6; i++ {
+		uploadBook(t, srv, fmt.Sprintf("g-%d.epub", i), fmt.Sprintf("Group Book %d", i), "Author")
+	}
+	uploadFile(t, srv, "fr.epub", buildEPUBBytesFromMetadata(`
+    <dc:title>Livre</dc:title><dc:creator>Auteur</dc:creator><dc:language>fr</dc:language>`))
+
+	get := func(target string) opds2.Feed {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", target, rr.Code, rr.Body.String())
+		}
+		var feed opds2.Feed
+		if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("GET %s: invalid JSON: %v", target, err)
+		}
+		return feed
+	}
+
+	feed := get("/opds/v2/publications")
+	if len(feed.Groups) != 2 {
+		t.Fatalf("groups: got %d, want recently added and languages", len(feed.Groups))
+	}
+	recent, langs := feed.Groups[0], feed.Groups[1]
+	if len(recent.Publications) != groupSize || len(recent.Links) != 1 || recent.Links[0].Href != "/opds/v2/recent" {
+		t.Errorf("recent group: %d publications, links %+v; want %d and a link to /opds/v2/recent",
+			len(recent.Publications), recent.Links, groupSize)
+	}
+	if len(langs.Navigation) != 2 || langs.Navigation[1].Href != "/opds/v2/publications?language=fr" {
+		t.Errorf("languages group: %+v, want en and fr", langs.Navigation)
+	}
+	if len(feed.Facets) != 1 || len(feed.Facets[0].Links) != 3 {
+		t.Fatalf("facets: got %+v, want one language facet with all, en and fr", feed.Facets)
+	}
+	facet := feed.Facets[0].Links
+	if facet[0].Rel != "self" || facet[1].Rel != nil {
+		t.Errorf("active facet: got rels %v, %v; want all languages active", facet[0].Rel, facet[1].Rel)
+	}
+	if facet[1].Properties == nil || facet[1].Properties.NumberOfItems != 6 {
+		t.Errorf("en facet properties: got %+v, want 6 items", facet[1].Properties)
+	}
+
+	fr := get("/opds/v2/publications?language=fr")
+	if len(fr.Publications) != 1 || len(fr.Groups) != 0 {
+		t.Errorf("fr feed: %d publications, %d groups; want 1 and none", len(fr.Publications), len(fr.Groups))
+	}
+	if fr.Facets[0].Links[2].Rel != "self" {
+		t.Errorf("fr feed: fr facet rel %v, want self", fr.Facets[0].Links[2].Rel)
+	}
+	if page2 := get("/opds/v2/publications?offset=2&limit=2"); len(page2.Groups) != 0 {
+		t.Errorf("second page: got %d groups, want none", len(page2.Groups))
+	}
+
+	if r := get("/opds/v2/recent?limit=3"); len(r.Publications) != 3 {
+		t.Errorf("recent feed: got %d publications, want 3", len(r.Publications))
+	}
+}
+
+func TestHandleOPDS2Publication(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "single.epub", "Single V2", "Author")
+
+	req := httptest.NewRequest(http.MethodGet, "/opds/v2/publications/"+bk.ID+"?token=tk", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var feed opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(feed.Publications) != 1 || feed.Publications[0].Metadata.Title != "Single V2" {
+		t.Fatalf("publications: got %+v, want the one book", feed.Publications)
+	}
+	links := map[string]string{}
+	for _, l := range feed.Links {
+		links[fmt.Sprint(l.Rel)] = l.Href
+	}
+	if want := "/opds/v2/publications/" + bk.ID + "?token=tk"; links["self"] != want {
+		t.Errorf("self link: got %q, want %q", links["self"], want)
+	}
+	if links["start"] != "/opds/v2?token=tk" {
+		t.Errorf("start link: got %q, want /opds/v2?token=tk", links["start"])
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/opds/v2/publications/missing", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown id: expected 404, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("unknown id: Content-Type %q, want JSON", ct)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("unknown id: body %q is not a JSON error", rr.Body.String())
+	}
+}
+
+func TestLending_BorrowLinksAndSignedDownloads(t *testing.T) {
+	srv := newTestServer(t, Options{Lending: true, LoanPeriod: time.Hour})
+	bk := uploadBook(t, srv, "lent.epub", "Lent Book", "Author")
+
+	// Feeds offer borrow links instead of direct acquisition links.
+	feed := getFeed(t, srv, "/opds/books")
+	if len(feed.Entries) != 1 {
+		t.Fatalf("entries: got %d, want 1", len(feed.Entries))
+	}
+	var borrow *opds.Link
+	for i, l := range feed.Entries[0].Links {
+		switch l.Rel {
+		case opds.RelAcquisition:
+			t.Errorf("direct acquisition link in lending mode: %+v", l)
+		case opds.RelAcquisitionBorrow:
+			borrow = &feed.Entries[0].Links[i]
+		}
+	}
+	if borrow == nil || !strings.HasPrefix(borrow.Href, "/api/books/"+bk.ID+"/borrow?path=") {
+		t.Fatalf("borrow link: got %+v", borrow)
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Direct downloads, and forged or tampered signatures, are rejected.
+	direct := "/opds/books/" + bk.ID + "/download"
+	for _, path := range []string{direct, direct + "?expires=9999999999&sig=00"} {
+		if rr := get(path, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("GET %s: expected 403, got %d", path, rr.Code)
+		}
+	}
+
+	// Borrowing issues a signed URL that downloads the file.
+	rr := get(borrow.Href, "application/json")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("borrow: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var loan struct {
+		Href      string    `json:"href"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&loan); err != nil {
+		t.Fatalf("decode loan: %v", err)
+	}
+	if d := time.Until(loan.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("loan expires in %v, want about an hour", d)
+	}
+	if rr := get(loan.Href, ""); rr.Code != http.StatusOK {
+		t.Errorf("signed download: expected 200, got %d", rr.Code)
+	}
+
+	// OPDS readers get an acquisition feed linking to the signed URL.
+	rr = get(borrow.Href, opds.MIMEAcquisitionFeed)
+	var entryFeed opds.Feed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &entryFeed); err != nil || len(entryFeed.Entries) != 1 {
+		t.Fatalf("borrow feed: %v (%s)", err, rr.Body.String())
+	}
+	var acq string
+	for _, l := range entryFeed.Entries[0].Links {
+		if l.Rel == opds.RelAcquisition {
+			acq = l.Href
+		}
+	}
+	if !strings.Contains(acq, "sig=") {
+		t.Errorf("borrow feed acquisition link: got %q, want a signed URL", acq)
+	}
+
+	// The URL stops working once the loan period is over.
+	srv.lender.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if rr := get(loan.Href, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expired download: expected 403, got %d", rr.Code)
+	}
+}
+
+func TestLending_Disabled(t *testing.T) {
+	srv := newTestServer(t, Options{})
+	bk := uploadBook(t, srv, "free.epub", "Free Book", "Author")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/books/"+bk.ID+"/borrow", nil)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("borrow without lending: expected 404, got %d", rr.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/opds/books/"+bk.ID+"/download", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("direct download without lending: expected 200, got %d", rr.Code)
+	}
+}
+
+func TestRandomBook(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			get := func(path string) *httptest.ResponseRecorder {
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+				return rr
+			}
+
+			// An empty catalog is a 404, not an empty feed.
+			for _, path := range []string{"/api/random", "/opds/random"} {
+				rr := get(path)
+				if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "catalog is empty") {
+					t.Errorf("GET %s on empty catalog: got %d %q", path, rr.Code, rr.Body.String())
+				}
+			}
+
+			ids := map[string]bool{}
+			for _, title := range []string{"One", "Two", "Three"} {
+				ids[uploadBook(t, srv, strings.ToLower(title)+".epub", title, "Author").ID] = true
+			}
+
+			rr := get("/api/random")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("/api/random: expected 200, got %d", rr.Code)
+			}
+			var bk bookJSON
+			if err := json.NewDecoder(rr.Body).Decode(&bk); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !ids[bk.ID] {
+				t.Errorf("/api/random: unknown book %q", bk.ID)
+			}
+
+			feed := getFeed(t, srv, "/opds/random")
+			if len(feed.Entries) != 1 || !ids[strings.TrimPrefix(feed.Entries[0].ID, "urn:nxt-opds:book:")] {
+				t.Errorf("/opds/random: got entries %v", entryTitles(feed))
+			}
+
+			// ?n= asks for several distinct books, capped by the catalog size.
+			for i := 0; i < 7; i++ {
+				ids[uploadBook(t, srv, fmt.Sprintf("extra%d.epub", i), fmt.Sprintf("Extra %d", i), "Author").ID] = true
+			}
+			seen := map[string]bool{}
+			for i := 0; i < 20; i++ {
+				randomFeedIDs(t, srv, 4, 4, ids, seen)
+			}
+			// Twenty draws of the same four books out of ten would happen
+			// with probability 210^-19.
+			if len(seen) <= 4 {
+				t.Errorf("/opds/random?n=4: 20 draws only returned %d books", len(seen))
+			}
+			randomFeedIDs(t, srv, 50, 10, ids, map[string]bool{})
+		})
+	}
+}
+
+// randomFeedIDs fetches /opds/random?n=n, checks that it holds want
+// distinct books from ids, and adds their IDs to seen.
+func randomFeedIDs(t *testing.T, srv *Server, n, want int, ids, seen map[string]bool) {
+	t.Helper()
+	feed := getFeed(t, srv, fmt.Sprintf("/opds/random?n=%d", n))
+	distinct := map[string]bool{}
+	for _, e := range feed.Entries {
+		id := strings.TrimPrefix(e.ID, "urn:nxt-opds:book:")
+		if !ids[id] {
+			t.Errorf("/opds/random?n=%d: unknown book %q", n, id)
+		}
+		distinct[id], seen[id] = true, true
+	}
+	if len(feed.Entries) != want || len(distinct) != want {
+		t.Errorf("/opds/random?n=%d: got %d entries (%d distinct), want %d", n, len(feed.Entries), len(distinct), want)
+	}
+}
+
+func TestDownloadCounts_PopularFeed(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			do := func(method, path string, header ...string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, path, nil)
+				if len(header) == 2 {
+					req.Header.Set(header[0], header[1])
+				}
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				return rr
+			}
+			get := func(path string, header ...string) *httptest.ResponseRecorder {
+				return do(http.MethodGet, path, header...)
+			}
+
+			one := uploadBook(t, srv, "one.epub", "One", "Author")
+			two := uploadBook(t, srv, "two.epub", "Two", "Author")
+			uploadBook(t, srv, "three.epub", "Three", "Author")
+
+			for _, id := range []string{two.ID, one.ID, two.ID} {
+				if rr := get("/opds/books/" + id + "/download"); rr.Code != http.StatusOK {
+					t.Fatalf("download %s: got %d", id, rr.Code)
+				}
+			}
+			// Range requests resume a download rather than start a new one.
+			if rr := get("/opds/books/"+one.ID+"/download", "Range", "bytes=0-9"); rr.Code != http.StatusPartialContent {
+				t.Fatalf("range download: got %d", rr.Code)
+			}
+			// Neither do HEAD requests nor revalidations answered 304.
+			rr := do(http.MethodHead, "/opds/books/"+two.ID+"/download")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("HEAD download: got %d", rr.Code)
+			}
+			if rr := get("/opds/books/"+two.ID+"/download", "If-Modified-Since", rr.Header().Get("Last-Modified")); rr.Code != http.StatusNotModified {
+				t.Fatalf("conditional download: got %d, want 304", rr.Code)
+			}
+
+			rr = get("/api/books/" + two.ID)
+			var bk bookJSON
+			if err := json.NewDecoder(rr.Body).Decode(&bk); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if bk.DownloadCount != 2 {
+				t.Errorf("downloadCount = %d, want 2", bk.DownloadCount)
+			}
+
+			feed := getFeed(t, srv, "/opds/popular")
+			if got := entryTitles(feed); !slices.Equal(got, []string{"Two", "One"}) {
+				t.Errorf("/opds/popular: got %v, want [Two One]", got)
+			}
+
+			root := getFeed(t, srv, "/opds")
+			if !slices.Contains(entryTitles(root), "Most Popular") {
+				t.Errorf("root feed lacks Most Popular: %v", entryTitles(root))
+			}
+		})
+	}
+}
+
+// manyNamesCatalog lists more authors, tags and publishers than fit in one
+// large page.
+type manyNamesCatalog struct {
+	noRefreshCatalog
+	names []string
+}
+
+func (c manyNamesCatalog) page(offset, limit int) ([]string, int, error) {
+	end := min(offset+limit, len(c.names))
+	if offset >= end {
+		return nil, len(c.names), nil
+	}
+	return c.names[offset:end], len(c.names), nil
+}
+
+func (c manyNamesCatalog) Authors(offset, limit int) ([]string, int, error)    { return c.page(offset, limit) }
+func (c manyNamesCatalog) Tags(offset, limit int) ([]string, int, error)       { return c.page(offset, limit) }
+func (c manyNamesCatalog) Publishers(offset, limit int) ([]string, int, error) { return c.page(offset, limit) }
+
+func TestAPINames_NoHardCodedCap(t *testing.T) {
+	cat := manyNamesCatalog{}
+	for i := range 10005 {
+		cat.names = append(cat.names, fmt.Sprintf("Name %05d", i))
+	}
+	srv := New(cat, Options{})
+
+	for _, path := range []string{"/api/authors", "/api/tags", "/api/publishers"} {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var names []string
+		if err := json.Unmarshal(rr.Body.Bytes(), &names); err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		if len(names) != len(cat.names) {
+			t.Errorf("%s: got %d names, want %d", path, len(names), len(cat.names))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/search?q=name%2010004", nil))
+	var res struct{ Authors []string }
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("search: decode: %v", err)
+	}
+	if !slices.Equal(res.Authors, []string{"Name 10004"}) {
+		t.Errorf("search authors: got %v, want [Name 10004]", res.Authors)
+	}
+}
+
+func TestAPISearch_GroupedResults(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			uploadFile(t, srv, "wizard.epub", buildEPUBBytesWithMetadata("A Wizard of Earthsea", "Ursula Le Guin",
+				`<meta name="calibre:series" content="Earthsea"/>`))
+			uploadBook(t, srv, "dispossessed.epub", "The Dispossessed", "Ursula Le Guin")
+			uploadBook(t, srv, "dune.epub", "Dune", "Frank Herbert")
+
+			search := func(q string) (result struct {
+				Books   []bookJSON `json:"books"`
+				Authors []string   `json:"authors"`
+				Series  []struct {
+					Name  string `json:"name"`
+					Count int    `json:"count"`
+				} `json:"series"`
+			}) {
+				t.Helper()
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/search?q="+url.QueryEscape(q), nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("search %q: expected 200, got %d", q, rr.Code)
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				return result
+			}
+
+			res := search("Le Guin")
+			if len(res.Authors) != 1 || res.Authors[0] != "Ursula Le Guin" {
+				t.Errorf("authors: got %v, want [Ursula Le Guin]", res.Authors)
+			}
+			var titles []string
+			for _, b := range res.Books {
+				titles = append(titles, b.Title)
+			}
+			slices.Sort(titles)
+			if !slices.Equal(titles, []string{"A Wizard of Earthsea", "The Dispossessed"}) {
+				t.Errorf("books: got %v, want Le Guin's two books", titles)
+			}
+			if len(res.Series) != 0 {
+				t.Errorf("series: got %v, want none", res.Series)
+			}
+
+			res = search("earthsea")
+			if len(res.Series) != 1 || res.Series[0].Name != "Earthsea" || res.Series[0].Count != 1 {
+				t.Errorf("series: got %+v, want Earthsea", res.Series)
+			}
+			if len(res.Authors) != 0 {
+				t.Errorf("authors: got %v, want none", res.Authors)
+			}
+		})
+	}
+
+	srv := newTestServer(t, Options{})
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/search", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("missing q: expected 400, got %d", rr.Code)
+	}
+}
+
+// ---- Multiple libraries ----
+
+func TestLibraries_ServeCatalogsUnderPrefixes(t *testing.T) {
+	newLib := func(opts Options) Library {
+		backend, err := fsbackend.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("fs.New: %v", err)
+		}
+		return Library{Catalog: backend, Options: opts}
+	}
+	libs := New(nil, Options{Libraries: map[string]Library{
+		"/lib1": newLib(Options{}),
+		"/lib2": newLib(Options{Password: "secret", OPDSToken: "tok2", Users: []User{testUser(t, "alice", "alice-pw")}}),
+	}})
+	t.Cleanup(libs.Close)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		libs.ServeHTTP(rr, req)
+		return rr
+	}
+	upload := func(prefix, title string) {
+		body, ct := buildMultipartBody(t, "file", strings.ToLower(title)+".epub", buildEPUBBytes(title, "Author"))
+		req := httptest.NewRequest(http.MethodPost, prefix+"/api/upload", body)
+		req.Header.Set("Content-Type", ct)
+		req.Header.Set("Authorization", "Bearer tok2")
+		if rr := serve(req); rr.Code != http.StatusCreated {
+			t.Fatalf("upload to %s: got %d: %s", prefix, rr.Code, rr.Body.String())
+		}
+	}
+	upload("/lib1", "First Library Book")
+	upload("/lib2", "Second Library Book")
+
+	feedOf := func(path string) opds.Feed {
+		rr := serve(httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, rr.Code)
+		}
+		var feed opds.Feed
+		if err := xml.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("GET %s: invalid XML: %v", path, err)
+		}
+		return feed
+	}
+
+	lib1 := feedOf("/lib1/opds/books")
+	if got := entryTitles(lib1); !slices.Equal(got, []string{"First Library Book"}) {
+		t.Errorf("/lib1/opds/books: got %q", got)
+	}
+	for _, l := range append(lib1.Links, lib1.Entries[0].Links...) {
+		if !strings.HasPrefix(l.Href, "/lib1/") {
+			t.Errorf("/lib1 feed link %q (%s) is outside the library", l.Href, l.Rel)
+		}
+	}
+	lib2 := feedOf("/lib2/opds/books?token=tok2")
+	if got := entryTitles(lib2); !slices.Equal(got, []string{"Second Library Book"}) {
+		t.Errorf("/lib2/opds/books: got %q", got)
+	}
+
+	// Each library has its own credentials.
+	if rr := serve(httptest.NewRequest(http.MethodGet, "/lib2/opds/books", nil)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("/lib2 without a token: got %d, want 401", rr.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/lib2/", nil)
+	req.Header.Set("Accept", "text/html")
+	if rr := serve(req); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/lib2/login" {
+		t.Errorf("/lib2/ in a browser: got %d to %q, want a redirect to /lib2/login", rr.Code, rr.Header().Get("Location"))
+	}
+	form := strings.NewReader("password=secret")
+	req = httptest.NewRequest(http.MethodPost, "/lib2/login", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := serve(req)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/lib2/" || rr.Header().Get("Location") != "/lib2/" {
+		t.Errorf("login to /lib2: got cookies %v, redirect %q", cookies, rr.Header().Get("Location"))
+	}
+	form = strings.NewReader("username=alice&password=alice-pw")
+	req = httptest.NewRequest(http.MethodPost, "/lib2/login", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rr := serve(req); len(rr.Result().Cookies()) != 1 {
+		t.Errorf("login to /lib2 as alice: got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := serve(httptest.NewRequest(http.MethodGet, "/opds/books", nil)); rr.Code != http.StatusNotFound {
+		t.Errorf("/opds/books outside any library: got %d, want 404", rr.Code)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("New with library prefix /: expected a panic")
+		}
+	}()
+	New(nil, Options{Libraries: map[string]Library{"/": newLib(Options{})}})
+}
+
+func TestLibraries_OPDS2PublicationsUnderPrefix(t *testing.T) {
+	backend, err := fsbackend.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("fs.New: %v", err)
+	}
+	libs := New(nil, Options{Libraries: map[string]Library{"/lib1": {Catalog: backend}}})
+	t.Cleanup(libs.Close)
+	for _, title := range []string{"First Book", "Second Book"} {
+		body, ct := buildMultipartBody(t, "file", strings.ToLower(strings.ReplaceAll(title, " ", "_"))+".epub", buildEPUBBytes(title, "Author"))
+		req := httptest.NewRequest(http.MethodPost, "/lib1/api/upload", body)
+		req.Header.Set("Content-Type", ct)
+		rr := httptest.NewRecorder()
+		libs.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("upload %q: got %d: %s", title, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	libs.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/lib1/opds/v2/publications", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var feed opds2.Feed
+	if err := json.Unmarshal(rr.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(feed.Facets) == 0 || len(feed.Groups) == 0 {
+		t.Fatalf("expected facets and groups, got %d and %d", len(feed.Facets), len(feed.Groups))
+	}
+
+	var hrefs []string
+	addLinks := func(links []opds2.Link) {
+		for _, l := range links {
+			hrefs = append(hrefs, l.Href)
+		}
+	}
+	addNav := func(nav []opds2.NavItem) {
+		for _, n := range nav {
+			hrefs = append(hrefs, n.Href)
+		}
+	}
+	addPubs := func(pubs []opds2.Publication) {
+		for _, p := range pubs {
+			addLinks(p.Links)
+			addLinks(p.Images)
+		}
+	}
+	addLinks(feed.Links)
+	addPubs(feed.Publications)
+	for _, f := range feed.Facets {
+		addLinks(f.Links)
+	}
+	for _, g := range feed.Groups {
+		addLinks(g.Links)
+		addNav(g.Navigation)
+		addPubs(g.Publications)
+	}
+	for _, href := range hrefs {
+		if strings.HasPrefix(href, "/") && !strings.HasPrefix(href, "/lib1/") {
+			t.Errorf("link %q is outside the library", href)
+		}
+	}
+}
+
+func TestAPIValidateOPDS_ReportsGeneratedFeeds(t *testing.T) {
+	for _, opts := range []Options{{}, {Lending: true, LoanPeriod: time.Hour}} {
+		srv := newTestServer(t, opts)
+		uploadBook(t, srv, "valid.epub", "Valid Book", "Author")
+
+		// A revalidating browser sends conditional headers the feeds
+		// would answer with 304.
+		req := httptest.NewRequest(http.MethodGet, "/api/validate/opds", nil)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var report struct {
+			Valid bool `json:"valid"`
+			Feeds []struct {
+				Path   string       `json:"path"`
+				Status int          `json:"status"`
+				Issues []opds.Issue `json:"issues"`
+			} `json:"feeds"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(report.Feeds) != 2 || report.Feeds[0].Path != "/opds" || report.Feeds[1].Path != "/opds/books" {
+			t.Fatalf("feeds: got %+v, want /opds and /opds/books", report.Feeds)
+		}
+		if !report.Valid {
+			t.Errorf("lending=%v: generated feeds reported invalid: %+v", opts.Lending, report.Feeds)
+		}
+	}
+}
+
+func TestSeriesFeeds(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T) catalog.Catalog
+	}{
+		{"fs", func(t *testing.T) catalog.Catalog {
+			backend, err := fsbackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("fs.New: %v", err)
+			}
+			return backend
+		}},
+		{"sqlite", func(t *testing.T) catalog.Catalog {
+			backend, err := sqlitebackend.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("sqlite.New: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := New(tc.backend(t), Options{})
+			for _, b := range []struct{ title, index string }{
+				{"Tehanu", "4"}, {"A Wizard of Earthsea", "1"}, {"The Farthest Shore", "3"}, {"The Tombs of Atuan", "2"},
+			} {
+				uploadFile(t, srv, b.title+".epub", buildEPUBBytesWithMetadata(b.title, "Ursula Le Guin",
+					`<meta name="calibre:series" content="Earthsea Cycle"/><meta name="calibre:series_index" content="`+b.index+`"/>`))
+			}
+			uploadBook(t, srv, "dune.epub", "Dune", "Frank Herbert")
+			uploadFile(t, srv, "pulp.epub", buildEPUBBytesWithMetadata("Pulp Story", "Anon",
+				`<meta name="calibre:series" content="100% Pulp / Noir"/>`))
+
+			root := getFeed(t, srv, "/opds")
+			if !slices.Contains(entryTitles(root), "By Series") {
+				t.Errorf("root entries %v lack By Series", entryTitles(root))
+			}
+
+			nav := getFeed(t, srv, "/opds/series")
+			if got := entryTitles(nav); !slices.Equal(got, []string{"100% Pulp / Noir", "Earthsea Cycle"}) {
+				t.Fatalf("series entries: got %v, want [100%% Pulp / Noir, Earthsea Cycle]", got)
+			}
+			link := nav.Entries[1].Links[0]
+			if link.Href != "/opds/series/"+nameSlug("Earthsea Cycle") || link.Count != 4 {
+				t.Errorf("series link: got %+v, want the Earthsea Cycle slug with count 4", link)
+			}
+
+			want := []string{"A Wizard of Earthsea", "The Tombs of Atuan", "The Farthest Shore", "Tehanu"}
+			// Links from earlier versions carry the escaped name.
+			for _, href := range []string{link.Href, "/opds/series/Earthsea%20Cycle"} {
+				if got := entryTitles(getFeed(t, srv, href)); !slices.Equal(got, want) {
+					t.Errorf("%s: got %v, want %v", href, got, want)
+				}
+			}
+			if got := entryTitles(getFeed(t, srv, nav.Entries[0].Links[0].Href)); !slices.Equal(got, []string{"Pulp Story"}) {
+				t.Errorf("100%% Pulp / Noir books: got %v", got)
+			}
+
+			for _, href := range []string{"/opds/series/Unknown", "/opds/series/100%25%20Pulp"} {
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, href, nil))
+				if rr.Code != http.StatusNotFound {
+					t.Errorf("%s: expected 404, got %d", href, rr.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesFeeds_NotSupported(t *testing.T) {
+	srv := New(noRefreshCatalog{}, Options{})
+
+	if titles := entryTitles(getFeed(t, srv, "/opds")); slices.Contains(titles, "By Series") {
+		t.Errorf("root entries %v offer By Series without a SeriesLister", titles)
+	}
+	for _, path := range []string{"/opds/series", "/opds/series/Earthsea"} {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusNotImplemented {
+			t.Errorf("%s: expected 501, got %d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/epub"
+	"github.com/banux/nxt-opds/internal/opds"
+)
+
+// serveKepub answers a ?format=kepub download of the EPUB at path with a
+// copy converted for Kobo readers. The stored file is not modified. With
+// Options.KepubCacheDir the conversion is kept on disk and served with
+// Range support; otherwise it is streamed as it is produced.
+func (s *Server) serveKepub(w http.ResponseWriter, r *http.Request, path string, modTime time.Time, size int64) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".kepub.epub"
+	w.Header().Set("Content-Type", opds.MIMEKEPub)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+
+	if s.opts.KepubCacheDir == "" {
+		cw := &countingWriter{w: w}
+		if err := epub.WriteKepub(cw, path); err != nil && cw.n == 0 {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "kepub conversion failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	cached, err := s.cachedKepub(path, modTime, size)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "kepub conversion failed", http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Open(cached)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "file unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, name, modTime, f)
+}
+
+// cachedKepub returns the path of the converted copy of the EPUB at path in
+// Options.KepubCacheDir, converting it first when missing. The cache key
+// changes whenever the source file is replaced or modified; the copies of
+// earlier versions are then removed.
+func (s *Server) cachedKepub(path string, modTime time.Time, size int64) (string, error) {
+	prefix := kepubCachePrefix(path)
+	version := sha256.Sum256([]byte(strconv.FormatInt(size, 10) + "\x00" + strconv.FormatInt(modTime.UnixNano(), 10)))
+	name := prefix + hex.EncodeToString(version[:8]) + ".kepub.epub"
+	cached := filepath.Join(s.opts.KepubCacheDir, name)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(s.opts.KepubCacheDir, 0755); err != nil {
+		return "", err
+	}
+	// Convert to a temporary file renamed into place, so a concurrent
+	// download of the same book never serves a partial conversion.
+	tmp, err := os.CreateTemp(s.opts.KepubCacheDir, ".kepub-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	werr := epub.WriteKepub(tmp, path)
+	if cerr := tmp.Close(); werr == nil {
+		werr = cerr
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), cached)
+	}
+	if werr != nil {
+		_ = os.Remove(tmp.Name())
+		return "", werr
+	}
+	s.removeCachedKepubs(prefix, name)
+	return cached, nil
+}
+
+// kepubCachePrefix returns the start of the cache file names of the
+// converted copies of the EPUB at path, one per version of the file.
+func kepubCachePrefix(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:8]) + "-"
+}
+
+// removeCachedKepubs removes the cached copies whose name starts with
+// prefix, except keep.
+func (s *Server) removeCachedKepubs(prefix, keep string) {
+	entries, err := os.ReadDir(s.opts.KepubCacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) && e.Name() != keep {
+			_ = os.Remove(filepath.Join(s.opts.KepubCacheDir, e.Name()))
+		}
+	}
+}
+
+// forgetKepubs removes the cached converted copies of the book's files,
+// once it is deleted or its metadata changes.
+func (s *Server) forgetKepubs(bk *catalog.Book) {
+	if s.opts.KepubCacheDir == "" || bk == nil {
+		return
+	}
+	for _, f := range bk.Files {
+		s.removeCachedKepubs(kepubCachePrefix(f.Path), "")
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banux/nxt-opds/internal/opds"
+)
+
+// defaultLoanPeriod is how long a borrow URL stays valid when
+// Options.LoanPeriod is zero.
+const defaultLoanPeriod = 24 * time.Hour
+
+// lender issues and checks the signed download URLs of lending mode. The
+// signing key is random per process, so loans end when the server restarts.
+type lender struct {
+	key    []byte
+	period time.Duration
+	now    func() time.Time
+}
+
+func newLender(period time.Duration) *lender {
+	if period <= 0 {
+		period = defaultLoanPeriod
+	}
+	key := make([]byte, 32)
+	_, _ = rand.Read(key) // never fails; crashes the program instead
+	return &lender{key: key, period: period, now: time.Now}
+}
+
+// signature returns the hex HMAC binding a book file to an expiry time.
+func (l *lender) signature(id, path string, expires int64) string {
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write([]byte(id + "\n" + path + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadURL returns a download URL for the book file valid for the loan
+// period, and the time it expires.
+func (l *lender) downloadURL(id, path string) (string, time.Time) {
+	expires := l.now().Add(l.period).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("path", path)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", l.signature(id, path, expires.Unix()))
+	return "/opds/books/" + id + "/download?" + q.Encode(), expires
+}
+
+// valid reports whether the expires and sig query parameters of a download
+// request were issued by downloadURL for this file and have not expired.
+func (l *lender) valid(id, path string, q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || l.now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("sig")), []byte(l.signature(id, path, expires)))
+}
+
+// borrowHref turns a direct download href into the matching borrow
+// endpoint, keeping its query (file path and token).
+func borrowHref(id, href string) string {
+	return strings.Replace(href, "/opds/books/"+id+"/download", "/api/books/"+id+"/borrow", 1)
+}
+
+// lendLinks rewrites the acquisition links of a book entry into borrow
+// links when lending mode is on.
+func (s *Server) lendLinks(id string, links []opds.Link) {
+	if s.lender == nil {
+		return
+	}
+	for i, l := range links {
+		if l.Rel == opds.RelAcquisition {
+			links[i] = opds.Link{
+				Rel:   opds.RelAcquisitionBorrow,
+				Href:  borrowHref(id, l.Href),
+				Type:  opds.MIMEAcquisitionFeed,
+				Title: l.Title,
+			}
+		}
+	}
+}
+
+// isBorrowPath reports whether path is a book's borrow endpoint, which OPDS
+// readers reach from feed links and may authenticate with ?token=.
+func isBorrowPath(path string) bool {
+	return strings.HasPrefix(path, "/api/books/") && strings.HasSuffix(path, "/borrow")
+}
+
+// acceptsJSON reports whether an Accept header lists application/json.
+func acceptsJSON(accept string) bool {
+	for _, part := range splitAccept(accept) {
+		if part == "application/json" {
+			return true
+		}
+	}
+	return false
+}
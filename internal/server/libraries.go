@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/opds"
+	"github.com/banux/nxt-opds/internal/opds2"
+)
+
+// Library is one catalog served under a prefix of Options.Libraries, with
+// the Options of its own Server (password, users, OPDS token, …).
+// Options.BasePath is set from the library's prefix and Options.Libraries
+// is ignored.
+type Library struct {
+	Catalog catalog.Catalog
+	Options Options
+}
+
+// libraries serves several catalogs from one listener, each under its own
+// path prefix: "/lib1/opds/books" is "/opds/books" of the library mounted
+// at "/lib1".
+type libraries struct {
+	servers  map[string]*Server // prefix -> server
+	prefixes []string           // longest first, so nested prefixes match
+}
+
+// newLibraries creates a Server for each library and mounts it under its
+// prefix, a path such as "/lib1" (a trailing slash is ignored). It panics
+// on a prefix that is empty, "/", not absolute or used twice, as
+// http.ServeMux does for conflicting patterns.
+func newLibraries(libs map[string]Library) *libraries {
+	l := &libraries{servers: make(map[string]*Server, len(libs))}
+	for prefix, lib := range libs {
+		p := strings.TrimRight(prefix, "/")
+		if p == "" || !strings.HasPrefix(p, "/") {
+			panic(fmt.Sprintf("server: invalid library prefix %q: want a path such as /lib1", prefix))
+		}
+		if _, dup := l.servers[p]; dup {
+			panic(fmt.Sprintf("server: library prefix %q is used twice", p))
+		}
+		opts := lib.Options
+		opts.BasePath = p
+		opts.Libraries = nil
+		l.servers[p] = New(lib.Catalog, opts)
+		l.prefixes = append(l.prefixes, p)
+	}
+	sort.Slice(l.prefixes, func(i, j int) bool { return len(l.prefixes[i]) > len(l.prefixes[j]) })
+	return l
+}
+
+// ServeHTTP routes the request to the library whose prefix it starts with,
+// stripping the prefix. Requests outside every library answer 404.
+func (l *libraries) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, p := range l.prefixes {
+		if r.URL.Path != p && !strings.HasPrefix(r.URL.Path, p+"/") {
+			continue
+		}
+		if r.URL.Path == p {
+			// The web UI resolves its API calls against the page path.
+			http.Redirect(w, r, p+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.StripPrefix(p, l.servers[p]).ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// Close stops the background work of every library's Server.
+func (l *libraries) Close() {
+	for _, s := range l.servers {
+		s.Close()
+	}
+}
+
+// url returns path under Options.BasePath when it is an absolute path on
+// this server ("/opds/…"); other hrefs are returned unchanged.
+func (s *Server) url(path string) string {
+	if s.opts.BasePath == "" || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return path
+	}
+	return s.opts.BasePath + path
+}
+
+// cookiePath scopes the session cookie to the library.
+func (s *Server) cookiePath() string {
+	return s.url("/")
+}
+
+// mountFeed moves the links of an OPDS 1.x feed under Options.BasePath.
+func (s *Server) mountFeed(feed *opds.Feed) {
+	if s.opts.BasePath == "" {
+		return
+	}
+	feed.Icon = s.url(feed.Icon)
+	for i := range feed.Links {
+		feed.Links[i].Href = s.url(feed.Links[i].Href)
+	}
+	for i := range feed.Entries {
+		for j := range feed.Entries[i].Links {
+			feed.Entries[i].Links[j].Href = s.url(feed.Entries[i].Links[j].Href)
+		}
+	}
+}
+
+// mountFeed2 is the OPDS 2.0 counterpart of mountFeed.
+func (s *Server) mountFeed2(feed *opds2.Feed) {
+	if s.opts.BasePath == "" {
+		return
+	}
+	mount := func(links []opds2.Link) {
+		for i := range links {
+			links[i].Href = s.url(links[i].Href)
+		}
+	}
+	mountNav := func(nav []opds2.NavItem) {
+		for i := range nav {
+			nav[i].Href = s.url(nav[i].Href)
+		}
+	}
+	mountPubs := func(pubs []opds2.Publication) {
+		for i := range pubs {
+			mount(pubs[i].Links)
+			mount(pubs[i].Images)
+		}
+	}
+	mount(feed.Links)
+	mountNav(feed.Navigation)
+	mountPubs(feed.Publications)
+	for i := range feed.Facets {
+		mount(feed.Facets[i].Links)
+	}
+	for i := range feed.Groups {
+		mount(feed.Groups[i].Links)
+		mountNav(feed.Groups[i].Navigation)
+		mountPubs(feed.Groups[i].Publications)
+	}
+}
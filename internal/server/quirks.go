@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/banux/nxt-opds/internal/opds"
+)
+
+// ClientQuirk adjusts OPDS 1.x feed generation for reader apps whose
+// User-Agent contains UserAgent (case-insensitively).
+type ClientQuirk struct {
+	UserAgent string
+
+	// AbsoluteURLs makes feed links absolute (scheme and host of the
+	// request) for readers that resolve relative hrefs incorrectly.
+	AbsoluteURLs bool
+
+	// PlainAtomType serves feeds and feed links as plain
+	// application/atom+xml, without the OPDS profile and kind parameters
+	// some readers fail to parse.
+	PlainAtomType bool
+}
+
+// DefaultClientQuirks lists the known problematic readers. Options.ClientQuirks
+// are matched before them, so an entry there can also switch a default off.
+var DefaultClientQuirks = []ClientQuirk{
+	{UserAgent: "Aldiko", AbsoluteURLs: true, PlainAtomType: true},
+	{UserAgent: "Marvin", AbsoluteURLs: true},
+}
+
+// clientQuirk returns the first quirk matching the request's User-Agent, or
+// the zero ClientQuirk when there is none.
+func (s *Server) clientQuirk(r *http.Request) ClientQuirk {
+	ua := strings.ToLower(r.Header.Get("User-Agent"))
+	if ua == "" {
+		return ClientQuirk{}
+	}
+	for _, quirks := range [][]ClientQuirk{s.opts.ClientQuirks, DefaultClientQuirks} {
+		for _, q := range quirks {
+			if q.UserAgent != "" && strings.Contains(ua, strings.ToLower(q.UserAgent)) {
+				return q
+			}
+		}
+	}
+	return ClientQuirk{}
+}
+
+// applyQuirk rewrites a mounted OPDS 1.x feed for q.
+func applyQuirk(feed *opds.Feed, r *http.Request, q ClientQuirk) {
+	fix := func(l *opds.Link) {
+		if q.AbsoluteURLs {
+			l.Href = absoluteURL(r, l.Href)
+		}
+		if q.PlainAtomType && strings.HasPrefix(l.Type, opds.MIMEAtomFeed+";") {
+			l.Type = opds.MIMEAtomFeed
+		}
+	}
+	if q.AbsoluteURLs {
+		feed.Icon = absoluteURL(r, feed.Icon)
+	}
+	for i := range feed.Links {
+		fix(&feed.Links[i])
+	}
+	for i := range feed.Entries {
+		for j := range feed.Entries[i].Links {
+			fix(&feed.Entries[i].Links[j])
+		}
+	}
+}
+
+// absoluteURL prefixes a path on this server with the scheme and host the
+// request was made to; other hrefs are returned unchanged. The scheme comes
+// from X-Forwarded-Proto when a reverse proxy sets it.
+func absoluteURL(r *http.Request, href string) string {
+	if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") {
+		return href
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + href
+}
@@ -4,6 +4,8 @@ package server
 import (
 	"io/fs"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -13,9 +15,15 @@ import (
 // Options holds optional configuration for the Server.
 type Options struct {
 	// Password is the shared password for form-based session authentication.
-	// If empty, authentication is disabled (useful for development).
+	// If empty and there are no Users, authentication is disabled (useful
+	// for development).
 	Password string
 
+	// Users are named accounts with their own passwords. With users the
+	// login form asks for a user name and each session belongs to the user
+	// who signed in; Password, if also set, still works as a shared login.
+	Users []User
+
 	// OPDSToken is the token accepted in the ?token= query parameter on OPDS
 	// routes, allowing OPDS reader clients to authenticate without Basic Auth.
 	// If empty, token authentication is disabled for OPDS routes.
@@ -24,36 +32,249 @@ type Options struct {
 	// StaticFS is the filesystem containing the frontend static assets.
 	// If nil, the frontend is not served.
 	StaticFS fs.FS
+
+	// TagSeparator enables hierarchical genres: tags are split on it (e.g.
+	// ">" turns "Fiction > SciFi" into "Fiction" → "SciFi") and /opds/tags
+	// drills down level by level. Empty disables the hierarchy.
+	TagSeparator string
+
+	// RobotsTxt is the body served at /robots.txt.
+	// If empty, defaultRobotsTxt (disallow everything) is served.
+	RobotsTxt string
+
+	// Private marks the catalog as private: every response carries an
+	// "X-Robots-Tag: noindex" header so search engines do not index it
+	// even if the server is accidentally exposed.
+	Private bool
+
+	// MaxFeedBytes caps the serialized size of paginated OPDS 1.x feeds.
+	// Oversize pages are shrunk to fewer entries with "next" links to the
+	// rest, so e-ink readers with little memory never get a huge document.
+	// 0 disables the cap.
+	MaxFeedBytes int
+
+	// MaxNavPageSize caps the page size of the author and genre navigation
+	// feeds (OPDS 1.x and 2.0), which can list many thousands of names.
+	// It also lowers their default page size when smaller. 0 uses the
+	// general cap of 200.
+	MaxNavPageSize int
+
+	// AuthorIndexThreshold is the number of authors above which the OPDS
+	// 1.x author feed becomes an alphabetical index, one entry per initial
+	// leading to ?letter= pages, when the backend implements
+	// catalog.AuthorIndexer. 0 always lists the authors.
+	AuthorIndexThreshold int
+
+	// MaxUploadSize is the largest book file, in bytes, accepted by the
+	// upload endpoint; larger ones are answered 413. 0 means
+	// catalog.DefaultMaxUploadSize and a negative value means no limit.
+	MaxUploadSize int64
+
+	// DownloadBlockedFormats lists file extensions ("pdf" or ".pdf",
+	// case-insensitive) that are indexed but not downloadable: the download
+	// endpoint answers 403 and feeds omit their acquisition links.
+	DownloadBlockedFormats []string
+
+	// KepubCacheDir, when set, keeps the EPUBs converted for
+	// ?format=kepub downloads in this directory, keyed by the source file's
+	// path, size and modification time. A copy is removed when a newer
+	// version of its source is converted, or when the book is updated or
+	// deleted. Empty converts on every download.
+	KepubCacheDir string
+
+	// PlaceholderCovers maps a book format, the MIME type or extension
+	// ("application/pdf", "pdf" or ".pdf", case-insensitive) of a book's
+	// primary file, to an image served as the cover of books of that format
+	// without one. The "default" entry serves every other format. Books
+	// without a cover answer 404 when nothing matches.
+	PlaceholderCovers map[string]string
+
+	// SharedDeviceTimeout enables a "shared device" option on the login
+	// form: sessions created with it end after this long without activity
+	// and are not remembered once the browser closes. 0 hides the option.
+	SharedDeviceTimeout time.Duration
+
+	// SessionDuration is how long a regular login session, and its cookie,
+	// lasts. 0 means 30 days.
+	SessionDuration time.Duration
+
+	// SecureCookies marks the session cookie Secure and
+	// SameSite=Strict, for servers reached over HTTPS.
+	SecureCookies bool
+
+	// TrailingSlash selects how requests for a route with extra trailing
+	// slashes ("/opds/books/") are handled: TrailingSlashRedirect (the
+	// default when empty) answers with a redirect to the canonical path,
+	// TrailingSlashServe serves the canonical route directly and
+	// TrailingSlashOff leaves them unmatched (404).
+	TrailingSlash string
+
+	// SessionSweepInterval is how often expired login sessions are purged
+	// from memory by a background goroutine; call Server.Close to stop it.
+	// 0 disables the sweep, leaving expired sessions to be dropped only
+	// when presented again.
+	SessionSweepInterval time.Duration
+
+	// Lending turns the catalog into a lending library: feeds advertise
+	// borrow links to /api/books/{id}/borrow instead of direct acquisition
+	// links, and downloads need the signed URL that endpoint issues.
+	Lending bool
+
+	// LoanPeriod is how long a signed download URL issued in lending mode
+	// stays valid. 0 means 24 hours.
+	LoanPeriod time.Duration
+
+	// DownloadLinkTitle is the title OPDS clients show for acquisition
+	// links; "{format}" is replaced by the file format ("EPUB", "PDF", …).
+	// Set it to localize the label, e.g. "Télécharger {format}". Empty
+	// means "Download {format}".
+	DownloadLinkTitle string
+
+	// EmptyCatalogNotice, when set, is the title of an informational entry
+	// heading the root navigation feed while the catalog has no books,
+	// e.g. "Library is empty — upload books". Empty leaves it out.
+	EmptyCatalogNotice string
+
+	// RatingScale selects how book ratings (1–5 stars) appear in feeds, as
+	// OPDS 2.0 metadata.rating and the calibre:rating element of OPDS 1.x
+	// entries: RatingScaleStars (the default when empty) emits the stars
+	// as is, RatingScaleFraction divides them by 5 and RatingScaleOff
+	// leaves ratings out.
+	RatingScale string
+
+	// ClientQuirks adjusts OPDS 1.x feeds for reader apps identified by
+	// their User-Agent. They are matched before DefaultClientQuirks.
+	ClientQuirks []ClientQuirk
+
+	// Libraries serves several catalogs from this Server, each under its
+	// own path prefix such as "/lib1" with its own Options, so each has its
+	// own password, users and OPDS token. When set, the catalog passed to
+	// New and the other options are not used.
+	Libraries map[string]Library
+
+	// BasePath is the path prefix the server is mounted under when it
+	// shares a listener with other libraries (see Libraries), e.g.
+	// "/lib1". Requests reach the server with the prefix stripped; it is
+	// added back to feed links, redirects and the session cookie path, so
+	// each library keeps its own login. Empty serves from the root.
+	BasePath string
+
+	// EffectiveConfig is the sanitized effective configuration reported by
+	// GET /api/config for debugging deployments. It must not contain
+	// secrets. Nil leaves it out of the response.
+	EffectiveConfig map[string]any
 }
 
+// Trailing-slash handling modes for Options.TrailingSlash.
+const (
+	TrailingSlashRedirect = "redirect"
+	TrailingSlashServe    = "serve"
+	TrailingSlashOff      = "off"
+)
+
+// Rating scales for Options.RatingScale.
+const (
+	RatingScaleStars    = "5"
+	RatingScaleFraction = "1"
+	RatingScaleOff      = "off"
+)
+
+// defaultRobotsTxt asks all crawlers to stay away from the whole catalog.
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
 // Server is the HTTP server for the OPDS catalog.
 type Server struct {
-	router        *mux.Router
-	catalog       catalog.Catalog
-	uploader      catalog.Uploader      // optional; nil if backend doesn't support upload
-	coverProvider catalog.CoverProvider // optional; nil if backend doesn't support cover serving
-	coverUpdater  catalog.CoverUpdater  // optional; nil if backend doesn't support cover update
-	updater       catalog.Updater       // optional; nil if backend doesn't support metadata editing
-	refresher     catalog.Refresher     // optional; nil if backend doesn't support manual refresh
-	deleter       catalog.Deleter       // optional; nil if backend doesn't support deletion
-	seriesLister  catalog.SeriesLister  // optional; nil if backend doesn't support series listing
-	sessions      *sessionStore
-	opts          Options
-	opdsToken     string // token for OPDS route authentication
+	router            *mux.Router
+	catalog           catalog.Catalog
+	uploader          catalog.Uploader             // optional; nil if backend doesn't support upload
+	coverProvider     catalog.CoverProvider        // optional; nil if backend doesn't support cover serving
+	thumbnailProvider catalog.ThumbnailProvider    // optional; nil if backend doesn't generate thumbnails
+	coverUpdater      catalog.CoverUpdater         // optional; nil if backend doesn't support cover update
+	updater           catalog.Updater              // optional; nil if backend doesn't support metadata editing
+	refresher         catalog.Refresher            // optional; nil if backend doesn't support manual refresh
+	deleter           catalog.Deleter              // optional; nil if backend doesn't support deletion
+	seriesLister      catalog.SeriesLister         // optional; nil if backend doesn't support series listing
+	yearBrowser       catalog.YearBrowser          // optional; nil if backend doesn't support browsing by year
+	languageLister    catalog.LanguageLister       // optional; nil if backend doesn't list languages
+	tagCounter        catalog.TagCounter           // optional; nil if backend can't count books per tag
+	authorCounter     catalog.AuthorCounter        // optional; nil if backend can't count books per author
+	pageSearcher      catalog.PageSearcher         // optional; nil if search totals are always exact
+	backupper         catalog.Backupper            // optional; nil if backend doesn't support backups
+	merger            catalog.Merger               // optional; nil if backend doesn't support merging duplicates
+	listManager       catalog.ListManager          // optional; nil if backend doesn't support reading lists
+	progressTracker   catalog.ProgressTracker      // optional; nil if backend doesn't track reading progress
+	randomPicker      catalog.RandomPicker         // optional; nil if backend can't pick random books
+	downloadCounter   catalog.DownloadCounter      // optional; nil if backend doesn't count downloads
+	lastModifier      catalog.LastModifier         // optional; nil if backend can't tell when it last changed
+	personalResetter  catalog.PersonalDataResetter // optional; nil if backend can't reset personal data
+	coverPurger       catalog.CoverPurger          // optional; nil if backend can't purge orphaned covers
+	authorAliaser     catalog.AuthorAliaser        // optional; nil if backend doesn't support author aliases
+	tagRelater        catalog.TagRelater           // optional; nil if backend can't relate tags
+	authorIndexer     catalog.AuthorIndexer        // optional; nil if backend can't list authors by letter
+	sessions          *sessionStore
+	auth              *authenticator
+	stopJanitor       func()     // stops the session sweep; nil when disabled
+	libraries         *libraries // set when serving Options.Libraries; the other fields are unused
+	opts              Options
+	opdsToken         string  // token for OPDS route authentication
+	lender            *lender // signs borrow URLs; nil unless Options.Lending
+	linkTitle         string  // acquisition link title layout, see acquisitionTitle
+
+	blockedFormats map[string]bool   // lower-case extensions without dot
+	placeholders   map[string]string // lower-case MIME type or extension -> placeholder cover path
+	authorSlugs    slugIndex         // author browse URL slug -> author name
+	tagSlugs       slugIndex         // tag browse URL slug -> tag or genre path
+	seriesSlugs    slugIndex         // series browse URL slug -> series name
+	started        time.Time         // fallback navigation timestamp for an empty catalog
 }
 
 // New creates and configures a new Server with the given catalog backend and options.
 // If the backend also implements catalog.Uploader, the upload endpoint is enabled.
 // If the backend also implements catalog.CoverProvider, the cover endpoint is enabled.
-// If opts.Password is non-empty, session-cookie auth is required on all endpoints except /health and /login.
+// If opts.Password or opts.Users is set, session-cookie auth is required on all endpoints except /health and /login.
 // If opts.StaticFS is non-nil, the frontend is served at /.
+// If opts.Libraries is set, each library is served under its prefix instead
+// and cat may be nil; New panics on an invalid or duplicate prefix.
 func New(cat catalog.Catalog, opts Options) *Server {
+	if len(opts.Libraries) > 0 {
+		return &Server{opts: opts, libraries: newLibraries(opts.Libraries)}
+	}
 	s := &Server{
 		router:    mux.NewRouter(),
 		catalog:   cat,
-		sessions:  newSessionStore(),
+		sessions:  newSessionStore(opts.SessionDuration),
+		auth:      newAuthenticator(opts.Password, opts.Users),
 		opts:      opts,
 		opdsToken: opts.OPDSToken,
+		started:   time.Now().Truncate(time.Second),
+	}
+	s.linkTitle = opts.DownloadLinkTitle
+	if s.linkTitle == "" {
+		s.linkTitle = defaultLinkTitle
+	}
+	for format, path := range opts.PlaceholderCovers {
+		format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+		if format != "" && path != "" {
+			if s.placeholders == nil {
+				s.placeholders = make(map[string]string)
+			}
+			s.placeholders[format] = path
+		}
+	}
+	for _, f := range opts.DownloadBlockedFormats {
+		f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), "."))
+		if f != "" {
+			if s.blockedFormats == nil {
+				s.blockedFormats = make(map[string]bool)
+			}
+			s.blockedFormats[f] = true
+		}
+	}
+	if opts.SessionSweepInterval > 0 {
+		s.stopJanitor = s.sessions.startJanitor(opts.SessionSweepInterval)
+	}
+	if opts.Lending {
+		s.lender = newLender(opts.LoanPeriod)
 	}
 	if u, ok := cat.(catalog.Uploader); ok {
 		s.uploader = u
@@ -61,6 +282,9 @@ func New(cat catalog.Catalog, opts Options) *Server {
 	if cp, ok := cat.(catalog.CoverProvider); ok {
 		s.coverProvider = cp
 	}
+	if tp, ok := cat.(catalog.ThumbnailProvider); ok {
+		s.thumbnailProvider = tp
+	}
 	if cu, ok := cat.(catalog.CoverUpdater); ok {
 		s.coverUpdater = cu
 	}
@@ -76,26 +300,147 @@ func New(cat catalog.Catalog, opts Options) *Server {
 	if sl, ok := cat.(catalog.SeriesLister); ok {
 		s.seriesLister = sl
 	}
+	if yb, ok := cat.(catalog.YearBrowser); ok {
+		s.yearBrowser = yb
+	}
+	if bu, ok := cat.(catalog.Backupper); ok {
+		s.backupper = bu
+	}
+	if tc, ok := cat.(catalog.TagCounter); ok {
+		s.tagCounter = tc
+	}
+	if ac, ok := cat.(catalog.AuthorCounter); ok {
+		s.authorCounter = ac
+	}
+	if ps, ok := cat.(catalog.PageSearcher); ok {
+		s.pageSearcher = ps
+	}
+	if ll, ok := cat.(catalog.LanguageLister); ok {
+		s.languageLister = ll
+	}
+	if mg, ok := cat.(catalog.Merger); ok {
+		s.merger = mg
+	}
+	if lm, ok := cat.(catalog.ListManager); ok {
+		s.listManager = lm
+	}
+	if pt, ok := cat.(catalog.ProgressTracker); ok {
+		s.progressTracker = pt
+	}
+	if rp, ok := cat.(catalog.RandomPicker); ok {
+		s.randomPicker = rp
+	}
+	if dc, ok := cat.(catalog.DownloadCounter); ok {
+		s.downloadCounter = dc
+	}
+	if lm, ok := cat.(catalog.LastModifier); ok {
+		s.lastModifier = lm
+	}
+	if pr, ok := cat.(catalog.PersonalDataResetter); ok {
+		s.personalResetter = pr
+	}
+	if cp, ok := cat.(catalog.CoverPurger); ok {
+		s.coverPurger = cp
+	}
+	if aa, ok := cat.(catalog.AuthorAliaser); ok {
+		s.authorAliaser = aa
+	}
+	if tr, ok := cat.(catalog.TagRelater); ok {
+		s.tagRelater = tr
+	}
+	if ai, ok := cat.(catalog.AuthorIndexer); ok {
+		s.authorIndexer = ai
+	}
 	s.registerRoutes()
 	return s
 }
 
-// ServeHTTP implements http.Handler, delegating to the mux router.
+// Close stops the server's background work (the session sweep). The
+// server keeps answering requests.
+func (s *Server) Close() {
+	if s.libraries != nil {
+		s.libraries.Close()
+	}
+	if s.stopJanitor != nil {
+		s.stopJanitor()
+	}
+}
+
+// ServeHTTP implements http.Handler, delegating to the mux router, or to
+// the library the path belongs to when serving Options.Libraries.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.libraries != nil {
+		s.libraries.ServeHTTP(w, r)
+		return
+	}
+	if canonical, ok := s.canonicalPath(r); ok {
+		if s.opts.TrailingSlash == TrailingSlashServe {
+			r.URL.Path, r.URL.RawPath = canonical, ""
+		} else {
+			u := *r.URL
+			u.Path, u.RawPath = canonical, ""
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				// 308 keeps the method and body of API writes.
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, s.url(u.RequestURI()), code)
+			return
+		}
+	}
 	s.router.ServeHTTP(w, r)
 }
 
+// canonicalPath reports the path without trailing slashes when the request
+// path has some, matches no route as-is (only the static catch-all) and
+// matches a route once they are removed. Routes registered with a trailing
+// slash, such as /opds/, and static directories are left alone.
+func (s *Server) canonicalPath(r *http.Request) (string, bool) {
+	if s.opts.TrailingSlash == TrailingSlashOff || !strings.HasSuffix(r.URL.Path, "/") {
+		return "", false
+	}
+	trimmed := strings.TrimRight(r.URL.Path, "/")
+	if trimmed == "" || s.routed(r) {
+		return "", false
+	}
+	canonical := r.Clone(r.Context())
+	canonical.URL.Path, canonical.URL.RawPath = trimmed, ""
+	if !s.routed(canonical) {
+		return "", false
+	}
+	return trimmed, true
+}
+
+// routed reports whether r matches a registered route other than the
+// catch-all "/" prefix.
+func (s *Server) routed(r *http.Request) bool {
+	var m mux.RouteMatch
+	if !s.router.Match(r, &m) || m.MatchErr != nil || m.Route == nil {
+		return false
+	}
+	tpl, err := m.Route.GetPathTemplate()
+	return err == nil && tpl != "/"
+}
+
 // registerRoutes sets up all endpoint routes.
 func (s *Server) registerRoutes() {
 	r := s.router
-	auth := authMiddleware(s.opts.Password, s.opdsToken, s.sessions)
+	auth := authMiddleware(s.auth, s.opdsToken, s.sessions, s.url("/login"))
+	if s.opts.Private {
+		r.Use(noindexMiddleware)
+	}
 
 	// Always-public endpoints (no auth required)
 	r.HandleFunc("/health", s.handleHealth).Methods(http.MethodGet)
+	r.HandleFunc("/robots.txt", s.handleRobots).Methods(http.MethodGet)
 	r.HandleFunc("/login", s.handleLoginPage).Methods(http.MethodGet)
 	r.HandleFunc("/login", s.handleLoginPost).Methods(http.MethodPost)
 	r.HandleFunc("/logout", s.handleLogout).Methods(http.MethodPost, http.MethodGet)
 
+	// Session status is checked outside the auth middleware so that polling
+	// it does not count as activity and keep an idle session alive.
+	r.HandleFunc("/api/session", s.handleAPISession).Methods(http.MethodGet)
+
 	// All other routes are wrapped with the auth middleware.
 	protected := r.NewRoute().Subrouter()
 	protected.Use(auth)
@@ -111,7 +456,7 @@ func (s *Server) registerRoutes() {
 	protected.HandleFunc("/opds/books/{id}", s.handleBook).Methods(http.MethodGet)
 
 	// File download
-	protected.HandleFunc("/opds/books/{id}/download", s.handleDownload).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/books/{id}/download", s.handleDownload).Methods(http.MethodGet, http.MethodHead)
 
 	// Search
 	protected.HandleFunc("/opds/search", s.handleSearch).Methods(http.MethodGet)
@@ -128,8 +473,30 @@ func (s *Server) registerRoutes() {
 	protected.HandleFunc("/opds/publishers", s.handlePublishers).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/publishers/{publisher}", s.handlePublisherBooks).Methods(http.MethodGet)
 
+	// Browse by series, in reading order
+	protected.HandleFunc("/opds/series", s.handleSeries).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/series/{name}", s.handleSeriesBooks).Methods(http.MethodGet)
+
+	// Browse by publication decade/year
+	protected.HandleFunc("/opds/years", s.handleYears).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/years/{range}", s.handleYearBooks).Methods(http.MethodGet)
+
+	// Random book for discovery
+	protected.HandleFunc("/opds/random", s.handleRandom).Methods(http.MethodGet)
+
+	// Most downloaded books feed
+	protected.HandleFunc("/opds/popular", s.handlePopular).Methods(http.MethodGet)
+
+	// Recently added books feed
+	protected.HandleFunc("/opds/recent", s.handleRecent).Methods(http.MethodGet)
+
 	// Unread books feed
 	protected.HandleFunc("/opds/unread", s.handleUnreadBooks).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/recently-read", s.handleRecentlyRead).Methods(http.MethodGet)
+
+	// User-curated reading lists
+	protected.HandleFunc("/opds/lists", s.handleLists).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/lists/{id}", s.handleListBooks).Methods(http.MethodGet)
 
 	// OpenSearch description document
 	protected.HandleFunc("/opds/opensearch.xml", s.handleOpenSearch).Methods(http.MethodGet)
@@ -137,6 +504,15 @@ func (s *Server) registerRoutes() {
 	// API: JSON books list for the web frontend
 	protected.HandleFunc("/api/books", s.handleAPIBooks).Methods(http.MethodGet)
 
+	// API: every book as a CSV spreadsheet
+	protected.HandleFunc("/api/export.csv", s.handleAPIExportCSV).Methods(http.MethodGet)
+
+	// API: universal search grouped into books, authors and series
+	protected.HandleFunc("/api/search", s.handleAPISearch).Methods(http.MethodGet)
+
+	// API: one random book (enabled when backend supports it)
+	protected.HandleFunc("/api/random", s.handleAPIRandom).Methods(http.MethodGet)
+
 	// API: get single book by ID
 	protected.HandleFunc("/api/books/{id}", s.handleAPIBook).Methods(http.MethodGet)
 
@@ -146,6 +522,30 @@ func (s *Server) registerRoutes() {
 	// API: delete a book (enabled when backend supports it)
 	protected.HandleFunc("/api/books/{id}", s.handleAPIDeleteBook).Methods(http.MethodDelete)
 
+	// API: raw resource (XHTML, CSS, image…) from inside a book's EPUB
+	protected.HandleFunc("/api/books/{id}/resource", s.handleAPIBookResource).Methods(http.MethodGet)
+
+	// API: Readium Web Publication Manifest of a book's EPUB
+	protected.HandleFunc("/api/books/{id}/manifest.json", s.handleAPIBookManifest).Methods(http.MethodGet)
+
+	// API: merge a duplicate book into this one (enabled when backend supports it)
+	protected.HandleFunc("/api/books/{id}/duplicate-merge", s.handleAPIMergeBooks).Methods(http.MethodPost)
+
+	// API: borrow a book in lending mode
+	protected.HandleFunc("/api/books/{id}/borrow", s.handleAPIBorrow).Methods(http.MethodGet)
+
+	// API: reading progress (enabled when backend supports it)
+	protected.HandleFunc("/api/books/{id}/progress", s.handleAPIGetProgress).Methods(http.MethodGet)
+	protected.HandleFunc("/api/books/{id}/progress", s.handleAPISetProgress).Methods(http.MethodPut)
+
+	// API: keep the current browser session alive
+	protected.HandleFunc("/api/session", s.handleAPISession).Methods(http.MethodPost)
+
+	// API: reading lists (enabled when backend supports it)
+	protected.HandleFunc("/api/lists", s.handleAPILists).Methods(http.MethodGet)
+	protected.HandleFunc("/api/lists", s.handleAPICreateList).Methods(http.MethodPost)
+	protected.HandleFunc("/api/lists/{id}/books", s.handleAPIAddToList).Methods(http.MethodPost)
+
 	// API: update cover image for a book (enabled when backend supports it)
 	protected.HandleFunc("/api/books/{id}/cover", s.handleAPIUpdateCover).Methods(http.MethodPost)
 
@@ -155,9 +555,15 @@ func (s *Server) registerRoutes() {
 	// API: list all distinct authors
 	protected.HandleFunc("/api/authors", s.handleAPIAuthors).Methods(http.MethodGet)
 
+	// API: group a pseudonym under a canonical author (enabled when backend supports it)
+	protected.HandleFunc("/api/authors/alias", s.handleAPIAuthorAlias).Methods(http.MethodPost)
+
 	// API: list all distinct tags
 	protected.HandleFunc("/api/tags", s.handleAPITags).Methods(http.MethodGet)
 
+	// API: tags that co-occur with a tag (enabled when backend supports it)
+	protected.HandleFunc("/api/tags/{tag}/related", s.handleAPIRelatedTags).Methods(http.MethodGet)
+
 	// API: list all distinct publishers
 	protected.HandleFunc("/api/publishers", s.handleAPIPublishers).Methods(http.MethodGet)
 
@@ -167,15 +573,29 @@ func (s *Server) registerRoutes() {
 	// API: public server config (opdsToken, etc.) for the web frontend
 	protected.HandleFunc("/api/config", s.handleAPIConfig).Methods(http.MethodGet)
 
+	// API: optional capabilities supported by the active backend
+	protected.HandleFunc("/api/capabilities", s.handleAPICapabilities).Methods(http.MethodGet)
+
+	// API: structural self-test of the generated OPDS feeds
+	protected.HandleFunc("/api/validate/opds", s.handleAPIValidateOPDS).Methods(http.MethodGet)
+
 	// API: trigger a manual catalog refresh (enabled when backend supports it)
 	protected.HandleFunc("/api/refresh", s.handleAPIRefresh).Methods(http.MethodPost)
 
+	// API: strip read state, ratings and progress from the whole catalog
+	protected.HandleFunc("/api/maintenance/reset-personal", s.handleAPIResetPersonal).Methods(http.MethodPost)
+
+	// API: remove cached covers of books no longer in the catalog
+	protected.HandleFunc("/api/maintenance/purge-covers", s.handleAPIPurgeCovers).Methods(http.MethodPost)
+
 	// Cover image endpoint
 	protected.HandleFunc("/covers/{id}", s.handleCover).Methods(http.MethodGet)
+	protected.HandleFunc("/covers/{id}/thumb", s.handleThumbnail).Methods(http.MethodGet)
 
 	// OPDS 2.0 JSON feed (https://drafts.opds.io/opds-2.0)
 	protected.HandleFunc("/opds/v2", s.handleOPDS2Root).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/publications", s.handleOPDS2Publications).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/v2/publications/{id}", s.handleOPDS2Publication).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/search", s.handleOPDS2Search).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/authors", s.handleOPDS2Authors).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/authors/{author}", s.handleOPDS2AuthorBooks).Methods(http.MethodGet)
@@ -184,6 +604,7 @@ func (s *Server) registerRoutes() {
 	protected.HandleFunc("/opds/v2/publishers", s.handleOPDS2Publishers).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/publishers/{publisher}", s.handleOPDS2PublisherBooks).Methods(http.MethodGet)
 	protected.HandleFunc("/opds/v2/unread", s.handleOPDS2Unread).Methods(http.MethodGet)
+	protected.HandleFunc("/opds/v2/recent", s.handleOPDS2Recent).Methods(http.MethodGet)
 
 	// Frontend static assets – serves index.html at / and any static files.
 	// When StaticFS is nil (e.g. in tests), a catch-all 404 handler is
@@ -197,3 +618,12 @@ func (s *Server) registerRoutes() {
 		})
 	}
 }
+
+// noindexMiddleware adds an X-Robots-Tag header telling search engines not to
+// index or follow any response.
+func noindexMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		next.ServeHTTP(w, r)
+	})
+}
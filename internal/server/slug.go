@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSlugPrefix caps the readable part of a slug so that very long names
+// still produce short URLs.
+const maxSlugPrefix = 40
+
+// nameSlug returns a stable, URL-safe path segment for an author or tag
+// name: a lower-case ASCII prefix for readability followed by a short hash
+// of the full name, e.g. "isaac-asimov-3f1c9e2a7b0d". Names made only of
+// non-ASCII characters are represented by the hash alone.
+func nameSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if b.Len() >= maxSlugPrefix {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if b.Len() > 0 && !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:6])
+	prefix := strings.TrimRight(b.String(), "-")
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
+
+// slugIndex is the reverse map from slugs back to the names they were
+// derived from. It is rebuilt lazily when a slug is not found and the
+// catalog has changed since the last build, so names added since resolve
+// without explicit invalidation while unknown slugs stay cheap.
+type slugIndex struct {
+	mu      sync.Mutex
+	names   map[string]string // slug -> name; nil until first loaded
+	version time.Time         // catalog change time the names were loaded at
+}
+
+// resolve returns the name for slug. On a miss the index is reloaded from
+// load, unless changed reports the same catalog change time as the last
+// load. The second result is false if no current name has that slug.
+func (x *slugIndex) resolve(slug string, changed func() time.Time, load func() ([]string, error)) (string, bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if name, ok := x.names[slug]; ok {
+		return name, true, nil
+	}
+	version := changed()
+	if x.names != nil && version.Equal(x.version) {
+		return "", false, nil
+	}
+	names, err := load()
+	if err != nil {
+		return "", false, err
+	}
+	x.names = make(map[string]string, len(names))
+	for _, name := range names {
+		x.names[nameSlug(name)] = name
+	}
+	x.version = version
+	name, ok := x.names[slug]
+	return name, ok, nil
+}
+
+// resolveAuthor maps an {author} path segment to an author name. Segments
+// that are not a known slug are treated as a path-escaped raw name so that
+// links from earlier versions keep working.
+func (s *Server) resolveAuthor(segment string) (string, error) {
+	name, ok, err := s.authorSlugs.resolve(segment, s.catalogUpdated, func() ([]string, error) {
+		return allNames(s.catalog.Authors)
+	})
+	if err != nil || ok {
+		return name, err
+	}
+	raw, _ := url.PathUnescape(segment)
+	return raw, nil
+}
+
+// resolveTag maps a {tag} path segment to a tag name, or to a genre path
+// when a tag hierarchy is configured. Unknown segments are treated as a
+// path-escaped raw name, as for resolveAuthor.
+func (s *Server) resolveTag(segment string) (string, error) {
+	name, ok, err := s.tagSlugs.resolve(segment, s.catalogUpdated, func() ([]string, error) {
+		tags, err := allNames(s.catalog.Tags)
+		if err != nil || s.opts.TagSeparator == "" {
+			return tags, err
+		}
+		// Intermediate genres such as "Fiction" in "Fiction > SciFi" are
+		// linked to even though no book carries them as a tag.
+		var paths []string
+		var walk func(n *tagNode)
+		walk = func(n *tagNode) {
+			for _, c := range n.children {
+				paths = append(paths, c.path)
+				walk(c)
+			}
+		}
+		walk(buildTagTree(tags, s.opts.TagSeparator))
+		return append(tags, paths...), nil
+	})
+	if err != nil || ok {
+		return name, err
+	}
+	raw, _ := url.PathUnescape(segment)
+	return raw, nil
+}
+
+// resolveSeries maps a {name} series path segment to a series name. A
+// segment that is not a known slug may be the series name itself, as
+// linked by earlier versions. The second result is false for an unknown
+// series.
+func (s *Server) resolveSeries(segment string) (string, bool, error) {
+	load := func() ([]string, error) {
+		series, err := s.seriesLister.Series()
+		names := make([]string, 0, len(series))
+		for _, se := range series {
+			names = append(names, se.Name)
+		}
+		return names, err
+	}
+	name, ok, err := s.seriesSlugs.resolve(segment, s.catalogUpdated, load)
+	if err != nil || ok || segment == "" {
+		return name, ok, err
+	}
+	return s.seriesSlugs.resolve(nameSlug(segment), s.catalogUpdated, load)
+}
+
+// allNames returns every name from a paginated catalog listing such as
+// Catalog.Authors or Catalog.Tags.
+func allNames(list func(offset, limit int) ([]string, int, error)) ([]string, error) {
+	_, total, err := list(0, 1)
+	if err != nil || total == 0 {
+		return nil, err
+	}
+	names, _, err := list(0, total)
+	return names, err
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+// tagNode is one level of a hierarchical genre tree built from tags such as
+// "Fiction > Science Fiction > Cyberpunk".
+type tagNode struct {
+	name     string // last path component, e.g. "Science Fiction"
+	path     string // components joined with the separator, e.g. "Fiction > Science Fiction"
+	tag      string // original catalog tag for this exact path; empty if only a prefix
+	children map[string]*tagNode
+}
+
+// buildTagTree splits every tag on sep and merges them into a tree whose
+// root has no name. Components are trimmed of surrounding whitespace and
+// empty components are dropped, so "Fiction>SciFi" and "Fiction > SciFi"
+// land on the same node.
+func buildTagTree(tags []string, sep string) *tagNode {
+	root := &tagNode{children: make(map[string]*tagNode)}
+	for _, tag := range tags {
+		parts := splitTagPath(tag, sep)
+		if len(parts) == 0 {
+			continue
+		}
+		node := root
+		for i, part := range parts {
+			child, ok := node.children[part]
+			if !ok {
+				child = &tagNode{
+					name:     part,
+					path:     strings.Join(parts[:i+1], sep),
+					children: make(map[string]*tagNode),
+				}
+				node.children[part] = child
+			}
+			node = child
+		}
+		if node.tag == "" {
+			node.tag = tag
+		}
+	}
+	return root
+}
+
+// find returns the node at the given separator-joined path, or nil.
+func (n *tagNode) find(path, sep string) *tagNode {
+	node := n
+	for _, part := range splitTagPath(path, sep) {
+		node = node.children[part]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// sortedChildren returns the node's children ordered case-insensitively by name.
+func (n *tagNode) sortedChildren() []*tagNode {
+	out := make([]*tagNode, 0, len(n.children))
+	for _, c := range n.children {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].name) < strings.ToLower(out[j].name)
+	})
+	return out
+}
+
+// splitTagPath splits tag on sep, trimming whitespace and dropping empty parts.
+func splitTagPath(tag, sep string) []string {
+	var parts []string
+	for _, p := range strings.Split(tag, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
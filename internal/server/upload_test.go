@@ -3,20 +3,43 @@ package server
 import (
 	"archive/zip"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	fsbackend "github.com/banux/nxt-opds/internal/backend/fs"
+	sqlitebackend "github.com/banux/nxt-opds/internal/backend/sqlite"
 	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/opds"
 )
 
 // buildEPUBBytes returns the raw bytes of a minimal valid EPUB.
 func buildEPUBBytes(title, author string) []byte {
+	return buildEPUBBytesWithMetadata(title, author, "")
+}
+
+// buildEPUBBytesWithMetadata returns a minimal valid EPUB whose OPF metadata
+// block additionally contains the raw XML in extra (e.g. "<dc:date>…</dc:date>").
+func buildEPUBBytesWithMetadata(title, author, extra string) []byte {
+	return buildEPUBBytesFromMetadata(`
+    <dc:title>` + title + `</dc:title>
+    <dc:creator>` + author + `</dc:creator>
+    <dc:language>en</dc:language>` + extra)
+}
+
+// buildEPUBBytesFromMetadata returns a minimal valid EPUB whose OPF metadata
+// block is exactly the raw XML in metadata.
+func buildEPUBBytesFromMetadata(metadata string) []byte {
 	containerXML := `<?xml version="1.0" encoding="UTF-8"?>
 <container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
   <rootfiles>
@@ -26,10 +49,7 @@ func buildEPUBBytes(title, author string) []byte {
 
 	contentOPF := `<?xml version="1.0" encoding="UTF-8"?>
 <package xmlns="http://www.idpf.org/2007/opf" version="2.0">
-  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
-    <dc:title>` + title + `</dc:title>
-    <dc:creator>` + author + `</dc:creator>
-    <dc:language>en</dc:language>
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">` + metadata + `
   </metadata>
 </package>`
 
@@ -205,3 +225,369 @@ func TestHandleDownload_Success(t *testing.T) {
 		t.Errorf("Content-Type: got %q, want %q", ct, "application/epub+zip")
 	}
 }
+
+func TestHandleDownload_KindleFormats(t *testing.T) {
+	srv := newTestServer(t, Options{})
+
+	for _, tc := range []struct{ filename, mimeType string }{
+		{"kindle-book.mobi", opds.MIMEMobiPocket},
+		{"kindle-book.azw3", opds.MIMEAZWThree},
+	} {
+		// Not a real MOBI header: the title falls back to the file name.
+		book := uploadFile(t, srv, tc.filename, []byte("not really a mobi file"))
+		if book.Title != "kindle-book" {
+			t.Errorf("%s: title got %q, want file name fallback", tc.filename, book.Title)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/opds/books/"+book.ID+"/download", nil)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s download: expected 200, got %d", tc.filename, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tc.mimeType {
+			t.Errorf("%s Content-Type: got %q, want %q", tc.filename, ct, tc.mimeType)
+		}
+	}
+}
+
+func TestHandleUpload_FB2(t *testing.T) {
+	fb2 := `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description><title-info><genre>sf</genre>
+<author><first-name>Ivan</first-name><last-name>Efremov</last-name></author>
+<book-title>Andromeda</book-title><lang>ru</lang></title-info></description>
+<body><section><p>Text</p></section></body>
+</FictionBook>`
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, _ := zw.Create("andromeda.fb2")
+	_, _ = f.Write([]byte(fb2))
+	_ = zw.Close()
+
+	backends := map[string]func(dir string) (catalog.Catalog, error){
+		"fs": func(dir string) (catalog.Catalog, error) { return fsbackend.New(dir) },
+		"sqlite": func(dir string) (catalog.Catalog, error) {
+			b, err := sqlitebackend.New(dir)
+			if err == nil {
+				t.Cleanup(func() { b.Close() })
+			}
+			return b, err
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend(t.TempDir())
+			if err != nil {
+				t.Fatalf("backend: %v", err)
+			}
+			srv := New(backend, Options{})
+
+			for _, tc := range []struct {
+				filename, mimeType string
+				data               []byte
+			}{
+				{"andromeda.fb2", opds.MIMEFB2, []byte(fb2)},
+				{"andromeda-zipped.fb2.zip", opds.MIMEFB2Zip, zipped.Bytes()},
+			} {
+				book := uploadFile(t, srv, tc.filename, tc.data)
+				if book.Title != "Andromeda" || book.Language != "ru" {
+					t.Errorf("%s: title/language got %q / %q", tc.filename, book.Title, book.Language)
+				}
+				if len(book.Authors) != 1 || book.Authors[0].Name != "Ivan Efremov" {
+					t.Errorf("%s: authors got %+v", tc.filename, book.Authors)
+				}
+
+				req := httptest.NewRequest(http.MethodGet, "/opds/books/"+book.ID+"/download", nil)
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("%s download: expected 200, got %d", tc.filename, rr.Code)
+				}
+				if ct := rr.Header().Get("Content-Type"); ct != tc.mimeType {
+					t.Errorf("%s Content-Type: got %q, want %q", tc.filename, ct, tc.mimeType)
+				}
+			}
+		})
+	}
+}
+
+// buildCBRBytes returns a RAR 1.5 archive holding entries uncompressed.
+func buildCBRBytes(entries map[string]string) []byte {
+	var buf bytes.Buffer
+	// block appends a block whose header starts with the low 16 bits of
+	// the CRC-32 of the rest of the header.
+	block := func(header, data []byte) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(crc32.ChecksumIEEE(header)))
+		buf.Write(header)
+		buf.Write(data)
+	}
+	buf.WriteString("Rar!\x1a\x07\x00")
+	block([]byte{0x73, 0, 0, 13, 0, 0, 0, 0, 0, 0, 0}, nil)
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		data := []byte(entries[name])
+		var h bytes.Buffer
+		h.WriteByte(0x74) // file block
+		for _, v := range []any{
+			uint16(0x8000),         // data follows the header
+			uint16(32 + len(name)), // header size
+			uint32(len(data)),      // packed size
+			uint32(len(data)),      // unpacked size
+			uint8(3),               // Unix
+			crc32.ChecksumIEEE(data),
+			uint32(0),   // DOS time
+			uint8(20),   // version to extract
+			uint8(0x30), // store
+			uint16(len(name)),
+			uint32(0o100644),
+		} {
+			_ = binary.Write(&h, binary.LittleEndian, v)
+		}
+		h.WriteString(name)
+		block(h.Bytes(), data)
+	}
+	block([]byte{0x7b, 0, 0x40, 7, 0}, nil)
+	return buf.Bytes()
+}
+
+func TestHandleUpload_Comics(t *testing.T) {
+	var cbz bytes.Buffer
+	zw := zip.NewWriter(&cbz)
+	for _, e := range []struct{ name, data string }{
+		{"__MACOSX/._001.jpg", "resource fork"},
+		{"pages/002.jpg", "second page"},
+		{"pages/001.jpg", "first page"},
+		{"ComicInfo.xml", "<ComicInfo/>"},
+	} {
+		f, _ := zw.Create(e.name)
+		_, _ = f.Write([]byte(e.data))
+	}
+	_ = zw.Close()
+
+	backends := map[string]func(dir string) (catalog.Catalog, error){
+		"fs": func(dir string) (catalog.Catalog, error) { return fsbackend.New(dir) },
+		"sqlite": func(dir string) (catalog.Catalog, error) {
+			b, err := sqlitebackend.New(dir)
+			if err == nil {
+				t.Cleanup(func() { b.Close() })
+			}
+			return b, err
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend(t.TempDir())
+			if err != nil {
+				t.Fatalf("backend: %v", err)
+			}
+			srv := New(backend, Options{})
+
+			for _, tc := range []struct {
+				filename, mimeType, cover string
+				data                      []byte
+			}{
+				{"Watchmen 01.cbz", opds.MIMECBZ, "first page", cbz.Bytes()},
+				{"Watchmen 02.cbr", opds.MIMECBR, "cover", buildCBRBytes(map[string]string{"01.jpg": "cover", "02.jpg": "page"})},
+			} {
+				book := uploadFile(t, srv, tc.filename, tc.data)
+				want := tc.filename[:len(tc.filename)-len(filepath.Ext(tc.filename))]
+				if book.Title != want {
+					t.Errorf("%s: title got %q, want %q", tc.filename, book.Title, want)
+				}
+
+				req := httptest.NewRequest(http.MethodGet, "/opds/books/"+book.ID+"/download", nil)
+				rr := httptest.NewRecorder()
+				srv.ServeHTTP(rr, req)
+				if rr.Code != http.StatusOK {
+					t.Fatalf("%s download: expected 200, got %d", tc.filename, rr.Code)
+				}
+				if ct := rr.Header().Get("Content-Type"); ct != tc.mimeType {
+					t.Errorf("%s Content-Type: got %q, want %q", tc.filename, ct, tc.mimeType)
+				}
+
+				if tc.cover == "" {
+					if book.CoverURL != "" {
+						t.Errorf("%s: unexpected cover %q", tc.filename, book.CoverURL)
+					}
+					continue
+				}
+				rr = getCover(srv, book.ID, "")
+				if rr.Code != http.StatusOK || rr.Body.String() != tc.cover {
+					t.Errorf("%s cover: got %d %q, want the first page", tc.filename, rr.Code, rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestKEPUB_IndexedWithKepubMIME(t *testing.T) {
+	dir := t.TempDir()
+	data := buildEPUBBytesWithMetadata("Kobo Story", "Jane Doe", "")
+	if err := os.WriteFile(filepath.Join(dir, "story.kepub.epub"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]func() (catalog.Catalog, error){
+		"fs": func() (catalog.Catalog, error) { return fsbackend.New(dir) },
+		"sqlite": func() (catalog.Catalog, error) {
+			b, err := sqlitebackend.New(dir)
+			if err == nil {
+				t.Cleanup(func() { b.Close() })
+			}
+			return b, err
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend()
+			if err != nil {
+				t.Fatalf("backend: %v", err)
+			}
+			srv := New(backend, Options{})
+
+			feed := getFeed(t, srv, "/opds/books")
+			if len(feed.Entries) != 1 || feed.Entries[0].Title.Value != "Kobo Story" {
+				t.Fatalf("entries: got %v, want the kepub parsed as an EPUB", entryTitles(feed))
+			}
+			var acq *opds.Link
+			for i, l := range feed.Entries[0].Links {
+				if l.Rel == opds.RelAcquisition {
+					acq = &feed.Entries[0].Links[i]
+				}
+			}
+			if acq == nil || acq.Type != opds.MIMEKEPub {
+				t.Fatalf("acquisition link: got %+v, want type %s", acq, opds.MIMEKEPub)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, acq.Href, nil)
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("download: expected 200, got %d", rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != opds.MIMEKEPub {
+				t.Errorf("download Content-Type: got %q, want %q", ct, opds.MIMEKEPub)
+			}
+		})
+	}
+}
+
+func TestHandleUpload_OrganizeUploads(t *testing.T) {
+	backends := map[string]func(dir string) (catalog.Catalog, error){
+		"fs": func(dir string) (catalog.Catalog, error) {
+			return fsbackend.NewWithOptions(dir, fsbackend.Options{OrganizeUploads: true})
+		},
+		"sqlite": func(dir string) (catalog.Catalog, error) {
+			b, err := sqlitebackend.NewWithOptions(dir, sqlitebackend.Options{OrganizeUploads: true})
+			if err == nil {
+				t.Cleanup(func() { b.Close() })
+			}
+			return b, err
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			backend, err := newBackend(dir)
+			if err != nil {
+				t.Fatalf("backend: %v", err)
+			}
+			srv := New(backend, Options{})
+
+			epubData := buildEPUBBytesWithMetadata("Foundation and Empire", "Isaac Asimov",
+				`<meta name="calibre:series" content="Foundation"/>`)
+			wantDir := filepath.Join(dir, "Isaac Asimov", "Foundation")
+			for i, wantFile := range []string{"Foundation and Empire.epub", "Foundation and Empire (2).epub"} {
+				book := uploadFile(t, srv, "upload.epub", epubData)
+				wantPath := filepath.Join(wantDir, wantFile)
+				if _, err := os.Stat(wantPath); err != nil {
+					t.Fatalf("upload %d: expected file at %s: %v", i, wantPath, err)
+				}
+				indexed, err := backend.BookByID(book.ID)
+				if err != nil {
+					t.Fatalf("upload %d: book not indexed: %v", i, err)
+				}
+				if len(indexed.Files) == 0 || indexed.Files[0].Path != wantPath {
+					t.Errorf("upload %d: indexed path = %v, want %s", i, indexed.Files, wantPath)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "upload.epub")); !os.IsNotExist(err) {
+				t.Error("staging file left in the books root")
+			}
+			if _, total, _ := backend.AllBooks(0, 10); total != 2 {
+				t.Errorf("catalog total: got %d, want 2", total)
+			}
+		})
+	}
+}
+
+func TestStoreBook_TooLarge(t *testing.T) {
+	const limit = 1 << 10
+	backends := map[string]func(dir string) (catalog.Uploader, error){
+		"fs": func(dir string) (catalog.Uploader, error) {
+			return fsbackend.NewWithOptions(dir, fsbackend.Options{MaxUploadSize: limit})
+		},
+		"sqlite": func(dir string) (catalog.Uploader, error) {
+			b, err := sqlitebackend.NewWithOptions(dir, sqlitebackend.Options{MaxUploadSize: limit})
+			if err == nil {
+				t.Cleanup(func() { b.Close() })
+			}
+			return b, err
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			backend, err := newBackend(dir)
+			if err != nil {
+				t.Fatalf("backend: %v", err)
+			}
+
+			src := io.NopCloser(bytes.NewReader(make([]byte, limit+1)))
+			if _, err := backend.StoreBook("big.epub", src); !errors.Is(err, catalog.ErrTooLarge) {
+				t.Fatalf("StoreBook error = %v, want ErrTooLarge", err)
+			}
+			if tmps, _ := filepath.Glob(filepath.Join(dir, ".upload-*.tmp")); len(tmps) > 0 {
+				t.Errorf("temp files left behind: %v", tmps)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "big.epub")); !os.IsNotExist(err) {
+				t.Error("oversize upload stored in the catalog")
+			}
+
+			// A file of exactly the limit is not rejected for its size.
+			src = io.NopCloser(bytes.NewReader(make([]byte, limit)))
+			if _, err := backend.StoreBook("fits.epub", src); errors.Is(err, catalog.ErrTooLarge) {
+				t.Errorf("StoreBook rejected a file at the limit: %v", err)
+			}
+		})
+	}
+
+	// 0 is the default limit and a negative one disables the check.
+	src := bytes.NewReader(make([]byte, catalog.DefaultMaxUploadSize+1))
+	if _, err := catalog.CopyLimited(io.Discard, src, 0); !errors.Is(err, catalog.ErrTooLarge) {
+		t.Errorf("CopyLimited with limit 0: error %v, want ErrTooLarge", err)
+	}
+	src.Reset(make([]byte, catalog.DefaultMaxUploadSize+1))
+	if n, err := catalog.CopyLimited(io.Discard, src, -1); err != nil || n != catalog.DefaultMaxUploadSize+1 {
+		t.Errorf("CopyLimited with limit -1: copied %d bytes, err %v; want all of them", n, err)
+	}
+}
+
+func TestHandleUpload_TooLarge(t *testing.T) {
+	dir := t.TempDir()
+	backend, _ := fsbackend.New(dir)
+	epubData := buildEPUBBytes("Big Book", "Big Author")
+	srv := New(backend, Options{MaxUploadSize: int64(len(epubData) - 1)})
+
+	body, ct := buildMultipartBody(t, "file", "big.epub", epubData)
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
+	req.Header.Set("Content-Type", ct)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.epub")); !os.IsNotExist(err) {
+		t.Error("oversize upload stored in the catalog")
+	}
+}
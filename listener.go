@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener is a net.Listener that accepts at most n simultaneous
+// connections. Accept blocks while the limit is reached and resumes as soon
+// as a previously accepted connection is closed.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+// newLimitListener wraps l so that at most n connections are open at once.
+func newLimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+// Accept waits for a free slot, then accepts the next connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// limitConn releases its listener slot exactly once when closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
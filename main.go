@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/banux/nxt-opds/internal/config"
+	"github.com/banux/nxt-opds/internal/epub"
 
 	fsbackend "github.com/banux/nxt-opds/internal/backend/fs"
 	sqlitebackend "github.com/banux/nxt-opds/internal/backend/sqlite"
@@ -16,6 +24,11 @@ import (
 	"github.com/banux/nxt-opds/web"
 )
 
+// shutdownGracePeriod bounds how long a SIGINT/SIGTERM waits for in-flight
+// requests and background work (refresh, backup) before the catalogs are
+// closed.
+const shutdownGracePeriod = 10 * time.Second
+
 func main() {
 	// Load configuration: YAML file (if found) merged with env var overrides.
 	cfgPath := config.FindConfigFile()
@@ -27,87 +40,346 @@ func main() {
 		log.Printf("loaded configuration from %q", cfgPath)
 	}
 
-	if cfg.Password == "" {
-		log.Printf("WARNING: auth_password is not set – authentication is disabled")
+	epubOpts := epub.Options{
+		Strict:              cfg.EPUBStrict,
+		OpenRetries:         cfg.ScanRetries,
+		OpenRetryDelay:      cfg.ScanRetryDelay,
+		CleanFilenameTitles: cfg.CleanFilenameTitles,
+		IgnoreFileAs:        cfg.IgnoreFileAs,
+		AddedAt:             epub.AddedAtSource(cfg.AddedAtSource),
+	}
+	if cfg.ParseCacheDir != "" {
+		cache, err := epub.NewParseCache(cfg.ParseCacheDir)
+		if err != nil {
+			log.Fatalf("parse cache: %v", err)
+		}
+		epubOpts.Cache = cache
+	}
+
+	opts := server.Options{
+		Password:               cfg.Password,
+		Users:                  users(cfg.Users),
+		OPDSToken:              cfg.OPDSToken,
+		StaticFS:               web.FS,
+		RobotsTxt:              cfg.RobotsTxt,
+		Private:                cfg.Private,
+		TagSeparator:           cfg.TagSeparator,
+		MaxFeedBytes:           cfg.MaxFeedBytes,
+		MaxNavPageSize:         cfg.MaxNavPageSize,
+		AuthorIndexThreshold:   cfg.AuthorIndexThreshold,
+		MaxUploadSize:          cfg.MaxUploadSize,
+		DownloadBlockedFormats: cfg.DownloadBlockedFormats,
+		KepubCacheDir:          cfg.KepubCacheDir,
+		PlaceholderCovers:      cfg.PlaceholderCovers,
+		SharedDeviceTimeout:    cfg.SharedDeviceTimeout,
+		SessionDuration:        cfg.SessionDuration,
+		SecureCookies:          cfg.SecureCookies,
+		SessionSweepInterval:   cfg.SessionSweepInterval,
+		TrailingSlash:          cfg.TrailingSlash,
+		Lending:                cfg.Lending,
+		LoanPeriod:             cfg.LoanPeriod,
+		DownloadLinkTitle:      cfg.DownloadLinkTitle,
+		EmptyCatalogNotice:     cfg.EmptyCatalogNotice,
+		RatingScale:            cfg.RatingScale,
+		ClientQuirks:           clientQuirks(cfg.ClientQuirks),
+		EffectiveConfig:        cfg.Sanitized(),
+	}
+
+	// SIGINT/SIGTERM cancel ctx, which stops the background refresh and
+	// backup loops and starts the graceful shutdown below.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+	var catalogs []catalog.Catalog
+
+	var handler http.Handler
+	var closeHandler func()
+	if len(cfg.Libraries) == 0 {
+		if cfg.Password == "" && len(cfg.Users) == 0 {
+			log.Printf("WARNING: neither auth_password nor users is set – authentication is disabled")
+		}
+		cat := openCatalog(ctx, &background, cfg, cfg.BooksDir, cfg.Backend, cfg.CoversDir, cfg.EffectiveBackupDir(), epubOpts)
+		catalogs = append(catalogs, cat)
+		srv := server.New(cat, opts)
+		handler, closeHandler = srv, srv.Close
+
+		log.Printf("nxt-opds starting on %s", cfg.ListenAddr)
+		log.Printf("Web UI available at http://localhost%s/", cfg.ListenAddr)
+		if cfg.OPDSToken != "" {
+			log.Printf("OPDS feed URL (for reader apps): http://localhost%s/opds?token=%s", cfg.ListenAddr, cfg.OPDSToken)
+		}
+	} else {
+		log.Printf("nxt-opds starting on %s", cfg.ListenAddr)
+		libs := make(map[string]server.Library, len(cfg.Libraries))
+		for _, lc := range cfg.Libraries {
+			if lc.Password == "" && len(lc.Users) == 0 {
+				log.Printf("WARNING: library %s has neither auth_password nor users – authentication is disabled", lc.Prefix)
+			}
+			// Each library keeps its covers and backups in its own books
+			// directory.
+			cat := openCatalog(ctx, &background, cfg, lc.BooksDir, lc.Backend, "", filepath.Join(lc.BooksDir, ".backups"), epubOpts)
+			catalogs = append(catalogs, cat)
+			libOpts := opts
+			libOpts.Password, libOpts.OPDSToken, libOpts.Users = lc.Password, lc.OPDSToken, users(lc.Users)
+			libs[lc.Prefix] = server.Library{Catalog: cat, Options: libOpts}
+			log.Printf("library %s: Web UI available at http://localhost%s%s/", lc.Prefix, cfg.ListenAddr, lc.Prefix)
+		}
+		srv := server.New(nil, server.Options{Libraries: libs})
+		handler, closeHandler = srv, srv.Close
+	}
+
+	if cfg.MaxConnections > 0 {
+		log.Printf("limiting to %d concurrent connections", cfg.MaxConnections)
+	}
+	ln, err := listen(cfg)
+	if err != nil {
+		log.Fatalf("listen error: %v", err)
+	}
+	if err := serve(ctx, newHTTPServer(cfg, handler), ln, shutdownGracePeriod); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+	// A second signal now kills the process instead of waiting.
+	stop()
+
+	// The HTTP server is down; stop the session janitors, then the
+	// background work, then the catalogs.
+	closeHandler()
+	closeCatalogs(catalogs, &background, shutdownGracePeriod)
+	log.Printf("nxt-opds stopped")
+}
+
+// closeCatalogs lets the background work of the catalogs (a refresh or
+// backup in flight, whose context is already cancelled) finish within
+// grace, then closes every catalog that implements io.Closer, which for
+// SQLite checkpoints the WAL.
+func closeCatalogs(catalogs []catalog.Catalog, background *sync.WaitGroup, grace time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		log.Printf("background work still running after %s; closing anyway", grace)
+	}
+	for _, cat := range catalogs {
+		if c, ok := cat.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("closing catalog: %v", err)
+			}
+		}
+	}
+}
+
+// serve runs srv on ln until ctx is cancelled, then shuts it down, giving
+// in-flight requests up to grace to complete. It returns nil after a
+// shutdown and the error of Serve if the server stops on its own.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("shutting down (grace period %s)", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+		_ = srv.Close()
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// users converts the configured accounts to server users.
+func users(cfgUsers []config.UserConfig) []server.User {
+	var out []server.User
+	for _, u := range cfgUsers {
+		out = append(out, server.User{Name: u.Name, PasswordHash: u.PasswordHash})
+	}
+	return out
+}
+
+// clientQuirks converts the configured reader workarounds to server options.
+func clientQuirks(cfgQuirks []config.ClientQuirkConfig) []server.ClientQuirk {
+	var out []server.ClientQuirk
+	for _, q := range cfgQuirks {
+		out = append(out, server.ClientQuirk{UserAgent: q.UserAgent, AbsoluteURLs: q.AbsoluteURLs, PlainAtomType: q.PlainAtomType})
 	}
+	return out
+}
 
-	// Ensure the books directory exists.
-	if err := os.MkdirAll(cfg.BooksDir, 0755); err != nil {
-		log.Fatalf("cannot create books directory %q: %v", cfg.BooksDir, err)
+// openCatalog opens the catalog of booksDir with the given backend ("fs"
+// or "sqlite"), creating the directory if needed, and starts its
+// background refresh and nightly backups as configured in cfg. Both stop
+// when ctx is cancelled; background tracks them so that the caller can wait
+// for an in-flight run before closing the catalog.
+func openCatalog(ctx context.Context, background *sync.WaitGroup, cfg config.Config, booksDir, backend, coversDir, backupDir string, epubOpts epub.Options) catalog.Catalog {
+	if err := os.MkdirAll(booksDir, 0755); err != nil {
+		log.Fatalf("cannot create books directory %q: %v", booksDir, err)
 	}
 
 	var cat catalog.Catalog
-	switch cfg.Backend {
+	switch backend {
 	case "sqlite":
-		b, err := sqlitebackend.New(cfg.BooksDir)
+		b, err := sqlitebackend.NewWithOptions(booksDir, sqlitebackend.Options{
+			CoversDir:         coversDir,
+			EPUB:              epubOpts,
+			PendingRetryDelay: cfg.PendingRetryDelay,
+			OrganizeUploads:   cfg.OrganizeUploads,
+			MaxUploadSize:     cfg.MaxUploadSize,
+			RemoveEmptyDirs:   cfg.RemoveEmptyDirs,
+			ScanConcurrency:   cfg.ScanConcurrency,
+			TrustDirListing:   cfg.TrustDirListing,
+			ScanHiddenFiles:   cfg.ScanHiddenFiles,
+			DedupeByHash:      cfg.DedupeByHash,
+			SearchCountCap:    cfg.SearchCountCap,
+			BackupLocation:    cfg.Location,
+		})
 		if err != nil {
 			log.Fatalf("sqlite catalog backend error: %v", err)
 		}
 		cat = b
-		log.Printf("using SQLite catalog backend (%s/.catalog.db)", cfg.BooksDir)
+		log.Printf("using SQLite catalog backend (%s/.catalog.db)", booksDir)
 	default: // "fs" or unset
-		b, err := fsbackend.New(cfg.BooksDir)
+		b, err := fsbackend.NewWithOptions(booksDir, fsbackend.Options{
+			CoversDir:         coversDir,
+			EPUB:              epubOpts,
+			PendingRetryDelay: cfg.PendingRetryDelay,
+			OrganizeUploads:   cfg.OrganizeUploads,
+			MaxUploadSize:     cfg.MaxUploadSize,
+			RemoveEmptyDirs:   cfg.RemoveEmptyDirs,
+			ScanConcurrency:   cfg.ScanConcurrency,
+			TrustDirListing:   cfg.TrustDirListing,
+			ScanHiddenFiles:   cfg.ScanHiddenFiles,
+			DedupeByHash:      cfg.DedupeByHash,
+		})
 		if err != nil {
 			log.Fatalf("catalog backend error: %v", err)
 		}
 		cat = b
 		log.Printf("using in-memory (fs) catalog backend")
 	}
-	log.Printf("catalog loaded from %q", cfg.BooksDir)
+	log.Printf("catalog loaded from %q", booksDir)
+	if coversDir != "" {
+		log.Printf("storing covers in %q", coversDir)
+	}
 
 	// Start background catalog refresh if the backend supports it and an
 	// interval is configured (> 0).
 	if r, ok := cat.(catalog.Refresher); ok && cfg.RefreshInterval > 0 {
 		log.Printf("background catalog refresh enabled (interval: %s)", cfg.RefreshInterval)
+		background.Add(1)
 		go func() {
+			defer background.Done()
 			ticker := time.NewTicker(cfg.RefreshInterval)
 			defer ticker.Stop()
-			for range ticker.C {
-				if err := r.Refresh(); err != nil {
-					log.Printf("background catalog refresh error: %v", err)
-				} else {
-					log.Printf("catalog refreshed")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
 				}
+				backgroundRefresh(r, booksDir)
+			}
+		}()
+	}
+
+	// Watch the books directory for near-immediate refreshes if enabled.
+	if r, ok := cat.(catalog.Refresher); ok && cfg.WatchEnabled {
+		log.Printf("watching %q for changes", booksDir)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := watchBooksDir(ctx, r, booksDir, watchDebounce); err != nil {
+				log.Printf("cannot watch %q: %v", booksDir, err)
 			}
 		}()
 	}
 
 	// Start nightly backup goroutine if the backend supports it.
 	if bu, ok := cat.(catalog.Backupper); ok {
-		backupDir := cfg.BackupDir
-		if backupDir == "" {
-			backupDir = filepath.Join(cfg.BooksDir, ".backups")
-		}
 		keep := cfg.BackupKeep
 		log.Printf("nightly database backup enabled (dir: %s, keep: %d)", backupDir, keep)
-		go runNightlyBackup(bu, backupDir, keep)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runNightlyBackup(ctx, bu, backupDir, keep, cfg.Location)
+		}()
 	}
+	return cat
+}
 
-	opts := server.Options{
-		Password:  cfg.Password,
-		OPDSToken: cfg.OPDSToken,
-		StaticFS:  web.FS,
+// backgroundRefresh runs one periodic refresh of the catalog of booksDir.
+// Errors are always logged; a successful refresh is logged only when it
+// added or removed books, so that a short interval does not flood the log.
+// Backends that cannot report what changed are logged on every refresh.
+func backgroundRefresh(r catalog.Refresher, booksDir string) {
+	sr, ok := r.(catalog.StatsRefresher)
+	if !ok {
+		if err := r.Refresh(); err != nil {
+			log.Printf("background catalog refresh error for %q: %v", booksDir, err)
+		} else {
+			log.Printf("catalog %q refreshed", booksDir)
+		}
+		return
+	}
+	stats, err := sr.RefreshWithStats()
+	switch {
+	case err != nil:
+		log.Printf("background catalog refresh error for %q: %v", booksDir, err)
+	case stats.Changed():
+		log.Printf("catalog %q refreshed: %d book(s) added, %d updated, %d removed", booksDir, stats.Added, stats.Updated, stats.Removed)
 	}
-	srv := server.New(cat, opts)
+}
 
-	log.Printf("nxt-opds starting on %s", cfg.ListenAddr)
-	log.Printf("Web UI available at http://localhost%s/", cfg.ListenAddr)
-	if cfg.OPDSToken != "" {
-		log.Printf("OPDS feed URL (for reader apps): http://localhost%s/opds?token=%s", cfg.ListenAddr, cfg.OPDSToken)
+// newHTTPServer returns an http.Server for handler configured with the
+// timeouts and header limit from cfg.
+func newHTTPServer(cfg config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           cfg.ListenAddr,
+		Handler:        handler,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
-	if err := http.ListenAndServe(cfg.ListenAddr, srv); err != nil {
-		log.Fatalf("server error: %v", err)
+}
+
+// listen opens the TCP listener on cfg.ListenAddr, wrapped so that at most
+// cfg.MaxConnections connections are open at once (when > 0).
+func listen(cfg config.Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnections > 0 {
+		ln = newLimitListener(ln, cfg.MaxConnections)
 	}
+	return ln, nil
 }
 
-// runNightlyBackup sleeps until the next local midnight, then calls
-// bu.Backup every 24 hours.  It is intended to run in a goroutine.
-func runNightlyBackup(bu catalog.Backupper, backupDir string, keep int) {
+// runNightlyBackup sleeps until the next midnight in loc, then calls
+// bu.Backup every night until ctx is cancelled.  It is intended to run in a
+// goroutine.
+func runNightlyBackup(ctx context.Context, bu catalog.Backupper, backupDir string, keep int, loc *time.Location) {
 	for {
-		now := time.Now()
-		// Next midnight in local time.
-		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
-		time.Sleep(time.Until(next))
+		now := time.Now().In(loc)
+		// Next midnight in the configured time zone.
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 
 		path, err := bu.Backup(backupDir, keep)
 		if err != nil {
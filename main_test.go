@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fsbackend "github.com/banux/nxt-opds/internal/backend/fs"
+	"github.com/banux/nxt-opds/internal/catalog"
+	"github.com/banux/nxt-opds/internal/config"
+)
+
+func TestNewHTTPServer_UsesConfiguredTimeouts(t *testing.T) {
+	cfg := config.Default()
+	cfg.ReadTimeout = 11 * time.Second
+	cfg.WriteTimeout = 22 * time.Second
+	cfg.IdleTimeout = 33 * time.Second
+	cfg.MaxHeaderBytes = 4096
+
+	srv := newHTTPServer(cfg, http.NotFoundHandler())
+	if srv.ReadTimeout != cfg.ReadTimeout {
+		t.Errorf("ReadTimeout: got %v, want %v", srv.ReadTimeout, cfg.ReadTimeout)
+	}
+	if srv.WriteTimeout != cfg.WriteTimeout {
+		t.Errorf("WriteTimeout: got %v, want %v", srv.WriteTimeout, cfg.WriteTimeout)
+	}
+	if srv.IdleTimeout != cfg.IdleTimeout {
+		t.Errorf("IdleTimeout: got %v, want %v", srv.IdleTimeout, cfg.IdleTimeout)
+	}
+	if srv.MaxHeaderBytes != 4096 {
+		t.Errorf("MaxHeaderBytes: got %d, want 4096", srv.MaxHeaderBytes)
+	}
+}
+
+func TestListen_LimitsConnections(t *testing.T) {
+	cfg := config.Default()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.MaxConnections = 1
+
+	ln, err := listen(cfg)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted while the limit was reached")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("second connection not accepted after the first closed")
+	}
+}
+
+func TestServe_ShutsDownGracefully(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, srv, ln, 5*time.Second) }()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			body <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request: got %q, want it to complete", got)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after shutdown")
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("listener still accepting connections after shutdown")
+	}
+}
+
+type countingBackupper struct{ calls int }
+
+func (b *countingBackupper) Backup(string, int) (string, error) {
+	b.calls++
+	return "", nil
+}
+
+func TestRunNightlyBackup_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bu := &countingBackupper{}
+	done := make(chan struct{})
+	go func() {
+		runNightlyBackup(ctx, bu, t.TempDir(), 1, time.UTC)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runNightlyBackup did not return after cancel")
+	}
+	if bu.calls != 0 {
+		t.Errorf("Backup called %d times, want 0", bu.calls)
+	}
+}
+
+// closingCatalog records when Close is called and whether the background
+// work was still running then.
+type closingCatalog struct {
+	catalog.Catalog
+	running *atomic.Bool
+	closed  bool
+	early   bool
+}
+
+func (c *closingCatalog) Close() error {
+	c.closed = true
+	c.early = c.running.Load()
+	return nil
+}
+
+func TestCloseCatalogs_WaitsForBackgroundThenCloses(t *testing.T) {
+	var running atomic.Bool
+	var background sync.WaitGroup
+	running.Store(true)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		time.Sleep(50 * time.Millisecond)
+		running.Store(false)
+	}()
+
+	dir := t.TempDir()
+	plain, err := fsbackend.New(dir)
+	if err != nil {
+		t.Fatalf("fs.New: %v", err)
+	}
+	closer := &closingCatalog{Catalog: plain, running: &running}
+	closeCatalogs([]catalog.Catalog{plain, closer}, &background, 2*time.Second)
+
+	if !closer.closed {
+		t.Fatal("Close was not called on a catalog implementing io.Closer")
+	}
+	if closer.early {
+		t.Error("Close was called before the background work finished")
+	}
+}
+
+func TestBackgroundRefresh_LogsOnlyChanges(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	b, err := fsbackend.New(dir)
+	if err != nil {
+		t.Fatalf("fs.New: %v", err)
+	}
+
+	backgroundRefresh(b, dir)
+	if logs.Len() != 0 {
+		t.Errorf("no-op refresh logged %q", logs.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "new.pdf"), []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backgroundRefresh(b, dir)
+	if !strings.Contains(logs.String(), "1 book(s) added, 0 updated, 0 removed") {
+		t.Errorf("changing refresh logged %q, want the number of added books", logs.String())
+	}
+
+	logs.Reset()
+	backgroundRefresh(b, dir)
+	if logs.Len() != 0 {
+		t.Errorf("second no-op refresh logged %q", logs.String())
+	}
+}
+
+func TestWatchBooksDir_RefreshesAfterChanges(t *testing.T) {
+	dir := t.TempDir()
+	b, err := fsbackend.New(dir)
+	if err != nil {
+		t.Fatalf("fs.New: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchBooksDir(ctx, b, dir, 50*time.Millisecond) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchBooksDir: %v", err)
+		}
+	}()
+
+	waitTotal := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, total, err := b.AllBooks(0, 10)
+			if err == nil && total == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("catalog has %d book(s), want %d", total, want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Give the watcher time to register before the first change.
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "new.pdf"), []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitTotal(1)
+
+	sub := filepath.Join(dir, "Author")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(sub, "nested.pdf"), []byte("%PDF-1.4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitTotal(2)
+
+	if err := os.Remove(filepath.Join(dir, "new.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	waitTotal(1)
+}
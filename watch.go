@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/banux/nxt-opds/internal/catalog"
+)
+
+// watchDebounce is how long the books directory must stay quiet after a
+// change before the watcher refreshes the catalog, so that a burst of
+// events (a sync client dropping a folder of books) causes one refresh.
+const watchDebounce = 2 * time.Second
+
+// watchBooksDir refreshes the catalog of booksDir debounce after files stop
+// appearing, disappearing or changing in it or its subdirectories, until
+// ctx is cancelled. Hidden files and directories (the catalog's own
+// metadata, covers and database, sync clients' temporary files) are
+// ignored. It returns an error only when the watch cannot be set up.
+func watchBooksDir(ctx context.Context, r catalog.Refresher, booksDir string, debounce time.Duration) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := watchTree(w, booksDir); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if hiddenPath(booksDir, ev.Name) || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			// New directories are not watched by fsnotify on their own.
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := watchTree(w, ev.Name); err != nil {
+						log.Printf("watch %q: %v", ev.Name, err)
+					}
+				}
+			}
+			timer.Reset(debounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("watch %q: %v", booksDir, err)
+		case <-timer.C:
+			backgroundRefresh(r, booksDir)
+		}
+	}
+}
+
+// watchTree adds root and every non-hidden directory below it to w.
+func watchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+// hiddenPath reports whether path, below booksDir, is or lies in a hidden
+// file or directory.
+func hiddenPath(booksDir, path string) bool {
+	rel, err := filepath.Rel(booksDir, path)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}